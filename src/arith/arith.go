@@ -0,0 +1,47 @@
+// Package arith is the arithmetic of linear sums shared by the
+// finite domain store of eval and the consistency solver. A sum is
+// kept as the coefficient of each variable plus a constant; the
+// coefficients are keyed by whatever names a variable there.
+package arith
+
+// Scale returns the coefficients times k, leaving out those that
+// become 0
+func Scale[V comparable](coefs map[V]int, k int) map[V]int {
+	s := map[V]int{}
+	for v, a := range coefs {
+		if a*k != 0 {
+			s[v] = a * k
+		}
+	}
+	return s
+}
+
+// Add returns the coefficients of the sum of both,
+// leaving out those that cancel
+func Add[V comparable](coefs, other map[V]int) map[V]int {
+	s := Scale(coefs, 1)
+	for v, a := range other {
+		if s[v] += a; s[v] == 0 {
+			delete(s, v)
+		}
+	}
+	return s
+}
+
+// FloorDiv is a / b rounded down
+func FloorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// CeilDiv is a / b rounded up
+func CeilDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && (a < 0) == (b < 0) {
+		q++
+	}
+	return q
+}
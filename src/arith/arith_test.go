@@ -0,0 +1,50 @@
+package arith
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiv(t *testing.T) {
+	for i, tt := range []struct {
+		a, b        int
+		floor, ceil int
+	}{
+		{7, 2, 3, 4},
+		{-7, 2, -4, -3},
+		{7, -2, -4, -3},
+		{-7, -2, 3, 4},
+		{6, 3, 2, 2},
+		{-6, 3, -2, -2},
+		{0, 5, 0, 0},
+	} {
+		if got := FloorDiv(tt.a, tt.b); got != tt.floor {
+			t.Errorf("%d): got %d want %d", i, got, tt.floor)
+		}
+		if got := CeilDiv(tt.a, tt.b); got != tt.ceil {
+			t.Errorf("%d): got %d want %d", i, got, tt.ceil)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	for i, tt := range []struct {
+		coefs, other, want map[string]int
+	}{
+		{map[string]int{"x": 1}, map[string]int{"y": 2}, map[string]int{"x": 1, "y": 2}},
+		{map[string]int{"x": 1, "y": 2}, map[string]int{"x": -1}, map[string]int{"y": 2}},
+		{map[string]int{}, map[string]int{}, map[string]int{}},
+	} {
+		if got := Add(tt.coefs, tt.other); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+	}
+	coefs := map[string]int{"x": 1}
+	Add(coefs, map[string]int{"x": -1})
+	if coefs["x"] != 1 {
+		t.Errorf("Add changed its argument: %v", coefs)
+	}
+	if got := Scale(map[string]int{"x": 2, "y": -1}, 0); len(got) != 0 {
+		t.Errorf("got %v want no coefficients", got)
+	}
+}
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"errors"
+
+	. "model"
+)
+
+// Backend abstracts the engine a rulebase is run on, so the
+// same rulebase can be run and compared across engines.
+type Backend interface {
+	// Load compiles the rulebase into the engine,
+	// replacing any rulebase loaded before
+	Load(ir InternalRepresentation) error
+
+	// RunTests runs the tests of the loaded rulebase, in order
+	RunTests() ([]TestResult, error)
+
+	// Query reports whether goal holds given facts, which
+	// instantiate objects and relations as in a test
+	Query(facts []Expression, goal Expression) (bool, error)
+}
+
+// TestResult tells whether the rules of a test held, Passed,
+// and whether they were expected to
+type TestResult struct {
+	Name       string
+	Passed     bool
+	ExpectFail bool
+}
+
+func NewTestResult(t Test, passed bool) TestResult {
+	return TestResult{Name: t.Name, Passed: passed, ExpectFail: t.ExpectFail()}
+}
+
+// OK reports whether the test came out as expected
+func (r TestResult) OK() bool {
+	return r.Passed != r.ExpectFail
+}
+
+var ErrNotLoaded = errors.New("no rulebase loaded")
+
+// Failed returns the names of the tests that did not come out
+// as expected
+func Failed(results []TestResult) []string {
+	failed := []string{}
+	for _, r := range results {
+		if !r.OK() {
+			failed = append(failed, r.Name)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,245 @@
+package consistency
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"model"
+)
+
+// Finding is a rule, or a rules block of one, that cannot hold or
+// that adds nothing, or a pair of rules that always agree or never
+// do. Rules are analysed over their typed conditions, with the rules
+// they call inlined: comparisons of integers are solved as linear
+// arithmetic, other comparisons as equalities between terms, and
+// relation and builtin calls are only known to hold or not.
+type Finding struct {
+	Check   string `json:"check"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+}
+
+func (f Finding) String() string {
+	pos := model.Position{File: f.File, Line: f.Line, Col: f.Col}
+	return fmt.Sprintf("%s: %s: %s", pos, f.Check, f.Message)
+}
+
+const (
+	// the conditions of a rule contradict each other
+	Unsatisfiable = "unsatisfiable"
+	// a rules block never holds, or only when an earlier one does
+	Unreachable = "unreachable"
+	// two rules hold for the same inputs
+	Equivalent = "equivalent"
+	// two rules never hold for the same inputs
+	Exclusive = "exclusive"
+)
+
+// Analyze returns the findings in ir ordered by position. Rules are
+// paired when they take inputs of the same types, unless one calls
+// the other, since a rule defined in terms of another is meant to
+// agree or disagree with it. The rulebase has to type check.
+func Analyze(ir model.InternalRepresentation) []Finding {
+	a := &analyzer{ir: ir, decls: map[string]model.Declaration{}}
+	for _, d := range ir.Declarations {
+		if d.Kind == "rule" {
+			a.decls[d.Name] = d
+		}
+	}
+	names := []string{}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	satisfiable := map[string]bool{}
+	for _, name := range names {
+		if a.rule(ir.Rules[name]) {
+			satisfiable[name] = true
+		}
+	}
+	for i, x := range names {
+		for _, y := range names[i+1:] {
+			if satisfiable[x] && satisfiable[y] && a.comparable(x, y) {
+				a.pair(x, y)
+			}
+		}
+	}
+
+	sort.SliceStable(a.findings, func(i, j int) bool {
+		f, g := a.findings[i], a.findings[j]
+		if f.File != g.File {
+			return f.File < g.File
+		}
+		if f.Line != g.Line {
+			return f.Line < g.Line
+		}
+		if f.Col != g.Col {
+			return f.Col < g.Col
+		}
+		if f.Check != g.Check {
+			return f.Check < g.Check
+		}
+		return f.Message < g.Message
+	})
+	return a.findings
+}
+
+// JSON returns the findings as an indented JSON array
+func JSON(findings []Finding) ([]byte, error) {
+	if findings == nil {
+		findings = []Finding{}
+	}
+	return json.MarshalIndent(findings, "", "  ")
+}
+
+type analyzer struct {
+	ir       model.InternalRepresentation
+	decls    map[string]model.Declaration
+	findings []Finding
+}
+
+func (a *analyzer) report(check, name string, pos model.Position, format string, args ...interface{}) {
+	a.findings = append(a.findings, Finding{
+		Check:   check,
+		Name:    name,
+		Message: fmt.Sprintf(format, args...),
+		File:    pos.File,
+		Line:    pos.Line,
+		Col:     pos.Col,
+	})
+}
+
+// name strips the package of the rulebase from a qualified name
+func (a *analyzer) name(name string) string {
+	return strings.TrimPrefix(name, a.ir.Package+".")
+}
+
+// block returns the position of the rules keyword of body j,
+// the first block of a declaration is its input
+func (a *analyzer) block(rule string, j int) model.Position {
+	d := a.decls[rule]
+	if j+1 >= len(d.Blocks) {
+		return d.Pos
+	}
+	pos := d.Blocks[j+1]
+	pos.File = d.Pos.File
+	return pos
+}
+
+// rule reports a rule that cannot hold, or else its bodies that
+// cannot, and reports whether it can
+func (a *analyzer) rule(r model.Rule) bool {
+	b := newBuilder(a.ir, r)
+	if !sat(b.rule(r, inputs(r), 0)) {
+		a.report(Unsatisfiable, r.Name, a.decls[r.Name].Pos,
+			"rule %s never holds, its conditions contradict each other", a.name(r.Name))
+		return false
+	}
+	clauses := r.Clauses()
+	if len(clauses) == 1 {
+		return true
+	}
+	earlier := or()
+	for j := range clauses {
+		c := b.clause(r, j, inputs(r), 0)
+		switch {
+		case !sat(c):
+			a.report(Unreachable, r.Name, a.block(r.Name, j),
+				"rules block %d of rule %s never holds, its conditions contradict each other", j+1, a.name(r.Name))
+		case j > 0 && !sat(and(c, negate(earlier))):
+			a.report(Unreachable, r.Name, a.block(r.Name, j),
+				"rules block %d of rule %s only holds when an earlier block does", j+1, a.name(r.Name))
+		}
+		earlier.args = append(earlier.args, c)
+	}
+	return true
+}
+
+// rules are comparable if they take inputs of the same types
+// and neither calls the other
+func (a *analyzer) comparable(x, y string) bool {
+	rx, ry := a.ir.Rules[x], a.ir.Rules[y]
+	if len(rx.Args) != len(ry.Args) {
+		return false
+	}
+	for i, arg := range rx.Args {
+		other := ry.Args[i]
+		if arg.TypeInfo != other.TypeInfo || arg.TypeInfo == model.OBJECT && arg.ObjectName() != other.ObjectName() {
+			return false
+		}
+	}
+	return !a.calls(x, y, map[string]bool{}) && !a.calls(y, x, map[string]bool{})
+}
+
+// calls reports whether rule x calls rule y, directly or not
+func (a *analyzer) calls(x, y string, seen map[string]bool) bool {
+	if seen[x] {
+		return false
+	}
+	seen[x] = true
+	for _, body := range a.ir.Rules[x].Clauses() {
+		for _, e := range body {
+			found := false
+			walk(e, func(e model.Expression) {
+				if _, ok := a.ir.Rules[e.Functor]; ok {
+					found = found || e.Functor == y || a.calls(e.Functor, y, seen)
+				}
+			})
+			if found {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func walk(n model.Node, f func(model.Expression)) {
+	e, ok := n.(model.Expression)
+	if !ok {
+		return
+	}
+	f(e)
+	for _, a := range e.Args {
+		walk(a, f)
+	}
+}
+
+// pair reports two rules called with the same inputs that never
+// hold together, or that each hold whenever the other does. The
+// finding is at the rule declared last.
+func (a *analyzer) pair(x, y string) {
+	first, second := x, y
+	if before(a.decls[y].Pos, a.decls[x].Pos) {
+		first, second = y, x
+	}
+	rx, ry := a.ir.Rules[first], a.ir.Rules[second]
+	b := newBuilder(a.ir, rx)
+	args := inputs(rx)
+	fx := func() formula { return b.rule(rx, args, 0) }
+	fy := func() formula { return b.rule(ry, args, 0) }
+	pos := a.decls[first].Pos
+	switch {
+	case !sat(and(fx(), fy())):
+		a.report(Exclusive, second, a.decls[second].Pos,
+			"rule %s never holds for the same inputs as rule %s at %s", a.name(second), a.name(first), pos)
+	case !sat(and(fx(), negate(fy()))) && !sat(and(fy(), negate(fx()))):
+		a.report(Equivalent, second, a.decls[second].Pos,
+			"rule %s holds for the same inputs as rule %s at %s", a.name(second), a.name(first), pos)
+	}
+}
+
+func before(a, b model.Position) bool {
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Col < b.Col
+}
@@ -0,0 +1,296 @@
+package consistency
+
+import (
+	"reflect"
+	"testing"
+
+	"model"
+)
+
+const src = `object cell {
+	number : int
+}
+
+object prisoner {
+	age      : int,
+	sentence : int,
+	name     : string,
+	cell     : cell
+}
+
+relation cellmates {
+	p        : prisoner,
+	cellmate : prisoner
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+rule grownUp {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age > 17
+	}
+}
+
+rule minor {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age < 18
+	}
+}
+
+rule phonecall {
+	input {
+		p : prisoner
+	}
+	rules {
+		adult(p)
+	}
+}
+
+rule eligible {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 21
+	}
+	rules {
+		p.age >= 25,
+		p.sentence < 10
+	}
+	rules {
+		let c : prisoner,
+		cellmates(p, c),
+		minor(c),
+		c.age > 20
+	}
+	rules {
+		p.sentence * 2 = 7
+	}
+}
+
+rule early {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age + p.sentence < 30,
+		p.sentence - p.age > 10,
+		p.age > 12
+	}
+}
+
+rule upstairs {
+	input {
+		p : prisoner
+	}
+	rules {
+		let floor = p.cell.number / 100,
+		floor > 2
+	}
+}
+
+rule ground {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.cell.number / 100 < 1
+	}
+}
+
+rule named {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.name = "jo",
+		startsWith(p.name, "j")
+	}
+	rules {
+		p.name = "al",
+		p.name = "jo"
+	}
+}
+
+rule sharing {
+	input {
+		p : prisoner
+	}
+	rules {
+		let c : prisoner,
+		cellmates(p, c),
+		c.cell = p.cell
+	}
+}
+
+rule eighteen {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age = 18
+	}
+	rules {
+		p.age >= 18,
+		p.age <= 18
+	}
+}
+`
+
+func TestAnalyze(t *testing.T) {
+	ir := model.Read(src)
+	got := []string{}
+	for _, f := range Analyze(ir) {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"26:6: equivalent: rule grownUp holds for the same inputs as rule adult at 17:6",
+		"35:6: exclusive: rule minor never holds for the same inputs as rule adult at 17:6",
+		"35:6: exclusive: rule minor never holds for the same inputs as rule grownUp at 26:6",
+		// phonecall calls adult, so it is only paired with the others
+		"44:6: equivalent: rule phonecall holds for the same inputs as rule grownUp at 26:6",
+		"44:6: exclusive: rule phonecall never holds for the same inputs as rule minor at 35:6",
+		"60:2: unreachable: rules block 2 of rule eligible only holds when an earlier block does",
+		"64:2: unreachable: rules block 3 of rule eligible never holds, its conditions contradict each other",
+		"70:2: unreachable: rules block 4 of rule eligible never holds, its conditions contradict each other",
+		"75:6: unsatisfiable: rule early never holds, its conditions contradict each other",
+		"96:6: exclusive: rule ground never holds for the same inputs as rule upstairs at 86:6",
+		"113:2: unreachable: rules block 2 of rule named never holds, its conditions contradict each other",
+		"130:6: exclusive: rule eighteen never holds for the same inputs as rule eligible at 53:6",
+		"130:6: exclusive: rule eighteen never holds for the same inputs as rule minor at 35:6",
+		"137:2: unreachable: rules block 2 of rule eighteen only holds when an earlier block does",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+// an int and a float are different constants, even of equal value
+func TestFloats(t *testing.T) {
+	ir := model.Read(`object prisoner {
+	w : float
+}
+
+rule a {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.w = 1
+	}
+}
+
+rule b {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.w = 1.0
+	}
+}
+
+rule c {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.w = 1.0
+	}
+}
+`)
+	got := []string{}
+	for _, f := range Analyze(ir) {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"14:6: exclusive: rule b never holds for the same inputs as rule a at 5:6",
+		"23:6: equivalent: rule c holds for the same inputs as rule b at 14:6",
+		"23:6: exclusive: rule c never holds for the same inputs as rule a at 5:6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestSat(t *testing.T) {
+	for i, tt := range []struct {
+		rules string
+		want  bool
+	}{
+		{"p.age > 18, p.age < 18", false},
+		{"p.age > 17, p.age < 19", true},
+		{"p.age = 18, p.age + 1 > 19", false},
+		{"p.age = p.sentence + 1, p.sentence >= p.age", false},
+		{"2 * p.age = 2 * p.sentence + 1", false},
+		{"3 * p.age <= 10, 3 * p.age >= 10", false},
+		{"3 * p.age <= 10, 3 * p.age >= 9", true},
+		{"p.age % 2 = 1, p.age % 2 = 0", false},
+		{"p.age / p.sentence > 2, p.age / p.sentence < 3", false},
+		{"p.age / p.sentence > 2, p.sentence / p.age < 3", true},
+		{`p.name = "jo", p.name = "al"`, false},
+		{`p.name = "jo", lower(p.name) = "al"`, true},
+		{"p.name < p.alias, p.name >= p.alias", false},
+		{"p.name < p.alias, p.alias < p.name", true},
+		{"p.weight > 1.5, p.weight <= 1.5", false},
+		{"startsWith(p.name, p.alias), p.name < p.alias", true},
+		{"let q : prisoner, cellmates(p, q), q.age > 20, q.age < 18", false},
+		{"adult(p), p.age < 18", false},
+		{"adult(p), p.age < 21", true},
+	} {
+		ir := model.Read(`object prisoner {
+	age      : int,
+	sentence : int,
+	weight   : float,
+	name     : string,
+	alias    : string
+}
+relation cellmates {
+	p        : prisoner,
+	cellmate : prisoner
+}
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+rule r {
+	input {
+		p : prisoner
+	}
+	rules {
+		` + tt.rules + `
+	}
+}
+`)
+		r := ir.Rules["r"]
+		if got := sat(newBuilder(ir, r).rule(r, inputs(r), 0)); got != tt.want {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := JSON(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "[]" {
+		t.Errorf("got %s want []", b)
+	}
+}
@@ -0,0 +1,226 @@
+package consistency
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"model"
+)
+
+// builder turns the conditions of a rule into a formula
+// over its inputs, with the rules it calls inlined
+type builder struct {
+	ir model.InternalRepresentation
+	// identifiers to their typed terms: the inputs of the
+	// rule, and variables declared with let, renamed name#n
+	scope map[string]model.Term
+	model.Inliner
+}
+
+func newBuilder(ir model.InternalRepresentation, r model.Rule) *builder {
+	return &builder{ir: ir, scope: r.Scope()}
+}
+
+// inputs returns the inputs of r as arguments to call it with
+func inputs(r model.Rule) []model.Node {
+	args := []model.Node{}
+	for _, a := range r.Args {
+		if a.TypeInfo == model.OBJECT {
+			args = append(args, a)
+		} else {
+			args = append(args, model.IdentifierTerm(a.Value.(string)))
+		}
+	}
+	return args
+}
+
+// rule holds if any of its bodies does
+func (b *builder) rule(r model.Rule, args []model.Node, depth int) formula {
+	f := or()
+	for j := range r.Clauses() {
+		f.args = append(f.args, b.clause(r, j, args, depth))
+	}
+	return f
+}
+
+func (b *builder) clause(r model.Rule, j int, args []model.Node, depth int) formula {
+	f := and()
+	b.Clause(r, r.Clauses()[j], args, func(v model.Term) {
+		b.scope[v.Value.(string)] = v
+	}, func(e model.Expression) {
+		f.args = append(f.args, b.condition(e, depth))
+	})
+	return f
+}
+
+func (b *builder) condition(e model.Expression, depth int) formula {
+	if r, ok := b.ir.Rules[e.Functor]; ok && depth < model.InlineDepth {
+		return b.rule(r, e.Args, depth+1)
+	}
+	if len(e.Args) == 2 {
+		switch e.Functor {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return b.comparison(e)
+		}
+	}
+	return lit(literal{kind: "prop", key: key(e)})
+}
+
+// comparisons of integers are linear constraints, others
+// equalities or orders, where a >= b is not a < b
+func (b *builder) comparison(e model.Expression) formula {
+	left, right := e.Args[0], e.Args[1]
+	if b.integer(left) && b.integer(right) {
+		d := b.linear(left).add(b.linear(right).scale(-1))
+		switch e.Functor {
+		case "==":
+			return lit(literal{kind: "linear", l: d, eq: true})
+		case "!=":
+			return literal{kind: "linear", l: d, eq: true}.negate()
+		case "<":
+			d.c++
+		case ">":
+			d = d.scale(-1)
+			d.c++
+		case ">=":
+			d = d.scale(-1)
+		}
+		return lit(literal{kind: "linear", l: d})
+	}
+	equal := literal{kind: "equal", a: key(left), b: key(right)}
+	less := func(a, b model.Node) literal {
+		return literal{kind: "prop", key: key(a) + " < " + key(b)}
+	}
+	switch e.Functor {
+	case "==":
+		return lit(equal)
+	case "!=":
+		return equal.negate()
+	case "<":
+		return lit(less(left, right))
+	case ">":
+		return lit(less(right, left))
+	case "<=":
+		return less(right, left).negate()
+	}
+	return less(left, right).negate()
+}
+
+func (b *builder) integer(n model.Node) bool {
+	typ, _ := b.typeOf(n)
+	return typ == model.INT
+}
+
+// typeOf returns the type of n and the object it is, if any, like
+// model's TypeOf but also for fields of fields such as p.cell.number
+func (b *builder) typeOf(n model.Node) (model.Token, string) {
+	if t, ok := n.(model.Term); ok {
+		if t.TypeInfo == model.IDENT {
+			t = b.scope[t.Value.(string)]
+		}
+		if t.TypeInfo == model.OBJECT {
+			return t.TypeInfo, t.ObjectName()
+		}
+		return t.TypeInfo, ""
+	}
+	e := n.(model.Expression)
+	switch e.Functor {
+	case ".":
+		typ, object := b.typeOf(e.Args[0])
+		if typ != model.OBJECT {
+			return model.ILLEGAL, ""
+		}
+		for _, f := range b.ir.Objects[object].Fields {
+			if f.Name != e.Args[1].(model.Term).Value.(string) {
+				continue
+			}
+			if f.TypeInfo == model.OBJECT {
+				return f.TypeInfo, f.ObjectName()
+			}
+			return f.TypeInfo, ""
+		}
+		return model.ILLEGAL, ""
+	case "+", "-", "*", "/", "%":
+		if len(e.Args) == 2 && b.integer(e.Args[0]) && b.integer(e.Args[1]) {
+			return model.INT, ""
+		}
+	}
+	return b.ir.TypeOf(n, b.scope), ""
+}
+
+// linear returns an integer expression as a sum of terms times
+// a number, anything else, such as a division by a field or a
+// builtin call, is a term of its own
+func (b *builder) linear(n model.Node) linear {
+	if t, ok := n.(model.Term); ok && t.TypeInfo == model.INT {
+		return constant(t.Value.(int))
+	}
+	e, ok := n.(model.Expression)
+	if !ok || len(e.Args) != 2 {
+		return variable(key(n))
+	}
+	switch e.Functor {
+	case "+", "-", "*", "/", "%":
+	default:
+		return variable(key(n))
+	}
+	l, r := b.linear(e.Args[0]), b.linear(e.Args[1])
+	switch {
+	case e.Functor == "+":
+		return l.add(r)
+	case e.Functor == "-":
+		return l.add(r.scale(-1))
+	case e.Functor == "*" && len(l.coefs) == 0:
+		return r.scale(l.c)
+	case e.Functor == "*" && len(r.coefs) == 0:
+		return l.scale(r.c)
+	case len(l.coefs) > 0 || len(r.coefs) > 0 || r.c == 0:
+		return variable(key(n))
+	case e.Functor == "/":
+		return constant(l.c / r.c)
+	}
+	// % takes the sign of the divisor
+	m := l.c % r.c
+	if m != 0 && (m < 0) != (r.c < 0) {
+		m += r.c
+	}
+	return constant(m)
+}
+
+// key identifies a term: equal keys are equal terms. Constants
+// start with $. Floats keep their decimal point, since the
+// interpreter compares 1 and 1.0 by standard order, as different.
+func key(n model.Node) string {
+	switch n := n.(type) {
+	case model.Term:
+		switch v := n.Value.(type) {
+		case int:
+			return "$" + strconv.Itoa(v)
+		case float64:
+			s := strconv.FormatFloat(v, 'f', -1, 64)
+			if !strings.Contains(s, ".") {
+				s += ".0"
+			}
+			return "$" + s
+		case time.Time:
+			return "$" + v.Format("2006-01-02")
+		case model.Duration:
+			return "$" + v.String()
+		}
+		if n.TypeInfo == model.STRING {
+			return "$" + strconv.Quote(n.Value.(string))
+		}
+		return n.Value.(string)
+	case model.Expression:
+		if n.Functor == "." {
+			return key(n.Args[0]) + "." + n.Args[1].(model.Term).Value.(string)
+		}
+		args := []string{}
+		for _, a := range n.Args {
+			args = append(args, key(a))
+		}
+		return n.Functor + "(" + strings.Join(args, ", ") + ")"
+	}
+	return ""
+}
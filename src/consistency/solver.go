@@ -0,0 +1,427 @@
+package consistency
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"arith"
+)
+
+// formula is a condition in negation normal form: a conjunction
+// or a disjunction of formulas, or a literal. An empty conjunction
+// is true, an empty disjunction false.
+type formula struct {
+	op   string // and, or, lit
+	args []formula
+	lit  literal
+}
+
+func and(fs ...formula) formula {
+	return formula{op: "and", args: fs}
+}
+
+func or(fs ...formula) formula {
+	return formula{op: "or", args: fs}
+}
+
+func negate(f formula) formula {
+	switch f.op {
+	case "and", "or":
+		args := make([]formula, len(f.args))
+		for i, a := range f.args {
+			args[i] = negate(a)
+		}
+		if f.op == "and" {
+			return or(args...)
+		}
+		return and(args...)
+	}
+	return f.lit.negate()
+}
+
+// literal is a linear constraint on integers, l <= 0 or l = 0,
+// an equality between two terms known by their key, or else a
+// proposition: a relation or builtin call, a rule call too deep
+// to inline, or an order between terms other than integers
+type literal struct {
+	kind string // linear, equal, prop
+	l    linear
+	eq   bool
+	a, b string
+	key  string
+	neg  bool
+}
+
+func lit(l literal) formula {
+	return formula{op: "lit", lit: l}
+}
+
+// negating l = 0 splits into l < 0 or l > 0, so that
+// the solver only deals with <= and =
+func (l literal) negate() formula {
+	if l.kind != "linear" {
+		l.neg = !l.neg
+		return lit(l)
+	}
+	greater := l.l.scale(-1)
+	greater.c++
+	if !l.eq {
+		return lit(literal{kind: "linear", l: greater})
+	}
+	less := l.l
+	less.c++
+	return or(lit(literal{kind: "linear", l: less}), lit(literal{kind: "linear", l: greater}))
+}
+
+const (
+	// disjunctions are split at most this many times per formula
+	maxBranches = 10000
+	// inequalities kept when eliminating a var
+	maxConstraints = 1000
+	// coefficients beyond this could overflow
+	maxCoefficient = 1 << 40
+)
+
+type solver struct {
+	branches int
+}
+
+// sat reports whether f can hold. It gives up by answering true,
+// so a formula is only ever found unsatisfiable when it is.
+func sat(f formula) bool {
+	s := &solver{}
+	return s.search([]formula{f}, nil)
+}
+
+// search collects the literals of the conjunctions pending,
+// then splits on the first disjunction left, depth first
+func (s *solver) search(pending []formula, lits []literal) bool {
+	for {
+		i := 0
+		for i < len(pending) && pending[i].op == "or" {
+			i++
+		}
+		if i == len(pending) {
+			break
+		}
+		f := pending[i]
+		rest := append(append([]formula{}, pending[:i]...), pending[i+1:]...)
+		switch f.op {
+		case "and":
+			pending = append(rest, f.args...)
+		case "lit":
+			pending = rest
+			lits = append(lits[:len(lits):len(lits)], f.lit)
+			if !propositional(lits) {
+				return false
+			}
+		}
+	}
+	if !consistent(lits) {
+		return false
+	}
+	if len(pending) == 0 {
+		return true
+	}
+	for _, g := range pending[0].args {
+		if s.branches++; s.branches > maxBranches {
+			return true
+		}
+		if s.search(append([]formula{g}, pending[1:]...), lits) {
+			return true
+		}
+	}
+	return false
+}
+
+// propositional reports whether no proposition is both
+// asserted and denied
+func propositional(lits []literal) bool {
+	props := map[string]bool{}
+	for _, l := range lits {
+		if l.kind != "prop" {
+			continue
+		}
+		if v, ok := props[l.key]; ok && v == l.neg {
+			return false
+		}
+		props[l.key] = !l.neg
+	}
+	return true
+}
+
+// consistent reports whether the literals can hold together:
+// propositions agree, terms that are equal are not different
+// constants or denied to be equal, and the integers have a
+// solution over the rationals
+func consistent(lits []literal) bool {
+	if !propositional(lits) {
+		return false
+	}
+	parent := map[string]string{}
+	var find func(k string) string
+	find = func(k string) string {
+		p, ok := parent[k]
+		if !ok || p == k {
+			return k
+		}
+		r := find(p)
+		parent[k] = r
+		return r
+	}
+	differ := [][2]string{}
+	constraints := []literal{}
+	for _, l := range lits {
+		switch {
+		case l.kind == "equal" && l.neg:
+			differ = append(differ, [2]string{l.a, l.b})
+		case l.kind == "equal":
+			a, b := find(l.a), find(l.b)
+			if _, ok := parent[b]; !ok {
+				parent[b] = b
+			}
+			parent[a] = b
+		case l.kind == "linear":
+			constraints = append(constraints, l)
+		}
+	}
+	constants := map[string]string{}
+	for k := range parent {
+		if !isConstant(k) {
+			continue
+		}
+		r := find(k)
+		if c, ok := constants[r]; ok && c != k {
+			return false
+		}
+		constants[r] = k
+	}
+	for _, d := range differ {
+		if find(d[0]) == find(d[1]) {
+			return false
+		}
+	}
+	return feasible(constraints)
+}
+
+// linear is the sum of vars times their coefficient, plus c.
+// Vars are integer terms by key.
+type linear struct {
+	coefs map[string]int
+	c     int
+}
+
+func constant(c int) linear {
+	return linear{coefs: map[string]int{}, c: c}
+}
+
+func variable(key string) linear {
+	return linear{coefs: map[string]int{key: 1}}
+}
+
+func (l linear) scale(k int) linear {
+	return linear{coefs: arith.Scale(l.coefs, k), c: l.c * k}
+}
+
+func (l linear) add(o linear) linear {
+	return linear{coefs: arith.Add(l.coefs, o.coefs), c: l.c + o.c}
+}
+
+// substitute replaces v by value
+func (l linear) substitute(v string, value linear) linear {
+	a, ok := l.coefs[v]
+	if !ok {
+		return l
+	}
+	s := l.scale(1)
+	delete(s.coefs, v)
+	return s.add(value.scale(a))
+}
+
+func (l linear) vars() []string {
+	vars := []string{}
+	for v := range l.coefs {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+func (l linear) String() string {
+	parts := []string{}
+	for _, v := range l.vars() {
+		parts = append(parts, strconv.Itoa(l.coefs[v])+"*"+v)
+	}
+	return strings.Join(parts, " + ") + " + " + strconv.Itoa(l.c)
+}
+
+func (l linear) large() bool {
+	if l.c > maxCoefficient || l.c < -maxCoefficient {
+		return true
+	}
+	for _, a := range l.coefs {
+		if a > maxCoefficient || a < -maxCoefficient {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize divides l <= 0 by the gcd of its coefficients,
+// rounding the constant up since the vars are integers
+func (l linear) normalize() linear {
+	g := 0
+	for _, a := range l.coefs {
+		g = gcd(g, a)
+	}
+	if g <= 1 {
+		return l
+	}
+	n := constant(arith.CeilDiv(l.c, g))
+	for v, a := range l.coefs {
+		n.coefs[v] = a / g
+	}
+	return n
+}
+
+// feasible solves the equalities with a var whose coefficient is
+// 1 or -1 for it, then eliminates the vars from the inequalities
+// one by one, as Fourier-Motzkin does
+func feasible(constraints []literal) bool {
+	eqs, leqs := []linear{}, []linear{}
+	for _, c := range constraints {
+		if c.eq {
+			eqs = append(eqs, c.l)
+		} else {
+			leqs = append(leqs, c.l)
+		}
+	}
+	for len(eqs) > 0 {
+		e := eqs[0]
+		eqs = eqs[1:]
+		if len(e.coefs) == 0 {
+			if e.c != 0 {
+				return false
+			}
+			continue
+		}
+		v := ""
+		g := 0
+		for _, w := range e.vars() {
+			if a := e.coefs[w]; (a == 1 || a == -1) && v == "" {
+				v = w
+			}
+			g = gcd(g, e.coefs[w])
+		}
+		if e.c%g != 0 {
+			return false
+		}
+		if v == "" {
+			leqs = append(leqs, e, e.scale(-1))
+			continue
+		}
+		// a*v + rest = 0, so v = -a*rest
+		rest := e.scale(1)
+		delete(rest.coefs, v)
+		value := rest.scale(-e.coefs[v])
+		for i := range eqs {
+			eqs[i] = eqs[i].substitute(v, value)
+		}
+		for i := range leqs {
+			leqs[i] = leqs[i].substitute(v, value)
+		}
+	}
+	return eliminate(leqs)
+}
+
+func eliminate(leqs []linear) bool {
+	for {
+		seen := map[string]bool{}
+		kept := []linear{}
+		for _, l := range leqs {
+			if l.large() {
+				return true
+			}
+			l = l.normalize()
+			if len(l.coefs) == 0 {
+				if l.c > 0 {
+					return false
+				}
+				continue
+			}
+			if k := l.String(); !seen[k] {
+				seen[k] = true
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == 0 {
+			return true
+		}
+		if len(kept) > maxConstraints {
+			return true
+		}
+		// the var with the fewest combinations goes first
+		pos, neg := map[string]int{}, map[string]int{}
+		for _, l := range kept {
+			for v, a := range l.coefs {
+				if a > 0 {
+					pos[v]++
+				} else {
+					neg[v]++
+				}
+			}
+		}
+		vars := []string{}
+		for v := range pos {
+			vars = append(vars, v)
+		}
+		for v := range neg {
+			if pos[v] == 0 {
+				vars = append(vars, v)
+			}
+		}
+		sort.Strings(vars)
+		v := vars[0]
+		for _, w := range vars[1:] {
+			if pos[w]*neg[w] < pos[v]*neg[v] {
+				v = w
+			}
+		}
+		leqs = []linear{}
+		uppers, lowers := []linear{}, []linear{}
+		for _, l := range kept {
+			switch a := l.coefs[v]; {
+			case a > 0:
+				uppers = append(uppers, l)
+			case a < 0:
+				lowers = append(lowers, l)
+			default:
+				leqs = append(leqs, l)
+			}
+		}
+		for _, u := range uppers {
+			for _, l := range lowers {
+				leqs = append(leqs, u.scale(-l.coefs[v]).add(l.scale(u.coefs[v])))
+			}
+		}
+	}
+}
+
+func gcd(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// constants have keys no identifier has
+func isConstant(key string) bool {
+	return strings.HasPrefix(key, "$")
+}
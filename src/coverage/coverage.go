@@ -0,0 +1,140 @@
+package coverage
+
+import (
+	"encoding/json"
+	"sort"
+
+	"backend"
+	"eval"
+	"model"
+)
+
+// Report holds the coverage of each rule by the tests, keyed by
+// the position of the rule and its conditions in the DSL source
+type Report struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Rule struct {
+	Name string `json:"name"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+
+	// how often the rule was called, and how often it held
+	Calls int `json:"calls"`
+	Held  int `json:"held"`
+
+	Conditions []Condition `json:"conditions"`
+}
+
+// Condition counts how often a condition in a rule body held
+// and failed. Lets bind a value and are left out.
+type Condition struct {
+	Body int    `json:"body"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+
+	True  int `json:"true"`
+	False int `json:"false"`
+}
+
+// Covered reports whether a condition was seen both true and false
+func (c Condition) Covered() bool {
+	return c.True > 0 && c.False > 0
+}
+
+// Run runs the tests in the interpreter, collecting coverage
+func Run(ir model.InternalRepresentation) (Report, []backend.TestResult, error) {
+	in := eval.New()
+	if err := in.Load(ir); err != nil {
+		return Report{}, nil, err
+	}
+	c := in.Cover()
+	results, err := in.RunTests()
+	if err != nil {
+		return Report{}, nil, err
+	}
+	return NewReport(ir, c), results, nil
+}
+
+// NewReport combines coverage with the positions in ir
+func NewReport(ir model.InternalRepresentation, c *eval.Coverage) Report {
+	declared := map[string]model.Declaration{}
+	for _, d := range ir.Declarations {
+		if d.Kind == "rule" {
+			declared[d.Name] = d
+		}
+	}
+	report := Report{Rules: []Rule{}}
+	for name, r := range ir.Rules {
+		d := declared[name]
+		rule := Rule{Name: name, File: d.Pos.File, Line: d.Pos.Line, Col: d.Pos.Col, Conditions: []Condition{}}
+		rc := c.Rules[name]
+		if rc != nil {
+			rule.Calls, rule.Held = rc.Calls, rc.Held
+		}
+		for j, body := range r.Clauses() {
+			for i, e := range body {
+				if e.Functor == "let" {
+					continue
+				}
+				cond := Condition{Body: j}
+				if j < len(d.Clauses) && i < len(d.Clauses[j]) {
+					pos := d.Clauses[j][i]
+					cond.File, cond.Line, cond.Col = d.Pos.File, pos.Line, pos.Col
+				}
+				if rc != nil {
+					cond.True, cond.False = rc.Conditions[j][i].True, rc.Conditions[j][i].False
+				}
+				rule.Conditions = append(rule.Conditions, cond)
+			}
+		}
+		report.Rules = append(report.Rules, rule)
+	}
+	sort.Slice(report.Rules, func(i, j int) bool {
+		a, b := report.Rules[i], report.Rules[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Name < b.Name
+	})
+	return report
+}
+
+// Summary counts the rules called by a test, and the conditions
+// seen both true and false
+type Summary struct {
+	Rules             int `json:"rules"`
+	RulesCalled       int `json:"rulesCalled"`
+	Conditions        int `json:"conditions"`
+	ConditionsCovered int `json:"conditionsCovered"`
+}
+
+func (r Report) Summary() Summary {
+	s := Summary{Rules: len(r.Rules)}
+	for _, rule := range r.Rules {
+		if rule.Calls > 0 {
+			s.RulesCalled++
+		}
+		for _, c := range rule.Conditions {
+			s.Conditions++
+			if c.Covered() {
+				s.ConditionsCovered++
+			}
+		}
+	}
+	return s
+}
+
+// JSON returns the report and its summary as indented JSON
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(struct {
+		Summary Summary `json:"summary"`
+		Rules   []Rule  `json:"rules"`
+	}{r.Summary(), r.Rules}, "", "  ")
+}
@@ -0,0 +1,114 @@
+package coverage
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"model"
+)
+
+const rulebase = `object prisoner {
+	age     : int,
+	married : string
+}
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+rule hasRightToPhonecall {
+	input {
+		p : prisoner
+	}
+	rules {
+		adult(p),
+		p.married = "yes"
+	}
+	rules {
+		p.age >= 65
+	}
+}
+rule untested {
+	input {
+		p : prisoner
+	}
+	rules {
+		let a = p.age,
+		a < 10
+	}
+}
+test "married adult" {
+	facts {
+		p : prisoner { age : 23, married : yes }
+	}
+	rules {
+		hasRightToPhonecall(p)
+	}
+}
+test "minor (fails)" {
+	facts {
+		p : prisoner { age : 15, married : no }
+	}
+	rules {
+		hasRightToPhonecall(p)
+	}
+}
+`
+
+func TestRun(t *testing.T) {
+	report, results, err := Run(model.Read(rulebase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || !results[0].Passed || results[1].Passed {
+		t.Fatalf("unexpected results %v", results)
+	}
+	want := []Rule{
+		{Name: "adult", Line: 5, Col: 6, Calls: 2, Held: 1, Conditions: []Condition{
+			{Body: 0, Line: 10, Col: 3, True: 1, False: 1},
+		}},
+		{Name: "hasRightToPhonecall", Line: 13, Col: 6, Calls: 2, Held: 1, Conditions: []Condition{
+			{Body: 0, Line: 18, Col: 3, True: 1, False: 1},
+			{Body: 0, Line: 19, Col: 3, True: 1, False: 0},
+			{Body: 1, Line: 22, Col: 3, True: 0, False: 1},
+		}},
+		{Name: "untested", Line: 25, Col: 6, Conditions: []Condition{
+			{Body: 0, Line: 31, Col: 3},
+		}},
+	}
+	for i := range want {
+		if i >= len(report.Rules) || !reflect.DeepEqual(report.Rules[i], want[i]) {
+			t.Errorf("%d): got %#v want %#v", i, report.Rules, want[i])
+		}
+	}
+	s := report.Summary()
+	if want := (Summary{Rules: 3, RulesCalled: 2, Conditions: 5, ConditionsCovered: 2}); s != want {
+		t.Errorf("got %#v want %#v", s, want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	report, _, err := Run(model.Read(rulebase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bytes.Buffer{}
+	if err := report.HTML(b, map[string]string{"": rulebase}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2 of 3 rules called",
+		`<tr class="covered"><td class="count">10</td><td class="count">1 true 1 false</td><td><pre>		p.age &gt;= 18</pre></td></tr>`,
+		`<tr class="partial"><td class="count">19</td>`,
+		`<tr class="uncovered"><td class="count">25</td><td class="count">0/0</td>`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected %q in %s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+)
+
+var page = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Rule coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; }
+.covered { background: #c8f0c8; }
+.partial { background: #f8f0a8; }
+.uncovered { background: #f8c0c0; }
+.count { color: #666; padding-right: 1em; text-align: right; }
+</style>
+</head>
+<body>
+<p>{{.Summary.RulesCalled}} of {{.Summary.Rules}} rules called,
+{{.Summary.ConditionsCovered}} of {{.Summary.Conditions}} conditions seen both true and false</p>
+{{range .Files}}
+<h2>{{.Name}}</h2>
+<table><tbody>
+{{range .Lines}}<tr class="{{.Class}}"><td class="count">{{.Number}}</td><td class="count">{{.Counts}}</td><td><pre>{{.Text}}</pre></td></tr>
+{{end}}</tbody></table>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlFile struct {
+	Name  string
+	Lines []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Text   string
+	Class  string
+	Counts string
+}
+
+// HTML writes the report as the DSL source, with the line of each
+// rule and condition marked covered, partially covered or
+// uncovered. Sources are keyed by file name, "" for a rulebase
+// that was read from a string.
+func (r Report) HTML(w io.Writer, sources map[string]string) error {
+	type mark struct {
+		class, counts string
+	}
+	marks := map[string]map[int]mark{}
+	// conditions sharing a line are marked by the least covered
+	rank := map[string]int{"uncovered": 0, "partial": 1, "covered": 2}
+	set := func(file string, line int, m mark) {
+		if marks[file] == nil {
+			marks[file] = map[int]mark{}
+		}
+		if prev, ok := marks[file][line]; ok {
+			if rank[prev.class] < rank[m.class] {
+				m.class = prev.class
+			}
+			m.counts = prev.counts + ", " + m.counts
+		}
+		marks[file][line] = m
+	}
+	for _, rule := range r.Rules {
+		class := "covered"
+		if rule.Calls == 0 {
+			class = "uncovered"
+		}
+		set(rule.File, rule.Line, mark{class, fmt.Sprintf("%d/%d", rule.Held, rule.Calls)})
+		for _, c := range rule.Conditions {
+			class := "partial"
+			switch {
+			case c.Covered():
+				class = "covered"
+			case c.True == 0 && c.False == 0:
+				class = "uncovered"
+			}
+			set(c.File, c.Line, mark{class, fmt.Sprintf("%d true %d false", c.True, c.False)})
+		}
+	}
+
+	names := []string{}
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := []htmlFile{}
+	for _, name := range names {
+		f := htmlFile{Name: name}
+		for i, text := range strings.Split(sources[name], "\n") {
+			m := marks[name][i+1]
+			f.Lines = append(f.Lines, htmlLine{Number: i + 1, Text: text, Class: m.class, Counts: m.counts})
+		}
+		files = append(files, f)
+	}
+	return page.Execute(w, struct {
+		Summary Summary
+		Files   []htmlFile
+	}{r.Summary(), files})
+}
@@ -0,0 +1,134 @@
+package datalog
+
+import (
+	"fmt"
+	"strings"
+
+	"backend"
+	"model"
+)
+
+// Datalog runs rulebases in the Datalog fragment bottom-up:
+// all facts derivable from a test are computed before its
+// goals are looked up.
+type Datalog struct {
+	ir      *model.InternalRepresentation
+	program Program
+}
+
+var _ backend.Backend = &Datalog{}
+
+func New() *Datalog {
+	return &Datalog{}
+}
+
+// Load fails if the rulebase falls outside the Datalog fragment
+func (d *Datalog) Load(ir model.InternalRepresentation) error {
+	p, errs := Translate(ir, model.Today())
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return fmt.Errorf("outside the Datalog fragment:\n%s", strings.Join(msgs, "\n"))
+	}
+	d.ir, d.program = &ir, p
+	return nil
+}
+
+func (d *Datalog) RunTests() ([]backend.TestResult, error) {
+	if d.ir == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	results := []backend.TestResult{}
+	for _, t := range d.ir.Tests {
+		passed, err := d.run(t.Facts, t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("test %q: %s", t.Name, err)
+		}
+		results = append(results, backend.NewTestResult(t, passed))
+	}
+	return results, nil
+}
+
+func (d *Datalog) Query(facts []model.Expression, goal model.Expression) (bool, error) {
+	if d.ir == nil {
+		return false, backend.ErrNotLoaded
+	}
+	return d.run(facts, []model.Expression{goal})
+}
+
+// run derives all facts and then solves the goals as the body of
+// a clause, identifiers that are not objects being variables
+func (d *Datalog) run(facts, goals []model.Expression) (bool, error) {
+	db, objects, err := d.database(facts)
+	if err != nil {
+		return false, err
+	}
+	d.program.Evaluate(db)
+
+	query := Clause{Head: Atom{Pred: "query"}}
+	for _, g := range goals {
+		a := Atom{Pred: g.Functor}
+		for _, n := range g.Args {
+			a.Args = append(a.Args, objectOrTerm(n.(model.Term), objects))
+		}
+		query.Body = append(query.Body, a)
+	}
+	holds := false
+	query.solve(db, -1, nil, func(tuple) {
+		holds = true
+	})
+	return holds, nil
+}
+
+func objectOrTerm(t model.Term, objects map[string]bool) Term {
+	if t.TypeInfo != model.IDENT && t.TypeInfo != model.OBJECT {
+		return Const(t.Value)
+	}
+	if name := t.Value.(string); objects[name] {
+		return Const(Ref(name))
+	}
+	return variable(t.Value.(string))
+}
+
+// database holds the objects and relation tuples of a test
+func (d *Datalog) database(facts []model.Expression) (Database, map[string]bool, error) {
+	db := Database{}
+	objects := map[string]bool{}
+	for _, f := range facts {
+		if f.Functor != "new" {
+			continue
+		}
+		o := f.Args[0].(model.Term)
+		name := o.Value.(string)
+		objects[name] = true
+		db.Add(o.ObjectName(), Ref(name))
+		object := d.ir.Objects[o.ObjectName()]
+		for _, n := range f.Args[1:] {
+			v := n.(model.Term)
+			value := v.Value
+			for _, field := range object.Fields {
+				if field.Name == v.FieldName() && field.TypeInfo == model.OBJECT {
+					value = Ref(value.(string))
+				}
+			}
+			db.Add(fieldPredicate(object.Name, v.FieldName()), Ref(name), value)
+		}
+	}
+	for _, f := range facts {
+		if f.Functor == "new" {
+			continue
+		}
+		args := make([]interface{}, len(f.Args))
+		for i, n := range f.Args {
+			t := objectOrTerm(n.(model.Term), objects)
+			if t.Var != "" {
+				return nil, nil, fmt.Errorf("%s: unknown object %v", f.Functor, n.(model.Term).Value)
+			}
+			args[i] = t.Value
+		}
+		db.Add(f.Functor, args...)
+	}
+	return db, objects, nil
+}
@@ -0,0 +1,185 @@
+package datalog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"eval"
+	"model"
+)
+
+func read(t *testing.T, file string) model.InternalRepresentation {
+	b, err := ioutil.ReadFile("../testdata/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.Read(string(b))
+}
+
+func TestTranslate(t *testing.T) {
+	p, errs := Translate(read(t, "reports.rules"), time.Now())
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := `% extensional 'person#name'/2
+% extensional manages/2
+% extensional person/1
+hasBoss(E) :- person(E), reportsTo(E, B).
+reportsTo(E, B) :- person(E), person(B), manages(B, E).
+reportsTo(E, B) :- person(E), person(B), manages(X, E), reportsTo(X, B).
+`
+	if got := p.String(); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+// fields and identifiers that only differ in _ or case
+// get distinct predicates and variables
+func TestNames(t *testing.T) {
+	p, errs := Translate(model.Read(`
+		object a {
+			b_c : int
+		}
+		object a_b {
+			c : int
+		}
+		relation a_b_c {
+			x : a,
+			y : int
+		}
+		rule r {
+			input {
+				p : a,
+				P : a_b
+			}
+			rules {
+				let v_1 : int,
+				a_b_c(p, v_1),
+				p.b_c = P.c,
+				P.c = v_1
+			}
+		}`), time.Now())
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := "r(P, X_P) :- a(P), a_b(X_P), a_b_c(P, X_v__1), 'a#b_c'(P, V_1), 'a_b#c'(X_P, V_2), 'a_b#c'(X_P, V_3), V_1 = V_2, V_3 = X_v__1."
+	if got := p.Clauses[0].String(); got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for i, tt := range []struct {
+		rules string
+		want  []string
+	}{
+		{
+			rules: `let n = p.name, startsWith(n, "jo"), p.age >= 18, p.admitted < today`,
+		},
+		{
+			rules: `p.age + 1 >= 18`,
+			want:  []string{"rule r: arithmetic (+) is outside the Datalog fragment"},
+		},
+		{
+			rules: `startsWith(lower(p.name), "jo")`,
+			want:  []string{"rule r: lower computes a new value, which is outside the Datalog fragment"},
+		},
+		{
+			rules: `let n : int, p.age >= n`,
+			want:  []string{"rule r: N is not bound by a relation, rule or field"},
+		},
+	} {
+		ir := model.Read(fmt.Sprintf(`
+			object prisoner {
+				age      : int,
+				name     : string,
+				admitted : date
+			}
+			rule r {
+				input {
+					p : prisoner
+				}
+				rules {
+					%s
+				}
+			}`, tt.rules))
+		got := []string{}
+		for _, err := range Check(ir) {
+			got = append(got, err.Error())
+		}
+		if len(got) != len(tt.want) || len(got) > 0 && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %q want %q", i, got, tt.want)
+		}
+	}
+}
+
+// Datalog and the interpreter agree on the corpus in the fragment
+func TestCorpus(t *testing.T) {
+	ir := read(t, "reports.rules")
+	d, in := New(), eval.New()
+	if err := d.Load(ir); err != nil {
+		t.Fatal(err)
+	}
+	in.Load(ir)
+	got, err := d.RunTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := in.RunTests()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	if err := New().Load(read(t, "arithmetic.rules")); err == nil {
+		t.Errorf("expected arithmetic to fall outside the fragment")
+	}
+}
+
+// cycles in relations loop forever in Prolog, not in Datalog
+func TestTermination(t *testing.T) {
+	ir := read(t, "reports.rules")
+	query := model.Read(`test "cycle" {
+		facts {
+			a : person { name : alice },
+			b : person { name : bob },
+			c : person { name : carol },
+			manages(a, b),
+			manages(b, a)
+		}
+		rules {
+			reportsTo(a, a),
+			reportsTo(c, a)
+		}
+	}`).Tests[0]
+
+	d := New()
+	if err := d.Load(ir); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false} {
+		got, err := d.Query(query.Facts, query.Body[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%d): got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestStrata(t *testing.T) {
+	p := Program{Clauses: []Clause{
+		{Head: Atom{Pred: "c"}, Body: []Atom{{Pred: "b"}, {Pred: "a"}}},
+		{Head: Atom{Pred: "b"}, Body: []Atom{{Pred: "a"}, {Pred: "b2"}}},
+		{Head: Atom{Pred: "b2"}, Body: []Atom{{Pred: "b"}}},
+		{Head: Atom{Pred: "a"}, Body: []Atom{{Pred: "edb"}}},
+	}}
+	got := p.strata()
+	want := []map[string]bool{{"a": true}, {"b": true, "b2": true}, {"c": true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
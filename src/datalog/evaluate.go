@@ -0,0 +1,333 @@
+package datalog
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"model"
+)
+
+// Ref is an object constant, named after the variable
+// it was instantiated as in a test
+type Ref string
+
+type tuple []interface{}
+
+func (t tuple) key() string {
+	s := ""
+	for _, v := range t {
+		s += fmt.Sprintf("%T %v|", v, v)
+	}
+	return s
+}
+
+type relation struct {
+	tuples []tuple
+	keys   map[string]bool
+}
+
+// Database holds the facts of each predicate
+type Database map[string]*relation
+
+// Add adds a fact, reporting whether it is new
+func (db Database) Add(pred string, args ...interface{}) bool {
+	r, ok := db[pred]
+	if !ok {
+		r = &relation{keys: map[string]bool{}}
+		db[pred] = r
+	}
+	t := tuple(args)
+	if r.keys[t.key()] {
+		return false
+	}
+	r.keys[t.key()] = true
+	r.tuples = append(r.tuples, t)
+	return true
+}
+
+func (db Database) Contains(pred string, args ...interface{}) bool {
+	r, ok := db[pred]
+	return ok && r.keys[tuple(args).key()]
+}
+
+// Evaluate adds all facts derivable by the program to db,
+// evaluating the strata bottom-up in dependency order.
+// Evaluation terminates: no new constants are introduced,
+// so there are finitely many facts to derive.
+func (p Program) Evaluate(db Database) {
+	for _, stratum := range p.strata() {
+		p.evaluateStratum(db, stratum)
+	}
+}
+
+// semi-naive: after the first round, each clause is only evaluated
+// with one of its atoms on a predicate of this stratum restricted
+// to the facts derived in the previous round
+func (p Program) evaluateStratum(db Database, stratum map[string]bool) {
+	clauses := []Clause{}
+	for _, c := range p.Clauses {
+		if stratum[c.Head.Pred] {
+			clauses = append(clauses, c)
+		}
+	}
+	delta := Database{}
+	for _, c := range clauses {
+		c.solve(db, -1, nil, func(t tuple) {
+			if db.Add(c.Head.Pred, t...) {
+				delta.Add(c.Head.Pred, t...)
+			}
+		})
+	}
+	for len(delta) > 0 {
+		next := Database{}
+		for _, c := range clauses {
+			for i, a := range c.Body {
+				if !stratum[a.Pred] || delta[a.Pred] == nil {
+					continue
+				}
+				c.solve(db, i, delta, func(t tuple) {
+					if db.Add(c.Head.Pred, t...) {
+						next.Add(c.Head.Pred, t...)
+					}
+				})
+			}
+		}
+		delta = next
+	}
+}
+
+type binding map[string]interface{}
+
+func (b binding) value(t Term) (interface{}, bool) {
+	if t.Var == "" {
+		return t.Value, true
+	}
+	v, ok := b[t.Var]
+	return v, ok
+}
+
+// solve joins the body atoms left to right and emits the head
+// for every binding passing the filters. The atom at deltaPos
+// only matches facts in delta.
+func (c Clause) solve(db Database, deltaPos int, delta Database, emit func(tuple)) {
+	var join func(int, binding)
+	join = func(i int, b binding) {
+		if i == len(c.Body) {
+			for _, f := range c.Filters {
+				if !f.holds(b) {
+					return
+				}
+			}
+			head := make(tuple, len(c.Head.Args))
+			for j, t := range c.Head.Args {
+				head[j], _ = b.value(t)
+			}
+			emit(head)
+			return
+		}
+		a := c.Body[i]
+		source := db[a.Pred]
+		if i == deltaPos {
+			source = delta[a.Pred]
+		}
+		if source == nil {
+			return
+		}
+		for _, t := range source.tuples {
+			if next, ok := match(a, t, b); ok {
+				join(i+1, next)
+			}
+		}
+	}
+	join(0, binding{})
+}
+
+func match(a Atom, t tuple, b binding) (binding, bool) {
+	if len(a.Args) != len(t) {
+		return nil, false
+	}
+	next, copied := b, false
+	for i, arg := range a.Args {
+		if v, ok := next.value(arg); ok {
+			if !equal(v, t[i]) {
+				return nil, false
+			}
+			continue
+		}
+		if !copied {
+			next, copied = binding{}, true
+			for k, v := range b {
+				next[k] = v
+			}
+		}
+		next[arg.Var] = t[i]
+	}
+	return next, true
+}
+
+func equal(a, b interface{}) bool {
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// compare orders numbers, strings, dates and durations,
+// values of different types are not comparable
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case int, float64:
+		fa, ok := number(x)
+		fb, ok2 := number(b)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		return sign(fa - fb), true
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case Ref:
+		if y, ok := b.(Ref); ok {
+			return strings.Compare(string(x), string(y)), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return sign(float64(x.Sub(y))), true
+		}
+	case model.Duration:
+		if y, ok := b.(model.Duration); ok {
+			for _, d := range []int{x.Years - y.Years, x.Months - y.Months, x.Days - y.Days} {
+				if d != 0 {
+					return sign(float64(d)), true
+				}
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
+
+func (f Filter) holds(b binding) bool {
+	args := make([]interface{}, len(f.Args))
+	for i, t := range f.Args {
+		v, ok := b.value(t)
+		if !ok {
+			return false
+		}
+		args[i] = v
+	}
+	switch f.Op {
+	case "startsWith", "contains", "matches":
+		s, ok := args[0].(string)
+		sub, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			return false
+		}
+		switch f.Op {
+		case "startsWith":
+			return strings.HasPrefix(s, sub)
+		case "contains":
+			return strings.Contains(s, sub)
+		}
+		re, err := regexp.Compile(sub)
+		return err == nil && re.MatchString(s)
+	}
+	c, ok := compare(args[0], args[1])
+	if !ok {
+		return f.Op == "!="
+	}
+	switch f.Op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// strata returns the intensional predicates grouped by strongly
+// connected component, each after the components it depends on.
+// Without negation any such order is a stratification.
+func (p Program) strata() []map[string]bool {
+	intensional := p.intensional()
+	edges := map[string][]string{}
+	for _, c := range p.Clauses {
+		for _, a := range c.Body {
+			if intensional[a.Pred] {
+				edges[c.Head.Pred] = append(edges[c.Head.Pred], a.Pred)
+			}
+		}
+	}
+
+	// Tarjan's algorithm emits a component after all
+	// components reachable from it
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	stack := []string{}
+	strata := []map[string]bool{}
+	var connect func(string)
+	connect = func(v string) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range edges[v] {
+			if _, seen := index[w]; !seen {
+				connect(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		component := map[string]bool{}
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component[w] = true
+			if w == v {
+				break
+			}
+		}
+		strata = append(strata, component)
+	}
+	for _, c := range p.Clauses {
+		if _, seen := index[c.Head.Pred]; !seen {
+			connect(c.Head.Pred)
+		}
+	}
+	return strata
+}
@@ -0,0 +1,129 @@
+package datalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"model"
+)
+
+// A Datalog program over the facts of a rulebase. Objects are
+// constants: prisoner(P) holds for each prisoner P, and each field
+// is a predicate 'prisoner#age'(P, Age). Relations are extensional
+// predicates, rules are intensional ones defined by clauses.
+
+// Term is a variable or a constant
+type Term struct {
+	Var   string // empty for constants
+	Value interface{}
+}
+
+func Var(name string) Term {
+	return Term{Var: name}
+}
+
+func Const(v interface{}) Term {
+	return Term{Value: v}
+}
+
+func (t Term) String() string {
+	if t.Var != "" {
+		return t.Var
+	}
+	switch v := t.Value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		// ISO dates order chronologically as strings
+		return fmt.Sprintf("%q", v.Format("2006-01-02"))
+	case model.Duration:
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", t.Value)
+}
+
+type Atom struct {
+	Pred string
+	Args []Term
+}
+
+func (a Atom) String() string {
+	args := make([]string, len(a.Args))
+	for i, t := range a.Args {
+		args[i] = t.String()
+	}
+	return fmt.Sprintf("%s(%s)", a.Pred, strings.Join(args, ", "))
+}
+
+// Filter is a builtin test on bound terms: a comparison, or
+// one of the string predicates startsWith, contains and matches
+type Filter struct {
+	Op   string
+	Args []Term
+}
+
+func (f Filter) String() string {
+	if _, ok := comparisons[f.Op]; ok {
+		return fmt.Sprintf("%s %s %s", f.Args[0], comparisons[f.Op], f.Args[1])
+	}
+	return Atom{Pred: f.Op, Args: f.Args}.String()
+}
+
+// comparisons in the DSL and in Datalog syntax
+var comparisons = map[string]string{
+	"==": "=", "!=": "!=", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+type Clause struct {
+	Head    Atom
+	Body    []Atom
+	Filters []Filter
+}
+
+func (c Clause) String() string {
+	if len(c.Body) == 0 && len(c.Filters) == 0 {
+		return c.Head.String() + "."
+	}
+	goals := []string{}
+	for _, a := range c.Body {
+		goals = append(goals, a.String())
+	}
+	for _, f := range c.Filters {
+		goals = append(goals, f.String())
+	}
+	return fmt.Sprintf("%s :- %s.", c.Head, strings.Join(goals, ", "))
+}
+
+// Program holds the clauses of the rules, and the arity
+// of each extensional predicate
+type Program struct {
+	Clauses     []Clause
+	Extensional map[string]int
+}
+
+func (p Program) String() string {
+	lines := []string{}
+	names := []string{}
+	for name := range p.Extensional {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%% extensional %s/%d", name, p.Extensional[name]))
+	}
+	for _, c := range p.Clauses {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// intensional returns the predicates defined by clauses
+func (p Program) intensional() map[string]bool {
+	preds := map[string]bool{}
+	for _, c := range p.Clauses {
+		preds[c.Head.Pred] = true
+	}
+	return preds
+}
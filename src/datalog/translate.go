@@ -0,0 +1,211 @@
+package datalog
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"model"
+	"prolog"
+)
+
+// The Datalog fragment of the DSL: rule bodies join relations,
+// rules and fields, and filter on comparisons and the string
+// predicates. Arithmetic and functions computing new values are
+// outside the fragment, since they can create infinitely many
+// facts, and every variable has to be bound by a relation, rule,
+// field or object input. The DSL has no negation, so every
+// program in the fragment is stratified.
+
+// Check reports why a rulebase falls outside the Datalog fragment
+func Check(ir model.InternalRepresentation) []error {
+	_, errs := Translate(ir, model.Today())
+	return errs
+}
+
+// Translate returns the Datalog program for the rules in ir,
+// with today as the evaluation date
+func Translate(ir model.InternalRepresentation, today time.Time) (Program, []error) {
+	p := Program{Extensional: map[string]int{}}
+	for name, o := range ir.Objects {
+		p.Extensional[name] = 1
+		for _, f := range o.Fields {
+			p.Extensional[fieldPredicate(name, f.Name)] = 2
+		}
+	}
+	for name, r := range ir.Relations {
+		p.Extensional[name] = len(r.Fields)
+	}
+
+	names := []string{}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []error{}
+	for _, name := range names {
+		r := ir.Rules[name]
+		for _, body := range r.Clauses() {
+			c, err := translateClause(ir, today, r, body)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: %s", r.Name, err))
+				continue
+			}
+			p.Clauses = append(p.Clauses, c)
+		}
+	}
+	return p, errs
+}
+
+// names are encoded as in Prolog, so distinct fields and
+// identifiers can't share a predicate or a variable
+func fieldPredicate(object, field string) string {
+	return prolog.Accessor(object, field)
+}
+
+func variable(name string) Term {
+	return Var(prolog.Variable(name))
+}
+
+type translator struct {
+	ir    model.InternalRepresentation
+	today time.Time
+
+	// let x = term makes x an alias
+	alias map[string]Term
+	n     int
+
+	body    []Atom
+	filters []Filter
+}
+
+func translateClause(ir model.InternalRepresentation, today time.Time, r model.Rule, body []model.Expression) (Clause, error) {
+	t := &translator{ir: ir, today: today, alias: map[string]Term{}}
+	head := Atom{Pred: r.Name}
+	for _, a := range r.Args {
+		v := variable(a.Value.(string))
+		head.Args = append(head.Args, v)
+		if a.TypeInfo == model.OBJECT {
+			t.body = append(t.body, Atom{Pred: a.ObjectName(), Args: []Term{v}})
+		}
+	}
+	for _, e := range body {
+		if err := t.goal(e); err != nil {
+			return Clause{}, err
+		}
+	}
+	c := Clause{Head: head, Body: t.body, Filters: t.filters}
+	return c, rangeRestricted(c)
+}
+
+// every variable in the head and in filters needs to
+// occur in an atom of the body
+func rangeRestricted(c Clause) error {
+	bound := map[string]bool{}
+	for _, a := range c.Body {
+		for _, t := range a.Args {
+			bound[t.Var] = true
+		}
+	}
+	terms := append([]Term{}, c.Head.Args...)
+	for _, f := range c.Filters {
+		terms = append(terms, f.Args...)
+	}
+	for _, t := range terms {
+		if t.Var != "" && !bound[t.Var] {
+			return fmt.Errorf("%s is not bound by a relation, rule or field", t.Var)
+		}
+	}
+	return nil
+}
+
+func (t *translator) newVar() Term {
+	t.n++
+	return Var(fmt.Sprintf("V_%d", t.n))
+}
+
+func (t *translator) goal(e model.Expression) error {
+	switch e.Functor {
+	case "let":
+		if len(e.Args) == 1 {
+			// declaration, bound by a later atom
+			return nil
+		}
+		v, err := t.term(e.Args[1])
+		if err != nil {
+			return err
+		}
+		t.alias[e.Args[0].(model.Term).Value.(string)] = v
+		return nil
+	case "==", "!=", "<", "<=", ">", ">=", "startsWith", "contains", "matches":
+		args, err := t.terms(e.Args)
+		if err != nil {
+			return err
+		}
+		t.filters = append(t.filters, Filter{Op: e.Functor, Args: args})
+		return nil
+	}
+	_, isRule := t.ir.Rules[e.Functor]
+	_, isRelation := t.ir.Relations[e.Functor]
+	if !isRule && !isRelation {
+		return fmt.Errorf("%s is outside the Datalog fragment", e.Functor)
+	}
+	args, err := t.terms(e.Args)
+	if err != nil {
+		return err
+	}
+	t.body = append(t.body, Atom{Pred: e.Functor, Args: args})
+	return nil
+}
+
+func (t *translator) terms(nodes []model.Node) ([]Term, error) {
+	terms := make([]Term, len(nodes))
+	for i, n := range nodes {
+		v, err := t.term(n)
+		if err != nil {
+			return nil, err
+		}
+		terms[i] = v
+	}
+	return terms, nil
+}
+
+func (t *translator) term(n model.Node) (Term, error) {
+	switch v := n.(type) {
+	case model.Term:
+		if v.TypeInfo != model.IDENT && v.TypeInfo != model.OBJECT {
+			return Const(v.Value), nil
+		}
+		if a, ok := t.alias[v.Value.(string)]; ok {
+			return a, nil
+		}
+		return variable(v.Value.(string)), nil
+	case model.Expression:
+		switch v.Functor {
+		case "today":
+			return Const(t.today), nil
+		case ".":
+			return t.field(v)
+		case "+", "-", "*", "/", "%":
+			return Term{}, fmt.Errorf("arithmetic (%s) is outside the Datalog fragment", v.Functor)
+		}
+		return Term{}, fmt.Errorf("%s computes a new value, which is outside the Datalog fragment", v.Functor)
+	}
+	return Term{}, fmt.Errorf("expected node to be term or expression")
+}
+
+// p.age --> 'prisoner#age'(P, V_n)
+func (t *translator) field(e model.Expression) (Term, error) {
+	object, err := t.term(e.Args[0])
+	if err != nil {
+		return Term{}, err
+	}
+	objectName := t.ir.ObjectOf(e.Args[0], nil)
+	if objectName == "" {
+		return Term{}, fmt.Errorf("field access on non-object %v", e.Args[0])
+	}
+	v := t.newVar()
+	pred := fieldPredicate(objectName, e.Args[1].(model.Term).Value.(string))
+	t.body = append(t.body, Atom{Pred: pred, Args: []Term{object, v}})
+	return v, nil
+}
@@ -0,0 +1,303 @@
+package deps
+
+import (
+	"reflect"
+	"sort"
+
+	"model"
+)
+
+// Node is an object, field, relation, rule or test of a rulebase.
+// Fields are named object.field.
+type Node struct {
+	Kind string
+	Name string
+}
+
+func (n Node) String() string {
+	return n.Kind + " " + n.Name
+}
+
+// Graph has an edge from each declaration to the declarations it
+// uses. Objects use the objects of their fields, fields the object
+// they belong to and their type, and relations the objects of their
+// fields. Rules use the objects of their inputs and variables, the
+// fields they read and the rules and relations they call. Tests use
+// the objects and fields of their facts, the relations they assert
+// facts of, and the rules and relations they call.
+type Graph struct {
+	// objects with their fields, relations, rules and
+	// then tests, each in declaration order
+	Nodes []Node
+	uses  map[Node]map[Node]bool
+}
+
+func Build(ir model.InternalRepresentation) *Graph {
+	g := &Graph{uses: map[Node]map[Node]bool{}}
+	add := func(n Node) {
+		if _, ok := g.uses[n]; !ok {
+			g.Nodes = append(g.Nodes, n)
+			g.uses[n] = map[Node]bool{}
+		}
+	}
+	for _, name := range ir.ObjectNames() {
+		add(Node{"object", name})
+		for _, f := range ir.Objects[name].Fields {
+			add(Node{"field", name + "." + f.Name})
+		}
+	}
+	for _, name := range ir.RelationNames() {
+		add(Node{"relation", name})
+	}
+	for _, name := range ir.RuleNames() {
+		add(Node{"rule", name})
+	}
+	for _, t := range ir.Tests {
+		add(Node{"test", t.Name})
+	}
+
+	for name, o := range ir.Objects {
+		g.fields(Node{"object", name}, o.Fields)
+		for _, f := range o.Fields {
+			field := Node{"field", name + "." + f.Name}
+			g.edge(field, Node{"object", name})
+			g.fields(field, []model.Field{f})
+		}
+	}
+	for name, r := range ir.Relations {
+		g.fields(Node{"relation", name}, r.Fields)
+	}
+	for name, r := range ir.Rules {
+		c := &clause{g: g, ir: ir, from: Node{"rule", name}}
+		for _, body := range r.Clauses() {
+			c.scope = map[string]string{}
+			for _, a := range r.Args {
+				c.node(a)
+				if a.TypeInfo == model.OBJECT {
+					c.scope[a.Value.(string)] = a.ObjectName()
+				}
+			}
+			for _, e := range body {
+				c.condition(e)
+			}
+		}
+	}
+	for _, t := range ir.Tests {
+		c := &clause{g: g, ir: ir, from: Node{"test", t.Name}, scope: map[string]string{}}
+		for _, e := range t.Facts {
+			c.fact(e)
+		}
+		for _, e := range t.Body {
+			c.condition(e)
+		}
+	}
+	return g
+}
+
+func (g *Graph) edge(from, to Node) {
+	if _, ok := g.uses[to]; ok {
+		g.uses[from][to] = true
+	}
+}
+
+func (g *Graph) fields(from Node, fields []model.Field) {
+	for _, f := range fields {
+		if f.TypeInfo == model.OBJECT {
+			g.edge(from, Node{"object", f.ObjectName()})
+		}
+	}
+}
+
+// clause adds the edges from a rule body or test, knowing
+// the object type of the variables in scope
+type clause struct {
+	g     *Graph
+	ir    model.InternalRepresentation
+	from  Node
+	scope map[string]string
+}
+
+func (c *clause) fact(e model.Expression) {
+	if e.Functor != "new" {
+		c.node(e)
+		return
+	}
+	o := e.Args[0].(model.Term)
+	c.node(o)
+	c.scope[o.Value.(string)] = o.ObjectName()
+	for _, n := range e.Args[1:] {
+		c.g.edge(c.from, Node{"field", o.ObjectName() + "." + n.(model.Term).FieldName()})
+	}
+}
+
+func (c *clause) condition(e model.Expression) {
+	if e.Functor != "let" {
+		c.node(e)
+		return
+	}
+	v := e.Args[0].(model.Term)
+	if len(e.Args) == 1 {
+		c.node(v)
+		if v.TypeInfo == model.OBJECT {
+			c.scope[v.Value.(string)] = v.ObjectName()
+		}
+		return
+	}
+	c.node(e.Args[1])
+	if name := c.ir.ObjectOf(e.Args[1], c.scope); name != "" {
+		c.scope[v.Value.(string)] = name
+	}
+}
+
+func (c *clause) node(n model.Node) {
+	switch n := n.(type) {
+	case model.Term:
+		if n.TypeInfo == model.OBJECT {
+			c.g.edge(c.from, Node{"object", n.ObjectName()})
+		}
+	case model.Expression:
+		if _, ok := c.ir.Rules[n.Functor]; ok {
+			c.g.edge(c.from, Node{"rule", n.Functor})
+		}
+		if _, ok := c.ir.Relations[n.Functor]; ok {
+			c.g.edge(c.from, Node{"relation", n.Functor})
+		}
+		if n.Functor == "." {
+			if object := c.ir.ObjectOf(n.Args[0], c.scope); object != "" {
+				c.g.edge(c.from, Node{"field", object + "." + n.Args[1].(model.Term).Value.(string)})
+			}
+		}
+		for _, a := range n.Args {
+			c.node(a)
+		}
+	}
+}
+
+// Uses returns the declarations n uses directly, sorted
+func (g *Graph) Uses(n Node) []Node {
+	return sorted(g.uses[n])
+}
+
+// DependsOn returns the declarations n uses,
+// directly or through others
+func (g *Graph) DependsOn(n Node) map[Node]bool {
+	deps := map[Node]bool{}
+	var visit func(n Node)
+	visit = func(n Node) {
+		for m := range g.uses[n] {
+			if !deps[m] {
+				deps[m] = true
+				visit(m)
+			}
+		}
+	}
+	visit(n)
+	return deps
+}
+
+// Dependents returns the declarations that use n,
+// directly or through others
+func (g *Graph) Dependents(n Node) map[Node]bool {
+	dependents := map[Node]bool{}
+	for _, m := range g.Nodes {
+		if g.DependsOn(m)[n] {
+			dependents[m] = true
+		}
+	}
+	return dependents
+}
+
+// Lookup returns the declarations named name, which
+// can be an object and a rule or relation at once
+func (g *Graph) Lookup(name string) []Node {
+	nodes := []Node{}
+	for _, n := range g.Nodes {
+		if n.Name == name {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// Affected returns the tests of new that are among changed or
+// depend on one of them, in new or as they were in old, so a test
+// calling a rule that was removed is affected too. The tests are
+// in declaration order.
+func Affected(old, new model.InternalRepresentation, changed map[Node]bool) []Node {
+	before, after := Build(old), Build(new)
+	tests := []Node{}
+	for _, n := range after.Nodes {
+		if n.Kind == "test" && (changed[n] || after.reaches(n, changed) || before.reaches(n, changed)) {
+			tests = append(tests, n)
+		}
+	}
+	return tests
+}
+
+// reaches reports whether n depends on any of nodes
+func (g *Graph) reaches(n Node, nodes map[Node]bool) bool {
+	for m := range g.DependsOn(n) {
+		if nodes[m] {
+			return true
+		}
+	}
+	return false
+}
+
+// Changed returns the declarations that differ between two
+// versions of a rulebase, including those added or removed
+func Changed(old, new model.InternalRepresentation) map[Node]bool {
+	changed := map[Node]bool{}
+	a, b := declarations(old), declarations(new)
+	for n, d := range a {
+		if !reflect.DeepEqual(d, b[n]) {
+			changed[n] = true
+		}
+	}
+	for n := range b {
+		if _, ok := a[n]; !ok {
+			changed[n] = true
+		}
+	}
+	return changed
+}
+
+// declarations returns the declarations of ir by node,
+// tests by the same name together
+func declarations(ir model.InternalRepresentation) map[Node]interface{} {
+	decls := map[Node]interface{}{}
+	for name, o := range ir.Objects {
+		decls[Node{"object", name}] = o
+		for _, f := range o.Fields {
+			decls[Node{"field", name + "." + f.Name}] = f
+		}
+	}
+	for name, r := range ir.Relations {
+		decls[Node{"relation", name}] = r
+	}
+	for name, r := range ir.Rules {
+		decls[Node{"rule", name}] = r
+	}
+	tests := map[string][]model.Test{}
+	for _, t := range ir.Tests {
+		tests[t.Name] = append(tests[t.Name], t)
+	}
+	for name, t := range tests {
+		decls[Node{"test", name}] = t
+	}
+	return decls
+}
+
+func sorted(set map[Node]bool) []Node {
+	nodes := []Node{}
+	for n := range set {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Kind != nodes[j].Kind {
+			return nodes[i].Kind < nodes[j].Kind
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
@@ -0,0 +1,257 @@
+package deps
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"model"
+)
+
+const src = `object cell {
+	number : int
+}
+
+object prisoner {
+	age  : int,
+	cell : cell
+}
+
+relation cellmates {
+	p        : prisoner,
+	cellmate : prisoner
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+rule adultCellmate {
+	input {
+		p : prisoner
+	}
+	rules {
+		let c : prisoner,
+		cellmates(p, c),
+		adult(c)
+	}
+}
+
+rule numbered {
+	input {
+		c : cell
+	}
+	rules {
+		c.number > 0
+	}
+}
+
+test "Adult" {
+	facts {
+		p : prisoner { age : 23 }
+	}
+	rules {
+		adult(p)
+	}
+}
+
+test "Adult cellmate" {
+	facts {
+		p : prisoner { age : 15 },
+		q : prisoner { age : 23 },
+		cellmates(p, q)
+	}
+	rules {
+		adultCellmate(p)
+	}
+}
+
+test "Cell" {
+	facts {
+		c : cell { number : 1 }
+	}
+	rules {
+		numbered(c)
+	}
+}
+`
+
+func TestUses(t *testing.T) {
+	g := Build(model.Read(src))
+	for i, tt := range []struct {
+		node Node
+		want []Node
+	}{
+		{Node{"object", "cell"}, []Node{}},
+		{Node{"object", "prisoner"}, []Node{{"object", "cell"}}},
+		{Node{"field", "prisoner.cell"}, []Node{{"object", "cell"}, {"object", "prisoner"}}},
+		{Node{"relation", "cellmates"}, []Node{{"object", "prisoner"}}},
+		{Node{"rule", "adult"}, []Node{{"field", "prisoner.age"}, {"object", "prisoner"}}},
+		{Node{"rule", "adultCellmate"}, []Node{{"object", "prisoner"}, {"relation", "cellmates"}, {"rule", "adult"}}},
+		{Node{"test", "Adult cellmate"}, []Node{{"field", "prisoner.age"}, {"object", "prisoner"}, {"relation", "cellmates"}, {"rule", "adultCellmate"}}},
+	} {
+		if got := g.Uses(tt.node); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+	}
+	want := []Node{
+		{"object", "cell"}, {"field", "cell.number"},
+		{"object", "prisoner"}, {"field", "prisoner.age"}, {"field", "prisoner.cell"},
+		{"relation", "cellmates"},
+		{"rule", "adult"}, {"rule", "adultCellmate"}, {"rule", "numbered"},
+		{"test", "Adult"}, {"test", "Adult cellmate"}, {"test", "Cell"},
+	}
+	if !reflect.DeepEqual(g.Nodes, want) {
+		t.Errorf("got %v want %v", g.Nodes, want)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	g := Build(model.Read(src))
+	for i, tt := range []struct {
+		node Node
+		want map[Node]bool
+	}{
+		{Node{"field", "prisoner.age"}, map[Node]bool{
+			{"rule", "adult"}: true, {"rule", "adultCellmate"}: true,
+			{"test", "Adult"}: true, {"test", "Adult cellmate"}: true,
+		}},
+		{Node{"field", "cell.number"}, map[Node]bool{
+			{"rule", "numbered"}: true, {"test", "Cell"}: true,
+		}},
+		{Node{"rule", "adultCellmate"}, map[Node]bool{
+			{"test", "Adult cellmate"}: true,
+		}},
+		{Node{"test", "Cell"}, map[Node]bool{}},
+	} {
+		if got := g.Dependents(tt.node); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	g := Build(model.Read(`object prisoner {
+	age : int
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+test "Adult\minor" {
+	facts {
+		p : prisoner { age : 23 }
+	}
+	rules {
+		adult(p)
+	}
+}
+`))
+	var b strings.Builder
+	if err := g.DOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph dependencies {
+	rankdir=LR;
+	"object prisoner" [label="prisoner", shape=box];
+	"field prisoner.age" [label="prisoner.age", shape=plaintext];
+	"rule adult" [label="adult", shape=ellipse];
+	"test Adult\\minor" [label="Adult\\minor", shape=note];
+	"field prisoner.age" -> "object prisoner";
+	"rule adult" -> "field prisoner.age";
+	"rule adult" -> "object prisoner";
+	"test Adult\\minor" -> "field prisoner.age";
+	"test Adult\\minor" -> "object prisoner";
+	"test Adult\\minor" -> "rule adult";
+}
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	js, err := g.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Nodes []struct{ ID, Kind, Name string }
+		Edges []struct{ From, To string }
+	}
+	if err := json.Unmarshal(js, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Nodes) != 4 || got.Nodes[1].ID != "field prisoner.age" || got.Nodes[1].Kind != "field" || got.Nodes[1].Name != "prisoner.age" {
+		t.Errorf("got nodes %+v", got.Nodes)
+	}
+	if len(got.Edges) != 6 || got.Edges[1].From != "rule adult" || got.Edges[1].To != "field prisoner.age" {
+		t.Errorf("got edges %+v", got.Edges)
+	}
+}
+
+func TestAffected(t *testing.T) {
+	for i, tt := range []struct {
+		old, new string
+		changed  map[Node]bool
+		want     []Node
+	}{
+		{
+			old:     src,
+			new:     src,
+			changed: map[Node]bool{},
+			want:    []Node{},
+		},
+		{
+			old:     src,
+			new:     strings.Replace(src, "p.age >= 18", "p.age > 18", 1),
+			changed: map[Node]bool{{"rule", "adult"}: true},
+			want:    []Node{{"test", "Adult"}, {"test", "Adult cellmate"}},
+		},
+		{
+			old:     src,
+			new:     strings.Replace(src, "number : int\n", "number : int,\n\tfloor : int\n", 1),
+			changed: map[Node]bool{{"object", "cell"}: true, {"field", "cell.floor"}: true},
+			want:    []Node{{"test", "Adult"}, {"test", "Adult cellmate"}, {"test", "Cell"}},
+		},
+		{
+			old:     src,
+			new:     strings.Replace(src, "number : 1", "number : 2", 1),
+			changed: map[Node]bool{{"test", "Cell"}: true},
+			want:    []Node{{"test", "Cell"}},
+		},
+		// a test calling a rule that was removed
+		{
+			old:     src,
+			new:     src[:strings.Index(src, "rule adult {")] + src[strings.Index(src, "rule adultCellmate"):],
+			changed: map[Node]bool{{"rule", "adult"}: true},
+			want:    []Node{{"test", "Adult"}, {"test", "Adult cellmate"}},
+		},
+		// and one calling a rule that was added
+		{
+			old:     src[:strings.Index(src, "rule adult {")] + src[strings.Index(src, "rule adultCellmate"):],
+			new:     src,
+			changed: map[Node]bool{{"rule", "adult"}: true},
+			want:    []Node{{"test", "Adult"}, {"test", "Adult cellmate"}},
+		},
+	} {
+		old, new := model.Read(tt.old), model.Read(tt.new)
+		changed := Changed(old, new)
+		if !reflect.DeepEqual(changed, tt.changed) {
+			t.Errorf("%d): got %v want %v", i, changed, tt.changed)
+		}
+		if got := Affected(old, new, changed); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+	}
+}
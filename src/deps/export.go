@@ -0,0 +1,67 @@
+package deps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// shapes of the kinds of nodes in Graphviz
+var shapes = map[string]string{
+	"object":   "box",
+	"field":    "plaintext",
+	"relation": "diamond",
+	"rule":     "ellipse",
+	"test":     "note",
+}
+
+// DOT writes the graph in the Graphviz DOT language, with
+// an edge from each declaration to those it uses
+func (g *Graph) DOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "\t%s [label=%s, shape=%s];\n", dotID(n.String()), dotID(n.Name), shapes[n.Kind])
+	}
+	for _, n := range g.Nodes {
+		for _, m := range g.Uses(n) {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", dotID(n.String()), dotID(m.String()))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotID quotes s as a DOT identifier
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+type jsonNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type jsonEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// JSON returns the graph as an indented JSON object with its
+// nodes, identified by kind and name, and its edges
+func (g *Graph) JSON() ([]byte, error) {
+	graph := struct {
+		Nodes []jsonNode `json:"nodes"`
+		Edges []jsonEdge `json:"edges"`
+	}{[]jsonNode{}, []jsonEdge{}}
+	for _, n := range g.Nodes {
+		graph.Nodes = append(graph.Nodes, jsonNode{n.String(), n.Kind, n.Name})
+		for _, m := range g.Uses(n) {
+			graph.Edges = append(graph.Edges, jsonEdge{n.String(), m.String()})
+		}
+	}
+	return json.MarshalIndent(graph, "", "  ")
+}
@@ -0,0 +1,388 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"arith"
+)
+
+// Var is an integer whose value is not known, such as a field left
+// out of the facts of a query. Comparing it posts a constraint to a
+// finite domain store instead, as clpfd does, and the constraints
+// left when the query holds tell for which values it does.
+type Var struct {
+	Name string
+}
+
+func (v *Var) String() string {
+	return v.Name
+}
+
+// linear is the sum of vars times their coefficient, plus c
+type linear struct {
+	coefs map[*Var]int
+	c     int
+}
+
+func symbolic(v interface{}) bool {
+	switch v.(type) {
+	case *Var, linear:
+		return true
+	}
+	return false
+}
+
+func toLinear(v interface{}) (linear, bool) {
+	switch x := v.(type) {
+	case int:
+		return linear{coefs: map[*Var]int{}, c: x}, true
+	case *Var:
+		return linear{coefs: map[*Var]int{x: 1}}, true
+	case linear:
+		return x, true
+	}
+	return linear{}, false
+}
+
+// simplify returns a linear without vars as an int,
+// and a single var as itself
+func (l linear) simplify() interface{} {
+	switch {
+	case len(l.coefs) == 0:
+		return l.c
+	case len(l.coefs) == 1 && l.c == 0:
+		for v, a := range l.coefs {
+			if a == 1 {
+				return v
+			}
+		}
+	}
+	return l
+}
+
+func (l linear) scale(k int) linear {
+	return linear{coefs: arith.Scale(l.coefs, k), c: l.c * k}
+}
+
+func (l linear) add(o linear) linear {
+	return linear{coefs: arith.Add(l.coefs, o.coefs), c: l.c + o.c}
+}
+
+// vars returns the vars of l by name
+func (l linear) vars() []*Var {
+	vars := []*Var{}
+	for v := range l.coefs {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// linearArithmetic is arithmetic on unknown integers,
+// which stays linear: only + and -, and * by a number
+func linearArithmetic(op string, a, b interface{}) (interface{}, error) {
+	la, ok := toLinear(a)
+	lb, ok2 := toLinear(b)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("invalid operation %v %s %v on an unknown integer", a, op, b)
+	}
+	switch {
+	case op == "+":
+		return la.add(lb).simplify(), nil
+	case op == "-":
+		return la.add(lb.scale(-1)).simplify(), nil
+	case op == "*" && len(la.coefs) == 0:
+		return lb.scale(la.c).simplify(), nil
+	case op == "*" && len(lb.coefs) == 0:
+		return la.scale(lb.c).simplify(), nil
+	}
+	return nil, fmt.Errorf("cannot constrain %v %s %v, only sums and multiples of unknown integers", a, op, b)
+}
+
+// bounds of a domain beyond which it is unbounded,
+// well within int after multiplying by a coefficient
+const inf = 1 << 50
+
+// bounded reports whether the numbers in l are within the
+// domains, a bound beyond them would be taken as no bound
+func (l linear) bounded() bool {
+	if l.c <= -inf || l.c >= inf {
+		return false
+	}
+	for _, a := range l.coefs {
+		if a <= -inf || a >= inf {
+			return false
+		}
+	}
+	return true
+}
+
+type interval struct {
+	lo, hi int
+}
+
+// constraint is l op 0, op is <=, = or !=
+type constraint struct {
+	l  linear
+	op string
+}
+
+// store holds the domains of the vars constrained so far and the
+// constraints on more than one var. It is copied on each change,
+// so backtracking drops the copy like it drops an env.
+type store struct {
+	vars        []*Var
+	domains     map[*Var]interval
+	constraints []constraint
+}
+
+// the store is kept in the env under a name no identifier has
+const storeKey = "#store"
+
+func (e env) store() *store {
+	if s, ok := e[storeKey].(*store); ok {
+		return s
+	}
+	return &store{domains: map[*Var]interval{}}
+}
+
+// constrain posts left op right, and reports whether
+// the constraints can still hold together
+func (e env) constrain(op string, left, right interface{}) (env, bool, error) {
+	l, ok := toLinear(left)
+	r, ok2 := toLinear(right)
+	if !ok || !ok2 {
+		return e, false, fmt.Errorf("cannot compare unknown integer with %v %s %v", left, op, right)
+	}
+	d := l.add(r.scale(-1))
+	if !d.bounded() {
+		return e, false, fmt.Errorf("cannot constrain %v %s %v, numbers beyond ±2^50 are out of range", left, op, right)
+	}
+	var c constraint
+	switch op {
+	case "==":
+		c = constraint{d, "="}
+	case "!=":
+		c = constraint{d, "!="}
+	case "<":
+		d.c++
+		c = constraint{d, "<="}
+	case "<=":
+		c = constraint{d, "<="}
+	case ">":
+		d = d.scale(-1)
+		d.c++
+		c = constraint{d, "<="}
+	default:
+		c = constraint{d.scale(-1), "<="}
+	}
+	s, ok := e.store().post(c)
+	if !ok {
+		return e, false, nil
+	}
+	return e.bind(storeKey, s), true, nil
+}
+
+func (s *store) post(c constraint) (*store, bool) {
+	n := &store{
+		vars:        append([]*Var{}, s.vars...),
+		domains:     map[*Var]interval{},
+		constraints: append(append([]constraint{}, s.constraints...), c),
+	}
+	for v, d := range s.domains {
+		n.domains[v] = d
+	}
+	for _, v := range c.l.vars() {
+		if _, ok := n.domains[v]; !ok {
+			n.vars = append(n.vars, v)
+			n.domains[v] = interval{-inf, inf}
+		}
+	}
+	return n, n.propagate()
+}
+
+// propagate narrows the domains to the bounds each constraint
+// allows given the others, until nothing changes
+func (s *store) propagate() bool {
+	for changed := true; changed; {
+		changed = false
+		for _, c := range s.constraints {
+			ok, narrowed := s.narrow(c)
+			if !ok {
+				return false
+			}
+			changed = changed || narrowed
+		}
+	}
+	return true
+}
+
+func (s *store) narrow(c constraint) (ok, narrowed bool) {
+	switch c.op {
+	case "<=":
+		return s.narrowLeq(c.l)
+	case "=":
+		ok, a := s.narrowLeq(c.l)
+		if !ok {
+			return false, false
+		}
+		ok, b := s.narrowLeq(c.l.scale(-1))
+		return ok, a || b
+	}
+	// != prunes a var whose value would make it equal,
+	// once the others are fixed, if that value is a bound
+	var free *Var
+	rest := c.l.c
+	for _, v := range c.l.vars() {
+		d := s.domains[v]
+		if d.lo != d.hi {
+			if free != nil {
+				return true, false
+			}
+			free = v
+			continue
+		}
+		rest += c.l.coefs[v] * d.lo
+	}
+	if free == nil {
+		return rest != 0, false
+	}
+	a := c.l.coefs[free]
+	if rest%a != 0 {
+		return true, false
+	}
+	d := s.domains[free]
+	switch -rest / a {
+	case d.lo:
+		d.lo++
+	case d.hi:
+		d.hi--
+	default:
+		return true, false
+	}
+	s.domains[free] = d
+	return d.lo <= d.hi, true
+}
+
+// narrowLeq narrows the domains by l <= 0: each var times its
+// coefficient is at most minus the least the rest can be
+func (s *store) narrowLeq(l linear) (ok, narrowed bool) {
+	for _, v := range l.vars() {
+		a := l.coefs[v]
+		rest, bounded := l.c, true
+		for _, w := range l.vars() {
+			if w == v {
+				continue
+			}
+			m, ok := s.least(w, l.coefs[w])
+			rest += m
+			bounded = bounded && ok
+		}
+		if !bounded {
+			continue
+		}
+		d := s.domains[v]
+		if a > 0 {
+			if hi := arith.FloorDiv(-rest, a); hi < d.hi {
+				d.hi, narrowed = hi, true
+			}
+		} else if lo := arith.CeilDiv(-rest, a); lo > d.lo {
+			d.lo, narrowed = lo, true
+		}
+		s.domains[v] = d
+		if d.lo > d.hi {
+			return false, narrowed
+		}
+	}
+	return true, narrowed
+}
+
+// least returns the least value of a times v, if it has one
+func (s *store) least(v *Var, a int) (int, bool) {
+	d := s.domains[v]
+	if a > 0 {
+		return a * d.lo, d.lo > -inf
+	}
+	return a * d.hi, d.hi < inf
+}
+
+// residual returns the constraints left: the domain of each var,
+// then those on more than one var that the domains do not imply
+func (s *store) residual() []string {
+	residual := []string{}
+	for _, v := range s.vars {
+		d := s.domains[v]
+		switch {
+		case d.lo == d.hi:
+			residual = append(residual, fmt.Sprintf("%s = %d", v, d.lo))
+			continue
+		case d.lo > -inf:
+			residual = append(residual, fmt.Sprintf("%s >= %d", v, d.lo))
+		}
+		if d.hi < inf {
+			residual = append(residual, fmt.Sprintf("%s <= %d", v, d.hi))
+		}
+	}
+	for _, c := range s.constraints {
+		if !s.entailed(c) {
+			residual = append(residual, c.String())
+		}
+	}
+	return residual
+}
+
+// entailed reports whether the domains imply c, which they do
+// for the bounds of a single var
+func (s *store) entailed(c constraint) bool {
+	vars := c.l.vars()
+	if len(vars) == 1 && c.op != "!=" {
+		return true
+	}
+	least, greatest := c.l.c, c.l.c
+	for _, v := range vars {
+		m, ok := s.least(v, c.l.coefs[v])
+		n, ok2 := s.least(v, -c.l.coefs[v])
+		if !ok || !ok2 {
+			return false
+		}
+		least += m
+		greatest -= n
+	}
+	switch c.op {
+	case "<=":
+		return greatest <= 0
+	case "=":
+		return least == 0 && greatest == 0
+	}
+	return least > 0 || greatest < 0
+}
+
+// String prints c with the constant on the right, and the
+// vars on the left with a positive coefficient first
+func (c constraint) String() string {
+	l, op := c.l, c.op
+	vars := l.vars()
+	if len(vars) > 0 && l.coefs[vars[0]] < 0 {
+		l = l.scale(-1)
+		op = map[string]string{"<=": ">=", "=": "=", "!=": "!="}[op]
+	}
+	var b strings.Builder
+	for i, v := range vars {
+		a := l.coefs[v]
+		switch {
+		case i > 0 && a < 0:
+			b.WriteString(" - ")
+			a = -a
+		case i > 0:
+			b.WriteString(" + ")
+		}
+		if a != 1 {
+			b.WriteString(strconv.Itoa(a) + " * ")
+		}
+		b.WriteString(v.Name)
+	}
+	return fmt.Sprintf("%s %s %d", b.String(), op, -l.c)
+}
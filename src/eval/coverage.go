@@ -0,0 +1,69 @@
+package eval
+
+import (
+	. "model"
+)
+
+// Coverage counts how often each rule was called and held, and
+// how often each condition in its bodies held or failed
+type Coverage struct {
+	Rules map[string]*RuleCoverage
+}
+
+type RuleCoverage struct {
+	Calls, Held int
+	// per body, per condition
+	Conditions [][]*ConditionCoverage
+}
+
+// a condition holds if it has at least one solution,
+// and fails if it has none
+type ConditionCoverage struct {
+	True, False int
+}
+
+// Cover starts collecting coverage of the rules
+// over the tests and queries run from now on
+func (in *Interpreter) Cover() *Coverage {
+	in.coverage = &Coverage{Rules: map[string]*RuleCoverage{}}
+	return in.coverage
+}
+
+func (c *Coverage) rule(r Rule) *RuleCoverage {
+	if c == nil {
+		return nil
+	}
+	rc, ok := c.Rules[r.Name]
+	if ok {
+		return rc
+	}
+	rc = &RuleCoverage{}
+	for _, body := range r.Clauses() {
+		conditions := make([]*ConditionCoverage, len(body))
+		for i := range conditions {
+			conditions[i] = &ConditionCoverage{}
+		}
+		rc.Conditions = append(rc.Conditions, conditions)
+	}
+	c.Rules[r.Name] = rc
+	return rc
+}
+
+// solveCovered is solve counting for each goal whether it held
+func (m *machine) solveCovered(conditions []*ConditionCoverage, goals []Expression, e env, k continuation) (bool, error) {
+	if len(goals) == 0 {
+		return k(e)
+	}
+	held := false
+	done, err := m.goal(goals[0], e, func(e env) (bool, error) {
+		if !held {
+			held = true
+			conditions[0].True++
+		}
+		return m.solveCovered(conditions[1:], goals[1:], e, k)
+	})
+	if !held && err == nil {
+		conditions[0].False++
+	}
+	return done, err
+}
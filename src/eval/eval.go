@@ -0,0 +1,207 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"backend"
+	. "model"
+)
+
+// Interpreter evaluates a rulebase directly over Go values,
+// without generating Prolog. It follows the semantics of the
+// generated Prolog: rule bodies are conjunctions searched depth
+// first, relations bind variables declared with let x : type,
+// and alternative bodies are tried in order.
+type Interpreter struct {
+	ir       *InternalRepresentation
+	today    time.Time
+	coverage *Coverage
+}
+
+var _ backend.Backend = &Interpreter{}
+
+func New() *Interpreter {
+	return &Interpreter{}
+}
+
+// Facts holds the facts of each relation, in the order
+// they were asserted
+type Facts map[string][][]interface{}
+
+func (in *Interpreter) Load(ir InternalRepresentation) error {
+	in.ir = &ir
+	in.today = Today()
+	return nil
+}
+
+func (in *Interpreter) RunTests() ([]backend.TestResult, error) {
+	if in.ir == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	results := []backend.TestResult{}
+	for _, t := range in.ir.Tests {
+		passed, err := in.run(t.Facts, t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("test %q: %s", t.Name, err)
+		}
+		results = append(results, backend.NewTestResult(t, passed))
+	}
+	return results, nil
+}
+
+func (in *Interpreter) Query(facts []Expression, goal Expression) (bool, error) {
+	if in.ir == nil {
+		return false, backend.ErrNotLoaded
+	}
+	return in.run(facts, []Expression{goal})
+}
+
+// Holds reports whether rule holds for args given the facts
+// of each relation. Objects are passed as instances.
+func (in *Interpreter) Holds(facts Facts, rule string, args ...interface{}) (bool, error) {
+	if in.ir == nil {
+		return false, backend.ErrNotLoaded
+	}
+	m := &machine{ir: in.ir, today: in.today, facts: facts, coverage: in.coverage}
+	e := env{}
+	goal := Expression{Functor: rule, Args: make([]Node, len(args))}
+	for i, v := range args {
+		name := fmt.Sprintf("arg%d", i)
+		e = e.bind(name, v)
+		goal.Args[i] = IdentifierTerm(name)
+	}
+	return m.solve([]Expression{goal}, e, func(env) (bool, error) {
+		return true, nil
+	})
+}
+
+// Solve reports whether goals hold together given the facts of
+// each relation, identifiers take their value from bindings
+func (in *Interpreter) Solve(facts Facts, goals []Expression, bindings map[string]interface{}) (bool, error) {
+	if in.ir == nil {
+		return false, backend.ErrNotLoaded
+	}
+	m := &machine{ir: in.ir, today: in.today, facts: facts, coverage: in.coverage}
+	return m.solve(goals, env(bindings), func(env) (bool, error) {
+		return true, nil
+	})
+}
+
+// Value evaluates n, identifiers take their value from bindings
+func (in *Interpreter) Value(n Node, bindings map[string]interface{}) (interface{}, error) {
+	if in.ir == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	m := &machine{ir: in.ir, today: in.today, facts: Facts{}}
+	return m.value(n, env(bindings))
+}
+
+// NewInstance instantiates object with field values by name,
+// fields left out are unbound
+func NewInstance(object Object, fields map[string]interface{}) *Instance {
+	values := make([]interface{}, len(object.Fields))
+	for i, f := range object.Fields {
+		values[i] = fields[f.Name]
+	}
+	return &Instance{Object: object.Name, Fields: values}
+}
+
+// run instantiates the facts as a test does and reports
+// whether all goals hold together
+func (in *Interpreter) run(facts, goals []Expression) (bool, error) {
+	m := &machine{ir: in.ir, today: in.today, facts: Facts{}, coverage: in.coverage}
+	e, err := m.assert(facts, false)
+	if err != nil {
+		return false, err
+	}
+	return m.solve(goals, e, func(env) (bool, error) {
+		return true, nil
+	})
+}
+
+// Answer is a way a query holds: the constraints on the integer
+// fields its facts leave out, such as p.age >= 18. Without
+// constraints the query holds whatever their values.
+type Answer struct {
+	Constraints []string
+}
+
+// answers are collected up to this many
+const maxAnswers = 100
+
+// Answers runs goals given facts like Query, but integer fields
+// the facts leave out are unknown rather than unbound: comparing
+// them constrains them, so rules can be run backwards to tell for
+// which values they hold. There is an answer for each distinct
+// way the goals hold, none if they cannot.
+func (in *Interpreter) Answers(facts, goals []Expression) ([]Answer, error) {
+	if in.ir == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	m := &machine{ir: in.ir, today: in.today, facts: Facts{}}
+	e, err := m.assert(facts, true)
+	if err != nil {
+		return nil, err
+	}
+	answers := []Answer{}
+	seen := map[string]bool{}
+	_, err = m.solve(goals, e, func(e env) (bool, error) {
+		a := Answer{Constraints: e.store().residual()}
+		if key := strings.Join(a.Constraints, "\n"); !seen[key] {
+			seen[key] = true
+			answers = append(answers, a)
+		}
+		return len(answers) == maxAnswers, nil
+	})
+	return answers, err
+}
+
+// assert instantiates objects and asserts relation facts, integer
+// fields left out are unknown if asked for
+func (m *machine) assert(facts []Expression, unknown bool) (env, error) {
+	e := env{}
+	for _, f := range facts {
+		if f.Functor == "new" {
+			o := f.Args[0].(Term)
+			i := m.instantiate(o, f.Args[1:], e)
+			for j, field := range m.ir.Objects[i.Object].Fields {
+				if unknown && i.Fields[j] == nil && field.TypeInfo == INT {
+					i.Fields[j] = &Var{Name: o.Value.(string) + "." + field.Name}
+				}
+			}
+			e = e.bind(o.Value.(string), i)
+			continue
+		}
+		args := make([]interface{}, len(f.Args))
+		for i, n := range f.Args {
+			v, err := m.value(n, e)
+			if err != nil {
+				return e, err
+			}
+			args[i] = v
+		}
+		m.facts[f.Functor] = append(m.facts[f.Functor], args)
+	}
+	return e, nil
+}
+
+// object fields refer to objects instantiated earlier
+func (m *machine) instantiate(o Term, fields []Node, e env) *Instance {
+	object := m.ir.Objects[o.ObjectName()]
+	values := map[string]interface{}{}
+	for _, f := range object.Fields {
+		for _, n := range fields {
+			v := n.(Term)
+			if v.FieldName() != f.Name {
+				continue
+			}
+			values[f.Name] = v.Value
+			if f.TypeInfo == OBJECT {
+				values[f.Name] = e[v.Value.(string)]
+			}
+		}
+	}
+	return NewInstance(object, values)
+}
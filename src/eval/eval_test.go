@@ -0,0 +1,314 @@
+package eval
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend"
+	. "model"
+	"prolog"
+)
+
+// corpus returns the rulebases in testdata by file name.
+// Tests whose name ends in (fails) are expected not to hold.
+func corpus(t *testing.T) map[string]InternalRepresentation {
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no corpus found: %v", err)
+	}
+	rulebases := map[string]InternalRepresentation{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ir := Read(string(b))
+		if errs := Check(ir); len(errs) > 0 {
+			t.Fatalf("%s: %v", f, errs)
+		}
+		rulebases[filepath.Base(f)] = ir
+	}
+	return rulebases
+}
+
+func run(t *testing.T, b backend.Backend, ir InternalRepresentation) []backend.TestResult {
+	if err := b.Load(ir); err != nil {
+		t.Fatal(err)
+	}
+	results, err := b.RunTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestCorpus(t *testing.T) {
+	for name, ir := range corpus(t) {
+		for _, r := range run(t, New(), ir) {
+			if !r.OK() {
+				t.Errorf("%s: %q got %v want %v", name, r.Name, r.Passed, !r.ExpectFail)
+			}
+		}
+	}
+}
+
+// TestEnginesAgree runs the corpus on every engine
+func TestEnginesAgree(t *testing.T) {
+	engines := map[string]backend.Backend{"golog": prolog.NewGolog()}
+	if swi, err := prolog.NewSWI(); err == nil {
+		engines["swipl"] = swi
+	}
+	for name, ir := range corpus(t) {
+		want := run(t, New(), ir)
+		for engine, b := range engines {
+			if got := run(t, b, ir); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %s got %v, eval got %v", name, engine, got, want)
+			}
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ir := corpus(t)["prisoners.rules"]
+	query := Read(`test "query" {
+		facts {
+			p1 : prisoner { age : 17, name : jim, admitted : 2010-01-01 },
+			p2 : prisoner { age : 40, name : joe, admitted : 2010-01-01 },
+			cellmates(p1, p2)
+		}
+		rules {
+			hasRightToPhonecall(p1),
+			hasAdultCellmate(p1)
+		}
+	}`).Tests[0]
+
+	in := New()
+	if _, err := in.Query(query.Facts, query.Body[0]); err != backend.ErrNotLoaded {
+		t.Errorf("got %v want %v", err, backend.ErrNotLoaded)
+	}
+	in.Load(ir)
+	for i, want := range []bool{false, true} {
+		got, err := in.Query(query.Facts, query.Body[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%d): got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestRecursionLimit(t *testing.T) {
+	ir := Read(`
+		object person {
+			name : string
+		}
+		rule loop {
+			input {
+				p : person
+			}
+			rules {
+				loop(p)
+			}
+		}
+		test "loops" {
+			facts {
+				p : person { name : x }
+			}
+			rules {
+				loop(p)
+			}
+		}`)
+	in := New()
+	in.Load(ir)
+	_, err := in.RunTests()
+	if err == nil || !strings.Contains(err.Error(), "recursion deeper than") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	date := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, tt := range []struct {
+		a, b interface{}
+		want int
+	}{
+		{a: 1, b: 2, want: -1},
+		{a: 2.5, b: 2, want: 1},
+		{a: 2.0, b: 2, want: -1},
+		{a: 2, b: 2, want: 0},
+		{a: 100, b: "a", want: -1},
+		{a: "b", b: "a", want: 1},
+		{a: nil, b: 1, want: -1},
+		{a: 1, b: nil, want: 1},
+		{a: date, b: date.AddDate(0, 0, 1), want: -1},
+		{a: date, b: date, want: 0},
+		{a: Duration{Months: 6}, b: Duration{Days: 200}, want: 1},
+		{a: &Instance{Object: "p", Fields: []interface{}{1}}, b: &Instance{Object: "p", Fields: []interface{}{1}}, want: 0},
+		{a: &Instance{Object: "p", Fields: []interface{}{nil}}, b: &Instance{Object: "p", Fields: []interface{}{nil}}, want: 1},
+	} {
+		got := compare(tt.a, tt.b)
+		if got < 0 {
+			got = -1
+		} else if got > 0 {
+			got = 1
+		}
+		if got != tt.want {
+			t.Errorf("%d): got %#v want %#v", i, got, tt.want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	date := time.Date(2018, 1, 31, 0, 0, 0, 0, time.UTC)
+	for i, tt := range []struct {
+		op   string
+		a, b interface{}
+		want interface{}
+	}{
+		{op: "/", a: 7, b: 2, want: 3},
+		{op: "/", a: -7, b: 2, want: -3},
+		{op: "%", a: -10, b: 7, want: 4},
+		{op: "%", a: 10, b: -7, want: -4},
+		{op: "*", a: 2, b: 1.5, want: 3.0},
+		{op: "+", a: "a", b: "b", want: "ab"},
+		{op: "+", a: date, b: Duration{Months: 1}, want: time.Date(2018, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{op: "-", a: date, b: Duration{Days: 31}, want: time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC)},
+	} {
+		got, err := arithmetic(tt.op, tt.a, tt.b)
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %#v want %#v", i, got, tt.want)
+		}
+	}
+	if _, err := arithmetic("/", 1, 0); err == nil {
+		t.Errorf("expected division by zero")
+	}
+}
+
+func TestAnswers(t *testing.T) {
+	ir := Read(`
+		object prisoner {
+			age      : int,
+			sentence : int,
+			name     : string
+		}
+		relation cellmates {
+			p        : prisoner,
+			cellmate : prisoner
+		}
+		rule adult {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age >= 18
+			}
+		}
+		rule phonecall {
+			input {
+				p : prisoner
+			}
+			rules {
+				adult(p),
+				p.age + p.sentence < 40
+			}
+			rules {
+				p.name = "vip"
+			}
+		}
+		rule olderCellmate {
+			input {
+				p : prisoner
+			}
+			rules {
+				let c : prisoner,
+				cellmates(p, c),
+				c.age > p.age * 2
+			}
+		}`)
+	in := New()
+	in.Load(ir)
+	for i, tt := range []struct {
+		facts, goals string
+		want         [][]string
+	}{
+		{
+			facts: "p : prisoner { name : john }",
+			goals: "adult(p)",
+			want:  [][]string{{"p.age >= 18"}},
+		},
+		{
+			facts: "p : prisoner { age : 15 }",
+			goals: "adult(p)",
+			want:  [][]string{},
+		},
+		{
+			facts: "p : prisoner { age : 23 }",
+			goals: "adult(p)",
+			want:  [][]string{{}},
+		},
+		{
+			facts: "p : prisoner { name : john }",
+			goals: "adult(p), p.age < 18",
+			want:  [][]string{},
+		},
+		{
+			facts: "p : prisoner { name : john }",
+			goals: "adult(p), p.age <= 18",
+			want:  [][]string{{"p.age = 18"}},
+		},
+		// one answer per alternative
+		{
+			facts: "p : prisoner { name : vip }",
+			goals: "phonecall(p)",
+			want: [][]string{
+				{"p.age >= 18", "p.sentence <= 21", "p.age + p.sentence <= 39"},
+				{},
+			},
+		},
+		{
+			facts: "p : prisoner { name : john, sentence : 20 }",
+			goals: "phonecall(p)",
+			want:  [][]string{{"p.age >= 18", "p.age <= 19"}},
+		},
+		// facts unify with unknown fields
+		{
+			facts: "p : prisoner { age : 20 }, q : prisoner { name : q }, cellmates(p, q)",
+			goals: "olderCellmate(p)",
+			want:  [][]string{{"q.age >= 41"}},
+		},
+	} {
+		test := Read("test t { facts { " + tt.facts + " } rules { t(t) } }").Tests[0]
+		goals := Read("rule q { input { _ : int } rules { " + tt.goals + " } }").Rules["q"].Body
+		answers, err := in.Answers(test.Facts, goals)
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		got := [][]string{}
+		for _, a := range answers {
+			got = append(got, a.Constraints)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %q want %q", i, got, tt.want)
+		}
+	}
+
+	test := Read("test t { facts { p : prisoner { name : john } } rules { t(t) } }").Tests[0]
+	goals := Read("rule q { input { _ : int } rules { p.age * p.age > 4 } }").Rules["q"].Body
+	if _, err := in.Answers(test.Facts, goals); err == nil || !strings.Contains(err.Error(), "only sums and multiples") {
+		t.Errorf("got %v want an error on a nonlinear constraint", err)
+	}
+	goals = Read("rule q { input { _ : int } rules { p.age < 2000000000000000 } }").Rules["q"].Body
+	if _, err := in.Answers(test.Facts, goals); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("got %v want an error on a bound out of range", err)
+	}
+}
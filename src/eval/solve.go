@@ -0,0 +1,363 @@
+package eval
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	. "model"
+)
+
+// env maps identifiers to values. Binding copies the map,
+// so backtracking is just dropping the copy.
+type env map[string]interface{}
+
+func (e env) bind(name string, v interface{}) env {
+	n := make(env, len(e)+1)
+	for k, x := range e {
+		n[k] = x
+	}
+	n[name] = v
+	return n
+}
+
+func (e env) unbind(name string) env {
+	n := make(env, len(e))
+	for k, x := range e {
+		if k != name {
+			n[k] = x
+		}
+	}
+	return n
+}
+
+// a continuation is called for each way the goals so far hold,
+// returning true stops the search
+type continuation func(env) (bool, error)
+
+// recursion without a base case would otherwise overflow the stack
+const maxDepth = 10000
+
+type machine struct {
+	ir    *InternalRepresentation
+	today time.Time
+	facts Facts
+	depth int
+
+	// nil unless coverage is being collected
+	coverage *Coverage
+}
+
+func (m *machine) solve(goals []Expression, e env, k continuation) (bool, error) {
+	if len(goals) == 0 {
+		return k(e)
+	}
+	return m.goal(goals[0], e, func(e env) (bool, error) {
+		return m.solve(goals[1:], e, k)
+	})
+}
+
+// goal calls next for each way goal holds
+func (m *machine) goal(goal Expression, e env, next continuation) (bool, error) {
+	switch goal.Functor {
+	case "let":
+		name := goal.Args[0].(Term).Value.(string)
+		if len(goal.Args) == 1 {
+			return next(e.unbind(name))
+		}
+		v, err := m.value(goal.Args[1], e)
+		if err != nil {
+			return false, err
+		}
+		return next(e.bind(name, v))
+	case "startsWith", "contains", "matches":
+		holds, err := m.predicate(goal, e)
+		if err != nil || !holds {
+			return false, err
+		}
+		return next(e)
+	case "==", "!=", "<", "<=", ">", ">=":
+		e, holds, err := m.comparison(goal, e)
+		if err != nil || !holds {
+			return false, err
+		}
+		return next(e)
+	}
+	if r, ok := m.ir.Rules[goal.Functor]; ok {
+		return m.callRule(r, goal, e, next)
+	}
+	if _, ok := m.ir.Relations[goal.Functor]; ok {
+		return m.callRelation(goal, e, next)
+	}
+	return false, fmt.Errorf("undefined rule %s", goal.Functor)
+}
+
+// callArgs evaluates the arguments of a call. Identifiers
+// without a value are returned by name, to be bound by the call.
+func (m *machine) callArgs(args []Node, e env) ([]interface{}, []string, error) {
+	values := make([]interface{}, len(args))
+	unbound := make([]string, len(args))
+	for i, n := range args {
+		if t, ok := n.(Term); ok && (t.TypeInfo == IDENT || t.TypeInfo == OBJECT) {
+			if _, bound := e[t.Value.(string)]; !bound {
+				unbound[i] = t.Value.(string)
+				continue
+			}
+		}
+		v, err := m.value(n, e)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[i] = v
+	}
+	return values, unbound, nil
+}
+
+// each clause is solved in an env holding only the rule inputs,
+// unbound arguments are bound in the caller by the clause
+func (m *machine) callRule(r Rule, goal Expression, e env, next continuation) (bool, error) {
+	args, unbound, err := m.callArgs(goal.Args, e)
+	if err != nil {
+		return false, err
+	}
+	if m.depth >= maxDepth {
+		return false, fmt.Errorf("rule %s: recursion deeper than %d calls", r.Name, maxDepth)
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	rc := m.coverage.rule(r)
+	held := false
+	if rc != nil {
+		rc.Calls++
+		defer func() {
+			if held {
+				rc.Held++
+			}
+		}()
+	}
+
+	// errors from the caller's continuation are passed on as is
+	var callerErr error
+	for j, body := range r.Clauses() {
+		// constraints on unknown integers carry over
+		local := env{}
+		if s, ok := e[storeKey]; ok {
+			local[storeKey] = s
+		}
+		for i, a := range r.Args {
+			if unbound[i] == "" {
+				local[a.Value.(string)] = args[i]
+			}
+		}
+		solve := m.solve
+		if rc != nil {
+			solve = func(goals []Expression, e env, k continuation) (bool, error) {
+				return m.solveCovered(rc.Conditions[j], goals, e, k)
+			}
+		}
+		done, err := solve(body, local, func(local env) (bool, error) {
+			held = true
+			out := e
+			if s, ok := local[storeKey]; ok {
+				out = out.bind(storeKey, s)
+			}
+			for i, a := range r.Args {
+				if v, ok := local[a.Value.(string)]; ok && unbound[i] != "" {
+					out = out.bind(unbound[i], v)
+				}
+			}
+			done, err := next(out)
+			callerErr = err
+			return done, err
+		})
+		if err != nil && err == callerErr {
+			return false, err
+		}
+		if err != nil {
+			return false, fmt.Errorf("rule %s: %s", r.Name, err)
+		}
+		if done {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// facts are tried in the order they were asserted
+func (m *machine) callRelation(goal Expression, e env, next continuation) (bool, error) {
+	args, unbound, err := m.callArgs(goal.Args, e)
+	if err != nil {
+		return false, err
+	}
+	for _, fact := range m.facts[goal.Functor] {
+		if len(fact) != len(args) {
+			continue
+		}
+		bound, ok := e, true
+		for i, v := range fact {
+			if unbound[i] == "" {
+				bound, ok = bound.unify(args[i], v)
+			} else if w, seen := bound[unbound[i]]; seen {
+				// same variable twice in one call
+				bound, ok = bound.unify(w, v)
+			} else {
+				bound = bound.bind(unbound[i], v)
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if done, err := next(bound); done || err != nil {
+			return done, err
+		}
+	}
+	return false, nil
+}
+
+// comparison on an unknown integer holds if it can,
+// constraining the integer in the env returned
+func (m *machine) comparison(goal Expression, e env) (env, bool, error) {
+	left, err := m.value(goal.Args[0], e)
+	if err != nil {
+		return e, false, err
+	}
+	right, err := m.value(goal.Args[1], e)
+	if err != nil {
+		return e, false, err
+	}
+	if symbolic(left) || symbolic(right) {
+		return e.constrain(goal.Functor, left, right)
+	}
+	c := compare(left, right)
+	switch goal.Functor {
+	case "==":
+		return e, c == 0, nil
+	case "!=":
+		return e, c != 0, nil
+	case "<":
+		return e, c < 0, nil
+	case "<=":
+		return e, c <= 0, nil
+	case ">":
+		return e, c > 0, nil
+	}
+	return e, c >= 0, nil
+}
+
+func (m *machine) predicate(goal Expression, e env) (bool, error) {
+	args, err := m.stringArgs(goal, e)
+	if err != nil {
+		return false, err
+	}
+	switch goal.Functor {
+	case "startsWith":
+		return strings.HasPrefix(args[0], args[1]), nil
+	case "contains":
+		return strings.Contains(args[0], args[1]), nil
+	}
+	re, err := regexp.Compile(args[1])
+	if err != nil {
+		return false, nil
+	}
+	return re.MatchString(args[0]), nil
+}
+
+// stringArgs evaluates the arguments of a string builtin
+func (m *machine) stringArgs(goal Expression, e env) ([]string, error) {
+	args := make([]string, len(goal.Args))
+	for i, n := range goal.Args {
+		v, err := m.value(n, e)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d of %s should be a string, got %v", i+1, goal.Functor, v)
+		}
+		args[i] = s
+	}
+	return args, nil
+}
+
+func (m *machine) value(n Node, e env) (interface{}, error) {
+	switch v := n.(type) {
+	case Term:
+		if v.TypeInfo == IDENT || v.TypeInfo == OBJECT {
+			return e[v.Value.(string)], nil
+		}
+		return v.Value, nil
+	case Expression:
+		return m.expression(v, e)
+	}
+	return nil, fmt.Errorf("expected node to be term or expression")
+}
+
+func (m *machine) expression(x Expression, e env) (interface{}, error) {
+	switch x.Functor {
+	case "today":
+		return m.today, nil
+	case ".":
+		return m.field(x, e)
+	case "length", "lower", "concat":
+		args, err := m.stringArgs(x, e)
+		if err != nil {
+			return nil, err
+		}
+		switch x.Functor {
+		case "length":
+			return utf8.RuneCountInString(args[0]), nil
+		case "lower":
+			return lowerASCII(args[0]), nil
+		}
+		return args[0] + args[1], nil
+	case "+", "-", "*", "/", "%":
+		left, err := m.value(x.Args[0], e)
+		if err != nil {
+			return nil, err
+		}
+		right, err := m.value(x.Args[1], e)
+		if err != nil {
+			return nil, err
+		}
+		if symbolic(left) || symbolic(right) {
+			return linearArithmetic(x.Functor, left, right)
+		}
+		return arithmetic(x.Functor, left, right)
+	}
+	return nil, fmt.Errorf("%s does not evaluate to a value", x.Functor)
+}
+
+// a field of an unbound object is unbound
+func (m *machine) field(x Expression, e env) (interface{}, error) {
+	v, err := m.value(x.Args[0], e)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	i, ok := v.(*Instance)
+	if !ok {
+		return nil, fmt.Errorf("field access on non-object %v", v)
+	}
+	name := x.Args[1].(Term).Value.(string)
+	for j, f := range m.ir.Objects[i.Object].Fields {
+		if f.Name == name {
+			return i.Fields[j], nil
+		}
+	}
+	return nil, fmt.Errorf("object %s has no field %s", i.Object, name)
+}
+
+// like lower_atom in the Prolog prelude, only ASCII is mapped
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
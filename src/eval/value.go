@@ -0,0 +1,248 @@
+package eval
+
+import (
+	"fmt"
+	"time"
+
+	. "model"
+)
+
+// values are Go values: int, float64, string, time.Time,
+// model.Duration and *Instance. nil is an unbound value,
+// like a field left out when instantiating an object, and
+// *Var an integer field left out of the facts of a query.
+
+// Instance is an instantiated object
+type Instance struct {
+	Object string
+	Fields []interface{} // in the order of the object's fields
+}
+
+func (i *Instance) String() string {
+	return fmt.Sprintf("%s%v", i.Object, i.Fields)
+}
+
+// compare orders values like the standard order of terms in
+// Prolog, so both engines agree on comparisons: unbound values
+// come first, then numbers by value, then strings, then compound
+// terms (dates, durations and instances) by name and arguments.
+// Unbound values are never equal to anything.
+func compare(a, b interface{}) int {
+	if a == nil || b == nil {
+		if b != nil {
+			return -1
+		}
+		return 1
+	}
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch x := a.(type) {
+	case int, float64:
+		return compareNumbers(x, b)
+	case string:
+		y := b.(string)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return compareCompound(a, b)
+}
+
+func rank(v interface{}) int {
+	switch v.(type) {
+	case int, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// numbers compare by value; if equal a float comes before an int
+func compareNumbers(a, b interface{}) int {
+	fa, fb := toFloat(a), toFloat(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	_, ia := a.(int)
+	_, ib := b.(int)
+	switch {
+	case ia == ib:
+		return 0
+	case ia:
+		return 1
+	}
+	return -1
+}
+
+func toFloat(v interface{}) float64 {
+	if i, ok := v.(int); ok {
+		return float64(i)
+	}
+	return v.(float64)
+}
+
+// compound terms compare by arity, then name, then arguments
+func compareCompound(a, b interface{}) int {
+	if ia, ok := a.(*Instance); ok && ia == b {
+		return 0
+	}
+	na, aa := compound(a)
+	nb, ab := compound(b)
+	if len(aa) != len(ab) {
+		return len(aa) - len(ab)
+	}
+	switch {
+	case na < nb:
+		return -1
+	case na > nb:
+		return 1
+	}
+	for i := range aa {
+		if c := compare(aa[i], ab[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compound(v interface{}) (string, []interface{}) {
+	switch x := v.(type) {
+	case time.Time:
+		return "date", []interface{}{x.Year(), int(x.Month()), x.Day()}
+	case Duration:
+		return "duration", []interface{}{x.Years, x.Months, x.Days}
+	case *Instance:
+		return x.Object, x.Fields
+	case *Var:
+		return "var", []interface{}{x.Name}
+	}
+	panic(fmt.Sprintf("eval: unexpected value %#v", v))
+}
+
+// unify reports whether a fact argument matches a value,
+// an unbound value on either side matches anything.
+// Unlike Prolog, unbound fields of instances are not bound.
+func unify(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	ia, ok := a.(*Instance)
+	ib, ok2 := b.(*Instance)
+	if !ok || !ok2 {
+		return compare(a, b) == 0
+	}
+	if ia == ib {
+		return true
+	}
+	if ia.Object != ib.Object || len(ia.Fields) != len(ib.Fields) {
+		return false
+	}
+	for i := range ia.Fields {
+		if !unify(ia.Fields[i], ib.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// unify is unify for a fact argument in an env, where an unknown
+// integer unifies with a value by constraining it
+func (e env) unify(a, b interface{}) (env, bool) {
+	if symbolic(a) || symbolic(b) {
+		e, ok, err := e.constrain("==", a, b)
+		return e, ok && err == nil
+	}
+	ia, ok := a.(*Instance)
+	ib, ok2 := b.(*Instance)
+	if !ok || !ok2 || ia == ib || ia.Object != ib.Object || len(ia.Fields) != len(ib.Fields) {
+		return e, unify(a, b)
+	}
+	for i := range ia.Fields {
+		if e, ok = e.unify(ia.Fields[i], ib.Fields[i]); !ok {
+			return e, false
+		}
+	}
+	return e, true
+}
+
+// arithmetic mirrors is/2: integer division truncates and
+// % takes the sign of the divisor
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	if a == nil || b == nil {
+		return nil, fmt.Errorf("unbound value in %v %s %v", a, op, b)
+	}
+	switch x := a.(type) {
+	case time.Time:
+		if d, ok := b.(Duration); ok {
+			if op == "-" {
+				d = d.Negate()
+			}
+			return d.AddTo(x), nil
+		}
+	case Duration:
+		if t, ok := b.(time.Time); ok && op == "+" {
+			return x.AddTo(t), nil
+		}
+	case string:
+		if y, ok := b.(string); ok && op == "+" {
+			return x + y, nil
+		}
+	case int:
+		if y, ok := b.(int); ok {
+			return intArithmetic(op, x, y)
+		}
+	}
+	if rank(a) == 1 && rank(b) == 1 {
+		return floatArithmetic(op, toFloat(a), toFloat(b))
+	}
+	return nil, fmt.Errorf("invalid operation %v %s %v", a, op, b)
+}
+
+func intArithmetic(op string, a, b int) (interface{}, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("division by zero in %d %s %d", a, op, b)
+	}
+	if op == "/" {
+		return a / b, nil
+	}
+	m := a % b
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return m, nil
+}
+
+func floatArithmetic(op string, a, b float64) (interface{}, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero in %v / %v", a, b)
+		}
+		return a / b, nil
+	}
+	return nil, fmt.Errorf("invalid operation %v %s %v", a, op, b)
+}
@@ -0,0 +1,503 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"model"
+)
+
+// Formatting is canonical: one declaration per paragraph, one
+// item per line indented by tabs, colons aligned within a block,
+// minimal parentheses, and quotes only where an identifier won't do.
+// Comments are kept before the item that follows them, or after it
+// when on the same line. A comment between two blocks of a rule or
+// test moves to the end of the first.
+
+// Source formats a rulebase, keeping its comments
+func Source(src []byte) ([]byte, error) {
+	ir, err := model.Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	// the parser keeps only the last of a duplicate declaration,
+	// printing the ir would silently drop the others
+	declared := map[string]model.Position{}
+	for _, d := range ir.Declarations {
+		switch d.Kind {
+		case "object", "relation", "rule":
+			key := d.Kind + " " + d.Name
+			if prev, ok := declared[key]; ok {
+				return nil, fmt.Errorf("duplicate %s %s at %s and %s", d.Kind, d.Name, prev, d.Pos)
+			}
+			declared[key] = d.Pos
+		}
+	}
+	lines := strings.Split(string(src), "\n")
+	p := newPrinter(ir)
+	p.blank = func(line int) bool {
+		return line <= len(lines) && strings.TrimSpace(lines[line-1]) == ""
+	}
+	p.file(ir.Declarations)
+	return p.buf.Bytes(), nil
+}
+
+// Fprint writes ir in canonical style. An ir read from source is
+// printed in declaration order with its comments, one built in Go
+// as its objects, relations, rules and then tests.
+func Fprint(w io.Writer, ir model.InternalRepresentation) error {
+	p := newPrinter(ir)
+	decls := ir.Declarations
+	if len(decls) == 0 {
+		decls = declarations(ir)
+	}
+	p.file(decls)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+func declarations(ir model.InternalRepresentation) []model.Declaration {
+	decls := []model.Declaration{}
+	if ir.Package != "" {
+		decls = append(decls, model.Declaration{Kind: "package", Name: ir.Package})
+	}
+	for _, path := range ir.Imports {
+		decls = append(decls, model.Declaration{Kind: "import", Name: path})
+	}
+	for _, name := range ir.ObjectNames() {
+		decls = append(decls, model.Declaration{Kind: "object", Name: name})
+	}
+	for _, name := range ir.RelationNames() {
+		decls = append(decls, model.Declaration{Kind: "relation", Name: name})
+	}
+	for _, name := range ir.RuleNames() {
+		decls = append(decls, model.Declaration{Kind: "rule", Name: name})
+	}
+	for _, t := range ir.Tests {
+		decls = append(decls, model.Declaration{Kind: "test", Name: t.Name})
+	}
+	return decls
+}
+
+// lines wider than this print object instantiations one field per line
+const width = 80
+
+type printer struct {
+	buf      bytes.Buffer
+	ir       model.InternalRepresentation
+	comments []model.Comment
+
+	// blank reports whether a line of the source is empty
+	blank  func(line int) bool
+	indent int
+	// nothing printed yet in the current block
+	first bool
+	// source line of the last line printed
+	last int
+	// a blank line goes before the next line printed
+	sep bool
+}
+
+func newPrinter(ir model.InternalRepresentation) *printer {
+	return &printer{
+		ir:       ir,
+		comments: ir.Comments,
+		blank:    func(int) bool { return false },
+		first:    true,
+	}
+}
+
+func (p *printer) file(decls []model.Declaration) {
+	tests := 0
+	prev := ""
+	for _, d := range decls {
+		if d.Kind == "field" {
+			continue
+		}
+		p.sep = prev != "" && !(prev == "import" && d.Kind == "import")
+		prev = d.Kind
+		p.flush(d.Pos)
+		switch d.Kind {
+		case "package":
+			p.line(d.Pos, "package "+d.Name)
+		case "import":
+			p.line(d.Pos, "import "+strconv.Quote(d.Name))
+		case "object":
+			p.object(d, p.ir.Objects[d.Name])
+		case "relation":
+			p.relation(d, p.ir.Relations[d.Name])
+		case "rule":
+			p.rule(d, p.ir.Rules[d.Name])
+		case "test":
+			p.test(d, p.ir.Tests[tests])
+			tests++
+		}
+	}
+	p.sep = false
+	p.flush(model.Position{Line: math.MaxInt32})
+}
+
+// before reports whether a comes before b,
+// nothing comes before an unknown position
+func before(a, b model.Position) bool {
+	if b.Line == 0 {
+		return false
+	}
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+// flush prints the comments before pos on lines of their own
+func (p *printer) flush(pos model.Position) {
+	for len(p.comments) > 0 && before(p.comments[0].Pos, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.line(c.Pos, c.Text)
+	}
+}
+
+// line prints text at pos, keeping a blank line above it
+func (p *printer) line(pos model.Position, text string) {
+	if p.sep || !p.first && pos.Line-1 > p.last && p.blank(pos.Line-1) {
+		p.buf.WriteString("\n")
+	}
+	p.sep, p.first = false, false
+	p.write(pos, text)
+}
+
+// write prints text and the comment following it on the same line
+func (p *printer) write(pos model.Position, text string) {
+	p.buf.WriteString(strings.Repeat("\t", p.indent) + text)
+	if pos.Line != 0 {
+		p.last = pos.Line
+	}
+	if len(p.comments) > 0 && pos.Line != 0 && p.comments[0].Pos.Line == pos.Line {
+		p.buf.WriteString(" " + strings.TrimRight(p.comments[0].Text, " \t\r"))
+		p.comments = p.comments[1:]
+	}
+	p.buf.WriteString("\n")
+}
+
+// item prints a line inside a block, after the comments above it
+func (p *printer) item(pos model.Position, text string) {
+	p.flush(pos)
+	p.line(pos, text)
+}
+
+// open prints the first line of a block and indents its items
+func (p *printer) open(pos model.Position, text string) {
+	p.item(pos, text)
+	p.indent++
+	p.first = true
+}
+
+// close prints the comments up to next, the position of whatever
+// follows the block, and its closing brace at pos, if known
+func (p *printer) close(next, pos model.Position) {
+	p.flush(next)
+	p.indent--
+	p.first = false
+	p.write(pos, "}")
+}
+
+// at returns positions[i] or an unknown position
+func at(positions []model.Position, i int) model.Position {
+	if i < len(positions) {
+		return positions[i]
+	}
+	return model.Position{}
+}
+
+func comma(i, n int) string {
+	if i < n-1 {
+		return ","
+	}
+	return ""
+}
+
+// pad pads names with spaces to the same width
+func pad(names []string) []string {
+	w := 0
+	for _, n := range names {
+		if c := utf8.RuneCountInString(n); c > w {
+			w = c
+		}
+	}
+	padded := make([]string, len(names))
+	for i, n := range names {
+		padded[i] = n + strings.Repeat(" ", w-utf8.RuneCountInString(n))
+	}
+	return padded
+}
+
+// aligned returns name : value lines, colons aligned
+func aligned(names, values []string) []string {
+	lines := pad(names)
+	for i := range lines {
+		lines[i] += " : " + values[i]
+	}
+	return lines
+}
+
+func (p *printer) fields(positions []model.Position, fields []model.Field) {
+	names, types := []string{}, []string{}
+	for _, f := range fields {
+		names = append(names, f.Name)
+		if f.TypeInfo == model.OBJECT {
+			types = append(types, p.name(f.ObjectName()))
+		} else {
+			types = append(types, f.TypeInfo.String())
+		}
+	}
+	for i, l := range aligned(names, types) {
+		p.item(at(positions, i), l+comma(i, len(names)))
+	}
+}
+
+func (p *printer) object(d model.Declaration, o model.Object) {
+	p.open(d.Pos, "object "+p.name(o.Name)+" {")
+	p.fields(d.Fields, o.Fields)
+	p.close(d.End, d.End)
+}
+
+func (p *printer) relation(d model.Declaration, r model.Relation) {
+	p.open(d.Pos, "relation "+p.name(r.Name)+" {")
+	p.fields(d.Fields, r.Fields)
+	p.close(d.End, d.End)
+}
+
+func (p *printer) rule(d model.Declaration, r model.Rule) {
+	p.open(d.Pos, "rule "+p.name(r.Name)+" {")
+	p.open(at(d.Blocks, 0), "input {")
+	names, types := []string{}, []string{}
+	for _, a := range r.Args {
+		names = append(names, a.Value.(string))
+		types = append(types, p.typeName(a))
+	}
+	for i, l := range aligned(names, types) {
+		p.item(at(d.Fields, i), l+comma(i, len(names)))
+	}
+	bodies := r.Clauses()
+	for j, body := range bodies {
+		p.close(at(d.Blocks, j+1), model.Position{})
+		p.open(at(d.Blocks, j+1), "rules {")
+		for i, e := range body {
+			p.item(clause(d, j, i), p.condition(e)+comma(i, len(body)))
+		}
+	}
+	p.close(d.End, model.Position{})
+	p.close(d.End, d.End)
+}
+
+// clause returns the position of condition i of body j
+func clause(d model.Declaration, j, i int) model.Position {
+	if j < len(d.Clauses) {
+		return at(d.Clauses[j], i)
+	}
+	return model.Position{}
+}
+
+func (p *printer) test(d model.Declaration, t model.Test) {
+	// test names are descriptions, quoted even if one word
+	p.open(d.Pos, "test \""+t.Name+"\" {")
+	p.open(at(d.Blocks, 0), "facts {")
+	// instantiations align on the colon after their variable
+	names := []string{}
+	for _, f := range t.Facts {
+		if f.Functor == "new" {
+			names = append(names, f.Args[0].(model.Term).Value.(string))
+		}
+	}
+	names = pad(names)
+	for i, f := range t.Facts {
+		text := p.node(f)
+		if f.Functor == "new" {
+			text = p.instantiation(f, names[0])
+			names = names[1:]
+		}
+		p.item(at(d.Fields, i), text+comma(i, len(t.Facts)))
+	}
+	p.close(at(d.Blocks, 1), model.Position{})
+	p.open(at(d.Blocks, 1), "rules {")
+	for i, e := range t.Body {
+		p.item(clause(d, 0, i), p.node(e)+comma(i, len(t.Body)))
+	}
+	p.close(d.End, model.Position{})
+	p.close(d.End, d.End)
+}
+
+// instantiation prints name : object { field : value, ... }
+// on one line if it fits, otherwise one field per line
+func (p *printer) instantiation(e model.Expression, name string) string {
+	o := e.Args[0].(model.Term)
+	fields, values := []string{}, []string{}
+	for _, a := range e.Args[1:] {
+		f := a.(model.Term)
+		fields = append(fields, f.FieldName())
+		values = append(values, p.value(f.Value))
+	}
+	head := name + " : " + p.name(o.ObjectName()) + " {"
+	oneLine := []string{}
+	for i := range fields {
+		oneLine = append(oneLine, fields[i]+" : "+values[i])
+	}
+	text := head + " " + strings.Join(oneLine, ", ") + " }"
+	if p.indent*8+len(text)+1 <= width {
+		return text
+	}
+	indent := strings.Repeat("\t", p.indent)
+	lines := aligned(fields, values)
+	for i := range lines {
+		lines[i] = indent + "\t" + lines[i] + comma(i, len(lines))
+	}
+	return head + "\n" + strings.Join(lines, "\n") + "\n" + indent + "}"
+}
+
+func (p *printer) condition(e model.Expression) string {
+	if e.Functor != "let" {
+		return p.node(e)
+	}
+	v := e.Args[0].(model.Term)
+	if len(e.Args) == 1 {
+		return "let " + v.Value.(string) + " : " + p.typeName(v)
+	}
+	return "let " + v.Value.(string) + " = " + p.node(e.Args[1])
+}
+
+func (p *printer) typeName(t model.Term) string {
+	if t.TypeInfo == model.OBJECT {
+		return p.name(t.ObjectName())
+	}
+	return t.TypeInfo.String()
+}
+
+// name strips the package of the rulebase from a qualified name
+func (p *printer) name(name string) string {
+	if p.ir.Package == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, p.ir.Package+".")
+}
+
+// operators by the functor they parse to
+var operators = map[string]model.Token{}
+
+func init() {
+	for tok := model.ADD; tok <= model.PERIOD; tok++ {
+		if tok.IsOperator() {
+			operators[tok.String()] = tok
+		}
+	}
+}
+
+// precedence of the operator at the root of n,
+// terms and calls bind tightest
+func precedence(n model.Node) int {
+	if e, ok := n.(model.Expression); ok && len(e.Args) == 2 {
+		if tok, ok := operators[e.Functor]; ok {
+			return tok.Precedence()
+		}
+	}
+	return model.HighestPrec
+}
+
+func (p *printer) node(n model.Node) string {
+	if t, ok := n.(model.Term); ok {
+		return p.term(t)
+	}
+	e := n.(model.Expression)
+	if tok, ok := operators[e.Functor]; ok && len(e.Args) == 2 {
+		// operators associate to the left
+		left, right := p.node(e.Args[0]), p.node(e.Args[1])
+		if precedence(e.Args[0]) < tok.Precedence() {
+			left = "(" + left + ")"
+		}
+		if precedence(e.Args[1]) <= tok.Precedence() {
+			right = "(" + right + ")"
+		}
+		switch tok {
+		case model.PERIOD:
+			return left + "." + right
+		case model.EQL:
+			return left + " = " + right
+		}
+		return left + " " + tok.String() + " " + right
+	}
+	if e.Functor == "today" && len(e.Args) == 0 {
+		return "today"
+	}
+	args := []string{}
+	for _, a := range e.Args {
+		args = append(args, p.node(a))
+	}
+	// a call to a rule named like a builtin stays qualified
+	functor := p.name(e.Functor)
+	if model.IsBuiltin(functor) && functor != e.Functor {
+		functor = e.Functor
+	}
+	return functor + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (p *printer) term(t model.Term) string {
+	if t.TypeInfo == model.STRING {
+		return `"` + t.Value.(string) + `"`
+	}
+	return p.value(t.Value)
+}
+
+// value prints a literal, strings without quotes if possible
+func (p *printer) value(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if model.IsIdentifier(v) {
+			return v
+		}
+		return `"` + v + `"`
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case time.Time:
+		return v.Format("2006-01-02")
+	case model.Duration:
+		return duration(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// duration prints 6 months, or P1Y6M if it has several parts
+func duration(d model.Duration) string {
+	parts := []struct {
+		n          int
+		unit, code string
+	}{{d.Years, "year", "Y"}, {d.Months, "month", "M"}, {d.Days, "day", "D"}}
+	iso, single := "P", ""
+	count := 0
+	for _, part := range parts {
+		if part.n == 0 {
+			continue
+		}
+		count++
+		iso += strconv.Itoa(part.n) + part.code
+		single = fmt.Sprintf("%d %s", part.n, part.unit)
+		if part.n != 1 {
+			single += "s"
+		}
+	}
+	switch count {
+	case 0:
+		return "0 days"
+	case 1:
+		return single
+	}
+	return iso
+}
@@ -0,0 +1,281 @@
+package format
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"model"
+)
+
+func TestSource(t *testing.T) {
+	for i, tt := range []struct {
+		src  string
+		want string
+	}{
+		{
+			src: `object prisoner { age:int, name : string }
+relation cellmates {p : prisoner,
+	cellmate:prisoner}`,
+			want: `object prisoner {
+	age  : int,
+	name : string
+}
+
+relation cellmates {
+	p        : prisoner,
+	cellmate : prisoner
+}
+`,
+		},
+		{
+			src: `rule r { input { a : account, d : date } rules {
+	let x = (a.balance + 1) * 2, x - (1 - 2) > 3,
+	let c : account, ((a.balance)) / 2 % 2 = 0,
+	a.opened + 1 year - P1Y2M + 14 days <= today,
+	length(a.name) = 4.0 }
+rules { a.rate > 1.50 } }`,
+			want: `rule r {
+	input {
+		a : account,
+		d : date
+	}
+	rules {
+		let x = (a.balance + 1) * 2,
+		x - (1 - 2) > 3,
+		let c : account,
+		a.balance / 2 % 2 = 0,
+		a.opened + 1 year - P1Y2M + 14 days <= today,
+		length(a.name) = 4.0
+	}
+	rules {
+		a.rate > 1.5
+	}
+}
+`,
+		},
+		{
+			src: `test phonecall { facts {
+	p : prisoner { name : "john", age: 23 },
+	p10 : prisoner { name : "henry the 2nd", sentence: P6M, admitted : 2017-01-01, nickname: "rule" },
+	cellmates(p, p10) }
+	rules { r(p, "jo", 2018-01-01) } }`,
+			want: `test "phonecall" {
+	facts {
+		p   : prisoner { name : john, age : 23 },
+		p10 : prisoner {
+			name     : "henry the 2nd",
+			sentence : 6 months,
+			admitted : 2017-01-01,
+			nickname : "rule"
+		},
+		cellmates(p, p10)
+	}
+	rules {
+		r(p, "jo", 2018-01-01)
+	}
+}
+`,
+		},
+		{
+			src: `package legal
+import "a.rules"
+import "b.rules"
+rule length { input { p : common.prisoner } rules { legal.length(p), common.adult(p), length(p.name) > 2 } }`,
+			want: `package legal
+
+import "a.rules"
+import "b.rules"
+
+rule length {
+	input {
+		p : common.prisoner
+	}
+	rules {
+		legal.length(p),
+		common.adult(p),
+		length(p.name) > 2
+	}
+}
+`,
+		},
+		{
+			src: `# header
+
+# about prisoners
+object prisoner {
+	age : int, # years
+
+
+	# the name
+	name : string
+	# nothing more
+}   # end
+rule r { # r
+	input {
+		# the prisoner
+		p : prisoner
+	}
+	rules {
+		p.age >= 18 # adult
+		# p.age < 99
+	}
+	# moves into the first rules block
+	rules {
+		# second
+		p.age > 17
+	}
+}
+# trailing`,
+			want: `# header
+
+# about prisoners
+object prisoner {
+	age  : int, # years
+
+	# the name
+	name : string
+	# nothing more
+} # end
+
+rule r { # r
+	input {
+		# the prisoner
+		p : prisoner
+	}
+	rules {
+		p.age >= 18 # adult
+		# p.age < 99
+		# moves into the first rules block
+	}
+	rules {
+		# second
+		p.age > 17
+	}
+}
+# trailing
+`,
+		},
+	} {
+		got, err := Source([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%d): got\n%s\nwant\n%s", i, got, tt.want)
+		}
+		again, err := Source(got)
+		if err != nil || !bytes.Equal(again, got) {
+			t.Errorf("%d): not idempotent, got\n%s", i, again)
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	for i, tt := range []struct {
+		src  string
+		want string
+	}{
+		{
+			src:  "object a { x : int }\nobject a { y : int }",
+			want: "duplicate object a at 1:8 and 2:8",
+		},
+		{
+			src:  `test "unterminated`,
+			want: "expected [ident string] got ILLEGAL at line 1 : col 19",
+		},
+	} {
+		_, err := Source([]byte(tt.src))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%d): got %v want %s", i, err, tt.want)
+		}
+	}
+}
+
+// the rulebases in testdata are formatted, and formatting
+// them again gives the same rulebase and comments
+func TestTestdata(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata: %v", err)
+	}
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Source(src)
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		if !bytes.Equal(got, src) {
+			t.Errorf("%s: not formatted, got\n%s", file, got)
+		}
+		before, _ := model.Parse(string(src))
+		after, err := model.Parse(string(got))
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		if !sameRulebase(before, after) {
+			t.Errorf("%s: formatting changed the rulebase", file)
+		}
+	}
+}
+
+func sameRulebase(a, b model.InternalRepresentation) bool {
+	if !reflect.DeepEqual(a.Objects, b.Objects) || !reflect.DeepEqual(a.Relations, b.Relations) {
+		return false
+	}
+	if !reflect.DeepEqual(a.Rules, b.Rules) || !reflect.DeepEqual(a.Tests, b.Tests) {
+		return false
+	}
+	if len(a.Comments) != len(b.Comments) {
+		return false
+	}
+	for i := range a.Comments {
+		if a.Comments[i].Text != b.Comments[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFprint(t *testing.T) {
+	src := `object person {
+	name : string
+}
+
+rule named {
+	input {
+		p : person
+	}
+	rules {
+		p.name = "ann"
+	}
+}
+
+test "Ann" {
+	facts {
+		p : person { name : ann }
+	}
+	rules {
+		named(p)
+	}
+}
+`
+	ir := model.Read(src)
+	// an ir built in Go has no declarations to order it by
+	ir.Declarations = nil
+	var b bytes.Buffer
+	if err := Fprint(&b, ir); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != src {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), src)
+	}
+}
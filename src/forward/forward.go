@@ -0,0 +1,460 @@
+package forward
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"eval"
+	. "model"
+)
+
+// Rules only ever hold for more inputs as facts are added: the DSL
+// has no negation, so a derived fact stays derived. The engine keeps
+// every rule instance derived so far, and on each insert only checks
+// the instances the new fact can affect. A new object is joined semi
+// naively: only combinations of inputs taking it are enumerated. So
+// is a new tuple, goal by goal: an instance can only start to hold
+// if one of its clauses has a goal matching the tuple, or a rule call
+// which itself started to hold. The inputs the match binds are fixed,
+// the others range over working memory, and the instances found are
+// matched in turn against the rules calling theirs until none are.
+// Rules are evaluated by the eval interpreter, against the evaluation
+// date at the time the engine was created.
+//
+// Only rules whose inputs are all objects are derived, other inputs
+// can't be enumerated from working memory. A rule calling one of
+// those can't be matched goal by goal: all its instances not derived
+// yet are checked again.
+
+// Event reports a rule holding for objects in working memory
+type Event struct {
+	Rule string
+	Args []string // object names
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s(%s)", e.Rule, strings.Join(e.Args, ", "))
+}
+
+type object struct {
+	name     string
+	instance *eval.Instance
+}
+
+type Engine struct {
+	ir InternalRepresentation
+	in *eval.Interpreter
+
+	objects []object // in insertion order
+	byName  map[string]*eval.Instance
+	facts   eval.Facts
+
+	derived map[string]bool
+	events  []Event
+
+	// relations each rule depends on, directly or through rule calls
+	depends map[string]map[string]bool
+}
+
+func New(ir InternalRepresentation) *Engine {
+	in := eval.New()
+	in.Load(ir)
+	e := &Engine{
+		ir:      ir,
+		in:      in,
+		byName:  map[string]*eval.Instance{},
+		facts:   eval.Facts{},
+		derived: map[string]bool{},
+		depends: map[string]map[string]bool{},
+	}
+	for name := range ir.Rules {
+		e.depends[name] = map[string]bool{}
+		e.dependencies(name, e.depends[name], map[string]bool{})
+	}
+	return e
+}
+
+func (e *Engine) dependencies(rule string, relations, seen map[string]bool) {
+	if seen[rule] {
+		return
+	}
+	seen[rule] = true
+	for _, body := range e.ir.Rules[rule].Clauses() {
+		for _, goal := range body {
+			if _, ok := e.ir.Relations[goal.Functor]; ok {
+				relations[goal.Functor] = true
+			}
+			if _, ok := e.ir.Rules[goal.Functor]; ok {
+				e.dependencies(goal.Functor, relations, seen)
+			}
+		}
+	}
+}
+
+// Insert adds a fact as written in the facts of a test: an object
+// instantiation or a relation tuple. It returns the rule instances
+// that hold as a result, which did not hold before.
+func (e *Engine) Insert(fact Expression) ([]Event, error) {
+	if fact.Functor == "new" {
+		o := fact.Args[0].(Term)
+		fields := map[string]interface{}{}
+		for _, n := range fact.Args[1:] {
+			v := n.(Term)
+			fields[v.FieldName()] = v.Value
+		}
+		return e.InsertObject(o.Value.(string), o.ObjectName(), fields)
+	}
+	args := make([]interface{}, len(fact.Args))
+	for i, n := range fact.Args {
+		args[i] = n.(Term).Value
+	}
+	return e.InsertRelation(fact.Functor, args...)
+}
+
+// InsertObject adds an object to working memory. Fields of object
+// type are given by the name of an object inserted earlier.
+func (e *Engine) InsertObject(name, objectName string, fields map[string]interface{}) ([]Event, error) {
+	o, ok := e.ir.Objects[objectName]
+	if !ok {
+		return nil, fmt.Errorf("undefined object %s", objectName)
+	}
+	if _, ok := e.byName[name]; ok {
+		return nil, fmt.Errorf("object %s already inserted", name)
+	}
+	values := map[string]interface{}{}
+	for k, v := range fields {
+		if !o.HasField(k) {
+			return nil, fmt.Errorf("object %s has no field %s", objectName, k)
+		}
+		values[k] = v
+	}
+	for _, f := range o.Fields {
+		if v, ok := values[f.Name]; ok && f.TypeInfo == OBJECT {
+			ref, err := e.lookup(v)
+			if err != nil {
+				return nil, err
+			}
+			values[f.Name] = ref
+		}
+	}
+	i := eval.NewInstance(o, values)
+	fresh := object{name: name, instance: i}
+	e.objects = append(e.objects, fresh)
+	e.byName[name] = i
+
+	events, err := e.fire(&fresh)
+	if err != nil {
+		e.objects = e.objects[:len(e.objects)-1]
+		delete(e.byName, name)
+	}
+	return events, err
+}
+
+// InsertRelation adds a tuple to a relation. Arguments of object
+// type are given by the name of an object inserted earlier.
+func (e *Engine) InsertRelation(relation string, args ...interface{}) ([]Event, error) {
+	r, ok := e.ir.Relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("undefined relation %s", relation)
+	}
+	if len(args) != len(r.Fields) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", relation, len(r.Fields), len(args))
+	}
+	tuple := make([]interface{}, len(args))
+	for i, f := range r.Fields {
+		tuple[i] = args[i]
+		if f.TypeInfo == OBJECT {
+			ref, err := e.lookup(args[i])
+			if err != nil {
+				return nil, err
+			}
+			tuple[i] = ref
+		}
+	}
+	e.facts[relation] = append(e.facts[relation], tuple)
+
+	events, err := e.propagate(relation, tuple)
+	if err != nil {
+		e.facts[relation] = e.facts[relation][:len(e.facts[relation])-1]
+	}
+	return events, err
+}
+
+func (e *Engine) lookup(name interface{}) (*eval.Instance, error) {
+	s, _ := name.(string)
+	if i, ok := e.byName[s]; ok {
+		return i, nil
+	}
+	return nil, fmt.Errorf("unknown object %v", name)
+}
+
+// Derived returns all rule instances derived so far, in order
+func (e *Engine) Derived() []Event {
+	return append([]Event{}, e.events...)
+}
+
+// fire checks the instances of every rule taking fresh which
+// have not been derived yet. On an evaluation error nothing is
+// derived, and the caller undoes the insert.
+func (e *Engine) fire(fresh *object) ([]Event, error) {
+	c := e.checker()
+	for _, name := range e.ruleNames() {
+		r := e.ir.Rules[name]
+		if err := e.instances(r, fresh, func(args []object) error {
+			_, err := c.check(r, args)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return c.commit(), nil
+}
+
+// propagate checks the instances the tuple can make hold, round
+// by round: those matching it first, then those matching the
+// instances found in the round before
+func (e *Engine) propagate(relation string, tuple []interface{}) ([]Event, error) {
+	c := e.checker()
+	delta := map[string][][]interface{}{relation: {tuple}}
+	for len(delta) > 0 {
+		next := map[string][][]interface{}{}
+		for _, name := range e.ruleNames() {
+			r := e.ir.Rules[name]
+			err := e.matches(r, delta, func(args []object) error {
+				holds, err := c.check(r, args)
+				if holds {
+					values := make([]interface{}, len(args))
+					for i, a := range args {
+						values[i] = a.instance
+					}
+					next[r.Name] = append(next[r.Name], values)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		delta = next
+	}
+	events := c.commit()
+	// rounds follow the derivations, report in rule order instead
+	index := map[string]int{}
+	for i, o := range e.objects {
+		index[o.name] = i
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		for k := range a.Args {
+			if a.Args[k] != b.Args[k] {
+				return index[a.Args[k]] < index[b.Args[k]]
+			}
+		}
+		return false
+	})
+	return events, nil
+}
+
+// checker collects the instances found to hold during an insert,
+// derived and events are only updated together once every instance
+// is checked
+type checker struct {
+	e       *Engine
+	derived map[string]bool
+	events  []Event
+}
+
+func (e *Engine) checker() *checker {
+	return &checker{e: e, derived: map[string]bool{}, events: []Event{}}
+}
+
+// check reports whether r newly holds for args
+func (c *checker) check(r Rule, args []object) (bool, error) {
+	ev := Event{Rule: r.Name, Args: make([]string, len(args))}
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		ev.Args[i] = a.name
+		values[i] = a.instance
+	}
+	if c.e.derived[ev.String()] || c.derived[ev.String()] {
+		return false, nil
+	}
+	holds, err := c.e.in.Holds(c.e.facts, r.Name, values...)
+	if err != nil || !holds {
+		return false, err
+	}
+	c.derived[ev.String()] = true
+	c.events = append(c.events, ev)
+	return true, nil
+}
+
+func (c *checker) commit() []Event {
+	for k := range c.derived {
+		c.e.derived[k] = true
+	}
+	c.e.events = append(c.e.events, c.events...)
+	return c.events
+}
+
+// matches calls f for the combinations of objects in working memory
+// matching the inputs of r, with those some goal of r binds to a
+// tuple of delta fixed to its values
+func (e *Engine) matches(r Rule, delta map[string][][]interface{}, f func([]object) error) error {
+	candidates, ok := e.candidates(r)
+	if !ok {
+		return nil
+	}
+	for functor := range delta {
+		if _, ok := e.ir.Rules[functor]; ok || !e.depends[r.Name][functor] {
+			continue
+		}
+		// the relation is reached through a rule not derived,
+		// so instances can't be matched
+		for _, body := range r.Clauses() {
+			for _, goal := range body {
+				if _, ok := e.ir.Rules[goal.Functor]; ok && e.depends[goal.Functor][functor] && !e.derivable(goal.Functor) {
+					return product(candidates, f)
+				}
+			}
+		}
+	}
+	index := map[*eval.Instance]object{}
+	for _, o := range e.objects {
+		index[o.instance] = o
+	}
+	for _, body := range r.Clauses() {
+		for _, goal := range body {
+			for _, tuple := range delta[goal.Functor] {
+				fixed := append([][]object{}, candidates...)
+				if !bind(r, goal, tuple, index, fixed) {
+					continue
+				}
+				if err := product(fixed, f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bind fixes the inputs of r which goal takes as arguments to the
+// objects of tuple, it fails if an input is fixed to two objects
+func bind(r Rule, goal Expression, tuple []interface{}, index map[*eval.Instance]object, fixed [][]object) bool {
+	inputs := map[string]int{}
+	for i, a := range r.Args {
+		inputs[a.Value.(string)] = i
+	}
+	bound := map[int]bool{}
+	for j, n := range goal.Args {
+		v, ok := n.(Term)
+		if !ok || v.TypeInfo != IDENT && v.TypeInfo != OBJECT {
+			continue
+		}
+		i, ok := inputs[v.Value.(string)]
+		if !ok {
+			continue
+		}
+		instance, _ := tuple[j].(*eval.Instance)
+		o, ok := index[instance]
+		if !ok || bound[i] && fixed[i][0].name != o.name {
+			return false
+		}
+		bound[i] = true
+		fixed[i] = []object{o}
+	}
+	return true
+}
+
+func (e *Engine) derivable(rule string) bool {
+	for _, a := range e.ir.Rules[rule].Args {
+		if a.TypeInfo != OBJECT {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the objects in working memory matching each
+// input of r, it fails if r is not derived
+func (e *Engine) candidates(r Rule) ([][]object, bool) {
+	if !e.derivable(r.Name) {
+		return nil, false
+	}
+	candidates := make([][]object, len(r.Args))
+	for i, a := range r.Args {
+		for _, o := range e.objects {
+			if o.instance.Object == a.ObjectName() {
+				candidates[i] = append(candidates[i], o)
+			}
+		}
+	}
+	return candidates, true
+}
+
+// product calls f for every combination of candidates
+func product(candidates [][]object, f func([]object) error) error {
+	args := make([]object, len(candidates))
+	var next func(i int) error
+	next = func(i int) error {
+		if i == len(args) {
+			return f(append([]object{}, args...))
+		}
+		for _, o := range candidates[i] {
+			args[i] = o
+			if err := next(i + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return next(0)
+}
+
+// instances calls f for every combination of objects in working
+// memory matching the inputs of r and taking fresh: it is fixed at
+// each input in turn, and the inputs before it range over the other
+// objects so none comes twice.
+func (e *Engine) instances(r Rule, fresh *object, f func([]object) error) error {
+	candidates, ok := e.candidates(r)
+	if !ok {
+		return nil
+	}
+	for i, a := range r.Args {
+		if a.ObjectName() != fresh.instance.Object {
+			continue
+		}
+		fixed := make([][]object, len(candidates))
+		for j := range candidates {
+			switch {
+			case j == i:
+				fixed[j] = []object{*fresh}
+			case j < i:
+				for _, o := range candidates[j] {
+					if o.name != fresh.name {
+						fixed[j] = append(fixed[j], o)
+					}
+				}
+			default:
+				fixed[j] = candidates[j]
+			}
+		}
+		if err := product(fixed, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) ruleNames() []string {
+	names := []string{}
+	for name := range e.ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
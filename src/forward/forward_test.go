@@ -0,0 +1,253 @@
+package forward
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	. "model"
+)
+
+func TestInsert(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/reports.rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(Read(string(b)))
+	facts := Read(`test "facts" {
+		facts {
+			a : person { name : alice },
+			b : person { name : bob },
+			c : person { name : carol },
+			manages(a, b),
+			manages(b, c)
+		}
+		rules {
+			hasBoss(c)
+		}
+	}`).Tests[0].Facts
+
+	for i, want := range [][]string{
+		{},
+		{},
+		{},
+		{"hasBoss(b)", "reportsTo(b, a)"},
+		{"hasBoss(c)", "reportsTo(c, a)", "reportsTo(c, b)"},
+	} {
+		events, err := e.Insert(facts[i])
+		if err != nil {
+			t.Fatalf("%d): %s", i, err)
+		}
+		got := []string{}
+		for _, ev := range events {
+			got = append(got, ev.String())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d): got %#v want %#v", i, got, want)
+		}
+	}
+	if got := len(e.Derived()); got != 5 {
+		t.Errorf("got %d derived facts want 5", got)
+	}
+
+	// derived facts are only reported once
+	events, err := e.InsertRelation("manages", "a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %v want no events", events)
+	}
+}
+
+func TestInsertObject(t *testing.T) {
+	e := New(Read(`
+		object prisoner {
+			age : int,
+			name : string
+		}
+		rule adult {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age >= 18
+			}
+		}`))
+
+	for i, tt := range []struct {
+		name   string
+		fields map[string]interface{}
+		want   []Event
+		err    string
+	}{
+		{
+			name:   "john",
+			fields: map[string]interface{}{"age": 23},
+			want:   []Event{{Rule: "adult", Args: []string{"john"}}},
+		},
+		{
+			name:   "henry",
+			fields: map[string]interface{}{"age": 15},
+			want:   []Event{},
+		},
+		{
+			name:   "jim",
+			fields: map[string]interface{}{"height": 180},
+			err:    "object prisoner has no field height",
+		},
+		{
+			name: "john",
+			err:  "object john already inserted",
+		},
+	} {
+		got, err := e.InsertObject(tt.name, "prisoner", tt.fields)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("%d): got %q want %q", i, err, tt.err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %#v want %#v", i, got, tt.want)
+		}
+	}
+}
+
+// a new object is only joined with the others, so no
+// combination is checked twice across inserts
+func TestInstances(t *testing.T) {
+	e := New(Read(`
+		object prisoner {
+			age : int
+		}
+		rule older {
+			input {
+				p : prisoner,
+				q : prisoner
+			}
+			rules {
+				p.age > q.age
+			}
+		}`))
+	seen := map[string]int{}
+	for i, tt := range []struct {
+		name string
+		age  int
+		want []string
+	}{
+		{"a", 30, []string{}},
+		{"b", 20, []string{"older(a, b)"}},
+		{"c", 25, []string{"older(c, b)", "older(a, c)"}},
+	} {
+		events, err := e.InsertObject(tt.name, "prisoner", map[string]interface{}{"age": tt.age})
+		if err != nil {
+			t.Fatalf("%d): %s", i, err)
+		}
+		got := []string{}
+		for _, ev := range events {
+			got = append(got, ev.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+		}
+		fresh := e.objects[len(e.objects)-1]
+		e.instances(e.ir.Rules["older"], &fresh, func(args []object) error {
+			seen[args[0].name+args[1].name]++
+			return nil
+		})
+	}
+	if len(seen) != 9 {
+		t.Errorf("got %d combinations want 9", len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Errorf("%s checked %d times", k, n)
+		}
+	}
+}
+
+// a new tuple is only matched against the goals taking it,
+// so only the inputs it leaves free range over working memory
+func TestMatches(t *testing.T) {
+	b, err := ioutil.ReadFile("../testdata/reports.rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(Read(string(b)))
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := e.InsertObject(name, "person", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tuple := []interface{}{e.byName["a"], e.byName["b"]}
+	got := []string{}
+	e.matches(e.ir.Rules["reportsTo"], map[string][][]interface{}{"manages": {tuple}}, func(args []object) error {
+		got = append(got, args[0].name+args[1].name)
+		return nil
+	})
+	// manages(b, e) binds both inputs, manages(x, e) only e
+	want := []string{"ba", "ba", "bb", "bc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+// an evaluation error derives nothing, not even
+// the instances checked before it, and undoes the insert
+func TestInsertError(t *testing.T) {
+	e := New(Read(`
+		object prisoner {
+			age : int,
+			cell : int
+		}
+		rule adult {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age >= 18
+			}
+		}
+		rule upstairs {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age / p.cell > 1
+			}
+		}
+		relation floor {
+			prisoner : prisoner,
+			height   : int
+		}
+		rule high {
+			input {
+				p : prisoner
+			}
+			rules {
+				let h : int,
+				floor(p, h),
+				p.age / h > 1
+			}
+		}`))
+	if _, err := e.InsertObject("john", "prisoner", map[string]interface{}{"age": 23, "cell": 0}); err == nil {
+		t.Fatal("got no error want division by zero")
+	}
+	if len(e.derived) != 0 || len(e.Derived()) != 0 {
+		t.Errorf("got %v derived and events %v want none", e.derived, e.Derived())
+	}
+	if len(e.objects) != 0 || e.byName["john"] != nil {
+		t.Errorf("got objects %v want john removed", e.objects)
+	}
+
+	if _, err := e.InsertObject("john", "prisoner", map[string]interface{}{"age": 23, "cell": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.InsertRelation("floor", "john", 0); err == nil {
+		t.Fatal("got no error want division by zero")
+	}
+	if len(e.facts["floor"]) != 0 {
+		t.Errorf("got floor %v want the tuple removed", e.facts["floor"])
+	}
+}
@@ -0,0 +1,319 @@
+package gogen
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	. "model"
+)
+
+// clause compiles a rule body into nested Go blocks: every goal
+// wraps the goals after it, and the innermost block returns true.
+// Relation calls loop over the facts, binding unbound variables.
+type clause struct {
+	g     *generator
+	scope map[string]Term
+	bound map[string]bool
+	facts int
+}
+
+func (c *clause) goals(body []Expression) (string, error) {
+	if len(body) == 0 {
+		return "return true\n", nil
+	}
+	e, rest := body[0], body[1:]
+
+	if e.Functor == "let" {
+		return c.let(e, rest)
+	}
+	if r, ok := c.g.ir.Relations[e.Functor]; ok {
+		return c.relation(e, r, rest)
+	}
+	if v, ok := c.unbound(e); ok {
+		return c.candidates(v, body)
+	}
+
+	cond, err := c.condition(e)
+	if err != nil {
+		return "", err
+	}
+	inner, err := c.goals(rest)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("if %s {\n%s}\n", cond, inner), nil
+}
+
+func (c *clause) let(e Expression, rest []Expression) (string, error) {
+	v := e.Args[0].(Term)
+	name := v.Value.(string)
+	if len(e.Args) == 1 {
+		// declared, bound by a later relation call, or
+		// else by trying every candidate for a rule call
+		c.scope[name] = v
+		return c.goals(rest)
+	}
+	value, err := c.expr(e.Args[1])
+	if err != nil {
+		return "", err
+	}
+	c.scope[name] = Term{Value: name, TypeInfo: c.g.ir.TypeOf(e.Args[1], c.scope)}
+	if o, ok := e.Args[1].(Term); ok && o.TypeInfo == OBJECT {
+		c.scope[name] = ObjectTerm(name, o.ObjectName())
+	}
+	c.bound[name] = true
+	inner, err := c.goals(rest)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s := %s\n_ = %s\n%s", local(name), value, local(name), inner), nil
+}
+
+func (c *clause) relation(e Expression, r Relation, rest []Expression) (string, error) {
+	c.facts++
+	fact := fmt.Sprintf("f%d", c.facts)
+	conds := []string{}
+	binds := []string{}
+	for i, n := range e.Args {
+		field := fmt.Sprintf("%s.%s", fact, exported(r.Fields[i].Name))
+		if v, ok := n.(Term); ok && (v.TypeInfo == IDENT || v.TypeInfo == OBJECT) {
+			name := v.Value.(string)
+			if !c.bound[name] {
+				c.bound[name] = true
+				binds = append(binds, fmt.Sprintf("%s := %s\n", local(name), field))
+				continue
+			}
+		}
+		arg, err := c.expr(n)
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, c.compare("==", r.Fields[i].TypeInfo, field, arg))
+	}
+	inner, err := c.goals(rest)
+	if err != nil {
+		return "", err
+	}
+	inner = strings.Join(binds, "") + inner
+	if len(conds) > 0 {
+		inner = fmt.Sprintf("if %s {\n%s}\n", strings.Join(conds, " && "), inner)
+	}
+	return fmt.Sprintf("for _, %s := range rb.%s {\n%s}\n", fact, exported(r.Name), inner), nil
+}
+
+// unbound returns an object declared with let and not bound yet
+// that a rule call takes, which Go cannot leave open
+func (c *clause) unbound(e Expression) (Term, bool) {
+	if _, ok := c.g.ir.Rules[e.Functor]; !ok {
+		return Term{}, false
+	}
+	for _, n := range e.Args {
+		v, ok := n.(Term)
+		if !ok || v.TypeInfo != IDENT && v.TypeInfo != OBJECT {
+			continue
+		}
+		name := v.Value.(string)
+		if s := c.scope[name]; !c.bound[name] && s.TypeInfo == OBJECT {
+			return s, true
+		}
+	}
+	return Term{}, false
+}
+
+// candidates binds v to each object of its type found in the
+// facts, the only objects a relation call could bind it to
+func (c *clause) candidates(v Term, body []Expression) (string, error) {
+	name := v.Value.(string)
+	c.bound[name] = true
+	c.g.candidates[v.ObjectName()] = true
+	inner, err := c.goals(body)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("for _, %s := range rb.%s() {\n%s}\n", local(name), candidatesOf(v.ObjectName()), inner), nil
+}
+
+// condition compiles a goal that evaluates to true or false
+func (c *clause) condition(e Expression) (string, error) {
+	if _, ok := c.g.ir.Rules[e.Functor]; ok {
+		args, err := c.args(e.Args)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rb.%s(%s)", exported(e.Functor), strings.Join(args, ", ")), nil
+	}
+	return c.expr(e)
+}
+
+func (c *clause) args(nodes []Node) ([]string, error) {
+	args := make([]string, len(nodes))
+	for i, n := range nodes {
+		a, err := c.expr(n)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = a
+	}
+	return args, nil
+}
+
+func (c *clause) expr(n Node) (string, error) {
+	switch v := n.(type) {
+	case Term:
+		if v.TypeInfo != IDENT && v.TypeInfo != OBJECT {
+			return c.g.literal(v.Value, v.TypeInfo), nil
+		}
+		name := v.Value.(string)
+		if !c.bound[name] {
+			return "", fmt.Errorf("%s is unbound", name)
+		}
+		return local(name), nil
+	case Expression:
+		return c.expression(v)
+	}
+	return "", fmt.Errorf("expected node to be term or expression")
+}
+
+func (c *clause) expression(e Expression) (string, error) {
+	switch e.Functor {
+	case "today":
+		return "rb.Today", nil
+	case ".":
+		object, err := c.expr(e.Args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s.%s", object, exported(e.Args[1].(Term).Value.(string))), nil
+	}
+
+	args, err := c.args(e.Args)
+	if err != nil {
+		return "", err
+	}
+	switch e.Functor {
+	case "startsWith":
+		c.g.imports["strings"] = true
+		return fmt.Sprintf("strings.HasPrefix(%s, %s)", args[0], args[1]), nil
+	case "contains":
+		c.g.imports["strings"] = true
+		return fmt.Sprintf("strings.Contains(%s, %s)", args[0], args[1]), nil
+	case "matches":
+		c.g.imports["regexp"] = true
+		return fmt.Sprintf("regexp.MustCompile(%s).MatchString(%s)", args[1], args[0]), nil
+	case "length":
+		c.g.imports["unicode/utf8"] = true
+		return fmt.Sprintf("utf8.RuneCountInString(%s)", args[0]), nil
+	case "lower":
+		c.g.imports["strings"] = true
+		return fmt.Sprintf("strings.ToLower(%s)", args[0]), nil
+	case "concat":
+		return fmt.Sprintf("(%s + %s)", args[0], args[1]), nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		c.numbers(e, args)
+		return c.compare(e.Functor, c.g.ir.TypeOf(e.Args[0], c.scope), args[0], args[1]), nil
+	case "+", "-":
+		left := c.g.ir.TypeOf(e.Args[0], c.scope)
+		right := c.g.ir.TypeOf(e.Args[1], c.scope)
+		if left == DURATION && right == DATE {
+			args[0], args[1] = args[1], args[0]
+			left = DATE
+		}
+		if left == DATE {
+			c.g.dates = true
+			if e.Functor == "-" {
+				return fmt.Sprintf("addDuration(%s, %s.negate())", args[0], args[1]), nil
+			}
+			return fmt.Sprintf("addDuration(%s, %s)", args[0], args[1]), nil
+		}
+		c.numbers(e, args)
+		return fmt.Sprintf("(%s %s %s)", args[0], e.Functor, args[1]), nil
+	case "%":
+		// % takes the sign of the divisor, unlike in Go
+		c.g.modulo = true
+		return fmt.Sprintf("mod(%s, %s)", args[0], args[1]), nil
+	case "*", "/":
+		c.numbers(e, args)
+		return fmt.Sprintf("(%s %s %s)", args[0], e.Functor, args[1]), nil
+	}
+	return "", fmt.Errorf("cannot compile %s to Go", e.Functor)
+}
+
+// numbers converts the integer side of an operation on an
+// integer and a float, which Go does not mix
+func (c *clause) numbers(e Expression, args []string) {
+	left := c.g.ir.TypeOf(e.Args[0], c.scope)
+	right := c.g.ir.TypeOf(e.Args[1], c.scope)
+	switch {
+	case left == INT && right == FLOAT:
+		args[0] = fmt.Sprintf("float64(%s)", args[0])
+	case left == FLOAT && right == INT:
+		args[1] = fmt.Sprintf("float64(%s)", args[1])
+	}
+}
+
+// dates compare through methods on time.Time
+func (c *clause) compare(op string, t Token, left, right string) string {
+	if t != DATE {
+		return fmt.Sprintf("%s %s %s", left, op, right)
+	}
+	switch op {
+	case "<":
+		return fmt.Sprintf("%s.Before(%s)", left, right)
+	case "<=":
+		return fmt.Sprintf("!%s.After(%s)", left, right)
+	case ">":
+		return fmt.Sprintf("%s.After(%s)", left, right)
+	case ">=":
+		return fmt.Sprintf("!%s.Before(%s)", left, right)
+	case "!=":
+		return fmt.Sprintf("!%s.Equal(%s)", left, right)
+	}
+	return fmt.Sprintf("%s.Equal(%s)", left, right)
+}
+
+func (g *generator) literal(v interface{}, t Token) string {
+	switch t {
+	case STRING, IDENT:
+		return strconv.Quote(fmt.Sprintf("%v", v))
+	case DATE:
+		g.imports["time"] = true
+		d := v.(time.Time)
+		return fmt.Sprintf("time.Date(%d, %d, %d, 0, 0, 0, 0, time.UTC)", d.Year(), d.Month(), d.Day())
+	case DURATION:
+		g.dates = true
+		d := v.(Duration)
+		return fmt.Sprintf("Duration{Years: %d, Months: %d, Days: %d}", d.Years, d.Months, d.Days)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// identifier returns a declared name as a Go identifier: Go
+// has no qualified names, so legal.adult becomes legal__adult
+func identifier(name string) string {
+	return strings.Replace(name, ".", "__", -1)
+}
+
+func exported(name string) string {
+	name = identifier(name)
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:]
+}
+
+// local avoids DSL identifiers clashing with Go keywords
+// and with the names used in generated code
+func local(name string) string {
+	if token.IsKeyword(name) || name == "rb" || name == "t" || strings.HasPrefix(name, "f") && isNumber(name[1:]) {
+		return name + "_"
+	}
+	return name
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
@@ -0,0 +1,352 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	. "model"
+)
+
+// Generate compiles a rulebase into a Go package: a struct per
+// object and relation, and a method on Rulebase per rule.
+// Relations are slices of facts on the Rulebase, which rules
+// search through to bind variables declared with let x : type.
+func Generate(ir InternalRepresentation, pkg string) ([]byte, error) {
+	g := newGenerator(ir)
+	body := &bytes.Buffer{}
+	for _, name := range SortedKeys(ir.Objects) {
+		g.printStruct(body, name, ir.Objects[name].Fields)
+	}
+	for _, name := range SortedKeys(ir.Relations) {
+		g.printStruct(body, name, ir.Relations[name].Fields)
+	}
+	g.printRulebase(body)
+	for _, name := range SortedKeys(ir.Rules) {
+		if err := g.printRule(body, ir.Rules[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range SortedKeys(g.candidates) {
+		g.printCandidates(body, name)
+	}
+	if g.dates {
+		body.WriteString(dateHelpers)
+	}
+	if g.modulo {
+		body.WriteString(modHelper)
+	}
+	return g.file(pkg, body)
+}
+
+// GenerateTests compiles the tests of a rulebase into a _test.go
+// file for the package produced by Generate. Tests are evaluated
+// against model.Today at the time of generation.
+func GenerateTests(ir InternalRepresentation, pkg string) ([]byte, error) {
+	g := newGenerator(ir)
+	g.imports["testing"] = true
+	body := &bytes.Buffer{}
+	names := testNames(ir.Tests)
+	for i, t := range ir.Tests {
+		g.printTest(body, names[i], t)
+	}
+	return g.file(pkg, body)
+}
+
+type generator struct {
+	ir      InternalRepresentation
+	imports map[string]bool
+
+	// date and modulo helpers are only printed when needed
+	dates  bool
+	modulo bool
+	// objects rules enumerate to bind a let
+	candidates map[string]bool
+}
+
+func newGenerator(ir InternalRepresentation) *generator {
+	return &generator{ir: ir, imports: map[string]bool{}, candidates: map[string]bool{}}
+}
+
+func (g *generator) file(pkg string, body *bytes.Buffer) ([]byte, error) {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "// Code generated by gogen from a rulebase. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	if len(g.imports) > 0 {
+		b.WriteString("import (\n")
+		for _, imp := range SortedKeys(g.imports) {
+			fmt.Fprintf(b, "\t%q\n", imp)
+		}
+		b.WriteString(")\n\n")
+	}
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+func (g *generator) printStruct(b *bytes.Buffer, name string, fields []Field) {
+	fmt.Fprintf(b, "type %s struct {\n", exported(name))
+	for _, f := range fields {
+		fmt.Fprintf(b, "\t%s %s\n", exported(f.Name), g.goType(f.TypeInfo, f))
+	}
+	b.WriteString("}\n\n")
+}
+
+func (g *generator) printRulebase(b *bytes.Buffer) {
+	g.imports["time"] = true
+	b.WriteString("// Rulebase holds the facts of each relation, and the date\n")
+	b.WriteString("// rules are evaluated against.\n")
+	b.WriteString("type Rulebase struct {\n\tToday time.Time\n")
+	for _, name := range SortedKeys(g.ir.Relations) {
+		fmt.Fprintf(b, "\t%s []%s\n", exported(name), exported(name))
+	}
+	b.WriteString("}\n\n")
+}
+
+// printCandidates prints a method listing the objects of a type
+// that appear in the facts of any relation
+func (g *generator) printCandidates(b *bytes.Buffer, object string) {
+	fmt.Fprintf(b, "func (rb *Rulebase) %s() []%s {\n", candidatesOf(object), exported(object))
+	fmt.Fprintf(b, "objects := []%s{}\n", exported(object))
+	for _, name := range SortedKeys(g.ir.Relations) {
+		for _, f := range g.ir.Relations[name].Fields {
+			if f.TypeInfo != OBJECT || f.ObjectName() != object {
+				continue
+			}
+			fmt.Fprintf(b, "for _, f := range rb.%s {\nobjects = append(objects, f.%s)\n}\n", exported(name), exported(f.Name))
+		}
+	}
+	b.WriteString("return objects\n}\n\n")
+}
+
+func candidatesOf(object string) string {
+	return "all" + exported(object)
+}
+
+func (g *generator) goType(t Token, f Field) string {
+	switch t {
+	case INT:
+		return "int"
+	case FLOAT:
+		return "float64"
+	case STRING:
+		return "string"
+	case DATE:
+		g.imports["time"] = true
+		return "time.Time"
+	case DURATION:
+		g.dates = true
+		return "Duration"
+	case OBJECT:
+		return exported(f.ObjectName())
+	}
+	panic(fmt.Sprintf("gogen: no Go type for %s", t))
+}
+
+func (g *generator) params(args []Term) string {
+	params := make([]string, len(args))
+	for i, a := range args {
+		typ := ""
+		if a.TypeInfo == OBJECT {
+			typ = exported(a.ObjectName())
+		} else {
+			typ = g.goType(a.TypeInfo, Field{})
+		}
+		params[i] = fmt.Sprintf("%s %s", local(a.Value.(string)), typ)
+	}
+	return strings.Join(params, ", ")
+}
+
+// a rule with alternative bodies holds if any of its clauses
+// does, each clause gets its own unexported method
+func (g *generator) printRule(b *bytes.Buffer, r Rule) error {
+	params := g.params(r.Args)
+	args := make([]string, len(r.Args))
+	for i, a := range r.Args {
+		args[i] = local(a.Value.(string))
+	}
+
+	clauses := r.Clauses()
+	if len(clauses) == 1 {
+		fmt.Fprintf(b, "func (rb *Rulebase) %s(%s) bool {\n", exported(r.Name), params)
+		if err := g.printClause(b, r, clauses[0]); err != nil {
+			return err
+		}
+		b.WriteString("}\n\n")
+		return nil
+	}
+
+	calls := make([]string, len(clauses))
+	for i := range clauses {
+		calls[i] = fmt.Sprintf("rb.%s%d(%s)", identifier(r.Name), i, strings.Join(args, ", "))
+	}
+	fmt.Fprintf(b, "func (rb *Rulebase) %s(%s) bool {\n\treturn %s\n}\n\n",
+		exported(r.Name), params, strings.Join(calls, " ||\n\t\t"))
+	for i, body := range clauses {
+		fmt.Fprintf(b, "func (rb *Rulebase) %s%d(%s) bool {\n", identifier(r.Name), i, params)
+		if err := g.printClause(b, r, body); err != nil {
+			return err
+		}
+		b.WriteString("}\n\n")
+	}
+	return nil
+}
+
+func (g *generator) printClause(b *bytes.Buffer, r Rule, body []Expression) error {
+	c := &clause{g: g, scope: r.Scope(), bound: map[string]bool{}}
+	for name := range c.scope {
+		c.bound[name] = true
+	}
+	code, err := c.goals(body)
+	if err != nil {
+		return fmt.Errorf("gogen: rule %s: %s", r.Name, err)
+	}
+	b.WriteString(code)
+	b.WriteString("return false\n")
+	return nil
+}
+
+func (g *generator) printTest(b *bytes.Buffer, name string, t Test) {
+	fmt.Fprintf(b, "func %s(t *testing.T) {\n", name)
+	fmt.Fprintf(b, "rb := &Rulebase{Today: %s}\n", g.literal(Today(), DATE))
+	used := map[string]bool{}
+	for _, e := range append(append([]Expression{}, t.Facts...), t.Body...) {
+		if e.Functor == "new" {
+			continue
+		}
+		for _, n := range e.Args {
+			if v, ok := n.(Term); ok && (v.TypeInfo == IDENT || v.TypeInfo == OBJECT) {
+				used[v.Value.(string)] = true
+			}
+		}
+	}
+	for _, e := range t.Facts {
+		if e.Functor == "new" {
+			o := e.Args[0].(Term)
+			name := o.Value.(string)
+			fmt.Fprintf(b, "%s := %s\n", local(name), g.instantiation(o, e.Args[1:]))
+			if !used[name] {
+				fmt.Fprintf(b, "_ = %s\n", local(name))
+			}
+			continue
+		}
+		fmt.Fprintf(b, "rb.%s = append(rb.%s, %s{%s})\n",
+			exported(e.Functor), exported(e.Functor), exported(e.Functor), g.testArgs(e.Args))
+	}
+	if t.ExpectFail() {
+		// only the rules together are expected not to hold
+		calls, described := []string{}, []string{}
+		for _, e := range t.Body {
+			calls = append(calls, fmt.Sprintf("rb.%s(%s)", exported(e.Functor), g.testArgs(e.Args)))
+			described = append(described, describe(e))
+		}
+		fmt.Fprintf(b, "if %s {\n\tt.Error(%q)\n}\n",
+			strings.Join(calls, " && "), fmt.Sprintf("%s hold", strings.Join(described, ", ")))
+		b.WriteString("}\n\n")
+		return
+	}
+	for _, e := range t.Body {
+		fmt.Fprintf(b, "if !rb.%s(%s) {\n\tt.Error(%q)\n}\n",
+			exported(e.Functor), g.testArgs(e.Args), fmt.Sprintf("%s does not hold", describe(e)))
+	}
+	b.WriteString("}\n\n")
+}
+
+func (g *generator) instantiation(o Term, fields []Node) string {
+	object := g.ir.Objects[o.ObjectName()]
+	values := []string{}
+	for _, f := range object.Fields {
+		for _, n := range fields {
+			v := n.(Term)
+			if v.FieldName() != f.Name {
+				continue
+			}
+			values = append(values, fmt.Sprintf("%s: %s", exported(f.Name), g.literal(v.Value, f.TypeInfo)))
+		}
+	}
+	return fmt.Sprintf("%s{%s}", exported(object.Name), strings.Join(values, ", "))
+}
+
+func (g *generator) testArgs(args []Node) string {
+	a := make([]string, len(args))
+	for i, n := range args {
+		v := n.(Term)
+		if v.TypeInfo == IDENT || v.TypeInfo == OBJECT {
+			a[i] = local(v.Value.(string))
+			continue
+		}
+		a[i] = g.literal(v.Value, v.TypeInfo)
+	}
+	return strings.Join(a, ", ")
+}
+
+func describe(e Expression) string {
+	args := make([]string, len(e.Args))
+	for i, n := range e.Args {
+		args[i] = fmt.Sprintf("%v", n.(Term).Value)
+	}
+	return fmt.Sprintf("%s(%s)", e.Functor, strings.Join(args, ", "))
+}
+
+// testNames returns the Go test function of each test. Descriptions
+// differing only by punctuation give the same name, later ones are
+// numbered from 2.
+func testNames(tests []Test) []string {
+	names := make([]string, len(tests))
+	used := map[string]bool{}
+	for i, t := range tests {
+		name := "Test" + testName(t.Name)
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("Test%s%d", testName(t.Name), n)
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// testName turns a test description into a Go identifier
+func testName(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_')
+	})
+	for i, w := range words {
+		words[i] = exported(w)
+	}
+	return strings.Join(words, "")
+}
+
+// helpers mirroring model.Duration
+const dateHelpers = `
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+}
+
+func (d Duration) negate() Duration {
+	return Duration{Years: -d.Years, Months: -d.Months, Days: -d.Days}
+}
+
+// addDuration adds years and months first, clamping the day to
+// the end of the resulting month, and then adds the days.
+func addDuration(t time.Time, d Duration) time.Time {
+	y, m, day := t.Date()
+	months := (y+d.Years)*12 + int(m) - 1 + d.Months
+	y, m = months/12, time.Month(months%12+1)
+	if max := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > max {
+		day = max
+	}
+	return time.Date(y, m, day+d.Days, 0, 0, 0, 0, time.UTC)
+}
+`
+
+// the remainder of the DSL, which takes the sign of the divisor
+const modHelper = `
+func mod(a, b int) int {
+	m := a % b
+	if m != 0 && (m < 0) != (b < 0) {
+		m += b
+	}
+	return m
+}
+`
@@ -0,0 +1,222 @@
+package gogen
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"eval"
+	. "model"
+)
+
+const rulebase = `
+	object person {
+		name : string,
+		admitted : date
+	}
+	relation manages {
+		boss : person,
+		employee : person
+	}
+	rule reportsTo {
+		input {
+			e : person,
+			b : person
+		}
+		rules {
+			manages(b, e)
+		}
+		rules {
+			let x : person,
+			manages(x, e),
+			reportsTo(x, b)
+		}
+	}
+	rule settled {
+		input {
+			p : person
+		}
+		rules {
+			startsWith(lower(p.name), "a"),
+			p.admitted + 6 months <= today
+		}
+	}
+	test "Indirect report" {
+		facts {
+			a : person { name : alice, admitted : 2017-01-31 },
+			b : person { name : bob },
+			manages(a, b)
+		}
+		rules {
+			reportsTo(b, a),
+			settled(a)
+		}
+	}`
+
+func TestGenerate(t *testing.T) {
+	ir := Read(rulebase)
+	got, err := Generate(ir, "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		`type Manages struct {
+	Boss     Person
+	Employee Person
+}`,
+		`func (rb *Rulebase) ReportsTo(e Person, b Person) bool {
+	return rb.reportsTo0(e, b) ||
+		rb.reportsTo1(e, b)
+}`,
+		`func (rb *Rulebase) reportsTo1(e Person, b Person) bool {
+	for _, f1 := range rb.Manages {
+		if f1.Employee == e {
+			x := f1.Boss
+			if rb.ReportsTo(x, b) {
+				return true
+			}
+		}
+	}
+	return false
+}`,
+		`func (rb *Rulebase) Settled(p Person) bool {
+	if strings.HasPrefix(strings.ToLower(p.Name), "a") {
+		if !addDuration(p.Admitted, Duration{Years: 0, Months: 6, Days: 0}).After(rb.Today) {
+			return true
+		}
+	}
+	return false
+}`,
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("%d): %s\nnot found in\n%s", i, want, got)
+		}
+	}
+}
+
+func TestGenerateTests(t *testing.T) {
+	defer func(today func() time.Time) { Today = today }(Today)
+	Today = func() time.Time { return time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	got, err := GenerateTests(Read(rulebase), "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `func TestIndirectReport(t *testing.T) {
+	rb := &Rulebase{Today: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)}
+	a := Person{Name: "alice", Admitted: time.Date(2017, 1, 31, 0, 0, 0, 0, time.UTC)}
+	b := Person{Name: "bob"}
+	rb.Manages = append(rb.Manages, Manages{a, b})
+	if !rb.ReportsTo(b, a) {
+		t.Error("reportsTo(b, a) does not hold")
+	}
+	if !rb.Settled(a) {
+		t.Error("settled(a) does not hold")
+	}
+}`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("%s\nnot found in\n%s", want, got)
+	}
+}
+
+func TestTestNames(t *testing.T) {
+	tests := []Test{{Name: "a b"}, {Name: "a-b"}, {Name: "a b 2"}, {Name: "A B"}, {Name: "!"}}
+	got := testNames(tests)
+	want := []string{"TestAB", "TestAB2", "TestAB22", "TestAB3", "Test"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+// TestCorpus compiles every rulebase in testdata with its tests
+// and runs them with go test, they should pass as they do in eval.
+// Each runs again in a package, with qualified names.
+func TestCorpus(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no corpus found: %v", err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCorpus(t, goTool, file, string(b))
+		testCorpus(t, goTool, file+" in package legal", "package legal\n"+string(b))
+	}
+}
+
+var result = regexp.MustCompile(`(?m)^--- (PASS|FAIL): (\w+)`)
+
+// testCorpus compiles and runs one rulebase, named f in errors
+func testCorpus(t *testing.T, goTool, f, src string) {
+	ir := Read(src)
+	in := eval.New()
+	if err := in.Load(ir); err != nil {
+		t.Fatal(err)
+	}
+	want, err := in.RunTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gopath, err := ioutil.TempDir("", "gogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	dir := filepath.Join(gopath, "src", "rules")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	code, err := Generate(ir, "rules")
+	if err != nil {
+		t.Fatalf("%s: %s", f, err)
+	}
+	tests, err := GenerateTests(ir, "rules")
+	if err != nil {
+		t.Fatalf("%s: %s", f, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules.go"), code, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules_test.go"), tests, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goTool, "test", "-v", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	// failing tests make go test fail, the output tells which
+	out, _ := cmd.CombinedOutput()
+	got := map[string]bool{}
+	for _, m := range result.FindAllStringSubmatch(string(out), -1) {
+		got[m[2]] = m[1] == "PASS"
+	}
+	if len(got) == 0 {
+		t.Errorf("%s: no test ran, go test said\n%s", f, out)
+		return
+	}
+	names := testNames(ir.Tests)
+	for i, r := range want {
+		name := names[i]
+		passed, ok := got[name]
+		if !ok {
+			t.Errorf("%s: %q did not run, go test said\n%s", f, r.Name, out)
+			continue
+		}
+		if passed != r.OK() {
+			t.Errorf("%s: %q got %v, eval got %v", f, r.Name, passed, r.OK())
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package impact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"deps"
+	"eval"
+	"model"
+)
+
+// Change is an object, field, relation, rule or test that was
+// added, removed or changed between two versions of a rulebase.
+// Declarations are compared on the internal representation, so
+// formatting and comments make no difference.
+type Change struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	// what changed: the type of a field, or the parts of a
+	// relation, rule or test
+	Detail string `json:"detail,omitempty"`
+}
+
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Outcome of a test in each version: pass, fail, error if it
+// could not run, or none if the version has no such test
+type Outcome struct {
+	Test   string `json:"test"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Report is the impact of changing a rulebase: the declarations
+// that changed, the tests depending on them, and the tests whose
+// outcome changed
+type Report struct {
+	Changes  []Change  `json:"changes"`
+	Affected []string  `json:"affected"`
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Compare compares two versions of a rulebase and runs the tests
+// of both in the interpreter. The versions have to type check,
+// apart from their tests.
+func Compare(old, new model.InternalRepresentation) (Report, error) {
+	r := Report{Changes: []Change{}, Affected: []string{}, Outcomes: []Outcome{}}
+	before, err := outcomes(old)
+	if err != nil {
+		return r, fmt.Errorf("old version: %s", err)
+	}
+	after, err := outcomes(new)
+	if err != nil {
+		return r, fmt.Errorf("new version: %s", err)
+	}
+
+	changed := deps.Changed(old, new)
+	oldGraph, newGraph := deps.Build(old), deps.Build(new)
+	declared := map[deps.Node]bool{}
+	for _, n := range oldGraph.Nodes {
+		declared[n] = true
+	}
+	for _, n := range newGraph.Nodes {
+		switch {
+		case !changed[n]:
+		case !declared[n]:
+			r.Changes = append(r.Changes, Change{n.Kind, n.Name, Added, ""})
+		default:
+			r.Changes = append(r.Changes, Change{n.Kind, n.Name, Changed, detail(old, new, n)})
+		}
+		delete(declared, n)
+	}
+	for _, n := range oldGraph.Nodes {
+		if declared[n] {
+			r.Changes = append(r.Changes, Change{n.Kind, n.Name, Removed, ""})
+		}
+	}
+	// fields stay with their object
+	sort.SliceStable(r.Changes, func(i, j int) bool {
+		return rank[r.Changes[i].Kind] < rank[r.Changes[j].Kind]
+	})
+
+	for _, n := range deps.Affected(old, new, changed) {
+		r.Affected = append(r.Affected, n.Name)
+	}
+
+	names := []string{}
+	for _, t := range new.Tests {
+		names = append(names, t.Name)
+	}
+	for _, t := range old.Tests {
+		names = append(names, t.Name)
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		o := Outcome{name, none(before[name]), none(after[name])}
+		if o.Before != o.After {
+			r.Outcomes = append(r.Outcomes, o)
+		}
+	}
+	return r, nil
+}
+
+var rank = map[string]int{"object": 0, "field": 0, "relation": 1, "rule": 2, "test": 3}
+
+func none(outcome string) string {
+	if outcome == "" {
+		return "none"
+	}
+	return outcome
+}
+
+// detail tells what changed in a declaration in both versions
+func detail(old, new model.InternalRepresentation, n deps.Node) string {
+	parts := []string{}
+	differs := func(part string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			parts = append(parts, part)
+		}
+	}
+	switch n.Kind {
+	case "field":
+		a, b := field(old, n.Name), field(new, n.Name)
+		return typeName(a) + " -> " + typeName(b)
+	case "object":
+		differs("fields", old.Objects[n.Name].Fields, new.Objects[n.Name].Fields)
+	case "relation":
+		differs("fields", old.Relations[n.Name].Fields, new.Relations[n.Name].Fields)
+	case "rule":
+		a, b := old.Rules[n.Name], new.Rules[n.Name]
+		differs("inputs", a.Args, b.Args)
+		differs("conditions", a.Clauses(), b.Clauses())
+	case "test":
+		a, b := tests(old, n.Name), tests(new, n.Name)
+		differs("facts", facts(a), facts(b))
+		differs("rules", bodies(a), bodies(b))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func field(ir model.InternalRepresentation, name string) model.Field {
+	i := strings.LastIndex(name, ".")
+	for _, f := range ir.Objects[name[:i]].Fields {
+		if f.Name == name[i+1:] {
+			return f
+		}
+	}
+	return model.Field{}
+}
+
+func typeName(f model.Field) string {
+	if f.TypeInfo == model.OBJECT {
+		return f.ObjectName()
+	}
+	return f.TypeInfo.String()
+}
+
+func tests(ir model.InternalRepresentation, name string) []model.Test {
+	tests := []model.Test{}
+	for _, t := range ir.Tests {
+		if t.Name == name {
+			tests = append(tests, t)
+		}
+	}
+	return tests
+}
+
+func facts(tests []model.Test) [][]model.Expression {
+	facts := [][]model.Expression{}
+	for _, t := range tests {
+		facts = append(facts, t.Facts)
+	}
+	return facts
+}
+
+func bodies(tests []model.Test) [][]model.Expression {
+	bodies := [][]model.Expression{}
+	for _, t := range tests {
+		bodies = append(bodies, t.Body)
+	}
+	return bodies
+}
+
+// outcomes runs the tests of a version, tests of the same name
+// together; a test that does not type check is an error
+func outcomes(ir model.InternalRepresentation) (map[string]string, error) {
+	outcomes := map[string]string{}
+	msgs := []string{}
+	for _, err := range model.Check(ir) {
+		if cerr, ok := err.(model.CheckError); ok && cerr.Kind == "test" {
+			outcomes[cerr.Name] = "error"
+			continue
+		}
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) > 0 {
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	for _, t := range ir.Tests {
+		if _, ok := outcomes[t.Name]; ok {
+			continue
+		}
+		version := ir
+		version.Tests = tests(ir, t.Name)
+		in := eval.New()
+		outcomes[t.Name] = "error"
+		if err := in.Load(version); err != nil {
+			continue
+		}
+		results, err := in.RunTests()
+		if err != nil {
+			continue
+		}
+		outcomes[t.Name] = "pass"
+		for _, r := range results {
+			if !r.OK() {
+				outcomes[t.Name] = "fail"
+			}
+		}
+	}
+	return outcomes, nil
+}
+
+// Text writes the report for reading: + for added, - for removed
+// and ~ for changed declarations, then the tests affected and the
+// outcomes that changed
+func (r Report) Text(w io.Writer) error {
+	var b strings.Builder
+	signs := map[string]string{Added: "+", Removed: "-", Changed: "~"}
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "%s %s %s", signs[c.Change], c.Kind, c.Name)
+		if c.Detail != "" {
+			fmt.Fprintf(&b, " (%s)", c.Detail)
+		}
+		b.WriteString("\n")
+	}
+	if len(r.Changes) == 0 {
+		b.WriteString("no changes\n")
+	}
+	if len(r.Affected) > 0 {
+		fmt.Fprintf(&b, "\ntests affected: %d\n", len(r.Affected))
+		for _, name := range r.Affected {
+			fmt.Fprintf(&b, "  %s\n", name)
+		}
+	}
+	if len(r.Outcomes) > 0 {
+		b.WriteString("\noutcomes changed:\n")
+		for _, o := range r.Outcomes {
+			fmt.Fprintf(&b, "  %s: %s -> %s\n", o.Test, o.Before, o.After)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// JSON returns the report as an indented JSON object
+func (r Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
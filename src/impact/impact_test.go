@@ -0,0 +1,177 @@
+package impact
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"model"
+)
+
+const src = `object cell {
+	number : int
+}
+
+object prisoner {
+	age  : int,
+	cell : cell
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+test "Adult" {
+	facts {
+		p : prisoner { age : 18 }
+	}
+	rules {
+		adult(p)
+	}
+}
+
+test "Minor" {
+	facts {
+		p : prisoner { age : 17 }
+	}
+	rules {
+		adult(p)
+	}
+}
+`
+
+func TestCompare(t *testing.T) {
+	for i, tt := range []struct {
+		old, new string
+		want     Report
+	}{
+		// formatting and comments are not changes
+		{
+			old:  src,
+			new:  strings.Replace(strings.Replace(src, "age  : int", "# years\n\tage : int", 1), "\t\tp.age", "\t\t  p.age", 1),
+			want: Report{Changes: []Change{}, Affected: []string{}, Outcomes: []Outcome{}},
+		},
+		{
+			old: src,
+			new: strings.Replace(src, "p.age >= 18", "p.age > 18", 1),
+			want: Report{
+				Changes:  []Change{{"rule", "adult", Changed, "conditions"}},
+				Affected: []string{"Adult", "Minor"},
+				Outcomes: []Outcome{{"Adult", "pass", "fail"}},
+			},
+		},
+		{
+			old: src,
+			new: strings.Replace(src, "cell : cell", "cell : int", 1),
+			want: Report{
+				Changes: []Change{
+					{"object", "prisoner", Changed, "fields"},
+					{"field", "prisoner.cell", Changed, "cell -> int"},
+				},
+				Affected: []string{"Adult", "Minor"},
+				Outcomes: []Outcome{},
+			},
+		},
+		// a test that no longer type checks
+		{
+			old: src,
+			new: strings.Replace(src, "age : 18", "height : 18", 1),
+			want: Report{
+				Changes:  []Change{{"test", "Adult", Changed, "facts"}},
+				Affected: []string{"Adult"},
+				Outcomes: []Outcome{{"Adult", "pass", "error"}},
+			},
+		},
+		{
+			old: src,
+			new: strings.Replace(strings.Replace(src, "number : int\n", "number : int,\n\tfloor  : int\n", 1),
+				"test \"Minor\"", "test \"Young\"", 1),
+			want: Report{
+				Changes: []Change{
+					{"object", "cell", Changed, "fields"},
+					{"field", "cell.floor", Added, ""},
+					{"test", "Young", Added, ""},
+					{"test", "Minor", Removed, ""},
+				},
+				Affected: []string{"Adult", "Young"},
+				Outcomes: []Outcome{{"Young", "none", "fail"}, {"Minor", "fail", "none"}},
+			},
+		},
+		{
+			old: src,
+			new: strings.Replace(src, "age : 17", "age : 21", 1),
+			want: Report{
+				Changes:  []Change{{"test", "Minor", Changed, "facts"}},
+				Affected: []string{"Minor"},
+				Outcomes: []Outcome{{"Minor", "fail", "pass"}},
+			},
+		},
+	} {
+		got, err := Compare(model.Read(tt.old), model.Read(tt.new))
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %+v want %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestCompareInvalid(t *testing.T) {
+	_, err := Compare(model.Read(src), model.Read(strings.Replace(src, "p.age >= 18", "p.size >= 18", 1)))
+	if err == nil || !strings.HasPrefix(err.Error(), "new version: rule adult:") {
+		t.Errorf("got %v want an error in rule adult of the new version", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := Report{
+		Changes: []Change{
+			{"object", "prisoner", Changed, "fields"},
+			{"field", "prisoner.age", Changed, "int -> string"},
+			{"rule", "release", Added, ""},
+			{"test", "Minor", Removed, ""},
+		},
+		Affected: []string{"Adult", "Minor"},
+		Outcomes: []Outcome{{"Adult", "pass", "error"}, {"Minor", "fail", "none"}},
+	}
+	var b strings.Builder
+	if err := r.Text(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `~ object prisoner (fields)
+~ field prisoner.age (int -> string)
++ rule release
+- test Minor
+
+tests affected: 2
+  Adult
+  Minor
+
+outcomes changed:
+  Adult: pass -> error
+  Minor: fail -> none
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	js, err := r.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(js, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("got %+v want %+v", got, r)
+	}
+}
@@ -0,0 +1,198 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	. "model"
+)
+
+// Issue is a problem found in a rulebase. Duplicate declarations
+// are errors, since the parser keeps only the last one; everything
+// else is a warning.
+type Issue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+}
+
+func (i Issue) String() string {
+	pos := Position{File: i.File, Line: i.Line, Col: i.Col}
+	return fmt.Sprintf("%s: %s: %s", pos, i.Severity, i.Message)
+}
+
+const (
+	Error   = "error"
+	Warning = "warning"
+)
+
+// Lint returns the issues in ir ordered by position,
+// so the output is stable between runs
+func Lint(ir InternalRepresentation) []Issue {
+	l := &linter{ir: ir, declared: map[string]Declaration{}}
+	l.duplicates()
+	l.unused()
+	l.untested()
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		a, b := l.issues[i], l.issues[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Col != b.Col {
+			return a.Col < b.Col
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Name < b.Name
+	})
+	return l.issues
+}
+
+// JSON returns the issues as an indented JSON array
+func JSON(issues []Issue) ([]byte, error) {
+	if issues == nil {
+		issues = []Issue{}
+	}
+	return json.MarshalIndent(issues, "", "  ")
+}
+
+// HasErrors reports whether any issue is an error
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+type linter struct {
+	ir     InternalRepresentation
+	issues []Issue
+
+	// namespace and name -> last declaration, which is
+	// the one the parser keeps
+	declared map[string]Declaration
+}
+
+func (l *linter) report(severity, check, name string, pos Position, format string, args ...interface{}) {
+	l.issues = append(l.issues, Issue{
+		Severity: severity,
+		Check:    check,
+		Name:     name,
+		Message:  fmt.Sprintf(format, args...),
+		File:     pos.File,
+		Line:     pos.Line,
+		Col:      pos.Col,
+	})
+}
+
+func (l *linter) duplicates() {
+	for _, d := range l.ir.Declarations {
+		k := d.Key()
+		if prev, ok := l.declared[k]; ok {
+			name := d.Name
+			if d.Kind == "field" {
+				name = d.Object + "." + d.Name
+			}
+			l.report(Error, "duplicate", name, d.Pos,
+				"duplicate %s %s, previously declared as %s at %s", d.Kind, name, prev.Kind, prev.Pos)
+		}
+		l.declared[k] = d
+	}
+}
+
+func (l *linter) pos(kind, name string) Position {
+	return l.declared[Declaration{Kind: kind, Name: name}.Key()].Pos
+}
+
+func (l *linter) fieldPos(object, field string) Position {
+	return l.declared[Declaration{Kind: "field", Name: field, Object: object}.Key()].Pos
+}
+
+// objects are used as a type of a rule input, variable, relation
+// or object field; relations and fields by rule bodies only, since
+// asserting facts in a test is not a use
+func (l *linter) unused() {
+	u := newUsage(l.ir)
+	for _, name := range SortedKeys(l.ir.Objects) {
+		o := l.ir.Objects[name]
+		if !u.objects[name] {
+			l.report(Warning, "unused", name, l.pos("object", name), "object %s is never used", name)
+			continue
+		}
+		for _, f := range o.Fields {
+			if !u.fields[name+"."+f.Name] {
+				l.report(Warning, "unused", name+"."+f.Name, l.fieldPos(name, f.Name),
+					"field %s of object %s is never used", f.Name, name)
+			}
+		}
+	}
+	for _, name := range SortedKeys(l.ir.Relations) {
+		if !u.calls[name] {
+			l.report(Warning, "unused", name, l.pos("relation", name), "relation %s is never used", name)
+		}
+	}
+}
+
+// a rule is tested if a test calls it, directly
+// or through the rules that the test calls
+func (l *linter) untested() {
+	tested := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		r, ok := l.ir.Rules[name]
+		if !ok || tested[name] {
+			return
+		}
+		tested[name] = true
+		for _, body := range r.Clauses() {
+			for _, e := range body {
+				walk(e, func(e Expression) {
+					visit(e.Functor)
+				})
+			}
+		}
+	}
+
+	tests := []Declaration{}
+	for _, d := range l.ir.Declarations {
+		if d.Kind == "test" {
+			tests = append(tests, d)
+		}
+	}
+	for i, t := range l.ir.Tests {
+		references := false
+		for _, e := range t.Body {
+			walk(e, func(e Expression) {
+				_, isRule := l.ir.Rules[e.Functor]
+				_, isRelation := l.ir.Relations[e.Functor]
+				references = references || isRule || isRelation
+				visit(e.Functor)
+			})
+		}
+		if references {
+			continue
+		}
+		pos := Position{}
+		if i < len(tests) {
+			pos = tests[i].Pos
+		}
+		l.report(Warning, "empty-test", t.Name, pos, "test %q references no rule or relation", t.Name)
+	}
+
+	for _, name := range SortedKeys(l.ir.Rules) {
+		if !tested[name] {
+			l.report(Warning, "untested", name, l.pos("rule", name), "rule %s is not tested", name)
+		}
+	}
+}
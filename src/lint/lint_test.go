@@ -0,0 +1,162 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+
+	. "model"
+)
+
+func TestLint(t *testing.T) {
+	ir := Read(`object prisoner {
+	age  : int,
+	name : string,
+	age  : int
+}
+object cell {
+	number : int
+}
+relation cellmates {
+	p : prisoner,
+	q : prisoner
+}
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age > 17
+	}
+}
+rule hasRightToPhonecall {
+	input {
+		p : prisoner
+	}
+	rules {
+		adult(p)
+	}
+}
+test "phonecall" {
+	facts {
+		p : prisoner { age : 23, name : john }
+	}
+	rules {
+		hasRightToPhonecall(p)
+	}
+}
+test "nothing" {
+	facts {
+		p : prisoner { age : 23, name : john }
+	}
+	rules {
+		startsWith("john", "jo")
+	}
+}
+`)
+	got := []string{}
+	for _, i := range Lint(ir) {
+		got = append(got, i.String())
+	}
+	want := []string{
+		"3:2: warning: field name of object prisoner is never used",
+		"4:2: error: duplicate field prisoner.age, previously declared as field at 2:2",
+		"6:8: warning: object cell is never used",
+		"9:10: warning: relation cellmates is never used",
+		"21:6: error: duplicate rule adult, previously declared as rule at 13:6",
+		"45:6: warning: test \"nothing\" references no rule or relation",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestUntested(t *testing.T) {
+	ir := Read(`object person {
+	name : string
+}
+relation manages {
+	boss : person,
+	employee : person
+}
+rule reportsTo {
+	input {
+		e : person,
+		b : person
+	}
+	rules {
+		manages(b, e)
+	}
+}
+rule hasBoss {
+	input {
+		e : person
+	}
+	rules {
+		let b : person,
+		reportsTo(e, b)
+	}
+}
+rule isBoss {
+	input {
+		b : person
+	}
+	rules {
+		let e : person,
+		manages(b, e),
+		startsWith(b.name, "a")
+	}
+}
+test "boss" {
+	facts {
+		a : person { name : alice },
+		b : person { name : bob },
+		manages(a, b)
+	}
+	rules {
+		hasBoss(b)
+	}
+}
+`)
+	got := Lint(ir)
+	want := []Issue{
+		{Severity: Warning, Check: "untested", Name: "isBoss", Message: "rule isBoss is not tested", Line: 26, Col: 6},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v want %#v", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	b, err := JSON(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "[]" {
+		t.Errorf("got %s want []", b)
+	}
+	b, err = JSON([]Issue{{Severity: Error, Check: "duplicate", Name: "adult", Message: "duplicate rule adult", Line: 3, Col: 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[
+  {
+    "severity": "error",
+    "check": "duplicate",
+    "name": "adult",
+    "message": "duplicate rule adult",
+    "line": 3,
+    "col": 6
+  }
+]`
+	if string(b) != want {
+		t.Errorf("got %s want %s", b, want)
+	}
+}
@@ -0,0 +1,112 @@
+package lint
+
+import (
+	. "model"
+)
+
+// usage records which objects, fields and rules or
+// relations are referred to in a rulebase
+type usage struct {
+	ir      InternalRepresentation
+	objects map[string]bool
+	fields  map[string]bool // object.field
+	calls   map[string]bool
+
+	// identifier -> object type, in the clause being walked
+	scope map[string]string
+}
+
+func newUsage(ir InternalRepresentation) *usage {
+	u := &usage{
+		ir:      ir,
+		objects: map[string]bool{},
+		fields:  map[string]bool{},
+		calls:   map[string]bool{},
+	}
+	for _, o := range ir.Objects {
+		u.fieldTypes(o.Name, o.Fields)
+	}
+	for _, r := range ir.Relations {
+		u.fieldTypes("", r.Fields)
+	}
+	for _, r := range ir.Rules {
+		for _, a := range r.Args {
+			if a.TypeInfo == OBJECT {
+				u.objects[a.ObjectName()] = true
+			}
+		}
+		for _, body := range r.Clauses() {
+			u.scope = map[string]string{}
+			for _, a := range r.Args {
+				if a.TypeInfo == OBJECT {
+					u.scope[a.Value.(string)] = a.ObjectName()
+				}
+			}
+			for _, e := range body {
+				u.condition(e)
+			}
+		}
+	}
+	for _, t := range ir.Tests {
+		for _, e := range t.Body {
+			walk(e, func(e Expression) {
+				u.calls[e.Functor] = true
+			})
+		}
+	}
+	return u
+}
+
+// an object used as field type of another object is used
+func (u *usage) fieldTypes(object string, fields []Field) {
+	for _, f := range fields {
+		if f.TypeInfo == OBJECT && f.ObjectName() != object {
+			u.objects[f.ObjectName()] = true
+		}
+	}
+}
+
+func (u *usage) condition(e Expression) {
+	if e.Functor == "let" {
+		v := e.Args[0].(Term)
+		if len(e.Args) == 1 {
+			if v.TypeInfo == OBJECT {
+				u.objects[v.ObjectName()] = true
+				u.scope[v.Value.(string)] = v.ObjectName()
+			}
+			return
+		}
+		u.node(e.Args[1])
+		if name := u.ir.ObjectOf(e.Args[1], u.scope); name != "" {
+			u.scope[v.Value.(string)] = name
+		}
+		return
+	}
+	u.node(e)
+}
+
+func (u *usage) node(n Node) {
+	e, ok := n.(Expression)
+	if !ok {
+		return
+	}
+	u.calls[e.Functor] = true
+	if e.Functor == "." {
+		if object := u.ir.ObjectOf(e.Args[0], u.scope); object != "" {
+			u.fields[object+"."+e.Args[1].(Term).Value.(string)] = true
+		}
+	}
+	for _, a := range e.Args {
+		u.node(a)
+	}
+}
+
+// walk calls f on e and every expression nested in it
+func walk(e Expression, f func(Expression)) {
+	f(e)
+	for _, n := range e.Args {
+		if a, ok := n.(Expression); ok {
+			walk(a, f)
+		}
+	}
+}
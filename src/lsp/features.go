@@ -0,0 +1,205 @@
+package lsp
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"format"
+	"model"
+)
+
+// symbolAt resolves the identifier at a position in a document
+func symbolAt(ws *workspace, params textDocumentPosition) (*file, int, symbol, bool) {
+	f := ws.file(params.TextDocument.URI)
+	if f == nil {
+		return nil, -1, symbol{}, false
+	}
+	k := f.ident(f.modelPosition(params.Position))
+	if k < 0 {
+		return nil, -1, symbol{}, false
+	}
+	s, ok := ws.resolve(f, k)
+	return f, k, s, ok
+}
+
+// nameRange spans the name declared at pos,
+// which for a let is after the keyword
+func (f *file) nameRange(pos model.Position) lspRange {
+	i := f.lexeme(pos)
+	if f.tok(i) == model.LET {
+		i++
+	}
+	if f.tok(i) != model.IDENT {
+		return lspRange{f.position(pos), f.position(pos)}
+	}
+	return f.rangeOf(f.lexemes[i])
+}
+
+func (s *server) definition(params textDocumentPosition) interface{} {
+	_, _, sym, ok := symbolAt(s.workspace(), params)
+	if !ok || sym.file == nil || sym.pos.Line == 0 {
+		return nil
+	}
+	return location{URI: sym.file.uri, Range: sym.file.nameRange(sym.pos)}
+}
+
+func (s *server) hover(params textDocumentPosition) interface{} {
+	ws := s.workspace()
+	f, k, sym, ok := symbolAt(ws, params)
+	if !ok {
+		return nil
+	}
+	var b bytes.Buffer
+	b.WriteString("```\n")
+	switch sym.kind {
+	case "object":
+		format.Fprint(&b, model.InternalRepresentation{Objects: map[string]model.Object{sym.name: ws.objects[sym.name]}})
+	case "relation":
+		format.Fprint(&b, model.InternalRepresentation{Relations: map[string]model.Relation{sym.name: ws.relations[sym.name]}})
+	case "rule":
+		inputs := []string{}
+		for _, a := range ws.rules[sym.name].Args {
+			inputs = append(inputs, fmt.Sprintf("%s : %s", a.Value, typeName(a)))
+		}
+		fmt.Fprintf(&b, "rule %s(%s)\n", sym.name, strings.Join(inputs, ", "))
+	case "field", "variable":
+		b.WriteString(sym.name)
+		if sym.typ != "" {
+			b.WriteString(" : " + sym.typ)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("```")
+	if sym.kind == "field" {
+		b.WriteString("\nfield of " + sym.object)
+	}
+	return hover{Contents: markupContent{Kind: "markdown", Value: b.String()}, Range: f.rangeOf(f.lexemes[k])}
+}
+
+// completion offers the fields of the object before a period,
+// or the rules, relations and objects of a package
+func (s *server) completion(params textDocumentPosition) interface{} {
+	items := []completionItem{}
+	ws := s.workspace()
+	f := ws.file(params.TextDocument.URI)
+	if f == nil {
+		return items
+	}
+	pos := f.modelPosition(params.Position)
+	i := -1
+	for j, l := range f.lexemes {
+		if before(l.Pos, pos) {
+			i = j
+		}
+	}
+	// p.| or p.ag|
+	if f.tok(i) == model.IDENT && f.tok(i-1) == model.PERIOD {
+		i--
+	}
+	if f.tok(i) != model.PERIOD || f.tok(i-1) != model.IDENT {
+		return items
+	}
+	k := i - 1
+
+	// while a field is being typed the document does not parse,
+	// but does with some field name in place
+	if _, err := model.Parse(f.text); err != nil {
+		if ir, err := model.Parse(f.text[:f.offset(pos)] + "x" + f.text[f.offset(pos):]); err == nil {
+			f.ir = ir
+		}
+	}
+
+	if object := ws.objectAt(f, k); object != "" {
+		for _, field := range ws.objects[object].Fields {
+			detail := field.TypeInfo.String()
+			if field.TypeInfo == model.OBJECT {
+				detail = field.ObjectName()
+			}
+			items = append(items, completionItem{Label: field.Name, Kind: kindField, Detail: detail})
+		}
+		return items
+	}
+	prefix := f.lit(k) + "."
+	for name := range ws.rules {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{Label: strings.TrimPrefix(name, prefix), Kind: kindFunction, Detail: "rule"})
+		}
+	}
+	for name := range ws.relations {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{Label: strings.TrimPrefix(name, prefix), Kind: kindFunction, Detail: "relation"})
+		}
+	}
+	for name := range ws.objects {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, completionItem{Label: strings.TrimPrefix(name, prefix), Kind: kindClass, Detail: "object"})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// offset returns the byte offset of pos in the text
+func (f *file) offset(pos model.Position) int {
+	offset := 0
+	for _, line := range f.lines[:pos.Line-1] {
+		offset += len(line) + 1
+	}
+	col := 1
+	for i := range f.lines[pos.Line-1] {
+		if col == pos.Col {
+			return offset + i
+		}
+		col++
+	}
+	return offset + len(f.lines[pos.Line-1])
+}
+
+// rename renames a rule or relation where it is declared and
+// wherever it is called, in every file of the workspace
+func (s *server) rename(params textDocumentPosition, newName string) (interface{}, error) {
+	ws := s.workspace()
+	_, _, sym, ok := symbolAt(ws, params)
+	if !ok || sym.kind != "rule" && sym.kind != "relation" {
+		return nil, fmt.Errorf("only rules and relations can be renamed")
+	}
+	if !model.IsIdentifier(newName) || model.IsBuiltin(newName) {
+		return nil, fmt.Errorf("%q is not a valid name", newName)
+	}
+	qualified := newName
+	if i := strings.LastIndex(sym.name, "."); i >= 0 {
+		qualified = sym.name[:i+1] + newName
+	}
+	if _, ok := ws.declared("call", qualified); ok {
+		return nil, fmt.Errorf("%s is already declared", qualified)
+	}
+	edit := workspaceEdit{Changes: map[string][]textEdit{}}
+	for _, f := range ws.files {
+		for i, l := range f.lexemes {
+			if f.refersTo(i, sym.name) {
+				edit.Changes[f.uri] = append(edit.Changes[f.uri], textEdit{Range: f.rangeOf(l), NewText: newName})
+			}
+		}
+	}
+	return edit, nil
+}
+
+// refersTo reports whether lexeme i declares or calls
+// the rule or relation with the qualified name
+func (f *file) refersTo(i int, name string) bool {
+	if f.tok(i) != model.IDENT {
+		return false
+	}
+	lit := f.lit(i)
+	switch {
+	case f.tok(i-1) == model.RULE || f.tok(i-1) == model.RELATION:
+		return f.qualify(lit) == name
+	case f.tok(i+1) != model.LPAREN:
+		return false
+	case f.tok(i-1) == model.PERIOD:
+		return f.tok(i-2) == model.IDENT && f.lit(i-2)+"."+lit == name
+	}
+	return !model.IsBuiltin(lit) && f.qualify(lit) == name
+}
@@ -0,0 +1,345 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const common = `package common
+
+object prisoner {
+	age  : int,
+	cell : cell
+}
+
+object cell {
+	number : int
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+`
+
+const main = `import "common.rules"
+
+rule phonecall {
+	input {
+		p : common.prisoner
+	}
+	rules {
+		common.adult(p),
+		let n = p.age,
+		n > 100
+	}
+}
+
+test "phonecall" {
+	facts {
+		p : common.prisoner { age : 23 }
+	}
+	rules {
+		phonecall(p)
+	}
+}
+`
+
+// session collects the messages a client sends
+// and the responses of the server to them
+type session struct {
+	t    *testing.T
+	root string
+	in   bytes.Buffer
+	id   int
+
+	results     map[int]json.RawMessage
+	errors      map[int]string
+	diagnostics map[string][]diagnostic
+}
+
+func newSession(t *testing.T) *session {
+	root, err := ioutil.TempDir("", "lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "common.rules"), []byte(common), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &session{t: t, root: root}
+	s.request("initialize", map[string]string{"rootUri": pathToURI(root)})
+	return s
+}
+
+func (s *session) uri(name string) string {
+	return pathToURI(filepath.Join(s.root, name))
+}
+
+func (s *session) send(v interface{}) {
+	if err := write(&s.in, v); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *session) notify(method string, params interface{}) {
+	s.send(notification{"2.0", method, params})
+}
+
+func (s *session) request(method string, params interface{}) int {
+	s.id++
+	s.send(map[string]interface{}{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+	return s.id
+}
+
+func (s *session) open(name, text string) {
+	s.notify("textDocument/didOpen", map[string]interface{}{"textDocument": textDocument{URI: s.uri(name), Text: text}})
+}
+
+// find returns the position of the nth occurrence of
+// needle in text, moved offset characters to the right
+func find(text, needle string, n, offset int) position {
+	i := -1
+	for ; n > 0; n-- {
+		i += 1 + strings.Index(text[i+1:], needle)
+	}
+	lines := strings.Split(text[:i], "\n")
+	return position{Line: len(lines) - 1, Character: len(lines[len(lines)-1]) + offset}
+}
+
+func (s *session) positionRequest(method, name string, p position) int {
+	return s.request(method, textDocumentPosition{TextDocument: textDocument{URI: s.uri(name)}, Position: p})
+}
+
+func (s *session) run() {
+	defer os.RemoveAll(s.root)
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+	var out bytes.Buffer
+	if err := Serve(&s.in, &out); err != nil {
+		s.t.Fatal(err)
+	}
+	s.results, s.errors, s.diagnostics = map[int]json.RawMessage{}, map[int]string{}, map[string][]diagnostic{}
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err != nil {
+			break
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			s.t.Fatal(err)
+		}
+		var m struct {
+			ID     int
+			Method string
+			Params publishDiagnostics
+			Result json.RawMessage
+			Error  *rpcError
+		}
+		if err := json.Unmarshal(body, &m); err != nil {
+			s.t.Fatal(err)
+		}
+		switch {
+		case m.Method == "textDocument/publishDiagnostics":
+			s.diagnostics[m.Params.URI] = m.Params.Diagnostics
+		case m.Error != nil:
+			s.errors[m.ID] = m.Error.Message
+		default:
+			s.results[m.ID] = m.Result
+		}
+	}
+}
+
+func (s *session) result(id int, v interface{}) {
+	if msg, ok := s.errors[id]; ok {
+		s.t.Fatalf("request %d: %s", id, msg)
+	}
+	if err := json.Unmarshal(s.results[id], v); err != nil {
+		s.t.Fatalf("request %d: %s", id, err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	s := newSession(t)
+	broken := strings.Replace(main, "n > 100", "p.name > 100", 1)
+	s.open("main.rules", broken)
+	s.open("syntax.rules", "object prisoner {\n\tage int\n}")
+	s.open("fixed.rules", main)
+	s.run()
+
+	for i, tt := range []struct {
+		uri  string
+		want []diagnostic
+	}{
+		{
+			uri: s.uri("main.rules"),
+			want: []diagnostic{{
+				Range:    lspRange{position{9, 2}, position{9, 14}},
+				Severity: severityError,
+				Source:   "ruleengine",
+				Message:  "rule phonecall: object common.prisoner has no field name",
+			}},
+		},
+		{
+			uri: s.uri("syntax.rules"),
+			want: []diagnostic{{
+				Range:    lspRange{position{2, 0}, position{2, 0}},
+				Severity: severityError,
+				Source:   "ruleengine",
+				Message:  "expected : got ident",
+			}},
+		},
+		{
+			uri:  s.uri("fixed.rules"),
+			want: []diagnostic{},
+		},
+	} {
+		if got := s.diagnostics[tt.uri]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d): got %#v want %#v", i, got, tt.want)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	s := newSession(t)
+	s.open("main.rules", main)
+	ids := []int{
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "adult", 1, 2)),
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "prisoner", 1, 0)),
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "p.age", 1, 3)),
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "n >", 1, 0)),
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "phonecall(p)", 1, 0)),
+		s.positionRequest("textDocument/definition", "main.rules", find(main, "age :", 1, 0)),
+	}
+	s.run()
+
+	for i, want := range []location{
+		{s.uri("common.rules"), lspRange{find(common, "adult", 1, 0), find(common, "adult", 1, 5)}},
+		{s.uri("common.rules"), lspRange{find(common, "prisoner", 1, 0), find(common, "prisoner", 1, 8)}},
+		{s.uri("common.rules"), lspRange{find(common, "age  :", 1, 0), find(common, "age  :", 1, 3)}},
+		{s.uri("main.rules"), lspRange{find(main, "n =", 1, 0), find(main, "n =", 1, 1)}},
+		{s.uri("main.rules"), lspRange{find(main, "phonecall", 1, 0), find(main, "phonecall", 1, 9)}},
+		{s.uri("common.rules"), lspRange{find(common, "age  :", 1, 0), find(common, "age  :", 1, 3)}},
+	} {
+		var got location
+		s.result(ids[i], &got)
+		if got != want {
+			t.Errorf("%d): got %#v want %#v", i, got, want)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	s := newSession(t)
+	s.open("main.rules", main)
+	ids := []int{
+		s.positionRequest("textDocument/hover", "main.rules", find(main, "age :", 1, 1)),
+		s.positionRequest("textDocument/hover", "main.rules", find(main, "n >", 1, 0)),
+		s.positionRequest("textDocument/hover", "main.rules", find(main, "p.age", 1, 2)),
+		s.positionRequest("textDocument/hover", "main.rules", find(main, "adult", 1, 0)),
+		s.positionRequest("textDocument/hover", "main.rules", find(main, "prisoner", 1, 0)),
+	}
+	s.run()
+
+	for i, want := range []string{
+		"```\nage : int\n```\nfield of common.prisoner",
+		"```\nn : int\n```",
+		"```\nage : int\n```\nfield of common.prisoner",
+		"```\nrule common.adult(p : common.prisoner)\n```",
+		"```\nobject common.prisoner {\n\tage  : int,\n\tcell : common.cell\n}\n```",
+	} {
+		var got hover
+		s.result(ids[i], &got)
+		if got.Contents.Value != want {
+			t.Errorf("%d): got %q want %q", i, got.Contents.Value, want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	s := newSession(t)
+	typing := strings.Replace(main, "n > 100", "p.cell.nu", 1)
+	s.open("main.rules", typing)
+	ids := []int{
+		s.positionRequest("textDocument/completion", "main.rules", find(typing, "p.cell", 1, 2)),
+		s.positionRequest("textDocument/completion", "main.rules", find(typing, "p.cell.nu", 1, 9)),
+		s.positionRequest("textDocument/completion", "main.rules", find(typing, "common.adult", 1, 7)),
+	}
+	s.run()
+
+	for i, want := range [][]completionItem{
+		{{"age", kindField, "int"}, {"cell", kindField, "common.cell"}},
+		{{"number", kindField, "int"}},
+		{{"adult", kindFunction, "rule"}, {"cell", kindClass, "object"}, {"prisoner", kindClass, "object"}},
+	} {
+		var got []completionItem
+		s.result(ids[i], &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d): got %#v want %#v", i, got, want)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := newSession(t)
+	s.open("main.rules", main)
+	rename := func(p position, name string) int {
+		return s.request("textDocument/rename", map[string]interface{}{
+			"textDocument": textDocument{URI: s.uri("main.rules")},
+			"position":     p,
+			"newName":      name,
+		})
+	}
+	ids := []int{
+		rename(find(main, "adult", 1, 0), "grownUp"),
+		rename(find(main, "phonecall", 1, 0), "mayCall"),
+		rename(find(main, "adult", 1, 0), "phonecall"),
+		rename(find(main, "n >", 1, 0), "m"),
+		rename(find(main, "adult", 1, 0), "length"),
+	}
+	s.run()
+
+	for i, want := range []map[string][]textEdit{
+		{
+			s.uri("common.rules"): {{lspRange{find(common, "adult", 1, 0), find(common, "adult", 1, 5)}, "grownUp"}},
+			s.uri("main.rules"):   {{lspRange{find(main, "adult", 1, 0), find(main, "adult", 1, 5)}, "grownUp"}},
+		},
+		{
+			s.uri("main.rules"): {
+				{lspRange{find(main, "phonecall", 1, 0), find(main, "phonecall", 1, 9)}, "mayCall"},
+				{lspRange{find(main, "phonecall(p)", 1, 0), find(main, "phonecall(p)", 1, 9)}, "mayCall"},
+			},
+		},
+	} {
+		var got workspaceEdit
+		s.result(ids[i], &got)
+		if !reflect.DeepEqual(got.Changes, want) {
+			t.Errorf("%d): got %#v want %#v", i, got.Changes, want)
+		}
+	}
+	for i, want := range []string{
+		// a rule of another package can take the name
+		"",
+		"only rules and relations can be renamed",
+		`"length" is not a valid name`,
+	} {
+		if got := s.errors[ids[i+2]]; got != want {
+			t.Errorf("%d): got %q want %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"model"
+)
+
+// Serve runs a language server for rulebases reading requests
+// from r and writing responses to w, until the client sends exit.
+// Documents are analysed together with the other .rules files
+// under the root the client opens, so imports resolve and rules
+// can be renamed across files.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		w:      w,
+		docs:   map[string]string{},
+		parsed: map[string]model.InternalRepresentation{},
+	}
+	in := bufio.NewReader(r)
+	for {
+		m, err := read(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(m)
+		if m.ID == nil {
+			continue
+		}
+		if err != nil {
+			rerr, ok := err.(*rpcError)
+			if !ok {
+				rerr = &rpcError{Code: invalidParams, Message: err.Error()}
+			}
+			err = write(w, errorResponse{JSONRPC: "2.0", ID: m.ID, Error: rerr})
+		} else {
+			err = write(w, response{JSONRPC: "2.0", ID: m.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	w    io.Writer
+	root string
+	// text of the open documents by uri
+	docs map[string]string
+	// last parse of each open document that succeeded, used
+	// for scopes while a document is being edited
+	parsed   map[string]model.InternalRepresentation
+	shutdown bool
+}
+
+func (s *server) handle(m message) (interface{}, error) {
+	switch m.Method {
+	case "initialize":
+		var params struct {
+			RootURI  string `json:"rootUri"`
+			RootPath string `json:"rootPath"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		s.root = params.RootPath
+		if params.RootURI != "" {
+			s.root = uriToPath(params.RootURI)
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full document on each change
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+				"renameProvider":     true,
+			},
+			"serverInfo": map[string]string{"name": "ruleengine"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		s.docs[params.TextDocument.URI] = params.TextDocument.Text
+		return nil, s.publish()
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   textDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+		return nil, s.publish()
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument textDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		uri := params.TextDocument.URI
+		delete(s.docs, uri)
+		delete(s.parsed, uri)
+		if err := write(s.w, notification{"2.0", "textDocument/publishDiagnostics", publishDiagnostics{uri, []diagnostic{}}}); err != nil {
+			return nil, err
+		}
+		return nil, s.publish()
+	case "textDocument/didSave", "initialized", "$/cancelRequest":
+		return nil, nil
+	case "textDocument/definition":
+		var params textDocumentPosition
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+	case "textDocument/hover":
+		var params textDocumentPosition
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+	case "textDocument/completion":
+		var params textDocumentPosition
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+	case "textDocument/rename":
+		var params struct {
+			textDocumentPosition
+			NewName string `json:"newName"`
+		}
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.rename(params.textDocumentPosition, params.NewName)
+	}
+	return nil, &rpcError{Code: methodNotFound, Message: "method not found: " + m.Method}
+}
+
+// publish sends the diagnostics of every open document,
+// since a change can break the documents importing it
+func (s *server) publish() error {
+	uris := []string{}
+	for uri := range s.docs {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		params := publishDiagnostics{URI: uri, Diagnostics: s.diagnostics(uri)}
+		if err := write(s.w, notification{"2.0", "textDocument/publishDiagnostics", params}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diagnostics returns the syntax error in a document, or the
+// type errors found loading it with everything it imports
+func (s *server) diagnostics(uri string) []diagnostic {
+	f := newFile(uri, s.docs[uri])
+	diagnostics := []diagnostic{}
+	ir, err := model.Parse(f.text)
+	if perr, ok := err.(model.ParseError); ok {
+		start := f.position(model.Position{Line: perr.Row, Col: perr.Col})
+		return append(diagnostics, diagnostic{lspRange{start, start}, severityError, "ruleengine", perr.Err.Error()})
+	}
+	s.parsed[uri] = ir
+	// a document outside of the root is checked on its own
+	path, ok := s.relative(uri)
+	if ok {
+		if ir, err = model.LoadWith(s.read, path); err != nil {
+			// import errors go on the first line
+			return append(diagnostics, diagnostic{f.lineRange(1), severityError, "ruleengine", err.Error()})
+		}
+	}
+	for _, err := range model.Check(ir) {
+		pos := model.Position{Line: 1, Col: 1}
+		if cerr, ok := err.(model.CheckError); ok {
+			d, found := declaration(ir, cerr.Kind, cerr.Name)
+			if found && d.Pos.File != path {
+				continue
+			}
+			if found {
+				pos = errorPosition(d, cerr)
+			}
+		}
+		diagnostics = append(diagnostics, diagnostic{f.lineRange(pos.Line), severityError, "ruleengine", err.Error()})
+	}
+	return diagnostics
+}
+
+func declaration(ir model.InternalRepresentation, kind, name string) (model.Declaration, bool) {
+	for _, d := range ir.Declarations {
+		if d.Kind == kind && d.Name == name {
+			return d, true
+		}
+	}
+	return model.Declaration{}, false
+}
+
+// errorPosition returns the position of the condition, fact
+// or rule call an error was found in
+func errorPosition(d model.Declaration, err model.CheckError) model.Position {
+	var positions []model.Position
+	switch {
+	case err.Kind == "test" && err.Body == 0:
+		positions = d.Fields
+	case err.Kind == "test" && err.Body == 1 && len(d.Clauses) > 0:
+		positions = d.Clauses[0]
+	case err.Kind == "rule" && err.Body >= 0 && err.Body < len(d.Clauses):
+		positions = d.Clauses[err.Body]
+	}
+	if err.Condition >= 0 && err.Condition < len(positions) {
+		return positions[err.Condition]
+	}
+	return d.Pos
+}
+
+// read reads a file relative to the root,
+// the open document if there is one
+func (s *server) read(path string) (string, error) {
+	full := filepath.Join(s.root, path)
+	for uri, text := range s.docs {
+		if uriToPath(uri) == full {
+			return text, nil
+		}
+	}
+	b, err := ioutil.ReadFile(full)
+	return string(b), err
+}
+
+// relative returns the path of a document relative to the root
+func (s *server) relative(uri string) (string, bool) {
+	if s.root == "" {
+		return "", false
+	}
+	path, err := filepath.Rel(s.root, uriToPath(uri))
+	if err != nil || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+// workspace reads the .rules files under the root and the open
+// documents; a document that does not parse keeps its last parse
+func (s *server) workspace() *workspace {
+	// documents by path, as clients may encode uris differently
+	type document struct{ uri, text string }
+	docs := map[string]document{}
+	if s.root != "" {
+		filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() && path != s.root && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !info.IsDir() && strings.HasSuffix(path, ".rules") {
+				if b, err := ioutil.ReadFile(path); err == nil {
+					docs[path] = document{pathToURI(path), string(b)}
+				}
+			}
+			return nil
+		})
+	}
+	for uri, text := range s.docs {
+		path := uriToPath(uri)
+		if path == "" {
+			path = uri
+		}
+		docs[path] = document{uri, text}
+	}
+	paths := []string{}
+	for path := range docs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	ws := newWorkspace()
+	for _, path := range paths {
+		f := newFile(docs[path].uri, docs[path].text)
+		ir, err := model.Parse(f.text)
+		if err != nil {
+			ir = s.parsed[f.uri]
+		}
+		f.ir = ir
+		ws.add(f)
+	}
+	return ws
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,428 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"model"
+)
+
+// file is a document with its tokens and its last parse.
+// Lookups work on the tokens, so they keep working while the
+// document is being edited and does not parse; scopes come
+// from the parse.
+type file struct {
+	uri, text string
+	lines     []string
+	// tokens without the comments
+	lexemes []model.Lexeme
+	ir      model.InternalRepresentation
+}
+
+func newFile(uri, text string) *file {
+	f := &file{uri: uri, text: text, lines: strings.Split(text, "\n")}
+	for _, l := range model.Scan(text) {
+		if l.Tok != model.COMMENT {
+			f.lexemes = append(f.lexemes, l)
+		}
+	}
+	return f
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// position converts a position in runes counted from 1 to one
+// of the protocol, counted from 0 in UTF-16 code units
+func (f *file) position(pos model.Position) position {
+	p := position{Line: pos.Line - 1}
+	if p.Line < 0 || p.Line >= len(f.lines) {
+		return p
+	}
+	col := 1
+	for _, r := range f.lines[p.Line] {
+		if col >= pos.Col {
+			break
+		}
+		p.Character += utf16Len(r)
+		col++
+	}
+	return p
+}
+
+// modelPosition converts a position of the protocol to one in runes
+func (f *file) modelPosition(p position) model.Position {
+	pos := model.Position{Line: p.Line + 1, Col: 1}
+	if p.Line < 0 || p.Line >= len(f.lines) {
+		return pos
+	}
+	n := 0
+	for _, r := range f.lines[p.Line] {
+		if n >= p.Character {
+			break
+		}
+		n += utf16Len(r)
+		pos.Col++
+	}
+	return pos
+}
+
+func (f *file) rangeOf(l model.Lexeme) lspRange {
+	end := l.Pos
+	end.Col += utf8.RuneCountInString(l.Lit)
+	return lspRange{f.position(l.Pos), f.position(end)}
+}
+
+// lineRange spans a line without its indentation
+func (f *file) lineRange(line int) lspRange {
+	r := lspRange{position{Line: line - 1}, position{Line: line - 1}}
+	if line < 1 || line > len(f.lines) {
+		return r
+	}
+	text := strings.TrimRight(f.lines[line-1], " \t\r")
+	for _, c := range text {
+		r.End.Character += utf16Len(c)
+	}
+	r.Start.Character = len(text) - len(strings.TrimLeft(text, " \t"))
+	return r
+}
+
+func (f *file) tok(i int) model.Token {
+	if i < 0 || i >= len(f.lexemes) {
+		return model.ILLEGAL
+	}
+	return f.lexemes[i].Tok
+}
+
+func (f *file) lit(i int) string {
+	return f.lexemes[i].Lit
+}
+
+// ident returns the identifier at pos, or just before
+// it, as when completing a word; -1 if there is none
+func (f *file) ident(pos model.Position) int {
+	for i, l := range f.lexemes {
+		end := l.Pos.Col + utf8.RuneCountInString(l.Lit)
+		if l.Tok == model.IDENT && l.Pos.Line == pos.Line && l.Pos.Col <= pos.Col && pos.Col <= end {
+			return i
+		}
+	}
+	return -1
+}
+
+// lexeme returns the token starting at pos, -1 if there is none
+func (f *file) lexeme(pos model.Position) int {
+	for i, l := range f.lexemes {
+		if l.Pos.Line == pos.Line && l.Pos.Col == pos.Col {
+			return i
+		}
+	}
+	return -1
+}
+
+// pkg returns the package of the file, read
+// from its tokens as the parse may be old
+func (f *file) pkg() string {
+	if f.tok(0) == model.PACKAGE && f.tok(1) == model.IDENT {
+		return f.lit(1)
+	}
+	return ""
+}
+
+// qualify qualifies a name as the parser does
+func (f *file) qualify(name string) string {
+	if pkg := f.pkg(); pkg != "" {
+		return pkg + "." + name
+	}
+	return name
+}
+
+// fieldsOf returns the object or relation whose fields are listed
+// in the braces around lexeme k, in a declaration or a fact
+func (f *file) fieldsOf(k int) (string, bool) {
+	depth := 0
+	for i := k - 1; i >= 0; i-- {
+		switch f.tok(i) {
+		case model.RBRACE:
+			depth++
+		case model.LBRACE:
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if f.tok(i-1) == model.IDENT && (f.tok(i-2) == model.OBJECT || f.tok(i-2) == model.RELATION) {
+				return f.qualify(f.lit(i - 1)), true
+			}
+			// p : prisoner { or p : pkg.prisoner {
+			if f.tok(i-1) == model.IDENT && f.tok(i-2) == model.COLON {
+				return f.qualify(f.lit(i - 1)), true
+			}
+			if f.tok(i-1) == model.IDENT && f.tok(i-2) == model.PERIOD && f.tok(i-3) == model.IDENT && f.tok(i-4) == model.COLON {
+				return f.lit(i-3) + "." + f.lit(i-1), true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// symbol is what an identifier refers to
+type symbol struct {
+	// object, relation, rule, field or variable
+	kind string
+	// qualified name, or name of a field or variable
+	name string
+	// object or relation of a field
+	object string
+	// type of a field or variable
+	typ string
+	// where it is declared
+	file *file
+	pos  model.Position
+}
+
+type workspace struct {
+	files     []*file
+	objects   map[string]model.Object
+	relations map[string]model.Relation
+	rules     map[string]model.Rule
+}
+
+func newWorkspace() *workspace {
+	return &workspace{
+		objects:   map[string]model.Object{},
+		relations: map[string]model.Relation{},
+		rules:     map[string]model.Rule{},
+	}
+}
+
+func (ws *workspace) add(f *file) {
+	ws.files = append(ws.files, f)
+	for name, o := range f.ir.Objects {
+		ws.objects[name] = o
+	}
+	for name, r := range f.ir.Relations {
+		ws.relations[name] = r
+	}
+	for name, r := range f.ir.Rules {
+		ws.rules[name] = r
+	}
+}
+
+func (ws *workspace) file(uri string) *file {
+	for _, f := range ws.files {
+		if f.uri == uri {
+			return f
+		}
+	}
+	return nil
+}
+
+// ir merges the declarations of all files, for type checking
+func (ws *workspace) ir() model.InternalRepresentation {
+	return model.InternalRepresentation{Objects: ws.objects, Relations: ws.relations, Rules: ws.rules}
+}
+
+// declaration returns the first declaration of kind, where
+// "call" is a rule or a relation since both are called alike
+func (ws *workspace) declaration(kind, name string) (*file, model.Declaration, bool) {
+	for _, f := range ws.files {
+		for _, d := range f.ir.Declarations {
+			if d.Name != name {
+				continue
+			}
+			if d.Kind == kind || kind == "call" && (d.Kind == "rule" || d.Kind == "relation") {
+				return f, d, true
+			}
+		}
+	}
+	return nil, model.Declaration{}, false
+}
+
+func (ws *workspace) declared(kind, name string) (symbol, bool) {
+	f, d, ok := ws.declaration(kind, name)
+	if !ok {
+		return symbol{}, false
+	}
+	return symbol{kind: d.Kind, name: name, file: f, pos: d.Pos}, true
+}
+
+// field returns the symbol for a field of an object or relation
+func (ws *workspace) field(object, name string) (symbol, bool) {
+	fields, kind := ws.objects[object].Fields, "object"
+	if _, ok := ws.relations[object]; ok {
+		fields, kind = ws.relations[object].Fields, "relation"
+	}
+	for i, f := range fields {
+		if f.Name != name {
+			continue
+		}
+		s := symbol{kind: "field", name: name, object: object, typ: f.TypeInfo.String()}
+		if f.TypeInfo == model.OBJECT {
+			s.typ = f.ObjectName()
+		}
+		if file, d, ok := ws.declaration(kind, object); ok {
+			s.file, s.pos = file, at(d.Fields, i)
+		}
+		return s, true
+	}
+	return symbol{}, false
+}
+
+// resolve returns what the identifier at lexeme k of f refers to
+func (ws *workspace) resolve(f *file, k int) (symbol, bool) {
+	lit := f.lit(k)
+	if f.tok(k-1) == model.PERIOD && f.tok(k-2) == model.IDENT {
+		// pkg.rule(, pkg.object or a field access
+		qualified := f.lit(k-2) + "." + lit
+		if f.tok(k+1) == model.LPAREN {
+			return ws.declared("call", qualified)
+		}
+		if s, ok := ws.declared("object", qualified); ok {
+			return s, true
+		}
+		return ws.field(ws.objectAt(f, k-2), lit)
+	}
+	switch {
+	case f.tok(k+1) == model.LPAREN:
+		if model.IsBuiltin(lit) {
+			return symbol{}, false
+		}
+		return ws.declared("call", f.qualify(lit))
+	case f.tok(k-1) == model.OBJECT:
+		return ws.declared("object", f.qualify(lit))
+	case f.tok(k-1) == model.RELATION, f.tok(k-1) == model.RULE:
+		return ws.declared("call", f.qualify(lit))
+	case f.tok(k+1) == model.COLON:
+		// a field being declared or given a value
+		if object, ok := f.fieldsOf(k); ok {
+			return ws.field(object, lit)
+		}
+	case f.tok(k-1) == model.COLON:
+		if s, ok := ws.declared("object", f.qualify(lit)); ok {
+			return s, true
+		}
+	}
+	s, ok := ws.variables(f, f.lexemes[k].Pos)[lit]
+	return s, ok
+}
+
+// objectAt returns the object the expression ending
+// in the identifier at lexeme k evaluates to, if any
+func (ws *workspace) objectAt(f *file, k int) string {
+	var typ string
+	if f.tok(k-1) == model.PERIOD && f.tok(k-2) == model.IDENT {
+		s, _ := ws.field(ws.objectAt(f, k-2), f.lit(k))
+		typ = s.typ
+	} else {
+		typ = ws.variables(f, f.lexemes[k].Pos)[f.lit(k)].typ
+	}
+	if _, ok := ws.objects[typ]; ok {
+		return typ
+	}
+	return ""
+}
+
+// variables returns the variables in scope at pos: the inputs
+// of a rule and the lets of the body pos is in, or the objects
+// instantiated by the facts of a test
+func (ws *workspace) variables(f *file, pos model.Position) map[string]symbol {
+	vars := map[string]symbol{}
+	tests := 0
+	for _, d := range f.ir.Declarations {
+		inside := !before(pos, d.Pos) && !before(d.End, pos)
+		switch {
+		case d.Kind == "test" && inside:
+			for i, fact := range f.ir.Tests[tests].Facts {
+				if fact.Functor == "new" {
+					v := fact.Args[0].(model.Term)
+					vars[v.Value.(string)] = symbol{kind: "variable", name: v.Value.(string), typ: v.ObjectName(), file: f, pos: at(d.Fields, i)}
+				}
+			}
+		case d.Kind == "rule" && inside:
+			ws.ruleVariables(f, d, pos, vars)
+		}
+		if d.Kind == "test" {
+			tests++
+		}
+	}
+	return vars
+}
+
+func (ws *workspace) ruleVariables(f *file, d model.Declaration, pos model.Position, vars map[string]symbol) {
+	r := f.ir.Rules[d.Name]
+	scope := map[string]model.Term{}
+	for i, a := range r.Args {
+		scope[a.Value.(string)] = a
+		vars[a.Value.(string)] = symbol{kind: "variable", name: a.Value.(string), typ: typeName(a), file: f, pos: at(d.Fields, i)}
+	}
+	var body []model.Expression
+	var positions []model.Position
+	for j, b := range r.Clauses() {
+		if block := at(d.Blocks, j+1); block.Line == 0 || !before(pos, block) {
+			body = b
+			positions = nil
+			if j < len(d.Clauses) {
+				positions = d.Clauses[j]
+			}
+		}
+	}
+	for i, e := range body {
+		if e.Functor != "let" {
+			continue
+		}
+		v := e.Args[0].(model.Term)
+		name := v.Value.(string)
+		if len(e.Args) == 2 {
+			v = model.Term{Value: name, TypeInfo: ws.ir().TypeOf(e.Args[1], scope)}
+			if v.TypeInfo == model.OBJECT {
+				v = model.ObjectTerm(name, ws.objectOf(e.Args[1], scope))
+			}
+		}
+		scope[name] = v
+		vars[name] = symbol{kind: "variable", name: name, typ: typeName(v), file: f, pos: at(positions, i)}
+	}
+}
+
+// objectOf returns the object a term or field access evaluates to
+func (ws *workspace) objectOf(n model.Node, scope map[string]model.Term) string {
+	switch n := n.(type) {
+	case model.Term:
+		name, _ := n.Value.(string)
+		if t, ok := scope[name]; ok && t.TypeInfo == model.OBJECT {
+			return t.ObjectName()
+		}
+	case model.Expression:
+		if n.Functor == "." {
+			s, _ := ws.field(ws.objectOf(n.Args[0], scope), n.Args[1].(model.Term).Value.(string))
+			return s.typ
+		}
+	}
+	return ""
+}
+
+func typeName(t model.Term) string {
+	if t.TypeInfo == model.OBJECT {
+		return t.ObjectName()
+	}
+	if t.TypeInfo == model.ILLEGAL {
+		return ""
+	}
+	return t.TypeInfo.String()
+}
+
+// before reports whether a comes before b
+func before(a, b model.Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+func at(positions []model.Position, i int) model.Position {
+	if i < len(positions) {
+		return positions[i]
+	}
+	return model.Position{}
+}
@@ -1,8 +1,26 @@
 package main
 
 import (
+	"backend"
+	"bytes"
+	"consistency"
+	"coverage"
+	"deps"
+	"flag"
+	"fmt"
+	"format"
+	"impact"
+	"io/ioutil"
+	"lint"
+	"lsp"
 	"model"
+	"os"
 	"prolog"
+	"repl"
+	"strings"
+	"testgen"
+	"time"
+	"watch"
 )
 
 /*
@@ -27,9 +45,69 @@ Endgoal:
 
 func main() {
 
+	// lint file.rules prints the issues found as JSON
+	if len(os.Args) > 2 && os.Args[1] == "lint" {
+		os.Exit(runLint(os.Args[2]))
+	}
+
+	// coverage [-html] file.rules runs the tests in the interpreter
+	// and prints which rules and conditions they exercised
+	if len(os.Args) > 2 && os.Args[1] == "coverage" {
+		html := os.Args[2] == "-html"
+		os.Exit(runCoverage(os.Args[len(os.Args)-1], html))
+	}
+
+	// fmt [-l] [-w] file.rules... prints the files in canonical
+	// style, -l lists the files that change and -w rewrites them
+	if len(os.Args) > 2 && os.Args[1] == "fmt" {
+		os.Exit(runFmt(os.Args[2:]))
+	}
+
+	// deps [-format dot|json] [-what name] file.rules prints which
+	// declarations use which, or those depending on name, which can
+	// be a field as object.field
+	if len(os.Args) > 2 && os.Args[1] == "deps" {
+		os.Exit(runDeps(os.Args[2:]))
+	}
+
+	// diff [-json] old.rules new.rules prints the declarations that
+	// changed between two versions and the tests whose outcome changed
+	if len(os.Args) > 2 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+
+	// gen [-rule name] file.rules prints tests for each rule, or
+	// the one named: inputs for which it holds and for which it fails
+	if len(os.Args) > 2 && os.Args[1] == "gen" {
+		os.Exit(runGen(os.Args[2:]))
+	}
+
+	// consistency [-json] file.rules prints the rules whose conditions
+	// contradict each other, rules blocks that never add anything, and
+	// pairs of rules that always agree or never do
+	if len(os.Args) > 2 && os.Args[1] == "consistency" {
+		os.Exit(runConsistency(os.Args[2:]))
+	}
+
+	// lsp serves the language server protocol on stdin and stdout
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		os.Exit(runLSP())
+	}
+
+	// test [--watch] dir runs the tests of the .rules files in dir,
+	// --watch reruns those affected whenever a file changes
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTest(os.Args[2:]))
+	}
+
+	// repl starts an interactive session for writing a rulebase
+	// test first, :help lists its commands
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		os.Exit(runREPL())
+	}
+
 	// 1. Read DSL
 
-	// TODO: 18 + 1 (eval) (golog does this in-place?)
 	// TODO: difference between facts(relations with arity?) and rules
 	// TODO: if-then-else
 	// TODO: support for-all and exists
@@ -94,11 +172,352 @@ func main() {
 	`
 	ir := model.Read(s)
 
+	// a rulebase file given as argument is read instead,
+	// resolving its imports from the current directory
+	if len(os.Args) > 1 {
+		var err error
+		ir, err = model.Load(".", os.Args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// 1.1 Type checking
+
+	if errs := model.Check(ir); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		os.Exit(1)
+	}
+
 	// 2. Generate Prolog
 
-	m := prolog.Generate(ir)
+	var b backend.Backend = prolog.NewGolog()
+	if err := b.Load(ir); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// 3. Execute Prolog tests
 
-	prolog.TestRulebase(m)
+	results, err := b.RunTests()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, r := range results {
+		status := "ok  "
+		if !r.OK() {
+			status = "FAIL"
+		}
+		fmt.Printf("%s %s\n", status, r.Name)
+	}
+}
+
+func runLint(file string) int {
+	ir, err := model.Load(".", file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	issues := lint.Lint(ir)
+	b, err := lint.JSON(issues)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(b))
+	if lint.HasErrors(issues) {
+		return 1
+	}
+	return 0
+}
+
+func runCoverage(file string, html bool) int {
+	ir, err := model.Load(".", file)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	report, _, err := coverage.Run(ir)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if html {
+		sources := map[string]string{}
+		for _, d := range ir.Declarations {
+			if _, ok := sources[d.Pos.File]; ok {
+				continue
+			}
+			b, err := ioutil.ReadFile(d.Pos.File)
+			if err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			sources[d.Pos.File] = string(b)
+		}
+		if err := report.HTML(os.Stdout, sources); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		return 0
+	}
+	b, err := report.JSON()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(b))
+	return 0
+}
+
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := flags.Bool("l", false, "list files whose formatting differs")
+	write := flags.Bool("w", false, "write the result to the file")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	status := 0
+	for _, file := range flags.Args() {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			status = 1
+			continue
+		}
+		out, err := format.Source(src)
+		if err != nil {
+			fmt.Printf("%s: %s\n", file, err)
+			status = 1
+			continue
+		}
+		changed := !bytes.Equal(src, out)
+		if *list && changed {
+			fmt.Println(file)
+		}
+		if *write && changed {
+			if err := ioutil.WriteFile(file, out, 0644); err != nil {
+				fmt.Println(err)
+				status = 1
+			}
+		}
+		if !*list && !*write {
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
+
+func runDeps(args []string) int {
+	flags := flag.NewFlagSet("deps", flag.ContinueOnError)
+	formatName := flags.String("format", "dot", "output format, dot or json")
+	what := flags.String("what", "", "list the declarations depending on this one")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+	ir, err := model.Load(".", flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	g := deps.Build(ir)
+	if *what != "" {
+		nodes := g.Lookup(*what)
+		if len(nodes) == 0 {
+			fmt.Printf("no declaration %s\n", *what)
+			return 1
+		}
+		dependents := map[deps.Node]bool{}
+		for _, n := range nodes {
+			for m := range g.Dependents(n) {
+				dependents[m] = true
+			}
+		}
+		for _, n := range g.Nodes {
+			if dependents[n] {
+				fmt.Println(n)
+			}
+		}
+		return 0
+	}
+	switch *formatName {
+	case "dot":
+		err = g.DOT(os.Stdout)
+	case "json":
+		var b []byte
+		if b, err = g.JSON(); err == nil {
+			fmt.Println(string(b))
+		}
+	default:
+		err = fmt.Errorf("unknown format %s", *formatName)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 2 {
+		return 2
+	}
+	old, err := model.Load(".", flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	new, err := model.Load(".", flags.Arg(1))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	r, err := impact.Compare(old, new)
+	if err == nil && *asJSON {
+		var b []byte
+		if b, err = r.JSON(); err == nil {
+			fmt.Println(string(b))
+		}
+	} else if err == nil {
+		err = r.Text(os.Stdout)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func runConsistency(args []string) int {
+	flags := flag.NewFlagSet("consistency", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the findings as JSON")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+	ir, err := model.Load(".", flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if errs := model.Check(ir); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		return 1
+	}
+	findings := consistency.Analyze(ir)
+	if !*asJSON {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		return 0
+	}
+	b, err := consistency.JSON(findings)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(b))
+	return 0
+}
+
+func runGen(args []string) int {
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	rule := flags.String("rule", "", "generate tests for this rule only")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return 2
+	}
+	ir, err := model.Load(".", flags.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if errs := model.Check(ir); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		return 1
+	}
+	rules := ir.RuleNames()
+	if *rule != "" {
+		rules = []string{*rule}
+		if ir.Package != "" && !strings.Contains(*rule, ".") {
+			rules[0] = ir.Package + "." + *rule
+		}
+	}
+	status := 0
+	tests := model.InternalRepresentation{Package: ir.Package}
+	today := false
+	for _, name := range rules {
+		examples, err := testgen.Generate(ir, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		today = today || examples.Today
+		for _, t := range examples.Tests {
+			tests.Tests = append(tests.Tests, t)
+			tests.Declarations = append(tests.Declarations, model.Declaration{Kind: "test", Name: t.Name})
+		}
+	}
+	if today {
+		fmt.Printf("# generated on %s, tests near a boundary on today's date\n# may change outcome on another day\n\n", model.Today().Format("2006-01-02"))
+	}
+	if err := format.Fprint(os.Stdout, tests); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return status
+}
+
+func runLSP() int {
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		// stdout belongs to the protocol
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runREPL() int {
+	if err := repl.Run(os.Stdin, os.Stdout); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	watching := flags.Bool("watch", false, "rerun the tests affected by changes to the files")
+	interval := flags.Duration("interval", 500*time.Millisecond, "how often to look for changes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	if *watching {
+		if err := watch.Watch(dir, os.Stdout, *interval); err != nil {
+			fmt.Println(err)
+		}
+		return 1
+	}
+	w := watch.New(dir)
+	if _, err := w.Poll(os.Stdout); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if !w.Passed() {
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,185 @@
+package mercury
+
+import (
+	"fmt"
+	"strings"
+
+	. "model"
+)
+
+// printGoal prints a condition in a rule body. Unlike in Prolog,
+// expressions nest as Mercury functions so no side effects are
+// needed. let x : type prints nothing: Mercury infers the type.
+func printGoal(g *generator, e Expression) (string, error) {
+	if e.Functor == "let" {
+		name := e.Args[0].(Term)
+		if len(e.Args) == 1 {
+			g.scope[name.Value.(string)] = name
+			g.unbound[name.Value.(string)] = true
+			return "", nil
+		}
+		value, err := printExpr(g, e.Args[1])
+		if err != nil {
+			return "", err
+		}
+		v := Term{Value: name.Value, TypeInfo: g.typeOf(e.Args[1])}
+		if o, ok := e.Args[1].(Term); ok && o.TypeInfo == OBJECT {
+			v = ObjectTerm(name.Value.(string), o.ObjectName())
+		}
+		g.scope[name.Value.(string)] = v
+		return fmt.Sprintf("%s = %s", printVar(name.Value.(string)), value), nil
+	}
+
+	args := make([]string, len(e.Args))
+	for i, n := range e.Args {
+		a, err := printExpr(g, n)
+		if err != nil {
+			return "", err
+		}
+		args[i] = a
+	}
+	if _, ok := g.ir.Relations[e.Functor]; ok {
+		for _, n := range e.Args {
+			if v, ok := n.(Term); ok && (v.TypeInfo == IDENT || v.TypeInfo == OBJECT) {
+				delete(g.unbound, v.Value.(string))
+			}
+		}
+		return fmt.Sprintf("list.member(%s, Facts ^ %s)", printCall(e.Functor, args), printName(e.Functor)), nil
+	}
+	if _, ok := g.ir.Rules[e.Functor]; ok {
+		// rules only take inputs, an object declared with let and
+		// not bound yet is tried against every one in the facts
+		goals := []string{}
+		for _, n := range e.Args {
+			v, ok := n.(Term)
+			if !ok || v.TypeInfo != IDENT && v.TypeInfo != OBJECT {
+				continue
+			}
+			name := v.Value.(string)
+			if s := g.scope[name]; g.unbound[name] && s.TypeInfo == OBJECT {
+				delete(g.unbound, name)
+				g.candidates[s.ObjectName()] = true
+				goals = append(goals, fmt.Sprintf("list.member(%s, all_%s(Facts))", printVar(name), printName(s.ObjectName())))
+			}
+		}
+		goals = append(goals, printCall(e.Functor, append([]string{"Facts"}, args...)))
+		return strings.Join(goals, ",\n\t"), nil
+	}
+
+	switch e.Functor {
+	case "startsWith":
+		return fmt.Sprintf("string.prefix(%s, %s)", args[0], args[1]), nil
+	case "contains":
+		return fmt.Sprintf("string.sub_string_search(%s, %s, _)", args[0], args[1]), nil
+	case "matches":
+		g.regexp = true
+		return fmt.Sprintf("matches(%s, %s)", args[0], args[1]), nil
+	case "==":
+		printNumbers(g, e, args)
+		return fmt.Sprintf("%s = %s", args[0], args[1]), nil
+	case "!=":
+		printNumbers(g, e, args)
+		return fmt.Sprintf("%s \\= %s", args[0], args[1]), nil
+	case "<", "<=", ">", ">=":
+		return printComparison(g, e, args), nil
+	}
+	return "", fmt.Errorf("%s does not evaluate to true or false", e.Functor)
+}
+
+// numbers compare arithmetically, other values using
+// the standard ordering on terms through compare/3
+func printComparison(g *generator, e Expression, args []string) string {
+	t := g.typeOf(e.Args[0])
+	if t == INT || t == FLOAT {
+		printNumbers(g, e, args)
+		op := e.Functor
+		if op == "<=" {
+			op = "=<"
+		}
+		return fmt.Sprintf("%s %s %s", args[0], op, args[1])
+	}
+	switch e.Functor {
+	case "<":
+		return fmt.Sprintf("compare((<), %s, %s)", args[0], args[1])
+	case ">":
+		return fmt.Sprintf("compare((>), %s, %s)", args[0], args[1])
+	case "<=":
+		return fmt.Sprintf("not compare((>), %s, %s)", args[0], args[1])
+	}
+	return fmt.Sprintf("not compare((<), %s, %s)", args[0], args[1])
+}
+
+// Mercury does not mix integers and floats, the integer
+// side of such an operation is converted
+func printNumbers(g *generator, e Expression, args []string) {
+	left, right := g.typeOf(e.Args[0]), g.typeOf(e.Args[1])
+	switch {
+	case left == INT && right == FLOAT:
+		args[0] = fmt.Sprintf("float(%s)", args[0])
+	case left == FLOAT && right == INT:
+		args[1] = fmt.Sprintf("float(%s)", args[1])
+	}
+}
+
+func printExpr(g *generator, n Node) (string, error) {
+	switch v := n.(type) {
+	case Term:
+		return printTerm(v), nil
+	case Expression:
+		return printExpression(g, v)
+	}
+	return "", fmt.Errorf("expected node to be term or expression")
+}
+
+func printExpression(g *generator, e Expression) (string, error) {
+	switch e.Functor {
+	case "today":
+		return "(Facts ^ today)", nil
+	case ".":
+		object, err := printExpr(g, e.Args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s ^ %s)", object, e.Args[1].(Term).Value.(string)), nil
+	}
+
+	args := make([]string, len(e.Args))
+	for i, n := range e.Args {
+		a, err := printExpr(g, n)
+		if err != nil {
+			return "", err
+		}
+		args[i] = a
+	}
+	switch e.Functor {
+	case "length":
+		return fmt.Sprintf("string.count_codepoints(%s)", args[0]), nil
+	case "lower":
+		return fmt.Sprintf("string.to_lower(%s)", args[0]), nil
+	case "concat":
+		return fmt.Sprintf("(%s ++ %s)", args[0], args[1]), nil
+	case "+", "-":
+		left, right := g.typeOf(e.Args[0]), g.typeOf(e.Args[1])
+		if left == DURATION && right == DATE {
+			args[0], args[1] = args[1], args[0]
+			left = DATE
+		}
+		switch {
+		case left == DATE && e.Functor == "+":
+			return fmt.Sprintf("date_add(%s, %s)", args[0], args[1]), nil
+		case left == DATE:
+			return fmt.Sprintf("date_sub(%s, %s)", args[0], args[1]), nil
+		case left == STRING:
+			return fmt.Sprintf("(%s ++ %s)", args[0], args[1]), nil
+		}
+		printNumbers(g, e, args)
+		return fmt.Sprintf("(%s %s %s)", args[0], e.Functor, args[1]), nil
+	case "*", "/":
+		printNumbers(g, e, args)
+		return fmt.Sprintf("(%s %s %s)", args[0], e.Functor, args[1]), nil
+	case "%":
+		// mod takes the sign of the divisor, as % does, rem does not
+		return fmt.Sprintf("(%s mod %s)", args[0], args[1]), nil
+	}
+	return "", fmt.Errorf("%s(%s) is not a value", e.Functor, strings.Join(args, ", "))
+}
@@ -0,0 +1,346 @@
+package mercury
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "model"
+)
+
+// Mercury is pure, so the facts of each relation and the date to
+// evaluate against are passed to every rule in a facts term.
+// Rule calls have mode ALL args as input and no outputs: each
+// rule becomes a semidet predicate, which the Mercury compiler
+// checks for us.
+
+type generator struct {
+	ir InternalRepresentation
+
+	// identifiers in scope of the rule being printed, and
+	// those declared with let x : type not bound yet
+	scope   map[string]Term
+	unbound map[string]bool
+
+	// objects rules try every one of, see all_ functions
+	candidates map[string]bool
+	// matches/2 is only printed when needed
+	regexp bool
+}
+
+func (g *generator) typeOf(n Node) Token {
+	return g.ir.TypeOf(n, g.scope)
+}
+
+const prelude = `
+:- type date ---> date(int, int, int).
+:- type duration ---> duration(int, int, int).
+
+:- func date_add(date, duration) = date.
+date_add(date(Y, M, D), duration(DY, DM, DD)) = Date :-
+	Months = (Y + DY) * 12 + M - 1 + DM,
+	Y1 = Months // 12,
+	M1 = Months mod 12 + 1,
+	D1 = int.min(D, days_in_month(Y1, M1)),
+	Date = days_to_date(date_to_days(date(Y1, M1, D1)) + DD).
+
+:- func date_sub(date, duration) = date.
+date_sub(Date, duration(DY, DM, DD)) = date_add(Date, duration(-DY, -DM, -DD)).
+
+:- func days_in_month(int, int) = int.
+days_in_month(Y, M) = Days :-
+	( if M = 2 then
+		Days = ( if leap_year(Y) then 29 else 28 )
+	else if ( M = 4 ; M = 6 ; M = 9 ; M = 11 ) then
+		Days = 30
+	else
+		Days = 31
+	).
+
+:- pred leap_year(int::in) is semidet.
+leap_year(Y) :-
+	Y mod 4 = 0,
+	( Y mod 100 \= 0 ; Y mod 400 = 0 ).
+
+:- func date_to_days(date) = int.
+date_to_days(date(Y, M, D)) = N :-
+	( if M =< 2 then Y0 = Y - 1, M0 = M + 9 else Y0 = Y, M0 = M - 3 ),
+	Era = Y0 // 400,
+	Yoe = Y0 - Era * 400,
+	Doy = (153 * M0 + 2) // 5 + D - 1,
+	Doe = Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N = Era * 146097 + Doe - 719468.
+
+:- func days_to_date(int) = date.
+days_to_date(N) = date(Y, M, D) :-
+	Z = N + 719468,
+	Era = Z // 146097,
+	Doe = Z - Era * 146097,
+	Yoe = (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy = Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp = (5 * Doy + 2) // 153,
+	D = Doy - (153 * Mp + 2) // 5 + 1,
+	M = ( if Mp < 10 then Mp + 3 else Mp - 9 ),
+	Y = ( if M =< 2 then Yoe + Era * 400 + 1 else Yoe + Era * 400 ).
+
+:- pred report(string::in, bool::in, io::di, io::uo) is det.
+report(Name, yes, !IO) :- io.format("ok   %s\n", [s(Name)], !IO).
+report(Name, no, !IO) :- io.format("FAIL %s\n", [s(Name)], !IO).
+`
+
+// the standard library has no regular expressions,
+// so matches/2 calls POSIX extended ones from C
+const matches = `:- pragma foreign_decl("C", "#include <regex.h>").
+
+:- pred matches(string::in, string::in) is semidet.
+:- pragma foreign_proc("C",
+	matches(S::in, Pattern::in),
+	[will_not_call_mercury, promise_pure, thread_safe],
+"
+	regex_t re;
+	SUCCESS_INDICATOR = MR_FALSE;
+	if (regcomp(&re, Pattern, REG_EXTENDED | REG_NOSUB) == 0) {
+		SUCCESS_INDICATOR = regexec(&re, S, 0, NULL, 0) == 0;
+		regfree(&re);
+	}
+").
+`
+
+// Generate returns a Mercury module with a type per object and
+// relation, a predicate per rule and a main/2 running the tests.
+func Generate(ir InternalRepresentation, module string) (string, error) {
+	g := &generator{ir: ir, candidates: map[string]bool{}}
+	s := fmt.Sprintf(":- module %s.\n:- interface.\n:- import_module io.\n\n", module)
+	s += ":- pred main(io::di, io::uo) is det.\n\n"
+	s += ":- implementation.\n:- import_module bool, int, float, list, string.\n"
+	s += prelude + "\n"
+
+	for _, name := range SortedKeys(ir.Objects) {
+		s += printType(g, name, ir.Objects[name].Fields)
+	}
+	for _, name := range SortedKeys(ir.Relations) {
+		s += printType(g, name, ir.Relations[name].Fields)
+	}
+	s += printFacts(g) + "\n"
+
+	for _, name := range SortedKeys(ir.Rules) {
+		r, err := printRule(g, ir.Rules[name])
+		if err != nil {
+			return "", err
+		}
+		s += r + "\n"
+	}
+	for _, name := range SortedKeys(g.candidates) {
+		s += printCandidates(g, name) + "\n"
+	}
+	if g.regexp {
+		s += matches + "\n"
+	}
+	for i, t := range ir.Tests {
+		s += printTest(g, i, t) + "\n"
+	}
+	s += printMain(g)
+	return s, nil
+}
+
+func printType(g *generator, name string, fields []Field) string {
+	f := make([]string, len(fields))
+	for i, v := range fields {
+		f[i] = fmt.Sprintf("%s :: %s", v.Name, printFieldType(v))
+	}
+	return fmt.Sprintf(":- type %s ---> %s(%s).\n", printName(name), printName(name), strings.Join(f, ", "))
+}
+
+func printFieldType(f Field) string {
+	switch f.TypeInfo {
+	case OBJECT:
+		return printName(f.ObjectName())
+	case DATE, DURATION, INT, FLOAT, STRING:
+		return f.TypeInfo.String()
+	}
+	panic(fmt.Sprintf("no mercury type for %s", f.TypeInfo))
+}
+
+// facts(today, relation1, relation2...)
+func printFacts(g *generator) string {
+	f := []string{"today :: date"}
+	for _, name := range SortedKeys(g.ir.Relations) {
+		f = append(f, fmt.Sprintf("%s :: list(%s)", printName(name), printName(name)))
+	}
+	return fmt.Sprintf(":- type facts ---> facts(%s).\n", strings.Join(f, ", "))
+}
+
+func printRule(g *generator, r Rule) (string, error) {
+	types := []string{"facts::in"}
+	args := []string{"Facts"}
+	for _, a := range r.Args {
+		t := a.TypeInfo.String()
+		if a.TypeInfo == OBJECT {
+			t = printName(a.ObjectName())
+		}
+		types = append(types, t+"::in")
+		args = append(args, printVar(a.Value.(string)))
+	}
+	s := fmt.Sprintf(":- pred %s(%s) is semidet.\n", printName(r.Name), strings.Join(types, ", "))
+	head := printCall(r.Name, args)
+	for _, body := range r.Clauses() {
+		g.scope = r.Scope()
+		g.unbound = map[string]bool{}
+		goals := []string{}
+		for _, e := range body {
+			goal, err := printGoal(g, e)
+			if err != nil {
+				return "", fmt.Errorf("mercury: rule %s: %s", r.Name, err)
+			}
+			if goal != "" {
+				goals = append(goals, goal)
+			}
+		}
+		if len(goals) == 0 {
+			goals = append(goals, "true")
+		}
+		s += fmt.Sprintf("%s :-\n\t%s.\n", head, strings.Join(goals, ",\n\t"))
+	}
+	return s, nil
+}
+
+// printCandidates prints a function listing the objects of a
+// type that appear in the facts of any relation
+func printCandidates(g *generator, object string) string {
+	lists := []string{}
+	for _, name := range SortedKeys(g.ir.Relations) {
+		for _, f := range g.ir.Relations[name].Fields {
+			if f.TypeInfo == OBJECT && f.ObjectName() == object {
+				lists = append(lists, fmt.Sprintf("list.map((func(F) = F ^ %s), Facts ^ %s)", f.Name, printName(name)))
+			}
+		}
+	}
+	if len(lists) == 0 {
+		lists = append(lists, "[]")
+	}
+	return fmt.Sprintf(":- func all_%s(facts) = list(%s).\nall_%s(Facts) =\n\t%s.\n",
+		printName(object), printName(object), printName(object), strings.Join(lists, " ++\n\t"))
+}
+
+// tests are semidet predicates without arguments, objects are
+// instantiated with zero values for fields left out
+func printTest(g *generator, i int, t Test) string {
+	goals := []string{}
+	relations := map[string][]string{}
+	for _, f := range t.Facts {
+		if f.Functor == "new" {
+			goals = append(goals, printNew(g, f.Args))
+			continue
+		}
+		relations[f.Functor] = append(relations[f.Functor], printCall(f.Functor, printArgs(g, f.Args)))
+	}
+	facts := []string{printDate(Today())}
+	for _, name := range SortedKeys(g.ir.Relations) {
+		facts = append(facts, "["+strings.Join(relations[name], ", ")+"]")
+	}
+	goals = append(goals, fmt.Sprintf("Facts = facts(%s)", strings.Join(facts, ", ")))
+	for _, e := range t.Body {
+		args := append([]string{"Facts"}, printArgs(g, e.Args)...)
+		goals = append(goals, printCall(e.Functor, args))
+	}
+	return fmt.Sprintf(":- pred test_%d is semidet.\n%% %s\ntest_%d :-\n\t%s.\n",
+		i+1, t.Name, i+1, strings.Join(goals, ",\n\t"))
+}
+
+// main reports every test, one expected to fail
+// is ok if it does not hold
+func printMain(g *generator) string {
+	if len(g.ir.Tests) == 0 {
+		return "main(!IO).\n"
+	}
+	calls := make([]string, len(g.ir.Tests))
+	for i, t := range g.ir.Tests {
+		ok, failed := "yes", "no"
+		if t.ExpectFail() {
+			ok, failed = failed, ok
+		}
+		calls[i] = fmt.Sprintf("report(%q, ( if test_%d then %s else %s ), !IO)", t.Name, i+1, ok, failed)
+	}
+	return fmt.Sprintf("main(!IO) :-\n\t%s.\n", strings.Join(calls, ",\n\t"))
+}
+
+func printNew(g *generator, args []Node) string {
+	o := args[0].(Term)
+	object := g.ir.Objects[o.ObjectName()]
+	values := make([]string, len(object.Fields))
+	for i, f := range object.Fields {
+		values[i] = zeroValue(f)
+		for _, n := range args[1:] {
+			if v := n.(Term); v.FieldName() == f.Name {
+				values[i] = printValueWithType(v.Value, f.TypeInfo)
+			}
+		}
+	}
+	return fmt.Sprintf("%s = %s(%s)", printVar(o.Value.(string)), printName(object.Name), strings.Join(values, ", "))
+}
+
+func zeroValue(f Field) string {
+	switch f.TypeInfo {
+	case INT:
+		return "0"
+	case FLOAT:
+		return "0.0"
+	case STRING:
+		return `""`
+	case DATE:
+		return "date(1970, 1, 1)"
+	case DURATION:
+		return "duration(0, 0, 0)"
+	}
+	panic(fmt.Sprintf("no zero value for %s field %s", f.TypeInfo, f.Name))
+}
+
+func printArgs(g *generator, args []Node) []string {
+	a := make([]string, len(args))
+	for i, n := range args {
+		a[i] = printTerm(n.(Term))
+	}
+	return a
+}
+
+func printCall(functor string, args []string) string {
+	return fmt.Sprintf("%s(%s)", printName(functor), strings.Join(args, ", "))
+}
+
+// printName returns a declared name as a Mercury name, where . would
+// qualify it with a module: legal.adult becomes legal__adult
+func printName(name string) string {
+	return strings.Replace(name, ".", "__", -1)
+}
+
+func printVar(name string) string {
+	v := strings.Title(name)
+	if v == "Facts" {
+		v += "_"
+	}
+	return v
+}
+
+func printTerm(t Term) string {
+	if t.TypeInfo == IDENT || t.TypeInfo == OBJECT {
+		return printVar(t.Value.(string))
+	}
+	return printValueWithType(t.Value, t.TypeInfo)
+}
+
+func printValueWithType(v interface{}, ti Token) string {
+	switch ti {
+	case STRING, IDENT:
+		return fmt.Sprintf("%q", v)
+	case DATE:
+		return printDate(v.(time.Time))
+	case DURATION:
+		d := v.(Duration)
+		return fmt.Sprintf("duration(%d, %d, %d)", d.Years, d.Months, d.Days)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func printDate(t time.Time) string {
+	return fmt.Sprintf("date(%d, %d, %d)", t.Year(), t.Month(), t.Day())
+}
@@ -0,0 +1,158 @@
+package mercury
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "model"
+)
+
+func TestGenerate(t *testing.T) {
+	defer func(today func() time.Time) { Today = today }(Today)
+	Today = func() time.Time { return time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	ir := Read(`
+		object prisoner {
+			age  : int,
+			name : string
+		}
+		relation cellmates {
+			p : prisoner,
+			cellmate : prisoner
+		}
+		rule hasRightToPhonecall {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age >= 18,
+				startsWith(lower(p.name), "j")
+			}
+		}
+		rule hasAdultCellmate {
+			input {
+				p : prisoner
+			}
+			rules {
+				let c : prisoner,
+				cellmates(p, c),
+				hasRightToPhonecall(c)
+			}
+		}
+		test "Right to phonecall" {
+			facts {
+				p1 : prisoner {
+					age:  23,
+					name: john
+				},
+				p2 : prisoner {
+					age: 15
+				},
+				cellmates(p2, p1)
+			}
+			rules {
+				hasRightToPhonecall(p1),
+				hasAdultCellmate(p2)
+			}
+		}`)
+	got, err := Generate(ir, "prison")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		":- type prisoner ---> prisoner(age :: int, name :: string).",
+		":- type cellmates ---> cellmates(p :: prisoner, cellmate :: prisoner).",
+		":- type facts ---> facts(today :: date, cellmates :: list(cellmates)).",
+		`:- pred hasRightToPhonecall(facts::in, prisoner::in) is semidet.
+hasRightToPhonecall(Facts, P) :-
+	(P ^ age) >= 18,
+	string.prefix(string.to_lower((P ^ name)), "j").`,
+		`hasAdultCellmate(Facts, P) :-
+	list.member(cellmates(P, C), Facts ^ cellmates),
+	hasRightToPhonecall(Facts, C).`,
+		`test_1 :-
+	P1 = prisoner(23, "john"),
+	P2 = prisoner(15, ""),
+	Facts = facts(date(2018, 3, 1), [cellmates(P2, P1)]),
+	hasRightToPhonecall(Facts, P1),
+	hasAdultCellmate(Facts, P2).`,
+		`main(!IO) :-
+	report("Right to phonecall", ( if test_1 then yes else no ), !IO).`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%d): %s\nnot found in\n%s", i, want, got)
+		}
+	}
+}
+
+// TestCorpus generates every rulebase in testdata, with a predicate
+// per rule and a report per test. Each is generated again in a
+// package, with qualified names.
+func TestCorpus(t *testing.T) {
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no corpus found: %v", err)
+	}
+	generated := map[string]string{}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pkg := range []string{"", "legal"} {
+			src, name := string(b), filepath.Base(f)
+			if pkg != "" {
+				src, name = "package "+pkg+"\n"+src, pkg+"/"+name
+			}
+			ir := Read(src)
+			got, err := Generate(ir, "rules")
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			generated[name] = got
+			if pkg != "" && strings.Contains(got, pkg+".") {
+				t.Errorf("%s: qualified name left in\n%s", name, got)
+			}
+			for rule := range ir.Rules {
+				if want := fmt.Sprintf(":- pred %s(facts::in", printName(rule)); !strings.Contains(got, want) {
+					t.Errorf("%s: %s not found", name, want)
+				}
+			}
+			for _, test := range ir.Tests {
+				if want := fmt.Sprintf("report(%q", test.Name); !strings.Contains(got, want) {
+					t.Errorf("%s: %s not found", name, want)
+				}
+			}
+		}
+	}
+	for i, tt := range []struct {
+		file, want string
+	}{
+		{"arithmetic.rules", "(((A ^ balance) / 2) mod 2) = 0"},
+		{"arithmetic.rules", "(float((A ^ balance)) * (A ^ rate)) > float(((A ^ balance) + 10))"},
+		{"reports.rules", `hasBoss(Facts, E) :-
+	list.member(B, all_person(Facts)),
+	reportsTo(Facts, E, B).`},
+		{"reports.rules", `all_person(Facts) =
+	list.map((func(F) = F ^ boss), Facts ^ manages) ++
+	list.map((func(F) = F ^ employee), Facts ^ manages).`},
+		{"strings.rules", `matches((P ^ email), "^[a-z.]+@[a-z]+[.][a-z]+$")`},
+		{"strings.rules", ":- pred matches(string::in, string::in) is semidet."},
+		{"reports.rules", `report("Top has no boss (fails)", ( if test_4 then no else yes ), !IO)`},
+		{"legal/reports.rules", ":- type legal__manages ---> legal__manages(boss :: legal__person, employee :: legal__person)."},
+		{"legal/reports.rules", `legal__hasBoss(Facts, E) :-
+	list.member(B, all_legal__person(Facts)),
+	legal__reportsTo(Facts, E, B).`},
+		{"legal/reports.rules", `list.member(legal__manages(X, E), Facts ^ legal__manages)`},
+		{"legal/reports.rules", `A = legal__person("alice"),`},
+	} {
+		if !strings.Contains(generated[tt.file], tt.want) {
+			t.Errorf("%d): %s\nnot found in\n%s", i, tt.want, generated[tt.file])
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// builtin functions usable in rule bodies. A builtin without
+// result type is a predicate: it evaluates to true or false.
+type builtin struct {
+	args   []Token
+	result Token
+}
+
+var builtins = map[string]builtin{
+	"startsWith": {args: []Token{STRING, STRING}},
+	"contains":   {args: []Token{STRING, STRING}},
+	"matches":    {args: []Token{STRING, STRING}},
+	"length":     {args: []Token{STRING}, result: INT},
+	"lower":      {args: []Token{STRING}, result: STRING},
+	"concat":     {args: []Token{STRING, STRING}, result: STRING},
+}
+
+func IsBuiltin(functor string) bool {
+	_, ok := builtins[functor]
+	return ok
+}
+
+// Scope maps the identifiers a rule takes as input to their typed terms
+func (r Rule) Scope() map[string]Term {
+	scope := map[string]Term{}
+	for _, a := range r.Args {
+		scope[a.Value.(string)] = a
+	}
+	return scope
+}
+
+// TypeOf returns the type a node evaluates to, or ILLEGAL if it
+// cannot be determined. Predicates evaluate to ILLEGAL as well.
+func (ir InternalRepresentation) TypeOf(n Node, scope map[string]Term) Token {
+	c := &checker{ir: ir, scope: scope}
+	t, _ := c.typeOf(n)
+	return t
+}
+
+type checker struct {
+	ir    InternalRepresentation
+	scope map[string]Term
+}
+
+// CheckError is an error in a rule or test. Body and Condition
+// locate the condition of a rule it was found in, or for a test
+// the fact (body 0) or rule call (body 1); both are -1 for an
+// error in the rule as a whole.
+type CheckError struct {
+	Kind, Name      string
+	Body, Condition int
+	Err             error
+}
+
+func (e CheckError) Error() string {
+	if e.Kind == "test" {
+		return fmt.Sprintf("test %q: %s", e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Kind, e.Name, e.Err)
+}
+
+// Check does type checking on the rules and tests in the
+// internal representation, returning all errors found,
+// each a CheckError.
+func Check(ir InternalRepresentation) []error {
+	names := []string{}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		errs = append(errs, checkRule(ir, ir.Rules[name])...)
+	}
+	errs = append(errs, checkLeftRecursion(ir)...)
+	for _, t := range ir.Tests {
+		errs = append(errs, checkTest(ir, t)...)
+	}
+	return errs
+}
+
+func checkRule(ir InternalRepresentation, r Rule) []error {
+	errs := []error{}
+	inputs := &checker{ir: ir, scope: map[string]Term{}}
+	for _, a := range r.Args {
+		if a.TypeInfo == OBJECT {
+			if _, ok := ir.Objects[a.ObjectName()]; !ok {
+				errs = append(errs, CheckError{"rule", r.Name, -1, -1, fmt.Errorf("undefined object %s", a.ObjectName())})
+			}
+		}
+		if err := inputs.declare(a); err != nil {
+			errs = append(errs, CheckError{"rule", r.Name, -1, -1, err})
+		}
+	}
+	for j, body := range r.Clauses() {
+		c := &checker{ir: ir, scope: r.Scope()}
+		for i, e := range body {
+			if err := c.checkCondition(e); err != nil {
+				errs = append(errs, CheckError{"rule", r.Name, j, i, err})
+			}
+		}
+	}
+	return errs
+}
+
+// facts introduce objects, which the rule calls
+// in the test body can refer to
+func checkTest(ir InternalRepresentation, t Test) []error {
+	c := &checker{ir: ir, scope: map[string]Term{}}
+	errs := []error{}
+	for i, e := range t.Facts {
+		if err := c.checkFact(e); err != nil {
+			errs = append(errs, CheckError{"test", t.Name, 0, i, err})
+		}
+	}
+	for i, e := range t.Body {
+		if err := c.checkCondition(e); err != nil {
+			errs = append(errs, CheckError{"test", t.Name, 1, i, err})
+		}
+	}
+	return errs
+}
+
+func (c *checker) checkFact(e Expression) error {
+	if e.Functor != "new" {
+		return c.checkCondition(e)
+	}
+	o := e.Args[0].(Term)
+	object, ok := c.ir.Objects[o.ObjectName()]
+	if !ok {
+		return fmt.Errorf("undefined object %s", o.ObjectName())
+	}
+	for _, n := range e.Args[1:] {
+		f := n.(Term)
+		if !object.HasField(f.FieldName()) {
+			return fmt.Errorf("object %s has no field %s", object.Name, f.FieldName())
+		}
+	}
+	return c.declare(o)
+}
+
+// declare adds a variable to the scope, reporting
+// identifiers that shadow an earlier declaration
+func (c *checker) declare(t Term) error {
+	name := t.Value.(string)
+	if _, ok := c.scope[name]; ok {
+		return fmt.Errorf("%s shadows an earlier declaration", name)
+	}
+	c.scope[name] = t
+	return nil
+}
+
+// a condition in a rule body has to evaluate to true or false,
+// except for let which binds a value to a new variable
+func (c *checker) checkCondition(e Expression) error {
+	if e.Functor == "let" {
+		return c.checkLet(e)
+	}
+	t, err := c.typeOf(e)
+	if err != nil {
+		return err
+	}
+	if t != ILLEGAL {
+		return fmt.Errorf("%s evaluates to %s, not to true or false", e.Functor, t)
+	}
+	return nil
+}
+
+func (c *checker) checkLet(e Expression) error {
+	if len(e.Args) == 1 {
+		v := e.Args[0].(Term)
+		if v.TypeInfo == OBJECT {
+			if _, ok := c.ir.Objects[v.ObjectName()]; !ok {
+				return fmt.Errorf("undefined object %s", v.ObjectName())
+			}
+		}
+		return c.declare(v)
+	}
+	name := e.Args[0].(Term).Value.(string)
+	t, err := c.typeOf(e.Args[1])
+	if err != nil {
+		return err
+	}
+	if t == ILLEGAL {
+		return fmt.Errorf("let %s: value does not evaluate to a value", name)
+	}
+	v := Term{Value: name, TypeInfo: t}
+	if t == OBJECT {
+		v = ObjectTerm(name, c.objectName(e.Args[1]))
+	}
+	return c.declare(v)
+}
+
+// objectName returns the object type of a node, if known
+func (c *checker) objectName(n Node) string {
+	t, ok := n.(Term)
+	if !ok {
+		return ""
+	}
+	if t.TypeInfo == IDENT {
+		t = c.scope[t.Value.(string)]
+	}
+	if t.TypeInfo != OBJECT {
+		return ""
+	}
+	return t.ObjectName()
+}
+
+// checkCall checks the args of a rule or relation call
+// against the declared input of the rule or relation
+func (c *checker) checkCall(e Expression, params []Term) error {
+	if len(e.Args) != len(params) {
+		return fmt.Errorf("%s takes %d arguments, got %d", e.Functor, len(params), len(e.Args))
+	}
+	for i, n := range e.Args {
+		t, err := c.typeOf(n)
+		if err != nil {
+			return err
+		}
+		want := params[i]
+		if t != want.TypeInfo && !(isNumeric(t) && isNumeric(want.TypeInfo)) {
+			return fmt.Errorf("argument %d of %s should be %s, got %s", i+1, e.Functor, want.TypeInfo, t)
+		}
+		if t != OBJECT || want.fieldInfo == "" {
+			continue
+		}
+		if name := c.objectName(n); name != "" && name != want.ObjectName() {
+			return fmt.Errorf("argument %d of %s should be %s, got %s", i+1, e.Functor, want.ObjectName(), name)
+		}
+	}
+	return nil
+}
+
+func (c *checker) typeOf(n Node) (Token, error) {
+	switch v := n.(type) {
+	case Term:
+		return c.typeOfTerm(v)
+	case Expression:
+		return c.typeOfExpression(v)
+	}
+	return ILLEGAL, fmt.Errorf("expected node to be term or expression")
+}
+
+func (c *checker) typeOfTerm(t Term) (Token, error) {
+	if t.TypeInfo == OBJECT {
+		if _, ok := c.scope[t.Value.(string)]; !ok {
+			return ILLEGAL, fmt.Errorf("undefined identifier %v", t.Value)
+		}
+	}
+	if t.TypeInfo != IDENT {
+		return t.TypeInfo, nil
+	}
+	if v, ok := c.scope[t.Value.(string)]; ok {
+		return v.TypeInfo, nil
+	}
+	return ILLEGAL, fmt.Errorf("undefined identifier %v", t.Value)
+}
+
+func (c *checker) typeOfExpression(e Expression) (Token, error) {
+	switch e.Functor {
+	case "today":
+		return DATE, nil
+	case ".":
+		return c.typeOfField(e)
+	}
+	if b, ok := builtins[e.Functor]; ok {
+		return b.result, c.checkArgs(e, b.args)
+	}
+	if _, ok := operators[e.Functor]; !ok {
+		return ILLEGAL, c.checkRuleCall(e)
+	}
+
+	left, err := c.typeOf(e.Args[0])
+	if err != nil {
+		return ILLEGAL, err
+	}
+	right, err := c.typeOf(e.Args[1])
+	if err != nil {
+		return ILLEGAL, err
+	}
+	op := lookupOperator(e.Functor)
+	if op.Precedence() == EQL.Precedence() {
+		if !comparable(left, right) {
+			return ILLEGAL, fmt.Errorf("cannot compare %s %s %s", left, e.Functor, right)
+		}
+		return ILLEGAL, nil
+	}
+	if t, ok := arithmetic(op, left, right); ok {
+		return t, nil
+	}
+	return ILLEGAL, fmt.Errorf("invalid operation %s %s %s", left, e.Functor, right)
+}
+
+// rule calls are resolved against rules, then relations
+func (c *checker) checkRuleCall(e Expression) error {
+	if r, ok := c.ir.Rules[e.Functor]; ok {
+		return c.checkCall(e, r.Args)
+	}
+	if r, ok := c.ir.Relations[e.Functor]; ok {
+		params := make([]Term, len(r.Fields))
+		for i, f := range r.Fields {
+			params[i] = Term{Value: f.Name, TypeInfo: f.TypeInfo}
+			if f.TypeInfo == OBJECT {
+				params[i].fieldInfo = f.ObjectName()
+			}
+		}
+		return c.checkCall(e, params)
+	}
+	return fmt.Errorf("undefined rule %s", e.Functor)
+}
+
+func (c *checker) typeOfField(e Expression) (Token, error) {
+	if _, err := c.typeOf(e.Args[0]); err != nil {
+		return ILLEGAL, err
+	}
+	objectName := c.objectName(e.Args[0])
+	if objectName == "" {
+		return ILLEGAL, fmt.Errorf("field access on non-object %v", e.Args[0])
+	}
+	fieldName := e.Args[1].(Term).Value.(string)
+	for _, f := range c.ir.Objects[objectName].Fields {
+		if f.Name == fieldName {
+			return f.TypeInfo, nil
+		}
+	}
+	return ILLEGAL, fmt.Errorf("object %s has no field %s", objectName, fieldName)
+}
+
+func (c *checker) checkArgs(e Expression, want []Token) error {
+	if len(e.Args) != len(want) {
+		return fmt.Errorf("%s takes %d arguments, got %d", e.Functor, len(want), len(e.Args))
+	}
+	for i, n := range e.Args {
+		t, err := c.typeOf(n)
+		if err != nil {
+			return err
+		}
+		if t != want[i] {
+			return fmt.Errorf("argument %d of %s should be %s, got %s", i+1, e.Functor, want[i], t)
+		}
+	}
+	return nil
+}
+
+func isNumeric(t Token) bool {
+	return t == INT || t == FLOAT
+}
+
+func comparable(left, right Token) bool {
+	return left == right && left != ILLEGAL || isNumeric(left) && isNumeric(right)
+}
+
+// arithmetic returns the type of `left op right`
+func arithmetic(op Token, left, right Token) (Token, bool) {
+	switch {
+	case isNumeric(left) && isNumeric(right):
+		if left == FLOAT || right == FLOAT {
+			return FLOAT, true
+		}
+		return INT, true
+	case op == ADD && left == STRING && right == STRING:
+		return STRING, true
+	case op == ADD && left == DATE && right == DURATION,
+		op == ADD && left == DURATION && right == DATE,
+		op == SUB && left == DATE && right == DURATION:
+		return DATE, true
+	}
+	return ILLEGAL, false
+}
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	objects := `
+		object prisoner {
+			age  : int,
+			name : string
+		}
+		rule adult {
+			input {
+				a : prisoner
+			}
+			rules {
+				a.age >= 18
+			}
+		}
+	`
+	for i, tt := range []struct {
+		rules string
+		want  []string
+	}{
+		{
+			rules: `startsWith(lower(p.name), "jo"),
+					length(p.name + suffix) >= 3,
+					matches(p.name, "^[a-z]+$")`,
+		},
+		{
+			rules: `let n = lower(p.name),
+					let q = p,
+					startsWith(n, "jo"),
+					adult(q)`,
+		},
+		{
+			rules: `let p = p.age`,
+			want:  []string{"rule r: p shadows an earlier declaration"},
+		},
+		{
+			rules: `startsWith(n, "jo")`,
+			want:  []string{"rule r: undefined identifier n"},
+		},
+		{
+			rules: `minor(p)`,
+			want:  []string{"rule r: undefined rule minor"},
+		},
+		{
+			rules: `adult(suffix)`,
+			want:  []string{"rule r: argument 1 of adult should be object, got string"},
+		},
+		{
+			rules: `startsWith(p.age, "1")`,
+			want:  []string{"rule r: argument 1 of startsWith should be string, got int"},
+		},
+		{
+			rules: `length(p.name)`,
+			want:  []string{"rule r: length evaluates to int, not to true or false"},
+		},
+		{
+			rules: `p.name >= 18`,
+			want:  []string{"rule r: cannot compare string >= int"},
+		},
+		{
+			rules: `p.name + 1 = suffix`,
+			want:  []string{"rule r: invalid operation string + int"},
+		},
+		{
+			rules: `p.height >= unknown`,
+			want:  []string{"rule r: object prisoner has no field height"},
+		},
+	} {
+		ir := Read(fmt.Sprintf(`%s
+			rule r {
+				input {
+					p : prisoner,
+					suffix : string
+				}
+				rules {
+					%s
+				}
+			}`, objects, tt.rules))
+		got := Check(ir)
+		if len(got) != len(tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+			continue
+		}
+		for j, err := range got {
+			if err.Error() != tt.want[j] {
+				t.Errorf("%d): got %q want %q", i, err, tt.want[j])
+			}
+		}
+	}
+}
+
+func TestCheckRecursion(t *testing.T) {
+	for i, tt := range []struct {
+		recursive string
+		want      []string
+	}{
+		{
+			recursive: `let x : person, manages(x, e), reportsTo(x, b)`,
+		},
+		{
+			recursive: `let x : person, reportsTo(x, b), manages(x, e)`,
+			want: []string{
+				"rule reportsTo: left-recursive via reportsTo -> reportsTo, which loops in Prolog; start the body with a relation or condition instead",
+			},
+		},
+	} {
+		ir := Read(fmt.Sprintf(`
+			object person {
+				name : string
+			}
+			relation manages {
+				boss : person,
+				employee : person
+			}
+			rule reportsTo {
+				input {
+					e : person,
+					b : person
+				}
+				rules {
+					manages(b, e)
+				}
+				rules {
+					%s
+				}
+			}`, tt.recursive))
+		got := Check(ir)
+		if len(got) != len(tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+			continue
+		}
+		for j, err := range got {
+			if err.Error() != tt.want[j] {
+				t.Errorf("%d): got %q want %q", i, err, tt.want[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Today returns the date rules are evaluated against.
+// Tests can replace it to get a fixed evaluation date.
+var Today = func() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Duration is a calendar duration: months and years don't
+// have a fixed length in days so components are kept apart.
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+}
+
+func (d Duration) String() string {
+	return fmt.Sprintf("P%dY%dM%dD", d.Years, d.Months, d.Days)
+}
+
+func (d Duration) Negate() Duration {
+	return Duration{Years: -d.Years, Months: -d.Months, Days: -d.Days}
+}
+
+// AddTo adds years and months first, clamping the day to the
+// end of the resulting month (jan 31 + 1 month = feb 28),
+// and then adds the days.
+func (d Duration) AddTo(t time.Time) time.Time {
+	y, m, day := t.Date()
+	months := (y+d.Years)*12 + int(m) - 1 + d.Months
+	y, m = months/12, time.Month(months%12+1)
+	if max := DaysInMonth(y, m); day > max {
+		day = max
+	}
+	return time.Date(y, m, day+d.Days, 0, 0, 0, 0, time.UTC)
+}
+
+func DaysInMonth(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func DateTerm(value time.Time) Term {
+	return Term{Value: value, TypeInfo: DATE}
+}
+func DurationTerm(value Duration) Term {
+	return Term{Value: value, TypeInfo: DURATION}
+}
+
+func parseDate(lit string) (time.Time, error) {
+	return time.Parse(dateLayout, lit)
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?$`)
+
+// only the date part of ISO-8601 durations is supported
+func parseISODuration(lit string) (Duration, bool) {
+	m := isoDuration.FindStringSubmatch(lit)
+	if m == nil || lit == "P" {
+		return Duration{}, false
+	}
+	n := make([]int, 4)
+	for i, s := range m[1:] {
+		if s == "" {
+			continue
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return Duration{}, false
+		}
+		n[i] = v
+	}
+	return Duration{Years: n[0], Months: n[1], Days: n[2]*7 + n[3]}, true
+}
+
+// durationWithUnit returns the duration for `n unit`, as in `6 months`
+func durationWithUnit(n int, unit string) (Duration, bool) {
+	switch unit {
+	case "day", "days":
+		return Duration{Days: n}, true
+	case "week", "weeks":
+		return Duration{Days: 7 * n}, true
+	case "month", "months":
+		return Duration{Months: n}, true
+	case "year", "years":
+		return Duration{Years: n}, true
+	}
+	return Duration{}, false
+}
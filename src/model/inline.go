@@ -0,0 +1,72 @@
+package model
+
+import "fmt"
+
+// Analyses reasoning about a rule as one formula, such as the
+// consistency checks and test generation, inline the rules it
+// calls. The variables a body declares with let are renamed
+// name#n, a name no identifier has, so those of different calls
+// stay apart.
+
+// InlineDepth is how deep rule calls are inlined, recursion stops there
+const InlineDepth = 4
+
+// Inliner numbers the variables declared by the bodies it inlines
+type Inliner struct {
+	lets int
+}
+
+// Clause walks body as called with args for the inputs of r: it
+// calls declare with each variable declared with let, renamed and
+// typed, and condition with every other goal, with inputs replaced
+// by their argument and lets binding a value by that value
+func (in *Inliner) Clause(r Rule, body []Expression, args []Node, declare func(Term), condition func(Expression)) {
+	subst := map[string]Node{}
+	for i, a := range r.Args {
+		subst[a.Value.(string)] = args[i]
+	}
+	for _, e := range body {
+		switch {
+		case e.Functor == "let" && len(e.Args) == 1:
+			t := e.Args[0].(Term)
+			in.lets++
+			name := fmt.Sprintf("%s#%d", t.Value.(string), in.lets)
+			if t.TypeInfo == OBJECT {
+				subst[t.Value.(string)] = ObjectTerm(name, t.ObjectName())
+				declare(ObjectTerm(name, t.ObjectName()))
+				continue
+			}
+			subst[t.Value.(string)] = IdentifierTerm(name)
+			declare(Term{Value: name, TypeInfo: t.TypeInfo})
+		case e.Functor == "let":
+			subst[e.Args[0].(Term).Value.(string)] = Substitute(e.Args[1], subst)
+		default:
+			condition(Substitute(e, subst).(Expression))
+		}
+	}
+}
+
+// Substitute replaces the identifiers in n found in subst
+func Substitute(n Node, subst map[string]Node) Node {
+	switch n := n.(type) {
+	case Term:
+		if n.TypeInfo == IDENT || n.TypeInfo == OBJECT {
+			if s, ok := subst[n.Value.(string)]; ok {
+				return s
+			}
+		}
+		return n
+	case Expression:
+		e := Expression{Functor: n.Functor, Args: make([]Node, len(n.Args))}
+		copy(e.Args, n.Args)
+		for i, a := range n.Args {
+			// the field name of a field access stays
+			if n.Functor == "." && i == 1 {
+				break
+			}
+			e.Args[i] = Substitute(a, subst)
+		}
+		return e
+	}
+	return n
+}
@@ -1,6 +1,10 @@
 package model
 
-import "strings"
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
 
 // DSL level
 
@@ -9,9 +13,26 @@ type Object struct {
 	Fields []Field
 }
 
+func (o Object) HasField(name string) bool {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 type Field struct {
-	Name     string
-	TypeInfo Token
+	Name       string
+	TypeInfo   Token
+	objectName string
+}
+
+func (f Field) ObjectName() string {
+	if f.TypeInfo != OBJECT {
+		panic("getting objectName of non-object field")
+	}
+	return f.objectName
 }
 
 type Relation struct {
@@ -24,6 +45,15 @@ type Rule struct {
 	Name string
 	Args []Term
 	Body []Expression // rules and truth statements
+
+	// each extra rules block is an alternative body:
+	// the rule holds if any of its bodies holds
+	Alternatives [][]Expression
+}
+
+// Clauses returns all bodies of the rule, in order
+func (r Rule) Clauses() [][]Expression {
+	return append([][]Expression{r.Body}, r.Alternatives...)
 }
 
 type Test struct {
@@ -32,6 +62,12 @@ type Test struct {
 	Body  []Expression // only rule calls ?
 }
 
+// ExpectFail reports whether the rules of t are expected not to
+// hold, which its name tells by ending in (fails)
+func (t Test) ExpectFail() bool {
+	return strings.HasSuffix(t.Name, "(fails)")
+}
+
 // an expression is either a
 // - rule call (which evaluates to boolean)
 // - comparison
@@ -97,10 +133,177 @@ func NewObject(name string, fields []Field) Object {
 }
 
 type InternalRepresentation struct {
+	// names declared in a package are qualified as pkg.name
+	Package string
+	// paths of imported files, relative to the root directory
+	Imports []string
+
 	Objects   map[string]Object
 	Relations map[string]Relation
 	Rules     map[string]Rule
 	Tests     []Test
+
+	// every declaration in source order, including those
+	// overwritten by a later declaration of the same name
+	Declarations []Declaration
+	// comments in source order, kept for the formatter
+	Comments []Comment
+}
+
+// Position in the DSL source, File is set by Load
+type Position struct {
+	File      string
+	Line, Col int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Declaration of a package, import, object, field,
+// relation, rule or test
+type Declaration struct {
+	Kind string
+	Name string
+	// object declaring a field
+	Object string
+	Pos    Position
+	// position of each field of an object or relation,
+	// input of a rule or fact of a test
+	Fields []Position
+	// position of the input, facts and rules keywords
+	Blocks []Position
+	// position of each condition of a rule, per body,
+	// or of each rule called by a test
+	Clauses [][]Position
+	// position of the closing brace
+	End Position
+}
+
+// Key identifies what d declares, declarations with the same key
+// clash. Rules and relations share a namespace, since both are
+// called the same way; objects have their own, fields are scoped
+// by their object, packages and imports by their file.
+func (d Declaration) Key() string {
+	switch d.Kind {
+	case "rule", "relation":
+		return "call " + d.Name
+	case "field":
+		return "field " + d.Object + "." + d.Name
+	case "package", "import":
+		return d.Kind + " " + d.Pos.File + " " + d.Name
+	}
+	return d.Kind + " " + d.Name
+}
+
+// Comment is a # comment, Text includes the #
+type Comment struct {
+	Pos  Position
+	Text string
+}
+
+// ObjectNames returns the objects in declaration order
+func (ir InternalRepresentation) ObjectNames() []string {
+	names := []string{}
+	for name := range ir.Objects {
+		names = append(names, name)
+	}
+	return ir.declarationOrder("object", names)
+}
+
+// RelationNames returns the relations in declaration order
+func (ir InternalRepresentation) RelationNames() []string {
+	names := []string{}
+	for name := range ir.Relations {
+		names = append(names, name)
+	}
+	return ir.declarationOrder("relation", names)
+}
+
+// RuleNames returns the rules in declaration order
+func (ir InternalRepresentation) RuleNames() []string {
+	names := []string{}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	return ir.declarationOrder("rule", names)
+}
+
+// ObjectOf returns the object n is: an object, a variable of an
+// object type, given by scope from variable to object name, or a
+// field of object type of either. It returns "" for other nodes.
+func (ir InternalRepresentation) ObjectOf(n Node, scope map[string]string) string {
+	switch v := n.(type) {
+	case Term:
+		if v.TypeInfo == OBJECT {
+			return v.ObjectName()
+		}
+		if name, ok := v.Value.(string); ok && v.TypeInfo == IDENT {
+			return scope[name]
+		}
+	case Expression:
+		if v.Functor != "." {
+			return ""
+		}
+		for _, f := range ir.Objects[ir.ObjectOf(v.Args[0], scope)].Fields {
+			if f.Name == v.Args[1].(Term).Value.(string) && f.TypeInfo == OBJECT {
+				return f.ObjectName()
+			}
+		}
+	}
+	return ""
+}
+
+// SortedKeys returns the names of a map of objects, relations or
+// rules, or of a set of names, in alphabetical order
+func SortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch v := m.(type) {
+	case map[string]Object:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]Relation:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]Rule:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// declarationOrder orders names as first declared, names without
+// a declaration (an ir built in Go) go last in alphabetical order
+func (ir InternalRepresentation) declarationOrder(kind string, names []string) []string {
+	index := map[string]int{}
+	for _, d := range ir.Declarations {
+		if _, seen := index[d.Name]; !seen && d.Kind == kind {
+			index[d.Name] = len(index)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, aok := index[names[i]]
+		b, bok := index[names[j]]
+		if aok && bok {
+			return a < b
+		}
+		if aok != bok {
+			return aok
+		}
+		return names[i] < names[j]
+	})
+	return names
 }
 
 func newInternalRepresentation() InternalRepresentation {
@@ -116,3 +319,19 @@ func Read(s string) InternalRepresentation {
 	p := newParser(strings.NewReader(s))
 	return p.parse()
 }
+
+// Parse is Read returning syntax errors instead of exiting
+func Parse(s string) (ir InternalRepresentation, err error) {
+	p := newParser(strings.NewReader(s))
+	p.panicOnError = true
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(ParseError)
+			if !ok {
+				panic(r)
+			}
+			err = perr
+		}
+	}()
+	return p.parse(), nil
+}
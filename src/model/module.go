@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Load reads file and everything it imports, with import paths
+// relative to root, and merges them into one representation.
+// The file itself may be anywhere, like ../x.rules or /tmp/x.rules.
+// Names declared in a package keep their qualified name pkg.name,
+// so the same name can be declared in different packages.
+func Load(root, file string) (InternalRepresentation, error) {
+	return LoadWith(func(path string) (string, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		b, err := ioutil.ReadFile(path)
+		return string(b), err
+	}, file)
+}
+
+// LoadWith is Load reading files relative to the root with read,
+// as an editor does to check files with unsaved changes
+func LoadWith(read func(path string) (string, error), file string) (InternalRepresentation, error) {
+	l := &loader{read: read, loaded: map[string]bool{}, declared: map[string]string{}}
+	l.ir = newInternalRepresentation()
+	if err := l.load(file); err != nil {
+		return InternalRepresentation{}, err
+	}
+	return l.ir, nil
+}
+
+type loader struct {
+	read func(path string) (string, error)
+	ir   InternalRepresentation
+
+	// files currently being loaded, for detecting cycles
+	stack  []string
+	loaded map[string]bool
+
+	// kind and name -> file it is declared in
+	declared map[string]string
+}
+
+// imported loads an import, which must be under the root
+func (l *loader) imported(file string) error {
+	path := filepath.Clean(file)
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("import %q is outside of the root directory", file)
+	}
+	return l.load(path)
+}
+
+func (l *loader) load(file string) error {
+	path := filepath.Clean(file)
+	for i, f := range l.stack {
+		if f == path {
+			cycle := append(append([]string{}, l.stack[i:]...), path)
+			return fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if l.loaded[path] {
+		return nil
+	}
+	src, err := l.read(path)
+	if err != nil {
+		return err
+	}
+	ir, err := Parse(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	l.stack = append(l.stack, path)
+	for _, imp := range ir.Imports {
+		if err := l.imported(imp); err != nil {
+			return err
+		}
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	l.loaded[path] = true
+	return l.merge(path, ir)
+}
+
+// merge adds the declarations of a file, which must
+// not clash with those of the files loaded before
+func (l *loader) merge(file string, ir InternalRepresentation) error {
+	for _, name := range ir.ObjectNames() {
+		if err := l.declare(file, "object", name); err != nil {
+			return err
+		}
+		l.ir.Objects[name] = ir.Objects[name]
+	}
+	for _, name := range ir.RelationNames() {
+		if err := l.declare(file, "relation", name); err != nil {
+			return err
+		}
+		l.ir.Relations[name] = ir.Relations[name]
+	}
+	for _, name := range ir.RuleNames() {
+		if err := l.declare(file, "rule", name); err != nil {
+			return err
+		}
+		l.ir.Rules[name] = ir.Rules[name]
+	}
+	l.ir.Tests = append(l.ir.Tests, ir.Tests...)
+	for _, d := range ir.Declarations {
+		d.Pos.File = file
+		l.ir.Declarations = append(l.ir.Declarations, d)
+	}
+	for _, c := range ir.Comments {
+		c.Pos.File = file
+		l.ir.Comments = append(l.ir.Comments, c)
+	}
+	return nil
+}
+
+func (l *loader) declare(file, kind, name string) error {
+	key := Declaration{Kind: kind, Name: name}.Key()
+	if prev, ok := l.declared[key]; ok {
+		return fmt.Errorf("duplicate %s %s: declared in %s and %s", kind, name, prev, file)
+	}
+	l.declared[key] = file
+	return nil
+}
@@ -0,0 +1,180 @@
+package model
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+const prisonerRules = `
+package common
+object prisoner {
+	age : int
+}`
+
+const legalRules = `
+package legal
+import "common/prisoner.rules"
+rule hasRightToPhonecall {
+	input {
+		p : common.prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}`
+
+func TestLoad(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"common/prisoner.rules": prisonerRules,
+		"legal/phonecall.rules": legalRules,
+		"main.rules": `
+			import "legal/phonecall.rules"
+			import "common/prisoner.rules"
+			rule adult {
+				input {
+					p : common.prisoner
+				}
+				rules {
+					legal.hasRightToPhonecall(p)
+				}
+			}
+			test "adult" {
+				facts {
+					p : common.prisoner { age : 23 }
+				}
+				rules {
+					adult(p)
+				}
+			}`,
+	})
+	defer os.RemoveAll(root)
+
+	ir, err := Load(root, "main.rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for name := range ir.Objects {
+		names = append(names, name)
+	}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"adult", "common.prisoner", "legal.hasRightToPhonecall"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v want %v", names, want)
+	}
+	want := Expression{Functor: "legal.hasRightToPhonecall", Args: []Node{ObjectTerm("p", "common.prisoner")}}
+	if got := ir.Rules["adult"].Body[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v want %#v", got, want)
+	}
+	if got := ir.Rules["legal.hasRightToPhonecall"].Args[0].ObjectName(); got != "common.prisoner" {
+		t.Errorf("got %s want common.prisoner", got)
+	}
+	if errs := Check(ir); len(errs) > 0 {
+		t.Error(errs)
+	}
+}
+
+// the file loaded is not an import, so it may be outside of the root
+func TestLoadOutsideRoot(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"lib/common/prisoner.rules": prisonerRules,
+		"app/main.rules":            `import "common/prisoner.rules"`,
+	})
+	defer os.RemoveAll(root)
+
+	for i, file := range []string{
+		filepath.Join(root, "app", "main.rules"),
+		filepath.Join("..", "app", "main.rules"),
+	} {
+		ir, err := Load(filepath.Join(root, "lib"), file)
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		if _, ok := ir.Objects["common.prisoner"]; !ok {
+			t.Errorf("%d): got %v want common.prisoner", i, ir.Objects)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for i, tt := range []struct {
+		files map[string]string
+		want  string
+	}{
+		{
+			files: map[string]string{
+				"a.rules": `import "b.rules"`,
+				"b.rules": `import "c.rules"`,
+				"c.rules": `import "a.rules"`,
+			},
+			want: "import cycle: a.rules -> b.rules -> c.rules -> a.rules",
+		},
+		{
+			files: map[string]string{
+				"a.rules": `import "b.rules"
+					object prisoner { age : int }`,
+				"b.rules": `object prisoner { name : string }`,
+			},
+			want: "duplicate object prisoner: declared in b.rules and a.rules",
+		},
+		{
+			files: map[string]string{
+				"a.rules": `import "b.rules"
+					relation detained { name : string }`,
+				"b.rules": `rule detained { input { name : string } rules { startsWith(name, "x") } }`,
+			},
+			want: "duplicate relation detained: declared in b.rules and a.rules",
+		},
+		{
+			files: map[string]string{
+				"a.rules": `import "../b.rules"`,
+			},
+			want: `import "../b.rules" is outside of the root directory`,
+		},
+		{
+			files: map[string]string{
+				"a.rules": `import "b.rules"`,
+				"b.rules": `object prisoner { age : int`,
+			},
+			want: "b.rules: expected [, }] got EOF at line 1 : col 29",
+		},
+		{
+			files: map[string]string{
+				"a.rules": `object prisoner { age : int }
+					package legal`,
+			},
+			want: "a.rules: package must be declared once, before anything else at line 2 : col 19",
+		},
+	} {
+		root := writeFiles(t, tt.files)
+		_, err := Load(root, "a.rules")
+		os.RemoveAll(root)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%d): got %v want %s", i, err, tt.want)
+		}
+	}
+}
@@ -4,15 +4,28 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 type parser struct {
 	scanner *scanner
 	tok     Token
 	lit     string
+	pos     Position
+	// position of the last token consumed
+	prev Position
 
 	// currently defined variables -> objectType
 	varsInScope map[string]string
+
+	// names declared in a package are qualified as pkg.name
+	pkg string
+
+	// return errors instead of exiting, see Parse
+	panicOnError bool
+
+	declarations []Declaration
+	comments     []Comment
 }
 
 func newParser(r io.Reader) *parser {
@@ -24,9 +37,14 @@ func newParser(r io.Reader) *parser {
 }
 
 func (p *parser) next() {
-	p.tok, p.lit = p.scanner.scan()
-	if p.tok == COMMENT {
-		p.next()
+	p.prev = p.pos
+	for {
+		p.tok, p.lit = p.scanner.scan()
+		p.pos = Position{Line: p.scanner.tokRow, Col: p.scanner.tokCol}
+		if p.tok != COMMENT {
+			return
+		}
+		p.comments = append(p.comments, Comment{Pos: p.pos, Text: p.lit})
 	}
 }
 
@@ -58,25 +76,80 @@ func (p *parser) expectSequence(expected ...Token) {
 	}
 }
 
+func (p *parser) declare(kind, name string, pos Position) {
+	p.declarations = append(p.declarations, Declaration{Kind: kind, Name: name, Pos: pos})
+}
+
 func (p *parser) parseObject() Object {
-	objectName := p.expect(IDENT)
+	pos := p.pos
+	objectName := p.qualify(p.expect(IDENT))
+	p.declare("object", objectName, pos)
+	decl := len(p.declarations) - 1
 	o := Object{Name: objectName}
 	p.expect(LBRACE)
 	for {
+		pos := p.pos
+		p.declarations[decl].Fields = append(p.declarations[decl].Fields, pos)
 		name := p.expect(IDENT)
+		p.declarations = append(p.declarations, Declaration{Kind: "field", Name: name, Object: objectName, Pos: pos})
 		p.expect(COLON)
-		typeInfo := p.expect(IDENT)
+		typeInfo := p.parseTypeName()
 		f := p.parseField(name, typeInfo)
 		o.Fields = append(o.Fields, f)
 		if !p.commaOrRbrace() {
 			break
 		}
 	}
+	p.declarations[decl].End = p.prev
 	return o
 }
 
 func (p *parser) parseField(name, typeInfo string) Field {
-	return Field{Name: name, TypeInfo: lookupType(typeInfo)}
+	f := Field{Name: name, TypeInfo: lookupType(typeInfo)}
+	if f.TypeInfo == OBJECT {
+		f.objectName = typeInfo
+	}
+	return f
+}
+
+// qualify returns name as declared in the current package
+func (p *parser) qualify(name string) string {
+	if p.pkg == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return p.pkg + "." + name
+}
+
+// pkg.name( is a qualified call, p.age a field access
+func (p *parser) isQualifiedCall() bool {
+	return p.tok == IDENT && p.scanner.qualifiedCall()
+}
+
+// parseQualifiedName parses name or pkg.name, qualifying
+// unqualified names that are not builtins
+func (p *parser) parseQualifiedName() string {
+	name := p.expect(IDENT)
+	if p.tok == PERIOD {
+		p.next()
+		return name + "." + p.expect(IDENT)
+	}
+	if IsBuiltin(name) {
+		return name
+	}
+	return p.qualify(name)
+}
+
+// parseTypeName parses a builtin type, object or pkg.object
+func (p *parser) parseTypeName() string {
+	name := p.expect(IDENT)
+	if p.tok == PERIOD {
+		p.next()
+		return name + "." + p.expect(IDENT)
+	}
+	if lookupType(name) != OBJECT {
+		return name
+	}
+	return p.qualify(name)
 }
 
 func (p *parser) parseTermWithType(name, typeInfo string) Term {
@@ -96,34 +169,58 @@ func (p *parser) parseTerm(tok Token, lit string) Term {
 			return ObjectTerm(lit, objectName)
 		}
 	}
-	return Term{TypeInfo: tok, Value: tokenValue(tok, lit)}
+	v, err := tokenValue(tok, lit)
+	if err != nil {
+		// only literals fail, which are parsed once consumed
+		p.errorAt(p.prev, err)
+	}
+	return Term{TypeInfo: tok, Value: v}
+}
+
+// parseLiteral parses a term whose token has already been
+// consumed, combining an int followed by a unit into a duration
+func (p *parser) parseLiteral(tok Token, lit string) Term {
+	t := p.parseTerm(tok, lit)
+	if tok != INT || p.tok != IDENT {
+		return t
+	}
+	if d, ok := durationWithUnit(t.Value.(int), p.lit); ok {
+		p.next()
+		return DurationTerm(d)
+	}
+	return t
 }
 
 func (p *parser) parseRelation() Relation {
-	relationName := p.expect(IDENT)
+	pos := p.pos
+	relationName := p.qualify(p.expect(IDENT))
+	p.declare("relation", relationName, pos)
+	decl := len(p.declarations) - 1
 	r := Relation{Name: relationName}
 	p.expect(LBRACE)
 	for {
+		p.declarations[decl].Fields = append(p.declarations[decl].Fields, p.pos)
 		name := p.expect(IDENT)
 		p.expect(COLON)
-		typeInfo := p.expect(IDENT)
+		typeInfo := p.parseTypeName()
 		f := p.parseField(name, typeInfo)
 		r.Fields = append(r.Fields, f)
 		if !p.commaOrRbrace() {
 			break
 		}
 	}
+	p.declarations[decl].End = p.prev
 	return r
 }
 
 // parse list of terms as args: functor(a1, a2, a3...)
 func (p *parser) parseRuleCall() (e Expression, more bool) {
-	functor := p.expect(IDENT)
+	functor := p.parseQualifiedName()
 	e = Expression{Functor: functor, Args: []Node{}}
 	p.expect(LPAREN)
 	for {
-		tok, lit := p.expectOneOf(IDENT, INT, FLOAT, STRING)
-		t := p.parseTerm(tok, lit)
+		tok, lit := p.expectOneOf(IDENT, INT, FLOAT, STRING, DATE, DURATION)
+		t := p.parseLiteral(tok, lit)
 		e.Args = append(e.Args, t)
 		tok, _ = p.expectOneOf(COMMA, RPAREN)
 		if tok == RPAREN {
@@ -134,47 +231,100 @@ func (p *parser) parseRuleCall() (e Expression, more bool) {
 }
 
 func (p *parser) parseExpression() (e Expression, more bool) {
-	// scanner is already looking 1 rune ahead
-	if p.tok == IDENT && p.scanner.ch == '(' {
-		return p.parseRuleCall()
+	if p.tok == LET {
+		return p.parseLet()
 	}
-
-	return p.parseExpressionTree(COMMA, RBRACE)
+	n, more := p.parseExpressionTree(COMMA, RBRACE)
+	e, ok := n.(Expression)
+	if !ok {
+		p.handleError(fmt.Errorf("expected expression but found %v", n))
+	}
+	return e, more
 }
 
-func (p *parser) parseExpressionTree(terminators ...Token) (e Expression, more bool) {
-	n1 := p.parseNode()
-	op := p.parseOperator()
-	n2 := p.parseNode()
-
-	e = Expression{Functor: op, Args: []Node{n1, n2}}
-
-	for p.tok.IsOperator() {
-		op := p.parseOperator()
-		n3 := p.parseNode()
-		e = merge(e, op, n3)
+// let name = expression, introduces a local variable
+// that can be used in the rest of the rule body.
+// let name : type introduces an unbound variable,
+// to be bound by a relation or rule call
+func (p *parser) parseLet() (e Expression, more bool) {
+	p.expect(LET)
+	name := p.expect(IDENT)
+	if tok, _ := p.expectOneOf(EQL, COLON); tok == COLON {
+		typeInfo := p.parseTypeName()
+		t := p.parseTermWithType(name, typeInfo)
+		if t.TypeInfo == OBJECT {
+			p.varsInScope[name] = typeInfo
+		}
+		tok, _ := p.expectOneOf(COMMA, RBRACE)
+		return Expression{Functor: "let", Args: []Node{t}}, tok == COMMA
+	}
+	value, more := p.parseExpressionTree(COMMA, RBRACE)
+	if t, ok := value.(Term); ok && t.TypeInfo == OBJECT {
+		p.varsInScope[name] = t.ObjectName()
 	}
+	return Expression{Functor: "let", Args: []Node{IdentifierTerm(name), value}}, more
+}
+
+// a tree is either a single node (a term or call)
+// or nodes joined by binary operators
+func (p *parser) parseExpressionTree(terminators ...Token) (n Node, more bool) {
+	n = p.parseBinary(LowestPrec + 1)
 
 	tok, _ := p.expectOneOf(terminators...)
 	// TODO: clean up this more logic
 	if tok == COMMA {
-		return e, true
+		return n, true
 	}
-	return e, false
+	return n, false
 }
 
 func (p *parser) parseNode() (n Node) {
 	switch p.tok {
-	case IDENT, INT, FLOAT, STRING:
+	case IDENT:
+		// scanner is already looking 1 rune ahead
+		if p.scanner.ch == '(' || p.isQualifiedCall() {
+			return p.parseCall()
+		}
+		// builtin: evaluation date
+		if p.lit == "today" {
+			p.next()
+			return Expression{Functor: "today", Args: []Node{}}
+		}
 		n = p.parseTerm(p.tok, p.lit)
 		p.next()
+	case INT, FLOAT, STRING, DATE, DURATION:
+		tok, lit := p.tok, p.lit
+		p.next()
+		n = p.parseLiteral(tok, lit)
 	case LPAREN:
 		p.next()
 		n, _ = p.parseExpressionTree(RPAREN)
+	default:
+		p.handleError(fmt.Errorf("unexpected %v", p.tok))
 	}
 	return n
 }
 
+// parse a rule or builtin call within an expression,
+// its args can be expressions: functor(e1, e2, e3...)
+func (p *parser) parseCall() Expression {
+	functor := p.parseQualifiedName()
+	e := Expression{Functor: functor, Args: []Node{}}
+	p.expect(LPAREN)
+	if p.tok == RPAREN {
+		p.next()
+		return e
+	}
+	for {
+		n, more := p.parseExpressionTree(COMMA, RPAREN)
+		e.Args = append(e.Args, n)
+		if !more {
+			break
+		}
+	}
+	return e
+}
+
 func (p *parser) parseOperator() string {
 	if !p.tok.IsOperator() {
 		p.handleError(fmt.Errorf("expected operator but found %v", p.tok))
@@ -184,23 +334,23 @@ func (p *parser) parseOperator() string {
 	return op
 }
 
-// assumption: tree is binary (has two children!)
-func merge(tree Expression, op string, node Node) Expression {
-	root := lookupOperator(tree.Functor).Precedence()
-	opp := lookupOperator(op).Precedence()
-	if root >= opp {
-		return Expression{Functor: op, Args: []Node{tree, node}}
+// parseBinary parses operators binding at least as tight as
+// prec, operators of equal precedence associate to the left
+func (p *parser) parseBinary(prec int) Node {
+	n := p.parseNode()
+	for p.tok.IsOperator() && p.tok.Precedence() >= prec {
+		opPrec := p.tok.Precedence()
+		op := p.parseOperator()
+		right := p.parseBinary(opPrec + 1)
+		n = Expression{Functor: op, Args: []Node{n, right}}
 	}
-	left := tree.Args[0]
-	right := tree.Args[1]
-	newTree := Expression{Functor: op, Args: []Node{right, node}}
-	return Expression{Functor: tree.Functor, Args: []Node{left, newTree}}
+	return n
 }
 
 func (p *parser) parseFact() (e Expression, more bool) {
 	// parse relation, looks like a rule call
 	// scanner is already looking 1 rune ahead
-	if p.tok == IDENT && p.scanner.ch == '(' {
+	if p.tok == IDENT && p.scanner.ch == '(' || p.isQualifiedCall() {
 		return p.parseRuleCall()
 	}
 	// otherwise parse an object instantiation
@@ -210,15 +360,15 @@ func (p *parser) parseFact() (e Expression, more bool) {
 func (p *parser) parseObjectInstantiation() (oi Expression, more bool) {
 	varName := p.expect(IDENT)
 	p.expect(COLON)
-	objectName := p.expect(IDENT)
+	objectName := p.parseTypeName()
 	o := ObjectTerm(varName, objectName)
 	oi = Expression{Functor: "new", Args: []Node{o}}
 	p.expect(LBRACE)
 	for {
 		fieldName := p.expect(IDENT)
 		p.expect(COLON)
-		tok, lit := p.expectOneOf(IDENT, INT, FLOAT, STRING)
-		f := FieldTerm(fieldName, tokenValue(tok, lit))
+		tok, lit := p.expectOneOf(IDENT, INT, FLOAT, STRING, DATE, DURATION)
+		f := FieldTerm(fieldName, p.parseLiteral(tok, lit).Value)
 		oi.Args = append(oi.Args, f)
 		if !p.commaOrRbrace() {
 			break
@@ -229,13 +379,19 @@ func (p *parser) parseObjectInstantiation() (oi Expression, more bool) {
 
 func (p *parser) parseRule() Rule {
 	p.varsInScope = map[string]string{}
-	ruleName := p.expect(IDENT)
+	pos := p.pos
+	ruleName := p.qualify(p.expect(IDENT))
+	p.declare("rule", ruleName, pos)
+	decl := len(p.declarations) - 1
 	r := Rule{Name: ruleName}
-	p.expectSequence(LBRACE, INPUT, LBRACE)
+	p.expect(LBRACE)
+	p.declarations[decl].Blocks = append(p.declarations[decl].Blocks, p.pos)
+	p.expectSequence(INPUT, LBRACE)
 	for {
+		p.declarations[decl].Fields = append(p.declarations[decl].Fields, p.pos)
 		name := p.expect(IDENT)
 		p.expect(COLON)
-		typeInfo := p.expect(IDENT)
+		typeInfo := p.parseTypeName()
 		f := p.parseTermWithType(name, typeInfo)
 		if f.TypeInfo == OBJECT {
 			p.varsInScope[name] = typeInfo
@@ -245,31 +401,66 @@ func (p *parser) parseRule() Rule {
 			break
 		}
 	}
+	inputs := p.varsInScope
+	var conditions []Position
+	p.declarations[decl].Blocks = append(p.declarations[decl].Blocks, p.pos)
+	r.Body, conditions = p.parseRuleBody(inputs)
+	p.declarations[decl].Clauses = append(p.declarations[decl].Clauses, conditions)
+	for p.tok == RULES {
+		p.declarations[decl].Blocks = append(p.declarations[decl].Blocks, p.pos)
+		body, conditions := p.parseRuleBody(inputs)
+		r.Alternatives = append(r.Alternatives, body)
+		p.declarations[decl].Clauses = append(p.declarations[decl].Clauses, conditions)
+	}
+	p.expect(RBRACE)
+	p.declarations[decl].End = p.prev
+	return r
+}
+
+// each body starts out with only the inputs in scope,
+// returned with the position of each condition
+func (p *parser) parseRuleBody(inputs map[string]string) ([]Expression, []Position) {
+	p.varsInScope = map[string]string{}
+	for k, v := range inputs {
+		p.varsInScope[k] = v
+	}
+	body := []Expression{}
+	positions := []Position{}
 	p.expectSequence(RULES, LBRACE)
 	for {
+		positions = append(positions, p.pos)
 		expression, more := p.parseExpression()
-		r.Body = append(r.Body, expression)
+		body = append(body, expression)
 		if !more {
 			break
 		}
 	}
-	p.expect(RBRACE)
-	return r
+	return body, positions
 }
 
 func (p *parser) parseTest() Test {
+	p.varsInScope = map[string]string{}
+	pos := p.pos
 	_, testName := p.expectOneOf(IDENT, STRING)
+	p.declare("test", testName, pos)
+	d := &p.declarations[len(p.declarations)-1]
 	t := Test{Name: testName}
-	p.expectSequence(LBRACE, FACTS, LBRACE)
+	p.expect(LBRACE)
+	d.Blocks = append(d.Blocks, p.pos)
+	p.expectSequence(FACTS, LBRACE)
 	for {
+		d.Fields = append(d.Fields, p.pos)
 		fact, more := p.parseFact()
 		t.Facts = append(t.Facts, fact)
 		if !more {
 			break
 		}
 	}
+	d.Blocks = append(d.Blocks, p.pos)
 	p.expectSequence(RULES, LBRACE)
+	calls := []Position{}
 	for {
+		calls = append(calls, p.pos)
 		rule, more := p.parseRuleCall()
 		t.Body = append(t.Body, rule)
 		if !more {
@@ -277,6 +468,8 @@ func (p *parser) parseTest() Test {
 		}
 	}
 	p.expect(RBRACE)
+	d.Clauses = [][]Position{calls}
+	d.End = p.prev
 	return t
 }
 
@@ -289,7 +482,22 @@ func (p *parser) parse() InternalRepresentation {
 		case ILLEGAL:
 			p.handleError(fmt.Errorf("ILLEGAL character"))
 		case EOF:
+			ir.Declarations = p.declarations
+			ir.Comments = p.comments
 			return ir
+		case PACKAGE:
+			if p.pkg != "" || len(ir.Objects)+len(ir.Relations)+len(ir.Rules)+len(ir.Tests) > 0 {
+				p.handleError(fmt.Errorf("package must be declared once, before anything else"))
+			}
+			pos := p.pos
+			p.pkg = p.expect(IDENT)
+			p.declare("package", p.pkg, pos)
+			ir.Package = p.pkg
+		case IMPORT:
+			pos := p.pos
+			path := p.expect(STRING)
+			p.declare("import", path, pos)
+			ir.Imports = append(ir.Imports, path)
 		case OBJECT:
 			o := p.parseObject()
 			ir.Objects[o.Name] = o
@@ -321,7 +529,26 @@ func (p *parser) commaOrRbrace() bool {
 	return false
 }
 
+// ParseError is a syntax error at a position in the source
+type ParseError struct {
+	Row, Col int
+	Err      error
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d : col %d", e.Err, e.Row, e.Col)
+}
+
 func (p *parser) handleError(e error) {
-	fmt.Printf("%s at line %d : col %d\n", e.Error(), p.scanner.row, p.scanner.col)
+	p.errorAt(Position{Line: p.scanner.row, Col: p.scanner.col}, e)
+}
+
+// errorAt is handleError at the position of a token
+func (p *parser) errorAt(pos Position, e error) {
+	err := ParseError{Row: pos.Line, Col: pos.Col, Err: e}
+	if p.panicOnError {
+		panic(err)
+	}
+	fmt.Println(err)
 	os.Exit(1)
 }
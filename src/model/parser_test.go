@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseExpression(t *testing.T) {
@@ -63,6 +64,92 @@ func TestParseExpression(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "2018-03-01 + 6 months <= today",
+			want: Expression{Functor: "<=",
+				Args: []Node{
+					Expression{Functor: "+",
+						Args: []Node{
+							DateTerm(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)),
+							DurationTerm(Duration{Months: 6}),
+						},
+					},
+					Expression{Functor: "today", Args: []Node{}},
+				},
+			},
+		},
+		{
+			input: "today - P1Y2W",
+			want: Expression{Functor: "-",
+				Args: []Node{
+					Expression{Functor: "today", Args: []Node{}},
+					DurationTerm(Duration{Years: 1, Days: 14}),
+				},
+			},
+		},
+		// the old merge only looked at the root, so the product
+		// bound to the sum instead of to z
+		{
+			input: "x < y + z * 2",
+			want: Expression{Functor: "<",
+				Args: []Node{
+					IdentifierTerm("x"),
+					Expression{Functor: "+",
+						Args: []Node{
+							IdentifierTerm("y"),
+							Expression{Functor: "*",
+								Args: []Node{
+									IdentifierTerm("z"),
+									IntTerm(2),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			input: "x - y - 1",
+			want: Expression{Functor: "-",
+				Args: []Node{
+					Expression{Functor: "-",
+						Args: []Node{
+							IdentifierTerm("x"),
+							IdentifierTerm("y"),
+						},
+					},
+					IntTerm(1),
+				},
+			},
+		},
+		{
+			input: "x * 2.5 < 10",
+			want: Expression{Functor: "<",
+				Args: []Node{
+					Expression{Functor: "*",
+						Args: []Node{
+							IdentifierTerm("x"),
+							Term{Value: 2.5, TypeInfo: FLOAT},
+						},
+					},
+					IntTerm(10),
+				},
+			},
+		},
+		{
+			input: "x + 1 != y",
+			want: Expression{Functor: "!=",
+				Args: []Node{
+					Expression{Functor: "+",
+						Args: []Node{
+							IdentifierTerm("x"),
+							IntTerm(1),
+						},
+					},
+					IdentifierTerm("y"),
+				},
+			},
+		},
 		{
 			input: `functor(arg1, arg2, 42)`,
 			want: Expression{Functor: "functor",
@@ -95,8 +182,8 @@ func TestReadObject(t *testing.T) {
 				name : string
 			}`,
 			want: NewObject("prisoner", []Field{
-				{"age", INT},
-				{"name", STRING},
+				{Name: "age", TypeInfo: INT},
+				{Name: "name", TypeInfo: STRING},
 			}),
 		},
 	} {
@@ -209,3 +296,92 @@ func TestReadTestcase(t *testing.T) {
 		}
 	}
 }
+
+func TestDeclarationOrder(t *testing.T) {
+	ir := Read(`
+		object prisoner { age : int }
+		object cell { number : int }
+		relation inCell { p : prisoner, c : cell }
+		relation cellmates { p : prisoner, q : prisoner }
+		rule zebra { input { p : prisoner } rules { p.age >= 18 } }
+		rule adult { input { p : prisoner } rules { p.age >= 18 } }`)
+	ir.Rules["handWritten"] = Rule{Name: "handWritten"}
+	for i, tt := range []struct {
+		got, want []string
+	}{
+		{got: ir.ObjectNames(), want: []string{"prisoner", "cell"}},
+		{got: ir.RelationNames(), want: []string{"inCell", "cellmates"}},
+		{got: ir.RuleNames(), want: []string{"zebra", "adult", "handWritten"}},
+		{got: SortedKeys(ir.Rules), want: []string{"adult", "handWritten", "zebra"}},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%d): got %v want %v", i, tt.got, tt.want)
+		}
+	}
+}
+
+func TestObjectOf(t *testing.T) {
+	ir := Read(`
+		object prisoner { age : int, cell : cell }
+		object cell { number : int }`)
+	scope := map[string]string{"p": "prisoner", "n": ""}
+	field := func(n Node, name string) Node {
+		return Expression{Functor: ".", Args: []Node{n, IdentifierTerm(name)}}
+	}
+	for i, tt := range []struct {
+		n    Node
+		want string
+	}{
+		{ObjectTerm("q", "prisoner"), "prisoner"},
+		{IdentifierTerm("p"), "prisoner"},
+		{IdentifierTerm("n"), ""},
+		{field(IdentifierTerm("p"), "cell"), "cell"},
+		{field(IdentifierTerm("p"), "age"), ""},
+		{field(field(IdentifierTerm("p"), "cell"), "number"), ""},
+	} {
+		if got := ir.ObjectOf(tt.n, scope); got != tt.want {
+			t.Errorf("%d): got %q want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestDeclarationKey(t *testing.T) {
+	for i, tt := range []struct {
+		a, b  Declaration
+		clash bool
+	}{
+		{Declaration{Kind: "rule", Name: "x"}, Declaration{Kind: "relation", Name: "x"}, true},
+		{Declaration{Kind: "rule", Name: "x"}, Declaration{Kind: "object", Name: "x"}, false},
+		{Declaration{Kind: "field", Name: "x", Object: "a"}, Declaration{Kind: "field", Name: "x", Object: "b"}, false},
+		{Declaration{Kind: "import", Name: "x", Pos: Position{File: "a"}}, Declaration{Kind: "import", Name: "x", Pos: Position{File: "b"}}, false},
+	} {
+		if got := tt.a.Key() == tt.b.Key(); got != tt.clash {
+			t.Errorf("%d): got %v want %v", i, got, tt.clash)
+		}
+	}
+}
+
+func TestParseInvalidLiterals(t *testing.T) {
+	for i, tt := range []struct {
+		condition string
+		want      string
+	}{
+		{"p.born < 2018-02-30", "invalid date 2018-02-30 at line 7 : col 12"},
+		{"p.born < 2018-13-01", "invalid date 2018-13-01 at line 7 : col 12"},
+		{"p.age < 99999999999999999999", "invalid integer 99999999999999999999 at line 7 : col 11"},
+		{"p.born + P99999999999999999999Y < today", "invalid duration P99999999999999999999Y at line 7 : col 12"},
+	} {
+		_, err := Parse(`rule r {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age > 1,
+		` + tt.condition + `
+	}
+}`)
+		if _, ok := err.(ParseError); !ok || err.Error() != tt.want {
+			t.Errorf("%d): got %v want %s", i, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Rules can call themselves or each other. Prolog evaluates
+// goals left to right, so a rule body starting with a call back
+// into the same rule never gets to the goals that would bind
+// its arguments, and loops. We report these instead of
+// rewriting them: the fix is usually to start the body with
+// the relation that is being closed over.
+
+// leftCalls maps each rule to the rules called first in
+// one of its bodies. lets only bind names so are skipped.
+func leftCalls(ir InternalRepresentation) map[string][]string {
+	calls := map[string][]string{}
+	for name, r := range ir.Rules {
+		for _, body := range r.Clauses() {
+			for _, e := range body {
+				if e.Functor == "let" {
+					continue
+				}
+				if _, ok := ir.Rules[e.Functor]; ok {
+					calls[name] = append(calls[name], e.Functor)
+				}
+				break
+			}
+		}
+	}
+	return calls
+}
+
+// leftRecursionPath returns a path of left calls from
+// the named rule back to itself, or nil if there is none
+func leftRecursionPath(calls map[string][]string, name string) []string {
+	visited := map[string]bool{}
+	var walk func(path []string) []string
+	walk = func(path []string) []string {
+		for _, next := range calls[path[len(path)-1]] {
+			if next == name {
+				return append(path, next)
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			if p := walk(append(path, next)); p != nil {
+				return p
+			}
+		}
+		return nil
+	}
+	return walk([]string{name})
+}
+
+func checkLeftRecursion(ir InternalRepresentation) []error {
+	calls := leftCalls(ir)
+	names := []string{}
+	for name := range ir.Rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	errs := []error{}
+	for _, name := range names {
+		path := leftRecursionPath(calls, name)
+		if path == nil {
+			continue
+		}
+		errs = append(errs, CheckError{"rule", name, -1, -1, fmt.Errorf("left-recursive via %s, which loops in Prolog; start the body with a relation or condition instead",
+			strings.Join(path, " -> "))})
+	}
+	return errs
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"bufio"
 	"io"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -11,6 +12,9 @@ type scanner struct {
 	r        *bufio.Reader
 	ch       rune // current character
 	row, col int  // position
+
+	// position of the last token scanned
+	tokRow, tokCol int
 }
 
 func newScanner(r io.Reader) *scanner {
@@ -31,13 +35,36 @@ func (s *scanner) next() {
 	s.ch = ch
 }
 
+// lookahead consumes the next character if it is r, peeking
+// so that the position stays right when it is not
 func (s *scanner) lookahead(r rune) bool {
+	b, err := s.r.Peek(1)
+	if err != nil || rune(b[0]) != r {
+		return false
+	}
 	s.next()
-	if s.ch == r {
-		return true
+	return true
+}
+
+// qualifiedCall reports whether the scanner is at the period
+// in pkg.name( without consuming anything
+func (s *scanner) qualifiedCall() bool {
+	if s.ch != '.' {
+		return false
+	}
+	for n := 1; ; n++ {
+		b, err := s.r.Peek(n)
+		if err != nil {
+			return false
+		}
+		ch := rune(b[n-1])
+		if ch == '(' {
+			return n > 1
+		}
+		if !isLetter(ch) && !(n > 1 && isDigit(ch)) {
+			return false
+		}
 	}
-	s.r.UnreadRune()
-	return false
 }
 
 func (s *scanner) skipWhitespace() {
@@ -48,7 +75,7 @@ func (s *scanner) skipWhitespace() {
 
 // TODO: dealing with CR?
 func (s *scanner) scanComment() (tok Token, lit string) {
-	for s.ch != '\n' {
+	for s.ch != '\n' && s.ch != 0 {
 		lit += string(s.ch)
 		s.next()
 	}
@@ -60,6 +87,11 @@ func (s *scanner) scanIdentifier() (tok Token, lit string) {
 		lit += string(s.ch)
 		s.next()
 	}
+	// out of range durations are scanned too, so
+	// that parsing reports them
+	if lit != "P" && isoDuration.MatchString(lit) {
+		return DURATION, lit
+	}
 	tok = lookupToken(lit)
 	return
 }
@@ -67,6 +99,9 @@ func (s *scanner) scanIdentifier() (tok Token, lit string) {
 func (s *scanner) scanString() (tok Token, lit string) {
 	s.next()
 	for s.ch != '"' {
+		if s.ch == 0 {
+			return ILLEGAL, lit
+		}
 		lit += string(s.ch)
 		s.next()
 	}
@@ -80,9 +115,79 @@ func (s *scanner) scanNumber() (tok Token, lit string) {
 		lit += string(s.ch)
 		s.next()
 	}
+	if len(lit) == 4 && s.ch == '-' {
+		if suffix, ok := s.scanDateSuffix(); ok {
+			return DATE, lit + suffix
+		}
+	}
+	// a dot only makes a float if a digit follows,
+	// otherwise it could be a field access
+	if s.ch == '.' {
+		if b, err := s.r.Peek(1); err == nil && isDigit(rune(b[0])) {
+			lit += "."
+			for s.next(); isDigit(s.ch); s.next() {
+				lit += string(s.ch)
+			}
+			return FLOAT, lit
+		}
+	}
 	return INT, lit
 }
 
+// scanDateSuffix scans the -MM-DD part of a date literal.
+// s.ch is already consumed from the reader, so we peek at
+// the 5 bytes after it before committing to a date.
+func (s *scanner) scanDateSuffix() (string, bool) {
+	b, err := s.r.Peek(5)
+	if err != nil {
+		return "", false
+	}
+	for i, c := range b {
+		if i == 2 && c != '-' || i != 2 && !('0' <= c && c <= '9') {
+			return "", false
+		}
+	}
+	lit := "-" + string(b)
+	for range lit {
+		s.next()
+	}
+	return lit, true
+}
+
+// Lexeme is a token with its literal and position
+type Lexeme struct {
+	Tok Token
+	Lit string
+	Pos Position
+}
+
+// Scan returns the tokens of src up to EOF, comments included.
+// It does not fail, characters it does not know are ILLEGAL.
+func Scan(src string) []Lexeme {
+	sc := newScanner(strings.NewReader(src))
+	sc.next()
+	lexemes := []Lexeme{}
+	for {
+		tok, lit := sc.scan()
+		if tok == EOF {
+			return lexemes
+		}
+		lexemes = append(lexemes, Lexeme{Tok: tok, Lit: lit, Pos: Position{Line: sc.tokRow, Col: sc.tokCol}})
+	}
+}
+
+// IsIdentifier reports whether s scans as a single identifier,
+// so it can be written without quotes
+func IsIdentifier(s string) bool {
+	sc := newScanner(strings.NewReader(s))
+	sc.next()
+	if tok, lit := sc.scan(); tok != IDENT || lit != s {
+		return false
+	}
+	tok, _ := sc.scan()
+	return tok == EOF
+}
+
 func isLetter(r rune) bool {
 	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_' || r >= utf8.RuneSelf && unicode.IsLetter(r)
 }
@@ -108,6 +213,7 @@ func isDigit(r rune) bool {
 func (s *scanner) scan() (tok Token, lit string) {
 	// TODOS: scan float
 	s.skipWhitespace()
+	s.tokRow, s.tokCol = s.row, s.col
 
 	if isLetter(s.ch) {
 		return s.scanIdentifier()
@@ -155,6 +261,12 @@ func (s *scanner) scan() (tok Token, lit string) {
 		}
 	case '=':
 		tok = EQL
+	case '!':
+		if s.lookahead('=') {
+			tok = NEQ
+		} else {
+			tok = NOT
+		}
 	case '+':
 		tok = ADD
 	case '-':
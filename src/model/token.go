@@ -15,10 +15,12 @@ const (
 	COMMENT
 
 	literal_beg
-	IDENT  // main
-	INT    // 12345
-	FLOAT  // 123.45
-	STRING // "abc"
+	IDENT    // main
+	INT      // 12345
+	FLOAT    // 123.45
+	STRING   // "abc"
+	DATE     // 2018-03-01
+	DURATION // P6M, 6 months
 	literal_end
 
 	operator_beg
@@ -61,6 +63,9 @@ const (
 	RULES
 	INPUT
 	RELATION
+	LET
+	PACKAGE
+	IMPORT
 	keyword_end
 )
 
@@ -69,10 +74,12 @@ var tokens = [...]string{
 	EOF:     "EOF",
 	COMMENT: "COMMENT",
 
-	IDENT:  "ident",
-	INT:    "int",
-	FLOAT:  "float",
-	STRING: "string",
+	IDENT:    "ident",
+	INT:      "int",
+	FLOAT:    "float",
+	STRING:   "string",
+	DATE:     "date",
+	DURATION: "duration",
 
 	ADD: "+",
 	SUB: "-",
@@ -108,6 +115,9 @@ var tokens = [...]string{
 	RULES:    "rules",
 	INPUT:    "input",
 	RELATION: "relation",
+	LET:      "let",
+	PACKAGE:  "package",
+	IMPORT:   "import",
 }
 
 func (tok Token) String() string {
@@ -191,23 +201,34 @@ func (tok Token) IsOperator() bool { return operator_beg < tok && tok < operator
 
 func (tok Token) IsKeyword() bool { return keyword_beg < tok && tok < keyword_end }
 
-func tokenValue(tok Token, lit string) interface{} {
+// tokenValue returns the value of a literal,
+// or an error if it is out of range or not a valid date
+func tokenValue(tok Token, lit string) (interface{}, error) {
 	switch tok {
 	case INT:
 		i, err := strconv.Atoi(lit)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("invalid integer %s", lit)
 		}
-		return i
+		return i, nil
 	case FLOAT:
-		i, err := strconv.ParseFloat(lit, 64)
+		f, err := strconv.ParseFloat(lit, 64)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("invalid float %s", lit)
 		}
-		return i
-	case STRING, IDENT:
-		return lit
-	default:
-		return lit
+		return f, nil
+	case DATE:
+		d, err := parseDate(lit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %s", lit)
+		}
+		return d, nil
+	case DURATION:
+		d, ok := parseISODuration(lit)
+		if !ok {
+			return nil, fmt.Errorf("invalid duration %s", lit)
+		}
+		return d, nil
 	}
+	return lit, nil
 }
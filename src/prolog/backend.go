@@ -0,0 +1,178 @@
+package prolog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"backend"
+	. "model"
+
+	"github.com/mndrix/golog"
+)
+
+// Golog runs rulebases in the embedded golog interpreter
+type Golog struct {
+	g *generator
+	m golog.Machine
+}
+
+var _ backend.Backend = &Golog{}
+
+func NewGolog() *Golog {
+	return &Golog{}
+}
+
+func (b *Golog) Load(ir InternalRepresentation) error {
+	return catch(func() {
+		b.g = newGenerator(ir)
+		m := golog.NewMachine()
+		m = m.RegisterForeign(foreignPredicates)
+		m = m.Consult(datePrelude)
+		m = m.Consult(stringPrelude)
+		m = m.Consult(printToday(Today()))
+		for _, prologString := range printProgram(b.g) {
+			m = m.Consult(prologString)
+		}
+		b.m = m
+	})
+}
+
+func (b *Golog) RunTests() ([]backend.TestResult, error) {
+	if b.m == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	results := []backend.TestResult{}
+	for _, t := range b.g.ir.Tests {
+		var passed bool
+		err := catch(func() {
+			passed = b.m.CanProve(fmt.Sprintf("test(%s).", printQuoted(t.Name)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("test %q: %s", t.Name, err)
+		}
+		results = append(results, backend.NewTestResult(t, passed))
+	}
+	return results, nil
+}
+
+// golog machines are immutable: the query is
+// consulted into a copy of the loaded machine
+func (b *Golog) Query(facts []Expression, goal Expression) (bool, error) {
+	if b.m == nil {
+		return false, backend.ErrNotLoaded
+	}
+	var holds bool
+	err := catch(func() {
+		m := b.m.Consult(printQuery(b.g, facts, goal))
+		holds = m.CanProve("rulebase_query.")
+	})
+	return holds, err
+}
+
+// golog reports errors in Prolog code by panicking
+func catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("golog: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+var ErrNoSWI = errors.New("swipl not found in PATH")
+
+// SWI runs rulebases exported as a module by an
+// external SWI-Prolog process, one per call
+type SWI struct {
+	path string
+	ir   *InternalRepresentation
+}
+
+var _ backend.Backend = &SWI{}
+
+// NewSWI returns ErrNoSWI if SWI-Prolog is not installed
+func NewSWI() (*SWI, error) {
+	path, err := exec.LookPath("swipl")
+	if err != nil {
+		return nil, ErrNoSWI
+	}
+	return &SWI{path: path}, nil
+}
+
+func (b *SWI) Load(ir InternalRepresentation) error {
+	b.ir = &ir
+	return nil
+}
+
+const swiModule = "rulebase"
+
+// each test is reported on its own line as pass or fail,
+// in the order of test_cases
+const swiRunTests = swiModule + `:test_cases(L), forall(member(T, L), ` +
+	`((` + swiModule + `:test(T) -> S = pass ; S = fail), format("~w~n", [S])))`
+
+func (b *SWI) RunTests() ([]backend.TestResult, error) {
+	if b.ir == nil {
+		return nil, backend.ErrNotLoaded
+	}
+	out, err := b.run(export(*b.ir, swiModule), swiRunTests)
+	if err != nil {
+		return nil, err
+	}
+	// tests are taken from the rulebase, since a name
+	// can hold anything, newlines included
+	results := []backend.TestResult{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		status := scanner.Text()
+		if status != "pass" && status != "fail" || len(results) == len(b.ir.Tests) {
+			continue
+		}
+		t := b.ir.Tests[len(results)]
+		results = append(results, backend.NewTestResult(t, status == "pass"))
+	}
+	if len(results) != len(b.ir.Tests) {
+		return nil, fmt.Errorf("swipl: expected %d test results, got output\n%s", len(b.ir.Tests), out)
+	}
+	return results, nil
+}
+
+func (b *SWI) Query(facts []Expression, goal Expression) (bool, error) {
+	if b.ir == nil {
+		return false, backend.ErrNotLoaded
+	}
+	g := newGenerator(*b.ir)
+	program := printExport(g, swiModule) + "\n" + printQuery(g, facts, goal) + "\n"
+	out, err := b.run(program, fmt.Sprintf(`(%s:rulebase_query -> write(yes) ; write(no))`, swiModule))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) == "yes", nil
+}
+
+// run loads the program from a temporary file and runs goal
+func (b *SWI) run(program, goal string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "rulebase")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, swiModule+".pl")
+	if err := ioutil.WriteFile(file, []byte(program), 0644); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(b.path, "-q", "-g", goal, "-t", "halt", file)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("swipl: %s", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,72 @@
+package prolog
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"backend"
+	. "model"
+)
+
+func newSWI(tb testing.TB) *SWI {
+	swi, err := NewSWI()
+	if err != nil {
+		tb.Skip(err)
+	}
+	return swi
+}
+
+func TestBackendsAgree(t *testing.T) {
+	swi := newSWI(t)
+	ir := Read(fmt.Sprintf(exportRulebase, `startsWith(lower(p.name), "j")`))
+	query := Read(`test "query" {
+		facts {
+			p : prisoner { age : 17, name : jim, admitted : 2010-01-01 }
+		}
+		rules {
+			hasRightToPhonecall(p)
+		}
+	}`).Tests[0]
+
+	for i, b := range []backend.Backend{NewGolog(), swi} {
+		if err := b.Load(ir); err != nil {
+			t.Fatalf("%d): %s", i, err)
+		}
+		got, err := b.RunTests()
+		if err != nil {
+			t.Fatalf("%d): %s", i, err)
+		}
+		want := []backend.TestResult{{Name: "Right to phonecall", Passed: true}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%d): got %v want %v", i, got, want)
+		}
+		holds, err := b.Query(query.Facts, query.Body[0])
+		if err != nil {
+			t.Fatalf("%d): %s", i, err)
+		}
+		if holds {
+			t.Errorf("%d): query holds for a minor", i)
+		}
+	}
+}
+
+func benchmarkBackend(b *testing.B, engine backend.Backend) {
+	ir := Read(fmt.Sprintf(exportRulebase, `startsWith(lower(p.name), "j")`))
+	for i := 0; i < b.N; i++ {
+		if err := engine.Load(ir); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := engine.RunTests(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGolog(b *testing.B) {
+	benchmarkBackend(b, NewGolog())
+}
+
+func BenchmarkSWI(b *testing.B) {
+	benchmarkBackend(b, newSWI(b))
+}
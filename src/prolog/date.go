@@ -0,0 +1,96 @@
+package prolog
+
+import (
+	"fmt"
+	"time"
+
+	. "model"
+)
+
+// dates are date(Y,M,D) terms, so standard order of terms
+// compares them chronologically. durations are duration(Y,M,D).
+// date_add mirrors Duration.AddTo: add months, clamp the day
+// to the end of the month, then add days via a day count.
+const datePrelude = `
+date_add(date(Y,M,D), duration(DY,DM,DD), Date) :-
+	Months is (Y + DY) * 12 + M - 1 + DM,
+	Y1 is Months // 12,
+	M1 is Months mod 12 + 1,
+	days_in_month(Y1, M1, Max),
+	D1 is min(D, Max),
+	date_to_days(date(Y1,M1,D1), N),
+	N1 is N + DD,
+	days_to_date(N1, Date).
+
+date_sub(Date, duration(DY,DM,DD), Result) :-
+	NY is -DY, NM is -DM, ND is -DD,
+	date_add(Date, duration(NY,NM,ND), Result).
+
+days_in_month(Y, 2, 29) :- leap_year(Y), !.
+days_in_month(_, 2, 28) :- !.
+days_in_month(_, 4, 30) :- !.
+days_in_month(_, 6, 30) :- !.
+days_in_month(_, 9, 30) :- !.
+days_in_month(_, 11, 30) :- !.
+days_in_month(_, _, 31).
+
+leap_year(Y) :- 0 =:= Y mod 400, !.
+leap_year(Y) :- 0 =:= Y mod 4, 0 =\= Y mod 100.
+
+date_to_days(date(Y,M,D), N) :-
+	( M =< 2 -> Y0 is Y - 1, M0 is M + 9 ; Y0 is Y, M0 is M - 3 ),
+	Era is Y0 // 400,
+	Yoe is Y0 - Era * 400,
+	Doy is (153 * M0 + 2) // 5 + D - 1,
+	Doe is Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N is Era * 146097 + Doe - 719468.
+
+days_to_date(N, date(Y,M,D)) :-
+	Z is N + 719468,
+	Era is Z // 146097,
+	Doe is Z - Era * 146097,
+	Yoe is (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy is Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp is (5 * Doy + 2) // 153,
+	D is Doy - (153 * Mp + 2) // 5 + 1,
+	( Mp < 10 -> M is Mp + 3 ; M is Mp - 9 ),
+	( M =< 2 -> Y is Yoe + Era * 400 + 1 ; Y is Yoe + Era * 400 ).
+`
+
+func printToday(t time.Time) string {
+	return fmt.Sprintf("today(%s).", printDate(t))
+}
+
+func printDate(t time.Time) string {
+	return fmt.Sprintf("date(%d,%d,%d)", t.Year(), t.Month(), t.Day())
+}
+
+func printDuration(d Duration) string {
+	return fmt.Sprintf("duration(%d,%d,%d)", d.Years, d.Months, d.Days)
+}
+
+// date arithmetic: date + duration, duration + date and
+// date - duration. Returns false if e is not date arithmetic.
+func printDateArithmetic(g *generator, e Expression) (string, []string, bool) {
+	if e.Functor != "+" && e.Functor != "-" {
+		return "", nil, false
+	}
+	left, right := e.Args[0], e.Args[1]
+	if g.typeOf(left) != DATE {
+		left, right = right, left
+		if e.Functor == "-" || g.typeOf(left) != DATE {
+			return "", nil, false
+		}
+	}
+	predicate := "date_add"
+	if e.Functor == "-" {
+		predicate = "date_sub"
+	}
+	date, sideEffects := printNodeRecursive(g, left)
+	duration, vs := printNodeRecursive(g, right)
+	varName := g.newVarName()
+	sideEffects = append(sideEffects, vs...)
+	sideEffects = append(sideEffects,
+		fmt.Sprintf("%s(%s, %s, %s)", predicate, date, duration, varName))
+	return varName, sideEffects, true
+}
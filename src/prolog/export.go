@@ -0,0 +1,117 @@
+package prolog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	. "model"
+)
+
+// the golog test driver uses printf, which is not ISO. A test
+// expected to fail passes if it does not hold.
+const isoTestDriver = `
+run_rulebase_tests :-
+	test_cases(List),
+	run_test_cases(List, Status),
+	write('Test '), write(Status), nl.
+
+run_test_cases([], success).
+run_test_cases([H|T], Status) :-
+	passes(H), !,
+	run_test_cases(T, Status).
+run_test_cases([H|T], failure) :-
+	\+(passes(H)), !,
+	write('Test case failed: '), write(H), nl,
+	run_test_cases(T, _).
+
+passes(H) :- expected_failure(H), !, \+(test(H)).
+passes(H) :- test(H).
+`
+
+// regular expressions are a Go foreign predicate in golog,
+// SWI-Prolog has them in library(pcre)
+const pcreMatches = `
+:- use_module(library(pcre)).
+matches(S, Re) :- re_match(Re, S).
+`
+
+// Export writes the rulebase as a self-contained Prolog module.
+// Load it in SWI-Prolog and either call run_rulebase_tests/0,
+// or run_tests/0 to run the same tests through plunit.
+func Export(ir InternalRepresentation, module string, w io.Writer) error {
+	_, err := io.WriteString(w, export(ir, module))
+	return err
+}
+
+func export(ir InternalRepresentation, module string) string {
+	return printExport(newGenerator(ir), module)
+}
+
+func printExport(g *generator, module string) string {
+	ir := g.ir
+	program := printProgram(g)
+
+	exports := []string{"run_rulebase_tests/0"}
+	for _, name := range ir.RuleNames() {
+		exports = append(exports, fmt.Sprintf("%s/%d", printPredicate(name), len(ir.Rules[name].Args)))
+	}
+
+	s := fmt.Sprintf("%% generated from a rulebase\n:- module(%s, [%s]).\n",
+		printAtom(module), strings.Join(exports, ", "))
+	s += ":- use_module(library(plunit)).\n"
+	s += ":- dynamic(today/1).\n"
+	s += ":- dynamic(expected_failure/1).\n"
+	if usesMatches(ir) {
+		s += pcreMatches
+	}
+	s += datePrelude + stringPrelude + "\n"
+	s += printToday(Today()) + "\n\n"
+	s += strings.Join(program, "\n\n") + "\n"
+	for _, t := range ir.Tests {
+		if t.ExpectFail() {
+			s += fmt.Sprintf("expected_failure(%s).\n", printQuoted(t.Name))
+		}
+	}
+	s += isoTestDriver + "\n"
+
+	s += fmt.Sprintf(":- begin_tests(%s).\n", printAtom(module))
+	for _, t := range ir.Tests {
+		options := ""
+		if t.ExpectFail() {
+			options = ", [fail]"
+		}
+		s += fmt.Sprintf("test(%s%s) :- %s:test(%s).\n", printQuoted(t.Name), options, printAtom(module), printQuoted(t.Name))
+	}
+	s += fmt.Sprintf(":- end_tests(%s).\n", printAtom(module))
+	return s
+}
+
+func usesMatches(ir InternalRepresentation) bool {
+	var uses func(n Node) bool
+	uses = func(n Node) bool {
+		e, ok := n.(Expression)
+		if !ok {
+			return false
+		}
+		if e.Functor == "matches" {
+			return true
+		}
+		for _, a := range e.Args {
+			if uses(a) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range ir.Rules {
+		for _, body := range r.Clauses() {
+			for _, e := range body {
+				if uses(e) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package prolog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "model"
+)
+
+const exportRulebase = `
+	object prisoner {
+		age      : int,
+		name     : string,
+		admitted : date
+	}
+	relation cellmates {
+		p : prisoner,
+		cellmate : prisoner
+	}
+	rule hasRightToPhonecall {
+		input {
+			p : prisoner
+		}
+		rules {
+			p.age >= 18,
+			p.admitted + 6 months <= today,
+			%s
+		}
+	}
+	rule hasAdultCellmate {
+		input {
+			p : prisoner
+		}
+		rules {
+			let c : prisoner,
+			cellmates(p, c),
+			hasRightToPhonecall(c)
+		}
+	}
+	test "Right to phonecall" {
+		facts {
+			p1 : prisoner {
+				age: 23,
+				name: john,
+				admitted: 2017-01-01
+			},
+			p2 : prisoner {
+				age: 15,
+				name: henry
+			},
+			cellmates(p2, p1)
+		}
+		rules {
+			hasRightToPhonecall(p1),
+			hasAdultCellmate(p2)
+		}
+	}`
+
+func TestExport(t *testing.T) {
+	defer func(today func() time.Time) { Today = today }(Today)
+	Today = func() time.Time { return time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	ir := Read(fmt.Sprintf(exportRulebase, `startsWith(lower(p.name), "j")`))
+	got := export(ir, "prison")
+	for i, want := range []string{
+		":- module(prison, [run_rulebase_tests/0, hasRightToPhonecall/1, hasAdultCellmate/1]).",
+		":- dynamic(cellmates/2).",
+		"today(date(2018,3,1)).",
+		"test_cases(['Right to phonecall']).",
+		`:- begin_tests(prison).
+test('Right to phonecall') :- prison:test('Right to phonecall').
+:- end_tests(prison).`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%d): %s\nnot found in\n%s", i, want, got)
+		}
+	}
+}
+
+func TestCheckISO(t *testing.T) {
+	for i, tt := range []struct {
+		condition string
+		want      []string
+	}{
+		{
+			condition: `startsWith(lower(p.name), "j")`,
+		},
+		{
+			condition: `matches(p.name, "^j")`,
+			want:      []string{"re_match/2 is neither defined nor an ISO builtin"},
+		},
+	} {
+		ir := Read(fmt.Sprintf(exportRulebase, tt.condition))
+		got := CheckISO(export(ir, "prison"))
+		if len(got) != len(tt.want) {
+			t.Errorf("%d): got %v want %v", i, got, tt.want)
+			continue
+		}
+		for j, err := range got {
+			if err.Error() != tt.want[j] {
+				t.Errorf("%d): got %q want %q", i, err, tt.want[j])
+			}
+		}
+	}
+}
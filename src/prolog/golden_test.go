@@ -0,0 +1,61 @@
+package prolog
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "model"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// the Prolog exported for each rulebase in the corpus is compared
+// against testdata/golden/<name>.pl, run with -update after an
+// intended change to the generated code
+func TestGolden(t *testing.T) {
+	defer func(today func() time.Time) { Today = today }(Today)
+	Today = func() time.Time { return time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC) }
+
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".rules")
+		ir, err := Load("../testdata", filepath.Base(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := export(ir, name)
+		// generation may not depend on map iteration order
+		for i := 0; i < 5; i++ {
+			ir, _ := Load("../testdata", filepath.Base(file))
+			if again := export(ir, name); again != got {
+				t.Fatalf("%s: export differs between runs", name)
+			}
+		}
+
+		if _, err := readClauses(got); err != nil {
+			t.Errorf("%s: generated Prolog does not read back: %s", name, err)
+		}
+
+		golden := filepath.Join("../testdata/golden", name+".pl")
+		if *update {
+			if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != string(want) {
+			t.Errorf("%s: generated Prolog differs from %s, run go test -update if intended", name, golden)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package prolog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtin predicates of ISO/IEC 13211-1 and its corrigenda
+var isoBuiltins = map[string]bool{}
+
+func init() {
+	for _, p := range strings.Fields(`
+		true/0 fail/0 false/0 !/0 ,/2 ;/2 ->/2 call/1 call/2 call/3 call/4
+		\+/1 once/1 catch/3 throw/1
+		=/2 \=/2 unify_with_occurs_check/2 subsumes_term/2
+		var/1 atom/1 integer/1 float/1 atomic/1 compound/1 nonvar/1
+		number/1 callable/1 ground/1
+		@=</2 ==/2 \==/2 @</2 @>/2 @>=/2 compare/3
+		functor/3 arg/3 =../2 copy_term/2 term_variables/2
+		is/2 =:=/2 =\=/2 </2 =</2 >/2 >=/2
+		clause/2 current_predicate/1 asserta/1 assertz/1 retract/1
+		abolish/1 retractall/1
+		findall/3 bagof/3 setof/3
+		atom_length/2 atom_concat/3 sub_atom/5 atom_chars/2 atom_codes/2
+		char_code/2 number_chars/2 number_codes/2
+		write/1 writeq/1 write_canonical/1 write_term/2 nl/0
+		halt/0 halt/1 keysort/2 sort/2
+	`) {
+		isoBuiltins[p] = true
+	}
+}
+
+// directives we emit: the ISO ones, plus SWI-Prolog's
+// module system and plunit which have no ISO counterpart
+var allowedDirectives = map[string]bool{
+	"dynamic/1": true, "discontiguous/1": true, "initialization/1": true,
+	"module/2": true, "use_module/1": true,
+	"begin_tests/1": true, "end_tests/1": true,
+}
+
+// CheckISO reports calls in a Prolog text to predicates that are
+// neither defined in the text itself nor ISO builtins.
+func CheckISO(text string) []error {
+	clauses, err := readClauses(text)
+	if err != nil {
+		return []error{err}
+	}
+	defined := map[string]bool{}
+	called := map[string]bool{}
+	errs := []error{}
+	for _, c := range clauses {
+		switch {
+		case c.kind == compoundTerm && c.name == ":-" && len(c.args) == 1:
+			d := c.args[0]
+			if !allowedDirectives[d.indicator()] {
+				errs = append(errs, fmt.Errorf("directive %s is not ISO", d.indicator()))
+			}
+			for _, a := range d.args {
+				if d.name == "dynamic" && a.name == "/" {
+					defined[a.args[0].name+"/"+a.args[1].name] = true
+				}
+			}
+		case c.kind == compoundTerm && c.name == ":-" && len(c.args) == 2:
+			defined[c.args[0].indicator()] = true
+			collectGoals(c.args[1], called)
+		default:
+			defined[c.indicator()] = true
+		}
+	}
+
+	missing := []string{}
+	for p := range called {
+		if !defined[p] && !isoBuiltins[p] {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	for _, p := range missing {
+		errs = append(errs, fmt.Errorf("%s is neither defined nor an ISO builtin", p))
+	}
+	return errs
+}
+
+// collectGoals walks control constructs down to the goals called
+func collectGoals(t pterm, called map[string]bool) {
+	if t.kind == varTerm {
+		return
+	}
+	if t.indicator() == ":/2" {
+		// module qualified goal
+		collectGoals(t.args[1], called)
+		return
+	}
+	called[t.indicator()] = true
+	switch t.indicator() {
+	case ",/2", ";/2", "->/2":
+		collectGoals(t.args[0], called)
+		collectGoals(t.args[1], called)
+	case "\\+/1", "call/1", "once/1":
+		collectGoals(t.args[0], called)
+	case "findall/3":
+		collectGoals(t.args[1], called)
+	}
+}
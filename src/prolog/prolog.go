@@ -3,6 +3,7 @@ package prolog
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	. "model"
 
@@ -10,9 +11,15 @@ import (
 )
 
 type generator struct {
-	n         int
-	ir        InternalRepresentation
-	objectMap map[string]string
+	n  int
+	ir InternalRepresentation
+
+	// identifiers in scope of the rule being printed
+	scope map[string]Term
+}
+
+func (g *generator) typeOf(n Node) Token {
+	return g.ir.TypeOf(n, g.scope)
 }
 
 func (g *generator) nextInt() int {
@@ -24,12 +31,6 @@ func (g *generator) newVarName() string {
 	return fmt.Sprintf("V_%d", g.nextInt())
 }
 
-func (g *generator) objectMapping(external string) string {
-	prologName := fmt.Sprintf("o_%d", g.nextInt())
-	g.objectMap[external] = prologName
-	return prologName
-}
-
 func printObject(g *generator, o Object) string {
 	s := ""
 	arity := len(o.Fields)
@@ -48,32 +49,53 @@ func printField(g *generator, f Field, objectName string, n, arity int) string {
 		}
 		underscores[i] = "_"
 	}
-	return fmt.Sprintf("%s_%s(A, B) :- B = %s(%s).\n",
-		objectName, f.Name, objectName, strings.Join(underscores, ","))
+	return fmt.Sprintf("%s(A, B) :- B = %s(%s).\n",
+		Accessor(objectName, f.Name), printAtom(objectName), strings.Join(underscores, ","))
 }
 
+// a rule with alternative bodies is printed as one clause per body
 func printRule(g *generator, r Rule) string {
 	args := ""
 	if len(r.Args) != 0 {
 		a := make([]string, len(r.Args))
 		for i, v := range r.Args {
-			a[i] = printTerm(v)
+			// inputs are variables, whatever their type
+			a[i] = Variable(v.Value.(string))
 		}
 		args = "(" + strings.Join(a, ",") + ")"
 	}
-	head := fmt.Sprintf("%s%s", strings.Replace(r.Name, " ", "_", -1), args)
+	head := fmt.Sprintf("%s%s", printPredicate(r.Name), args)
 
-	if len(r.Body) == 0 {
+	clauses := []string{}
+	for _, b := range r.Clauses() {
+		g.scope = r.Scope()
+		clauses = append(clauses, printClause(g, head, b))
+	}
+	return strings.Join(clauses, "\n")
+}
+
+func printClause(g *generator, head string, b []Expression) string {
+	if len(b) == 0 {
 		return head + "."
 	}
-	body := make([]string, len(r.Body))
-	for i, v := range r.Body {
-		body[i] = printNode(g, v)
+	body := []string{}
+	for _, v := range b {
+		if v.Functor == "let" && len(v.Args) == 1 {
+			// declaration of an unbound variable
+			g.scope[v.Args[0].(Term).Value.(string)] = v.Args[0].(Term)
+			continue
+		}
+		body = append(body, printNode(g, v))
 	}
 	return fmt.Sprintf("%s :- \n\t%s.",
 		head, strings.Join(body, ",\n\t"))
 }
 
+// relations are facts asserted by tests
+func printRelation(g *generator, r Relation) string {
+	return fmt.Sprintf(":- dynamic(%s/%d).", printPredicate(r.Name), len(r.Fields))
+}
+
 func printNode(g *generator, n Node) string {
 	switch v := n.(type) {
 	case Term:
@@ -107,6 +129,24 @@ func printExpression(g *generator, e Expression) (string, []string) {
 		return printNew(g, e.Args), nil
 	case ".":
 		return printFieldAccessor(g, e.Args)
+	case "let":
+		return printLet(g, e.Args)
+	case "today":
+		varName := g.newVarName()
+		return varName, []string{fmt.Sprintf("today(%s)", varName)}
+	case "+", "-":
+		if s, sideEffects, ok := printDateArithmetic(g, e); ok {
+			return s, sideEffects
+		}
+		if e.Functor == "+" && g.typeOf(e) == STRING {
+			e.Functor = "concat"
+			return printBuiltin(g, e)
+		}
+		return printArithmetic(g, e)
+	case "*", "/", "%":
+		return printArithmetic(g, e)
+	case "startsWith", "contains", "length", "lower", "concat":
+		return printBuiltin(g, e)
 	case ">=":
 		e.Functor = "@>="
 	case ">":
@@ -115,6 +155,8 @@ func printExpression(g *generator, e Expression) (string, []string) {
 		e.Functor = "@=<"
 	case "<":
 		e.Functor = "@<"
+	case "!=":
+		e.Functor = "\\=="
 	}
 
 	sideEffects := []string{}
@@ -124,7 +166,18 @@ func printExpression(g *generator, e Expression) (string, []string) {
 		args[i] = ve
 		sideEffects = append(sideEffects, vs...)
 	}
-	return fmt.Sprintf("%s(%s)", e.Functor, strings.Join(args, ",")), sideEffects
+	functor := e.Functor
+	if g.isPredicate(functor) {
+		functor = printPredicate(functor)
+	}
+	return fmt.Sprintf("%s(%s)", functor, strings.Join(args, ",")), sideEffects
+}
+
+// isPredicate reports whether functor is a rule or relation
+func (g *generator) isPredicate(functor string) bool {
+	_, isRule := g.ir.Rules[functor]
+	_, isRelation := g.ir.Relations[functor]
+	return isRule || isRelation
 }
 
 // TODO: do something with typeinfo on terms
@@ -135,23 +188,52 @@ func printTerm(t Term) string {
 func printValueWithType(v interface{}, ti Token) string {
 	switch ti {
 	case IDENT, OBJECT:
-		return strings.Title(v.(string))
+		return Variable(v.(string))
 	case STRING:
-		return fmt.Sprintf("'%s'", v.(string))
+		return printQuoted(v.(string))
+	case DATE:
+		return printDate(v.(time.Time))
+	case DURATION:
+		return printDuration(v.(Duration))
 	}
 	return fmt.Sprintf("%v", v)
 }
 
+// relations asserted in a test are retracted at the start
+// of each test, so tests don't see each other's facts
 func printTest(g *generator, t Test) string {
-	header := fmt.Sprintf("test('%s')", t.Name)
-	body := make([]string, len(t.Body)+len(t.Facts))
-	for i, v := range t.Facts {
-		body[i] = printNode(g, v)
+	header := fmt.Sprintf("test(%s)", printQuoted(t.Name))
+	body := printTestBody(g, t.Facts, t.Body)
+	return fmt.Sprintf("%s :- %s.", header, strings.Join(body, ","))
+}
+
+func printTestBody(g *generator, facts, goals []Expression) []string {
+	body := []string{}
+	for _, name := range g.ir.RelationNames() {
+		r := g.ir.Relations[name]
+		underscores := make([]string, len(r.Fields))
+		for i := range underscores {
+			underscores[i] = "_"
+		}
+		body = append(body, fmt.Sprintf("retractall(%s(%s))", printPredicate(name), strings.Join(underscores, ",")))
+	}
+	for _, v := range facts {
+		if _, ok := g.ir.Relations[v.Functor]; ok {
+			body = append(body, fmt.Sprintf("assertz(%s)", printNode(g, v)))
+			continue
+		}
+		body = append(body, printNode(g, v))
 	}
-	for i, v := range t.Body {
-		body[i+len(t.Facts)] = printNode(g, v)
+	for _, v := range goals {
+		body = append(body, printNode(g, v))
 	}
-	return fmt.Sprintf("%s :- %s.", header, strings.Join(body, ","))
+	return body
+}
+
+// queries are printed like tests, as a clause for rulebase_query/0
+func printQuery(g *generator, facts []Expression, goal Expression) string {
+	body := printTestBody(g, facts, []Expression{goal})
+	return fmt.Sprintf("rulebase_query :- %s.", strings.Join(body, ","))
 }
 
 // builtin functions:
@@ -159,8 +241,8 @@ func printTest(g *generator, t Test) string {
 // Varname = class(args)
 func printNew(g *generator, args []Node) string {
 	objectTerm := args[0].(Term)
-	objectName := g.objectMap[objectTerm.ObjectName()]
-	varName := strings.Title(objectTerm.Value.(string))
+	objectName := printAtom(objectTerm.ObjectName())
+	varName := Variable(objectTerm.Value.(string))
 	object := g.ir.Objects[objectTerm.ObjectName()]
 	fields := args[1:]
 
@@ -179,7 +261,41 @@ func printNew(g *generator, args []Node) string {
 	return fmt.Sprintf("%s = %s(%s)", varName, objectName, strings.Join(a, ","))
 }
 
-// .(Soldier, age) --> {"NewlyIntroducedVarname", o_x_age(NewlyIntroducedVarname, Soldier)}
+// let(name, value) --> Name = Value, name is in scope
+// for the rest of the rule body
+func printLet(g *generator, args []Node) (string, []string) {
+	name := args[0].(Term)
+	varName := printTerm(name)
+	value, sideEffects := printNodeRecursive(g, args[1])
+	if t := g.typeOf(args[1]); t != ILLEGAL {
+		name.TypeInfo = t
+	}
+	if v, ok := args[1].(Term); ok && v.TypeInfo == OBJECT {
+		name = ObjectTerm(name.Value.(string), v.ObjectName())
+	}
+	g.scope[name.Value.(string)] = name
+	return fmt.Sprintf("%s = %s", varName, value), sideEffects
+}
+
+// numbers are evaluated with is/2 as a side effect, integer
+// division truncates and % takes the sign of the divisor
+func printArithmetic(g *generator, e Expression) (string, []string) {
+	left, sideEffects := printNodeRecursive(g, e.Args[0])
+	right, rs := printNodeRecursive(g, e.Args[1])
+	sideEffects = append(sideEffects, rs...)
+	op := e.Functor
+	switch {
+	case op == "/" && g.typeOf(e) == INT:
+		op = "//"
+	case op == "%":
+		op = "mod"
+	}
+	varName := g.newVarName()
+	sideEffects = append(sideEffects, fmt.Sprintf("%s is %s %s %s", varName, left, op, right))
+	return varName, sideEffects
+}
+
+// .(Soldier, age) --> {"NewlyIntroducedVarname", 'soldier#age'(NewlyIntroducedVarname, Soldier)}
 func printFieldAccessor(g *generator, args []Node) (string, []string) {
 	object := args[0].(Term)
 	fieldName := args[1].(Term).Value.(string)
@@ -187,39 +303,49 @@ func printFieldAccessor(g *generator, args []Node) (string, []string) {
 
 	// TODO: recursive access ? (soldier.job.length)
 	sideEffects := []string{}
-	objectName := g.objectMap[object.ObjectName()]
-	fieldAccess := fmt.Sprintf("%s_%s(%s, %s)",
-		objectName, fieldName, varName, printTerm(object))
+	fieldAccess := fmt.Sprintf("%s(%s, %s)",
+		Accessor(object.ObjectName(), fieldName), varName, printTerm(object))
 	sideEffects = append(sideEffects, fieldAccess)
 	return varName, sideEffects
 }
 
-func Generate(ir InternalRepresentation) golog.Machine {
-	g := &generator{
-		ir:        ir,
-		objectMap: map[string]string{},
+// printProgram returns the clauses generated from the rulebase
+// in the order to consult them, without preludes or test driver
+func printProgram(g *generator) []string {
+	program := []string{}
+	for _, name := range g.ir.ObjectNames() {
+		program = append(program, printObject(g, g.ir.Objects[name]))
 	}
-	m := golog.NewMachine()
-	for _, o := range ir.Objects {
-		o.Name = g.objectMapping(o.Name)
-		prologString := printObject(g, o)
-		fmt.Println(prologString)
-		m = m.Consult(prologString)
+	for _, name := range g.ir.RelationNames() {
+		program = append(program, printRelation(g, g.ir.Relations[name]))
 	}
-	for _, r := range ir.Rules {
-		prologString := printRule(g, r)
-		fmt.Println(prologString)
-		m = m.Consult(prologString)
+	for _, name := range g.ir.RuleNames() {
+		program = append(program, printRule(g, g.ir.Rules[name]))
 	}
 	tests := []string{}
-	for _, t := range ir.Tests {
-		tests = append(tests, fmt.Sprintf("'%s'", t.Name))
-		prologString := printTest(g, t)
+	for _, t := range g.ir.Tests {
+		tests = append(tests, printQuoted(t.Name))
+		program = append(program, printTest(g, t))
+	}
+	testCases := fmt.Sprintf("test_cases([%s]).", strings.Join(tests, ","))
+	return append(program, testCases)
+}
+
+func newGenerator(ir InternalRepresentation) *generator {
+	return &generator{ir: ir}
+}
+
+func Generate(ir InternalRepresentation) golog.Machine {
+	g := newGenerator(ir)
+	m := golog.NewMachine()
+	m = m.RegisterForeign(foreignPredicates)
+	m = m.Consult(datePrelude)
+	m = m.Consult(stringPrelude)
+	m = m.Consult(printToday(Today()))
+	for _, prologString := range printProgram(g) {
 		fmt.Println(prologString)
 		m = m.Consult(prologString)
 	}
-	testCases := fmt.Sprintf("test_cases([%s]).", strings.Join(tests, ","))
-	m = m.Consult(testCases)
 	return m
 }
 
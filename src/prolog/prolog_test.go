@@ -5,14 +5,12 @@ import (
 	"testing"
 
 	. "model"
+
+	"github.com/mndrix/golog/term"
 )
 
 func TestPrintObject(t *testing.T) {
-	g := &generator{
-		objectMap: map[string]string{
-			"prisoner": "o_1",
-		},
-	}
+	g := &generator{}
 
 	for i, tt := range []struct {
 		object Object
@@ -20,11 +18,11 @@ func TestPrintObject(t *testing.T) {
 	}{
 		{
 			object: NewObject("prisoner", []Field{
-				{"age", INT},
-				{"name", STRING},
+				{Name: "age", TypeInfo: INT},
+				{Name: "name", TypeInfo: STRING},
 			}),
-			want: `prisoner_age(A, B) :- B = prisoner(A,_).
-					prisoner_name(A, B) :- B = prisoner(_,A).`,
+			want: `'prisoner#age'(A, B) :- B = prisoner(A,_).
+					'prisoner#name'(A, B) :- B = prisoner(_,A).`,
 		},
 	} {
 		got := printObject(g, tt.object)
@@ -34,9 +32,6 @@ func TestPrintObject(t *testing.T) {
 
 func TestPrintRule(t *testing.T) {
 	g := &generator{
-		objectMap: map[string]string{
-			"prisoner": "o_1",
-		},
 		n: 1,
 	}
 
@@ -63,7 +58,7 @@ func TestPrintRule(t *testing.T) {
 				},
 			},
 			want: `hasRightToPhonecall(PrisonerVarName) :- 
-					o_1_age(V_2, PrisonerVarName),
+					'prisoner#age'(V_2, PrisonerVarName),
 					@>=(V_2,18).`,
 		},
 	} {
@@ -72,16 +67,261 @@ func TestPrintRule(t *testing.T) {
 	}
 }
 
-func TestPrintTest(t *testing.T) {
+func TestPrintDateArithmetic(t *testing.T) {
 	g := &generator{
-		objectMap: map[string]string{
-			"prisoner": "o_1",
+		ir: InternalRepresentation{
+			Objects: map[string]Object{
+				"prisoner": NewObject("prisoner", []Field{
+					{Name: "admitted", TypeInfo: DATE},
+				}),
+			},
 		},
+	}
+
+	for i, tt := range []struct {
+		rule Rule
+		want string
+	}{
+		{
+			rule: Rule{
+				Name: "eligible",
+				Args: []Term{ObjectTerm("p", "prisoner")},
+				Body: []Expression{
+					{Functor: "<=",
+						Args: []Node{
+							Expression{Functor: "+",
+								Args: []Node{
+									Expression{Functor: ".",
+										Args: []Node{
+											ObjectTerm("p", "prisoner"),
+											IdentifierTerm("admitted"),
+										},
+									},
+									DurationTerm(Duration{Months: 6}),
+								},
+							},
+							Expression{Functor: "today", Args: []Node{}},
+						},
+					},
+				},
+			},
+			want: `eligible(P) :- 
+					'prisoner#admitted'(V_1, P),
+					date_add(V_1, duration(0,6,0), V_2),
+					today(V_3),
+					@=<(V_2,V_3).`,
+		},
+	} {
+		got := printRule(g, tt.rule)
+		helperFunc(t, i, got, tt.want)
+	}
+}
+
+func TestPrintStringBuiltins(t *testing.T) {
+	g := &generator{
 		ir: InternalRepresentation{
 			Objects: map[string]Object{
 				"prisoner": NewObject("prisoner", []Field{
-					{"age", INT},
-					{"name", STRING},
+					{Name: "name", TypeInfo: STRING},
+				}),
+			},
+		},
+	}
+	name := Expression{Functor: ".",
+		Args: []Node{
+			ObjectTerm("p", "prisoner"),
+			IdentifierTerm("name"),
+		},
+	}
+
+	for i, tt := range []struct {
+		rule Rule
+		want string
+	}{
+		{
+			rule: Rule{
+				Name: "shortName",
+				Args: []Term{ObjectTerm("p", "prisoner")},
+				Body: []Expression{
+					{Functor: "startsWith",
+						Args: []Node{
+							Expression{Functor: "lower", Args: []Node{name}},
+							StringTerm("jo"),
+						},
+					},
+					{Functor: "<",
+						Args: []Node{
+							Expression{Functor: "length",
+								Args: []Node{
+									Expression{Functor: "+",
+										Args: []Node{name, StringTerm("!")},
+									},
+								},
+							},
+							IntTerm(6),
+						},
+					},
+				},
+			},
+			want: `shortName(P) :- 
+					'prisoner#name'(V_1, P),
+					lower_atom(V_1, V_2),
+					sub_atom(V_2, 0, _, _, 'jo'),
+					'prisoner#name'(V_3, P),
+					atom_concat(V_3, '!', V_4),
+					atom_length(V_4, V_5),
+					@<(V_5,6).`,
+		},
+		{
+			rule: Rule{
+				Name: "shortName",
+				Args: []Term{ObjectTerm("p", "prisoner")},
+				Body: []Expression{
+					{Functor: "let",
+						Args: []Node{IdentifierTerm("n"), name},
+					},
+					{Functor: "startsWith",
+						Args: []Node{
+							IdentifierTerm("n"),
+							StringTerm("jo"),
+						},
+					},
+				},
+			},
+			want: `shortName(P) :- 
+					'prisoner#name'(V_6, P),
+					N = V_6,
+					sub_atom(N, 0, _, _, 'jo').`,
+		},
+	} {
+		got := printRule(g, tt.rule)
+		helperFunc(t, i, got, tt.want)
+	}
+}
+
+func TestAtomText(t *testing.T) {
+	for i, s := range []string{"john", `^\d+$`, "o'brien", `a\b`} {
+		if got := atomText(term.NewAtom(s)); got != s {
+			t.Errorf("%d): got %q want %q", i, got, s)
+		}
+	}
+}
+
+func TestPrintArithmetic(t *testing.T) {
+	ir := Read(`
+		object prisoner {
+			age  : int,
+			time : float
+		}
+		rule halfway {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.age / 2 + p.age % 7 >= 10,
+				p.time * 2 < 5.5
+			}
+		}`)
+	g := &generator{
+		ir: ir,
+	}
+
+	got := printRule(g, ir.Rules["halfway"])
+	helperFunc(t, 0, got, `halfway(P) :- 
+		'prisoner#age'(V_1, P),
+		V_2 is V_1 // 2,
+		'prisoner#age'(V_3, P),
+		V_4 is V_3 mod 7,
+		V_5 is V_2 + V_4,
+		@>=(V_5,10),
+		'prisoner#time'(V_6, P),
+		V_7 is V_6 * 2,
+		@<(V_7,5.5).`)
+}
+
+func TestPrintNotEqual(t *testing.T) {
+	ir := Read(`
+		object prisoner {
+			name : string
+		}
+		rule notJohn {
+			input {
+				p : prisoner
+			}
+			rules {
+				p.name != "john"
+			}
+		}`)
+	g := &generator{
+		ir: ir,
+	}
+
+	got := printRule(g, ir.Rules["notJohn"])
+	helperFunc(t, 0, got, `notJohn(P) :- 
+		'prisoner#name'(V_1, P),
+		\==(V_1,'john').`)
+}
+
+func TestPrintRecursion(t *testing.T) {
+	ir := Read(`
+		object person {
+			name : string
+		}
+		relation manages {
+			boss : person,
+			employee : person
+		}
+		rule reportsTo {
+			input {
+				e : person,
+				b : person
+			}
+			rules {
+				manages(b, e)
+			}
+			rules {
+				let x : person,
+				manages(x, e),
+				reportsTo(x, b)
+			}
+		}
+		test "indirect report" {
+			facts {
+				a : person { name : alice },
+				b : person { name : bob },
+				c : person { name : carol },
+				manages(a, b),
+				manages(b, c)
+			}
+			rules {
+				reportsTo(c, a)
+			}
+		}`)
+	g := &generator{
+		ir: ir,
+	}
+
+	got := printRule(g, ir.Rules["reportsTo"])
+	helperFunc(t, 0, got, `reportsTo(E,B) :- 
+		manages(B,E).
+		reportsTo(E,B) :- 
+		manages(X,E),
+		reportsTo(X,B).`)
+
+	got = printTest(g, ir.Tests[0])
+	helperFunc(t, 1, got, `test('indirect report') :- retractall(manages(_,_)),
+		A = person('alice'),B = person('bob'),C = person('carol'),
+		assertz(manages(A,B)),assertz(manages(B,C)),
+		reportsTo(C,A).`)
+}
+
+func TestPrintTest(t *testing.T) {
+	g := &generator{
+		ir: InternalRepresentation{
+			Objects: map[string]Object{
+				"prisoner": NewObject("prisoner", []Field{
+					{Name: "age", TypeInfo: INT},
+					{Name: "name", TypeInfo: STRING},
 				}),
 			},
 		},
@@ -112,7 +352,7 @@ func TestPrintTest(t *testing.T) {
 				},
 			},
 			want: `test('Prisoner has right to phonecall') :- 
-					PrisonerVarName = o_1(23,'john'),
+					PrisonerVarName = prisoner(23,'john'),
 					hasRightToPhonecall(PrisonerVarName).`,
 		},
 	} {
@@ -132,3 +372,50 @@ func helperFunc(t *testing.T, i int, got, want string) {
 		t.Errorf("%d): got %s want %s", i, got, want)
 	}
 }
+
+func TestPrintQualified(t *testing.T) {
+	ir := Read(`
+		package legal
+		object prisoner {
+			age : int
+		}
+		relation detained {
+			p : prisoner
+		}
+		rule hasRightToPhonecall {
+			input {
+				p : prisoner
+			}
+			rules {
+				detained(p),
+				p.age >= 18
+			}
+		}
+		test "adult" {
+			facts {
+				p : prisoner { age : 23 },
+				detained(p)
+			}
+			rules {
+				legal.hasRightToPhonecall(p)
+			}
+		}`)
+	g := &generator{
+		ir: ir,
+	}
+
+	got := printRule(g, ir.Rules["legal.hasRightToPhonecall"])
+	helperFunc(t, 0, got, `'legal.hasRightToPhonecall'(P) :- 
+		'legal.detained'(P),
+		'legal.prisoner#age'(V_1, P),
+		@>=(V_1,18).`)
+
+	got = printTest(g, ir.Tests[0])
+	helperFunc(t, 1, got, `test('adult') :- retractall('legal.detained'(_)),
+		P = 'legal.prisoner'(23),
+		assertz('legal.detained'(P)),
+		'legal.hasRightToPhonecall'(P).`)
+
+	got = printRelation(g, ir.Relations["legal.detained"])
+	helperFunc(t, 2, got, `:- dynamic('legal.detained'/1).`)
+}
@@ -0,0 +1,143 @@
+package prolog
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Everything taken from the DSL goes through these encoders:
+// strings and test names are quoted atoms, identifiers become
+// variables, and rules, relations and objects become atoms that
+// cannot clash with each other or with the predicates we rely on.
+// The datalog backend names its variables and field predicates
+// with Variable and Accessor too.
+
+// printAtom returns name as an atom, quoted if it is not a
+// plain atom: a lowercase letter followed by letters, digits or _
+func printAtom(name string) string {
+	if isPlainAtom(name) {
+		return name
+	}
+	return printQuoted(name)
+}
+
+func isPlainAtom(name string) bool {
+	if name == "" || !('a' <= name[0] && name[0] <= 'z') {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isAlphanumeric(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// printQuoted returns s as a quoted atom, escaping quotes,
+// backslashes and control characters
+func printQuoted(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch {
+		case r == '\'':
+			b.WriteString(`\'`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < ' ' || r == 0x7f || r == utf8.RuneError:
+			fmt.Fprintf(&b, `\x%x\`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// Variable returns the variable for a DSL identifier. Plain
+// identifiers are capitalised, p becomes P; others are mangled
+// behind an X_ prefix, so distinct identifiers stay distinct
+// variables. Identifiers starting with v_ or x_ are mangled as
+// well, keeping V_1 free for variables we introduce.
+func Variable(name string) string {
+	if isPlainAtom(name) && !strings.HasPrefix(name, "v_") && !strings.HasPrefix(name, "x_") {
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+	return "X_" + mangle(name)
+}
+
+// mangle keeps letters and digits, doubles _ and
+// writes anything else as _uHEX_
+func mangle(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < utf8.RuneSelf && isAlphanumeric(byte(r)) && r != '_':
+			b.WriteRune(r)
+		case r == '_':
+			b.WriteString("__")
+		default:
+			fmt.Fprintf(&b, "_u%x_", r)
+		}
+	}
+	return b.String()
+}
+
+// printPredicate returns the atom for a rule or relation. Names
+// of builtins and of predicates in the preludes get a dsl_ prefix,
+// as do names already starting with dsl_: a rule length becomes
+// dsl_length and a rule dsl_length becomes dsl_dsl_length.
+func printPredicate(name string) string {
+	if reserved[name] || strings.HasPrefix(name, "dsl_") {
+		name = "dsl_" + name
+	}
+	return printAtom(name)
+}
+
+// Accessor returns the predicate getting a field of an
+// object. DSL names cannot contain #, so prisoner#age does
+// not clash with any rule or relation.
+func Accessor(object, field string) string {
+	return printAtom(object + "#" + field)
+}
+
+// reserved holds the names of builtins and of the predicates
+// defined by the preludes and test drivers
+var reserved = map[string]bool{}
+
+// runs after the init in iso.go, which fills isoBuiltins
+func init() {
+	for p := range isoBuiltins {
+		reserved[p[:strings.LastIndex(p, "/")]] = true
+	}
+	for p := range foreignPredicates {
+		reserved[p[:strings.LastIndex(p, "/")]] = true
+	}
+	for _, name := range strings.Fields(`
+		today test test_cases run_tests run_test_cases run_rulebase_tests
+		rulebase_query printf format length between succ plus msort
+		forall ignore member memberchk append not assert re_match
+		atom_string atom_number string_concat sub_string
+	`) {
+		reserved[name] = true
+	}
+	clauses, err := readClauses(datePrelude + stringPrelude + isoTestDriver)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range clauses {
+		if c.name == ":-" && len(c.args) == 2 {
+			c = c.args[0]
+		}
+		reserved[c.name] = true
+	}
+}
@@ -0,0 +1,169 @@
+package prolog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	. "model"
+
+	"github.com/mndrix/golog"
+)
+
+func TestQuote(t *testing.T) {
+	for i, tt := range []struct {
+		got, want string
+	}{
+		{got: printAtom("prisoner"), want: "prisoner"},
+		{got: printAtom("legal.prisoner"), want: "'legal.prisoner'"},
+		{got: printAtom("Prisoner"), want: "'Prisoner'"},
+		{got: printAtom(""), want: "''"},
+		{got: printQuoted("o'brien"), want: `'o\'brien'`},
+		{got: printQuoted(`a\b`), want: `'a\\b'`},
+		{got: printQuoted("two\nlines\x01"), want: `'two\nlines\x1\'`},
+		{got: Variable("p"), want: "P"},
+		{got: Variable("P"), want: "X_P"},
+		{got: Variable("v_1"), want: "X_v__1"},
+		{got: Variable("é"), want: "X__ue9_"},
+		{got: printPredicate("hasBoss"), want: "hasBoss"},
+		{got: printPredicate("length"), want: "dsl_length"},
+		{got: printPredicate("today"), want: "dsl_today"},
+		{got: printPredicate("date_add"), want: "dsl_date_add"},
+		{got: printPredicate("dsl_length"), want: "dsl_dsl_length"},
+		{got: Accessor("prisoner", "age"), want: "'prisoner#age'"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%d): got %s want %s", i, tt.got, tt.want)
+		}
+	}
+}
+
+// readAtom reads back the atom printed as argument of a term
+func readAtom(t *testing.T, printed string) string {
+	clauses, err := readClauses("x(" + printed + ").")
+	if err != nil {
+		t.Fatalf("%s: %s", printed, err)
+	}
+	if len(clauses) != 1 || len(clauses[0].args) != 1 {
+		t.Fatalf("%s: read %v", printed, clauses)
+	}
+	return clauses[0].args[0].name
+}
+
+// readsAs reports whether golog reads the atom printed as argument
+// of a term as s, which is given to it as a list of codes
+func readsAs(t *testing.T, printed, s string) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s: %v", printed, r)
+			ok = false
+		}
+	}()
+	codes := []string{}
+	for _, r := range s {
+		codes = append(codes, strconv.Itoa(int(r)))
+	}
+	m := golog.NewMachine().Consult("x(" + printed + ").")
+	return m.CanProve(fmt.Sprintf("atom_codes(A, [%s]), x(A).", strings.Join(codes, ",")))
+}
+
+func FuzzPrintQuoted(f *testing.F) {
+	for _, s := range []string{"john", "o'brien", `a\b`, "two\nlines", "\x00\x7f", "'\\'", "'", `\`, "\x01"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !utf8.ValidString(s) {
+			return
+		}
+		if printed := printQuoted(s); !readsAs(t, printed, s) {
+			t.Errorf("golog does not read %s as %q", printed, s)
+		}
+		if printed := printAtom(s); !readsAs(t, printed, s) {
+			t.Errorf("golog does not read %s as %q", printed, s)
+		}
+	})
+}
+
+var fresh = regexp.MustCompile(`^V_[0-9]+$`)
+
+func FuzzPrintVariable(f *testing.F) {
+	f.Add("p", "P")
+	f.Add("v_1", "V_1")
+	f.Add("a_b", "a__b")
+	f.Add("x_P", "P")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if !utf8.ValidString(a) || !utf8.ValidString(b) {
+			return
+		}
+		va, vb := Variable(a), Variable(b)
+		if a != b && va == vb {
+			t.Errorf("%q and %q both print as %s", a, b, va)
+		}
+		if fresh.MatchString(va) {
+			t.Errorf("%q prints as fresh variable %s", a, va)
+		}
+		tokens, err := tokenize(va)
+		if err != nil || len(tokens) != 1 || tokens[0].kind != varTerm {
+			t.Errorf("%q prints as %s, which is not a variable", a, va)
+		}
+	})
+}
+
+func FuzzPrintPredicate(f *testing.F) {
+	f.Add("hasBoss", "length")
+	f.Add("length", "dsl_length")
+	f.Add("legal.x", "legal_x")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if !utf8.ValidString(a) || !utf8.ValidString(b) {
+			return
+		}
+		pa, pb := printPredicate(a), printPredicate(b)
+		if a != b && pa == pb {
+			t.Errorf("%q and %q both print as %s", a, b, pa)
+		}
+		name := readAtom(t, pa)
+		if reserved[name] || strings.Contains(name, "#") && !strings.Contains(a, "#") {
+			t.Errorf("%q prints as %s, which clashes", a, pa)
+		}
+	})
+}
+
+// strings and test names in the DSL end up in the generated
+// Prolog unchanged, whatever they hold
+func FuzzDSLString(f *testing.F) {
+	for _, s := range []string{"john", "o'brien", `C:\temp`, "it's a\ttab", "x). halt. %"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		// the DSL has no escapes in strings
+		if !utf8.ValidString(s) || strings.ContainsAny(s, "\"\x00") {
+			return
+		}
+		ir := Read(fmt.Sprintf(`
+			object person { name : string }
+			rule named { input { p : person } rules { p.name = "x" } }
+			test "%s" {
+				facts { p : person { name : "%s" } }
+				rules { named(p) }
+			}`, s, s))
+		g := newGenerator(ir)
+		clauses, err := readClauses(printTest(g, ir.Tests[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		head, body := clauses[0].args[0], clauses[0].args[1]
+		if got := head.args[0].name; got != s {
+			t.Errorf("test name: got %q want %q", got, s)
+		}
+		// P = person(Name), ...
+		for body.name == "," {
+			body = body.args[0]
+		}
+		if got := body.args[1].args[0].name; got != s {
+			t.Errorf("string: got %q want %q", got, s)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package prolog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A small reader for the Prolog text we generate ourselves,
+// enough to walk clause bodies: standard operators, quoted
+// atoms, lists and % comments. It is not a full ISO reader.
+
+type termKind int
+
+const (
+	atomTerm termKind = iota
+	varTerm
+	numberTerm
+	compoundTerm
+)
+
+type pterm struct {
+	kind termKind
+	name string
+	args []pterm
+}
+
+func (t pterm) indicator() string {
+	return fmt.Sprintf("%s/%d", t.name, len(t.args))
+}
+
+type opType int
+
+const (
+	xfx opType = iota
+	xfy
+	yfx
+	fy
+	fx
+)
+
+type op struct {
+	prec int
+	typ  opType
+}
+
+var infixOps = map[string]op{
+	":-": {1200, xfx}, "-->": {1200, xfx},
+	";": {1100, xfy}, "|": {1100, xfy},
+	"->": {1050, xfy},
+	",":  {1000, xfy},
+	"=":  {700, xfx}, "\\=": {700, xfx}, "==": {700, xfx}, "\\==": {700, xfx},
+	"@<": {700, xfx}, "@>": {700, xfx}, "@=<": {700, xfx}, "@>=": {700, xfx},
+	"=..": {700, xfx}, "is": {700, xfx}, "=:=": {700, xfx}, "=\\=": {700, xfx},
+	"<": {700, xfx}, ">": {700, xfx}, "=<": {700, xfx}, ">=": {700, xfx},
+	":": {200, xfy},
+	"+": {500, yfx}, "-": {500, yfx}, "/\\": {500, yfx}, "\\/": {500, yfx},
+	"*": {400, yfx}, "/": {400, yfx}, "//": {400, yfx}, "rem": {400, yfx},
+	"mod": {400, yfx}, "<<": {400, yfx}, ">>": {400, yfx},
+	"**": {200, xfx}, "^": {200, xfy},
+}
+
+var prefixOps = map[string]op{
+	":-": {1200, fx}, "?-": {1200, fx},
+	"\\+": {900, fy},
+	"-":   {200, fy}, "\\": {200, fy},
+}
+
+const symbolChars = "+-*/\\^<>=~:.?@#&$"
+
+type ptoken struct {
+	kind  termKind // atomTerm for atoms and punctuation
+	text  string
+	punct bool // ( ) [ ] { } , | and the end .
+	// an atom directly followed by ( is a functor
+	functor bool
+}
+
+type reader struct {
+	tokens []ptoken
+	pos    int
+}
+
+func tokenize(s string) ([]ptoken, error) {
+	tokens := []ptoken{}
+	r := []rune(s)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '%':
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '.' && (i+1 == len(r) || unicode.IsSpace(r[i+1]) || r[i+1] == '%'):
+			tokens = append(tokens, ptoken{text: ".", punct: true})
+			i++
+		case strings.ContainsRune("()[]{},|", c):
+			tokens = append(tokens, ptoken{text: string(c), punct: true})
+			i++
+		case c == '!' || c == ';':
+			tokens = append(tokens, ptoken{text: string(c)})
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(r) && unicode.IsDigit(r[j]) {
+				j++
+			}
+			if j+1 < len(r) && r[j] == '.' && unicode.IsDigit(r[j+1]) {
+				for j++; j < len(r) && unicode.IsDigit(r[j]); j++ {
+				}
+			}
+			tokens = append(tokens, ptoken{kind: numberTerm, text: string(r[i:j])})
+			i = j
+		case c == '_' || unicode.IsUpper(c):
+			j := i
+			for j < len(r) && (r[j] == '_' || unicode.IsLetter(r[j]) || unicode.IsDigit(r[j])) {
+				j++
+			}
+			tokens = append(tokens, ptoken{kind: varTerm, text: string(r[i:j])})
+			i = j
+		case unicode.IsLower(c):
+			j := i
+			for j < len(r) && (r[j] == '_' || unicode.IsLetter(r[j]) || unicode.IsDigit(r[j])) {
+				j++
+			}
+			tokens = append(tokens, ptoken{text: string(r[i:j]), functor: j < len(r) && r[j] == '('})
+			i = j
+		case c == '\'':
+			text, j, err := readQuoted(r, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, ptoken{text: text, functor: j < len(r) && r[j] == '('})
+			i = j
+		case strings.ContainsRune(symbolChars, c):
+			j := i
+			for j < len(r) && strings.ContainsRune(symbolChars, r[j]) {
+				j++
+			}
+			tokens = append(tokens, ptoken{text: string(r[i:j]), functor: j < len(r) && r[j] == '('})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+// readQuoted reads a quoted atom starting at r[i], returning
+// its text and the index after the closing quote
+func readQuoted(r []rune, i int) (string, int, error) {
+	text := []rune{}
+	for j := i + 1; j < len(r); j++ {
+		switch r[j] {
+		case '\'':
+			if j+1 < len(r) && r[j+1] == '\'' {
+				text = append(text, '\'')
+				j++
+				continue
+			}
+			return string(text), j + 1, nil
+		case '\\':
+			j++
+			if j == len(r) {
+				break
+			}
+			switch r[j] {
+			case 'n':
+				text = append(text, '\n')
+			case 't':
+				text = append(text, '\t')
+			case '\n':
+				// continuation
+			case 'x':
+				// \xHEX\
+				end := j + 1
+				for end < len(r) && r[end] != '\\' {
+					end++
+				}
+				code, err := strconv.ParseUint(string(r[j+1:end]), 16, 32)
+				if err != nil || end == len(r) {
+					return "", 0, fmt.Errorf("invalid escape in quoted atom")
+				}
+				text = append(text, rune(code))
+				j = end
+			default:
+				text = append(text, r[j])
+			}
+		default:
+			text = append(text, r[j])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted atom")
+}
+
+// readClauses reads all clauses in s
+func readClauses(s string) ([]pterm, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	rd := &reader{tokens: tokens}
+	clauses := []pterm{}
+	for rd.pos < len(rd.tokens) {
+		t, err := rd.parse(1200)
+		if err != nil {
+			return nil, err
+		}
+		if err := rd.expect("."); err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, t)
+	}
+	return clauses, nil
+}
+
+func (rd *reader) peek() (ptoken, bool) {
+	if rd.pos >= len(rd.tokens) {
+		return ptoken{}, false
+	}
+	return rd.tokens[rd.pos], true
+}
+
+func (rd *reader) expect(text string) error {
+	t, ok := rd.peek()
+	if !ok || t.text != text || t.kind != atomTerm {
+		return fmt.Errorf("expected %s got %q", text, t.text)
+	}
+	rd.pos++
+	return nil
+}
+
+func (rd *reader) parse(maxPrec int) (pterm, error) {
+	left, prec, err := rd.parsePrimary(maxPrec)
+	if err != nil {
+		return pterm{}, err
+	}
+	for {
+		t, ok := rd.peek()
+		if !ok || t.kind != atomTerm || t.punct && t.text != "," && t.text != "|" {
+			return left, nil
+		}
+		o, ok := infixOps[t.text]
+		if !ok || o.prec > maxPrec {
+			return left, nil
+		}
+		leftMax, rightMax := o.prec-1, o.prec-1
+		switch o.typ {
+		case xfy:
+			rightMax = o.prec
+		case yfx:
+			leftMax = o.prec
+		}
+		if prec > leftMax {
+			return left, nil
+		}
+		rd.pos++
+		right, err := rd.parse(rightMax)
+		if err != nil {
+			return pterm{}, err
+		}
+		left = pterm{kind: compoundTerm, name: t.text, args: []pterm{left, right}}
+		prec = o.prec
+	}
+}
+
+// parsePrimary returns a term and its precedence
+func (rd *reader) parsePrimary(maxPrec int) (pterm, int, error) {
+	t, ok := rd.peek()
+	if !ok {
+		return pterm{}, 0, fmt.Errorf("unexpected end of clause")
+	}
+	rd.pos++
+	switch {
+	case t.kind == numberTerm:
+		return pterm{kind: numberTerm, name: t.text}, 0, nil
+	case t.kind == varTerm:
+		return pterm{kind: varTerm, name: t.text}, 0, nil
+	case t.punct && t.text == "(":
+		inner, err := rd.parse(1200)
+		if err != nil {
+			return pterm{}, 0, err
+		}
+		return inner, 0, rd.expect(")")
+	case t.punct && t.text == "[":
+		l, err := rd.parseList()
+		return l, 0, err
+	case t.punct:
+		return pterm{}, 0, fmt.Errorf("unexpected %q", t.text)
+	case t.functor:
+		rd.pos++ // (
+		args := []pterm{}
+		for {
+			arg, err := rd.parse(999)
+			if err != nil {
+				return pterm{}, 0, err
+			}
+			args = append(args, arg)
+			next, _ := rd.peek()
+			rd.pos++
+			if next.text == ")" {
+				break
+			}
+			if next.text != "," {
+				return pterm{}, 0, fmt.Errorf("expected , or ) got %q", next.text)
+			}
+		}
+		return pterm{kind: compoundTerm, name: t.text, args: args}, 0, nil
+	}
+
+	// prefix operator applied to an operand
+	if o, ok := prefixOps[t.text]; ok && rd.startsTerm() {
+		if t.text == "-" {
+			if n, ok := rd.peek(); ok && n.kind == numberTerm {
+				rd.pos++
+				return pterm{kind: numberTerm, name: "-" + n.text}, 0, nil
+			}
+		}
+		prec := o.prec
+		if prec > maxPrec {
+			prec = 999
+		}
+		argMax := prec - 1
+		if o.typ == fy {
+			argMax = prec
+		}
+		arg, err := rd.parse(argMax)
+		if err != nil {
+			return pterm{}, 0, err
+		}
+		return pterm{kind: compoundTerm, name: t.text, args: []pterm{arg}}, prec, nil
+	}
+	prec := 0
+	if o, ok := infixOps[t.text]; ok {
+		prec = o.prec
+		if prec > maxPrec {
+			prec = 0
+		}
+	}
+	return pterm{kind: atomTerm, name: t.text}, prec, nil
+}
+
+// startsTerm reports whether the next token can start an operand
+func (rd *reader) startsTerm() bool {
+	t, ok := rd.peek()
+	if !ok {
+		return false
+	}
+	if t.punct {
+		return t.text == "(" || t.text == "["
+	}
+	if t.kind != atomTerm {
+		return true
+	}
+	_, infix := infixOps[t.text]
+	return !infix || t.functor
+}
+
+func (rd *reader) parseList() (pterm, error) {
+	if t, _ := rd.peek(); t.punct && t.text == "]" {
+		rd.pos++
+		return pterm{kind: atomTerm, name: "[]"}, nil
+	}
+	items := []pterm{}
+	tail := pterm{kind: atomTerm, name: "[]"}
+	for {
+		item, err := rd.parse(999)
+		if err != nil {
+			return pterm{}, err
+		}
+		items = append(items, item)
+		next, _ := rd.peek()
+		rd.pos++
+		if next.text == "|" {
+			if tail, err = rd.parse(999); err != nil {
+				return pterm{}, err
+			}
+			next, _ = rd.peek()
+			rd.pos++
+		}
+		if next.text == "]" {
+			break
+		}
+		if next.text != "," {
+			return pterm{}, fmt.Errorf("expected , or ] got %q", next.text)
+		}
+	}
+	for i := len(items) - 1; i >= 0; i-- {
+		tail = pterm{kind: compoundTerm, name: ".", args: []pterm{items[i], tail}}
+	}
+	return tail, nil
+}
@@ -0,0 +1,83 @@
+package prolog
+
+import (
+	"fmt"
+	"regexp"
+
+	. "model"
+
+	"github.com/mndrix/golog"
+	"github.com/mndrix/golog/term"
+)
+
+// strings are printed as atoms, so string builtins
+// compile to atom builtins. lower_atom only maps ASCII.
+const stringPrelude = `
+lower_atom(A, L) :-
+	atom_codes(A, Cs),
+	lower_codes(Cs, Ls),
+	atom_codes(L, Ls).
+
+lower_codes([], []).
+lower_codes([C|Cs], [L|Ls]) :-
+	( C >= 65, C =< 90 -> L is C + 32 ; L = C ),
+	lower_codes(Cs, Ls).
+`
+
+// there is no regex support in prolog, matches/2 is done in Go
+var foreignPredicates = map[string]golog.ForeignPredicate{
+	"matches/2": matches,
+}
+
+func matches(m golog.Machine, args []term.Term) golog.ForeignReturn {
+	re, err := regexp.Compile(atomText(args[1]))
+	if err != nil {
+		return golog.ForeignFail()
+	}
+	if re.MatchString(atomText(args[0])) {
+		return golog.ForeignTrue()
+	}
+	return golog.ForeignFail()
+}
+
+// atomText returns the text of an atom as read, without the
+// quotes and escapes String prints it with
+func atomText(t term.Term) string {
+	if a, ok := t.(term.Callable); ok && a.Arity() == 0 {
+		return a.Name()
+	}
+	return t.String()
+}
+
+// predicates are printed as goals, functions introduce
+// a new variable for their result as a side effect
+func printBuiltin(g *generator, e Expression) (string, []string) {
+	sideEffects := []string{}
+	args := make([]string, len(e.Args))
+	for i, n := range e.Args {
+		ve, vs := printNodeRecursive(g, n)
+		args[i] = ve
+		sideEffects = append(sideEffects, vs...)
+	}
+
+	var goal string
+	switch e.Functor {
+	case "startsWith":
+		return fmt.Sprintf("sub_atom(%s, 0, _, _, %s)", args[0], args[1]), sideEffects
+	case "contains":
+		return fmt.Sprintf("sub_atom(%s, _, _, _, %s)", args[0], args[1]), sideEffects
+	case "length":
+		goal = "atom_length(%s, %s)"
+	case "lower":
+		goal = "lower_atom(%s, %s)"
+	case "concat":
+		goal = "atom_concat(%s, %s, %s)"
+	}
+	varName := g.newVarName()
+	goalArgs := []interface{}{}
+	for _, a := range args {
+		goalArgs = append(goalArgs, a)
+	}
+	sideEffects = append(sideEffects, fmt.Sprintf(goal, append(goalArgs, varName)...))
+	return varName, sideEffects
+}
@@ -0,0 +1,212 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"eval"
+	"model"
+)
+
+const help = `object, relation, rule and test declarations are added to the
+session, replacing earlier ones of the same name; the tests that
+depend on them are run. Anything else is a query, conditions
+separated by commas as in a rule body. Integer fields left out of
+the facts asserted are unknown: a query then answers with the
+constraints on them for which it holds, such as p.age >= 18.
+
+:assert fact      assert an object instantiation or relation fact
+:retract fact     retract a fact, or the facts instantiating an object
+:facts            list the facts asserted
+:test [name]      run all tests, or the named one
+:list             print the declarations of the session
+:load file        declare the contents of a rulebase file
+:save file        save the declarations to a rulebase file
+:history          list the inputs so far, !n repeats input n
+:help             print this help
+:quit             leave the session
+`
+
+// Run reads declarations, queries and commands from r and writes
+// the results to w, until the input ends or the :quit command.
+// A declaration continues over lines until its braces close.
+func Run(r io.Reader, w io.Writer) error {
+	s := New()
+	in := bufio.NewScanner(r)
+	history := []string{}
+	for {
+		input, ok := read(in, w)
+		if !ok {
+			fmt.Fprintln(w)
+			return in.Err()
+		}
+		if strings.HasPrefix(input, "!") {
+			n, err := strconv.Atoi(input[1:])
+			if err != nil || n < 1 || n > len(history) {
+				fmt.Fprintf(w, "no input %s in history\n", input[1:])
+				continue
+			}
+			input = history[n-1]
+			fmt.Fprintln(w, input)
+		}
+		history = append(history, input)
+		switch input {
+		case ":quit", ":q":
+			return nil
+		case ":history":
+			for i, h := range history {
+				fmt.Fprintf(w, "%3d  %s\n", i+1, strings.Replace(h, "\n", "\n     ", -1))
+			}
+			continue
+		}
+		s.execute(w, input)
+	}
+}
+
+// read reads the next input that is not empty, prompting
+// for more lines while a brace is left open
+func read(in *bufio.Scanner, w io.Writer) (string, bool) {
+	prompt := "> "
+	lines := []string{}
+	depth := 0
+	for {
+		fmt.Fprint(w, prompt)
+		if !in.Scan() {
+			return "", false
+		}
+		line := in.Text()
+		if len(lines) == 0 && strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+		for _, l := range model.Scan(line) {
+			switch l.Tok {
+			case model.LBRACE:
+				depth++
+			case model.RBRACE:
+				depth--
+			}
+		}
+		input := strings.TrimSpace(strings.Join(lines, "\n"))
+		// comments go with the declaration after them
+		if depth <= 0 && first(input) != model.EOF {
+			return input, true
+		}
+		prompt = "... "
+	}
+}
+
+func (s *Session) execute(w io.Writer, input string) {
+	if strings.HasPrefix(input, ":") {
+		s.command(w, input)
+		return
+	}
+	switch first(input) {
+	case model.OBJECT, model.RELATION, model.RULE, model.TEST, model.PACKAGE, model.IMPORT:
+		results, err := s.Define(input)
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		printResults(w, results)
+		return
+	}
+	answers, err := s.Query(input)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	printAnswers(w, answers)
+}
+
+// printAnswers prints true or false, or if the query holds only
+// for some values of the integers left unknown, the constraints
+// on them, a line for each way it holds
+func printAnswers(w io.Writer, answers []eval.Answer) {
+	for _, a := range answers {
+		if len(a.Constraints) == 0 {
+			fmt.Fprintln(w, true)
+			return
+		}
+	}
+	if len(answers) == 0 {
+		fmt.Fprintln(w, false)
+	}
+	for _, a := range answers {
+		fmt.Fprintln(w, strings.Join(a.Constraints, ", "))
+	}
+}
+
+// first returns the first token of input that is not a comment
+func first(input string) model.Token {
+	for _, l := range model.Scan(input) {
+		if l.Tok != model.COMMENT {
+			return l.Tok
+		}
+	}
+	return model.EOF
+}
+
+func (s *Session) command(w io.Writer, input string) {
+	cmd, arg := input, ""
+	if i := strings.IndexAny(input, " \t\n"); i >= 0 {
+		cmd, arg = input[:i], strings.TrimSpace(input[i:])
+	}
+	switch cmd {
+	case ":help", ":h":
+		fmt.Fprint(w, help)
+	case ":assert":
+		if err := s.Assert(arg); err != nil {
+			fmt.Fprintln(w, err)
+		}
+	case ":retract":
+		if !s.Retract(arg) {
+			fmt.Fprintf(w, "no fact %s\n", arg)
+		}
+	case ":facts":
+		for _, f := range s.Facts() {
+			fmt.Fprintln(w, f)
+		}
+	case ":test":
+		if name, err := strconv.Unquote(arg); err == nil {
+			arg = name
+		}
+		results, err := s.Test(arg)
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		printResults(w, results)
+	case ":list":
+		fmt.Fprint(w, s.Source())
+	case ":load":
+		results, err := s.Load(arg)
+		if err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		printResults(w, results)
+	case ":save":
+		if err := s.Save(arg); err != nil {
+			fmt.Fprintln(w, err)
+		}
+	default:
+		fmt.Fprintf(w, "unknown command %s, :help lists the commands\n", cmd)
+	}
+}
+
+func printResults(w io.Writer, results []Result) {
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(w, "FAIL %s: %s\n", r.Name, r.Err)
+		case r.Passed:
+			fmt.Fprintf(w, "ok   %s\n", r.Name)
+		default:
+			fmt.Fprintf(w, "FAIL %s\n", r.Name)
+		}
+	}
+}
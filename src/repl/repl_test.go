@@ -0,0 +1,228 @@
+package repl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	s := New()
+	for i, tt := range []struct {
+		input string
+		want  string
+	}{
+		{
+			input: "object prisoner {\n\tage  : int,\n\tname : string\n}",
+			want:  "",
+		},
+		// a test can be written before the rule it tests
+		{
+			input: `test "Adult" { facts { p : prisoner { age : 23, name : john } } rules { adult(p) } }`,
+			want:  "FAIL Adult: undefined rule adult\n",
+		},
+		{
+			input: "rule adult { input { p : prisoner } rules { p.age > 30 } }",
+			want:  "FAIL Adult\n",
+		},
+		{
+			input: "rule adult { input { p : prisoner } rules { p.age >= 18 } }",
+			want:  "ok   Adult\n",
+		},
+		// tests that do not depend on a declaration are not rerun
+		{
+			input: "object cell { number : int }",
+			want:  "",
+		},
+		{
+			input: "rule bigCell { input { c : cell } rules { c.number > 100 } }",
+			want:  "",
+		},
+		{
+			input: "rule adult { input { p : prisoner } rules { p.size > 1 } }",
+			want:  "rule adult: object prisoner has no field size\n",
+		},
+		{
+			input: "object prisoner { age : int }",
+			want:  "FAIL Adult: object prisoner has no field name\n",
+		},
+		{
+			input: "object prisoner {\n\tage  : int,\n\tname : string\n}",
+			want:  "ok   Adult\n",
+		},
+		{
+			input: `rule minor { input { p : prisoner } rules { p.age < 18 } } test "Minor" { facts { p : prisoner { age : 15 } } rules { minor(p) } }`,
+			want:  "ok   Minor\n",
+		},
+		{
+			input: ":assert p1 : prisoner { age : 15, name : henry }",
+			want:  "",
+		},
+		{
+			input: ":assert p2 : guard { age : 40 }",
+			want:  "undefined object guard\n",
+		},
+		{
+			input: ":assert p2 : prisoner { age : 40 }",
+			want:  "",
+		},
+		{
+			input: "adult(p1)",
+			want:  "false\n",
+		},
+		{
+			input: `minor(p1), p1.name = "henry", p2.age > p1.age`,
+			want:  "true\n",
+		},
+		{
+			input: "p1.nme = 1",
+			want:  "object prisoner has no field nme\n",
+		},
+		{
+			input: "p1.age >",
+			want:  "unexpected }\n",
+		},
+		{
+			input: ":facts",
+			want:  "p1 : prisoner { age : 15, name : henry }\np2 : prisoner { age : 40 }\n",
+		},
+		{
+			input: ":retract p1",
+			want:  "",
+		},
+		{
+			input: ":retract p1",
+			want:  "no fact p1\n",
+		},
+		{
+			input: "adult(p1)",
+			want:  "undefined identifier p1\n",
+		},
+		// the age left out is unknown, the answer constrains it
+		{
+			input: ":assert p3 : prisoner { name : jim }",
+			want:  "",
+		},
+		{
+			input: "adult(p3), p3.age + 5 < p2.age",
+			want:  "p3.age >= 18, p3.age <= 34\n",
+		},
+		{
+			input: "adult(p3), minor(p3)",
+			want:  "false\n",
+		},
+		{
+			input: ":retract p3",
+			want:  "",
+		},
+		{
+			input: ":test",
+			want:  "ok   Adult\nok   Minor\n",
+		},
+		{
+			input: `:test "Minor"`,
+			want:  "ok   Minor\n",
+		},
+		{
+			input: ":test Major",
+			want:  "no test \"Major\"\n",
+		},
+		{
+			input: `import "prisoners.rules"`,
+			want:  "import is not supported in a session\n",
+		},
+		{
+			input: ":what",
+			want:  "unknown command :what, :help lists the commands\n",
+		},
+	} {
+		var b bytes.Buffer
+		s.execute(&b, tt.input)
+		if got := b.String(); got != tt.want {
+			t.Errorf("%d): got %q want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	in := `
+# prisoners
+object prisoner {
+	age : int
+}
+rule adult {
+	input { p : prisoner }
+	rules { p.age >= 18 }
+}
+:assert p : prisoner { age : 20 }
+adult(p)
+!4
+!9
+:history
+:quit
+adult(p)
+`
+	want := `> ... ... ... > ... ... ... > > true
+> adult(p)
+true
+> no input 9 in history
+>   1  # prisoners
+     object prisoner {
+     	age : int
+     }
+  2  rule adult {
+     	input { p : prisoner }
+     	rules { p.age >= 18 }
+     }
+  3  :assert p : prisoner { age : 20 }
+  4  adult(p)
+  5  adult(p)
+  6  :history
+> `
+	var out bytes.Buffer
+	if err := Run(strings.NewReader(in[1:]), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := filepath.Glob("../testdata/*.rules")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata: %v", err)
+	}
+	for i, file := range files {
+		s := New()
+		results, err := s.Load(file)
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		for _, r := range results {
+			if r.Err != nil || !r.Passed {
+				t.Errorf("%d): %s: got %v %v", i, r.Name, r.Passed, r.Err)
+			}
+		}
+		// the testdata is formatted, comments included
+		saved := filepath.Join(dir, filepath.Base(file))
+		if err := s.Save(saved); err != nil {
+			t.Fatal(err)
+		}
+		want, _ := ioutil.ReadFile(file)
+		got, _ := ioutil.ReadFile(saved)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%d): %s saved as\n%s", i, file, got)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"deps"
+	"eval"
+	"format"
+	"model"
+)
+
+// Session is a rulebase built up one declaration at a time,
+// with facts asserted to query it. Declaring an object, relation,
+// rule or test again replaces the earlier declaration, and the
+// tests depending on it are rerun.
+type Session struct {
+	decls []chunk
+	facts []string
+	// the declarations parsed and checked
+	ir model.InternalRepresentation
+}
+
+// chunk is the source of one declaration,
+// with the comments before it
+type chunk struct {
+	kind, name, text string
+}
+
+// Result of running a test, Err is set if it could not run.
+// Passed is set if it came out as expected: a test whose name
+// ends in (fails) passes if its rules do not hold.
+type Result struct {
+	Name   string
+	Passed bool
+	Err    error
+}
+
+// name of the test queries run as
+const query = "?-"
+
+func New() *Session {
+	ir, _ := model.Parse("")
+	return &Session{ir: ir}
+}
+
+// Source returns the declarations of the session as a rulebase,
+// formatted
+func (s *Session) Source() string {
+	src := []byte(source(s.decls))
+	if out, err := format.Source(src); err == nil {
+		src = out
+	}
+	return string(src)
+}
+
+func source(decls []chunk) string {
+	texts := make([]string, len(decls))
+	for i, c := range decls {
+		texts[i] = c.text
+	}
+	if len(texts) == 0 {
+		return ""
+	}
+	return strings.Join(texts, "\n\n") + "\n"
+}
+
+// Define adds the declarations in src, replacing those of the
+// same kind and name, and runs the tests that changed or depend
+// on what changed. Declarations that do not type check are
+// rejected, except for tests: a test can be written before the
+// rules it calls, and fails until they are declared.
+func (s *Session) Define(src string) ([]Result, error) {
+	chunks, err := split(src)
+	if err != nil {
+		return nil, err
+	}
+	decls := append([]chunk{}, s.decls...)
+	// declaring something again reruns its tests even if it
+	// did not change
+	changed := map[deps.Node]bool{}
+	for _, c := range chunks {
+		changed[deps.Node{Kind: c.kind, Name: c.name}] = true
+		decls = replace(decls, c)
+	}
+	ir, err := model.Parse(source(decls))
+	if err != nil {
+		return nil, err
+	}
+	msgs := []string{}
+	for _, err := range model.Check(ir) {
+		if cerr, ok := err.(model.CheckError); ok && cerr.Kind == "test" {
+			continue
+		}
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) > 0 {
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	for n := range deps.Changed(s.ir, ir) {
+		changed[n] = true
+	}
+	tests := []model.Test{}
+	for _, n := range deps.Affected(s.ir, ir, changed) {
+		tests = append(tests, named(ir, n.Name)...)
+	}
+	s.decls, s.ir = decls, ir
+	return s.run(tests), nil
+}
+
+// split splits src into the declarations it contains
+func split(src string) ([]chunk, error) {
+	ir, err := model.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	chunks := []chunk{}
+	start := 0
+	for _, d := range ir.Declarations {
+		switch d.Kind {
+		case "package", "import":
+			return nil, fmt.Errorf("%s is not supported in a session", d.Kind)
+		case "object", "relation", "rule", "test":
+			end := offset(src, d.End) + len("}")
+			if end > len(src) {
+				end = len(src)
+			}
+			chunks = append(chunks, chunk{d.Kind, d.Name, strings.TrimSpace(src[start:end])})
+			start = end
+		}
+	}
+	// comments after the last declaration stay with it
+	if n := len(chunks); n > 0 {
+		chunks[n-1].text += strings.TrimRight(src[start:], " \t\r\n")
+	}
+	return chunks, nil
+}
+
+// offset returns the byte offset in src of a position
+func offset(src string, pos model.Position) int {
+	line, col := 1, 1
+	for i, r := range src {
+		if line == pos.Line && col == pos.Col {
+			return i
+		}
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return len(src)
+}
+
+// replace replaces the declaration of the same kind and name
+// as c, or adds c after the others
+func replace(decls []chunk, c chunk) []chunk {
+	for i, d := range decls {
+		if d.kind == c.kind && d.name == c.name {
+			decls[i] = c
+			return decls
+		}
+	}
+	return append(decls, c)
+}
+
+// Test runs the test with the given name, or all tests if
+// the name is empty
+func (s *Session) Test(name string) ([]Result, error) {
+	if name == "" {
+		return s.run(s.ir.Tests), nil
+	}
+	tests := named(s.ir, name)
+	if len(tests) == 0 {
+		return nil, fmt.Errorf("no test %q", name)
+	}
+	return s.run(tests), nil
+}
+
+func named(ir model.InternalRepresentation, name string) []model.Test {
+	tests := []model.Test{}
+	for _, t := range ir.Tests {
+		if t.Name == name {
+			tests = append(tests, t)
+		}
+	}
+	return tests
+}
+
+// run runs tests in the interpreter, one at a time so
+// one that does not type check does not stop the others
+func (s *Session) run(tests []model.Test) []Result {
+	invalid := map[string]error{}
+	for _, err := range model.Check(s.ir) {
+		if cerr, ok := err.(model.CheckError); ok && cerr.Kind == "test" && invalid[cerr.Name] == nil {
+			invalid[cerr.Name] = cerr.Err
+		}
+	}
+	results := []Result{}
+	for _, t := range tests {
+		if err := invalid[t.Name]; err != nil {
+			results = append(results, Result{Name: t.Name, Err: err})
+			continue
+		}
+		passed, err := s.eval(t)
+		results = append(results, Result{Name: t.Name, Passed: passed, Err: err})
+	}
+	return results
+}
+
+func (s *Session) eval(t model.Test) (bool, error) {
+	ir := s.ir
+	ir.Tests = []model.Test{t}
+	in := eval.New()
+	if err := in.Load(ir); err != nil {
+		return false, err
+	}
+	results, err := in.RunTests()
+	if err != nil {
+		return false, errors.New(strings.TrimPrefix(err.Error(), fmt.Sprintf("test %q: ", t.Name)))
+	}
+	return results[0].OK(), nil
+}
+
+// Assert adds a fact for queries: an object instantiation
+// as in the facts of a test, or a fact of a relation
+func (s *Session) Assert(fact string) error {
+	facts := append(append([]string{}, s.facts...), fact)
+	if _, err := s.query(facts, ""); err != nil {
+		return err
+	}
+	s.facts = facts
+	return nil
+}
+
+// Retract removes the facts that are fact, or that
+// instantiate the object named by fact, and reports
+// whether there were any
+func (s *Session) Retract(fact string) bool {
+	facts := []string{}
+	for _, f := range s.facts {
+		if f != fact && instance(f) != fact {
+			facts = append(facts, f)
+		}
+	}
+	removed := len(facts) < len(s.facts)
+	s.facts = facts
+	return removed
+}
+
+// instance returns the name an object instantiation declares
+func instance(fact string) string {
+	lexemes := model.Scan(fact)
+	if len(lexemes) > 1 && lexemes[0].Tok == model.IDENT && lexemes[1].Tok == model.COLON {
+		return lexemes[0].Lit
+	}
+	return ""
+}
+
+// Facts returns the facts asserted, in order
+func (s *Session) Facts() []string {
+	return append([]string{}, s.facts...)
+}
+
+// Query returns the ways the conditions in goals, separated by
+// commas as in the body of a rule, hold given the facts asserted.
+// Integer fields the facts leave out are unknown, each answer has
+// the constraints on them for which the goals hold.
+func (s *Session) Query(goals string) ([]eval.Answer, error) {
+	t, err := s.query(s.facts, goals)
+	if err != nil {
+		return nil, err
+	}
+	in := eval.New()
+	if err := in.Load(s.ir); err != nil {
+		return nil, err
+	}
+	return in.Answers(t.Facts, t.Body)
+}
+
+// query returns a test of goals given facts,
+// which type checks against the session
+func (s *Session) query(facts []string, goals string) (model.Test, error) {
+	t := model.Test{Name: query}
+	// the parser knows facts in a test, and conditions in a rule
+	// where the inputs give the objects instantiated their type
+	inputs := []string{}
+	if len(facts) > 0 {
+		ir, err := model.Parse("test t {\n\tfacts {\n" + strings.Join(facts, ",\n") + "\n\t}\n\trules {\n\t\tt(t)\n\t}\n}\n")
+		if err != nil {
+			return t, syntaxError(err)
+		}
+		t.Facts = ir.Tests[0].Facts
+		for _, f := range t.Facts {
+			if f.Functor == "new" {
+				o := f.Args[0].(model.Term)
+				inputs = append(inputs, fmt.Sprintf("%s : %s", o.Value, o.ObjectName()))
+			}
+		}
+	}
+	if goals != "" {
+		if len(inputs) == 0 {
+			// a rule has at least one input
+			inputs = append(inputs, "_ : int")
+		}
+		ir, err := model.Parse("rule q {\n\tinput {\n\t\t" + strings.Join(inputs, ",\n\t\t") + "\n\t}\n\trules {\n" + goals + "\n\t}\n}\n")
+		if err != nil {
+			return t, syntaxError(err)
+		}
+		if len(ir.Rules["q"].Alternatives) > 0 {
+			return t, fmt.Errorf("a query has one body")
+		}
+		t.Body = ir.Rules["q"].Body
+	}
+	ir := s.ir
+	ir.Tests = []model.Test{t}
+	msgs := []string{}
+	for _, err := range model.Check(ir) {
+		if cerr, ok := err.(model.CheckError); ok && cerr.Kind == "test" {
+			msgs = append(msgs, cerr.Err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		return t, errors.New(strings.Join(msgs, "\n"))
+	}
+	return t, nil
+}
+
+// syntaxError drops the position of a parse error,
+// which is in the source wrapped around the input
+func syntaxError(err error) error {
+	if perr, ok := err.(model.ParseError); ok {
+		return perr.Err
+	}
+	return err
+}
+
+// Load declares the contents of a rulebase file
+func (s *Session) Load(file string) ([]Result, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.Define(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return results, nil
+}
+
+// Save writes the declarations of the session to a
+// rulebase file; the facts are not saved
+func (s *Session) Save(file string) error {
+	return ioutil.WriteFile(file, []byte(s.Source()), 0644)
+}
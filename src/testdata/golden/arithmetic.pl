@@ -0,0 +1,147 @@
+% generated from a rulebase
+:- module(arithmetic, [run_rulebase_tests/0, halfEven/1, grows/1, overdrawnAfterFee/1, renewsEndOfFebruary/1]).
+:- use_module(library(plunit)).
+:- dynamic(today/1).
+:- dynamic(expected_failure/1).
+
+date_add(date(Y,M,D), duration(DY,DM,DD), Date) :-
+	Months is (Y + DY) * 12 + M - 1 + DM,
+	Y1 is Months // 12,
+	M1 is Months mod 12 + 1,
+	days_in_month(Y1, M1, Max),
+	D1 is min(D, Max),
+	date_to_days(date(Y1,M1,D1), N),
+	N1 is N + DD,
+	days_to_date(N1, Date).
+
+date_sub(Date, duration(DY,DM,DD), Result) :-
+	NY is -DY, NM is -DM, ND is -DD,
+	date_add(Date, duration(NY,NM,ND), Result).
+
+days_in_month(Y, 2, 29) :- leap_year(Y), !.
+days_in_month(_, 2, 28) :- !.
+days_in_month(_, 4, 30) :- !.
+days_in_month(_, 6, 30) :- !.
+days_in_month(_, 9, 30) :- !.
+days_in_month(_, 11, 30) :- !.
+days_in_month(_, _, 31).
+
+leap_year(Y) :- 0 =:= Y mod 400, !.
+leap_year(Y) :- 0 =:= Y mod 4, 0 =\= Y mod 100.
+
+date_to_days(date(Y,M,D), N) :-
+	( M =< 2 -> Y0 is Y - 1, M0 is M + 9 ; Y0 is Y, M0 is M - 3 ),
+	Era is Y0 // 400,
+	Yoe is Y0 - Era * 400,
+	Doy is (153 * M0 + 2) // 5 + D - 1,
+	Doe is Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N is Era * 146097 + Doe - 719468.
+
+days_to_date(N, date(Y,M,D)) :-
+	Z is N + 719468,
+	Era is Z // 146097,
+	Doe is Z - Era * 146097,
+	Yoe is (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy is Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp is (5 * Doy + 2) // 153,
+	D is Doy - (153 * Mp + 2) // 5 + 1,
+	( Mp < 10 -> M is Mp + 3 ; M is Mp - 9 ),
+	( M =< 2 -> Y is Yoe + Era * 400 + 1 ; Y is Yoe + Era * 400 ).
+
+lower_atom(A, L) :-
+	atom_codes(A, Cs),
+	lower_codes(Cs, Ls),
+	atom_codes(L, Ls).
+
+lower_codes([], []).
+lower_codes([C|Cs], [L|Ls]) :-
+	( C >= 65, C =< 90 -> L is C + 32 ; L = C ),
+	lower_codes(Cs, Ls).
+
+today(date(2018,3,1)).
+
+'account#balance'(A, B) :- B = account(A,_,_).
+'account#rate'(A, B) :- B = account(_,A,_).
+'account#opened'(A, B) :- B = account(_,_,A).
+
+
+halfEven(A) :- 
+	'account#balance'(V_1, A),
+	V_2 is V_1 // 2,
+	V_3 is V_2 mod 2,
+	==(V_3,0).
+
+grows(A) :- 
+	'account#balance'(V_4, A),
+	'account#rate'(V_5, A),
+	V_6 is V_4 * V_5,
+	'account#balance'(V_7, A),
+	V_8 is V_7 + 10,
+	@>(V_6,V_8).
+
+overdrawnAfterFee(A) :- 
+	'account#balance'(V_9, A),
+	V_10 is V_9 - 20,
+	After = V_10,
+	@<(After,0),
+	V_11 is After mod 7,
+	==(V_11,4).
+
+renewsEndOfFebruary(A) :- 
+	'account#opened'(V_12, A),
+	date_add(V_12, duration(1,0,0), V_13),
+	date_sub(V_13, duration(0,0,1), V_14),
+	==(V_14,date(2021,2,28)).
+renewsEndOfFebruary(A) :- 
+	'account#opened'(V_15, A),
+	date_add(V_15, duration(1,0,0), V_16),
+	==(V_16,date(2021,2,28)).
+
+test('Integer division') :- A = account(9,1.5,_),halfEven(A).
+
+test('Odd half (fails)') :- A = account(6,1.5,_),halfEven(A).
+
+test('Interest') :- A = account(100,1.25,_),grows(A).
+
+test('Too little interest (fails)') :- A = account(100,1.05,_),grows(A).
+
+test('Modulo takes the sign of the divisor') :- A = account(10,1.5,_),overdrawnAfterFee(A).
+
+test('Leap day') :- A = account(0,1.5,date(2020,2,29)),renewsEndOfFebruary(A).
+
+test('First of March') :- A = account(0,1.5,date(2020,3,1)),renewsEndOfFebruary(A).
+
+test('Mid February (fails)') :- A = account(0,1.5,date(2020,2,14)),renewsEndOfFebruary(A).
+
+test_cases(['Integer division','Odd half (fails)','Interest','Too little interest (fails)','Modulo takes the sign of the divisor','Leap day','First of March','Mid February (fails)']).
+expected_failure('Odd half (fails)').
+expected_failure('Too little interest (fails)').
+expected_failure('Mid February (fails)').
+
+run_rulebase_tests :-
+	test_cases(List),
+	run_test_cases(List, Status),
+	write('Test '), write(Status), nl.
+
+run_test_cases([], success).
+run_test_cases([H|T], Status) :-
+	passes(H), !,
+	run_test_cases(T, Status).
+run_test_cases([H|T], failure) :-
+	\+(passes(H)), !,
+	write('Test case failed: '), write(H), nl,
+	run_test_cases(T, _).
+
+passes(H) :- expected_failure(H), !, \+(test(H)).
+passes(H) :- test(H).
+
+:- begin_tests(arithmetic).
+test('Integer division') :- arithmetic:test('Integer division').
+test('Odd half (fails)', [fail]) :- arithmetic:test('Odd half (fails)').
+test('Interest') :- arithmetic:test('Interest').
+test('Too little interest (fails)', [fail]) :- arithmetic:test('Too little interest (fails)').
+test('Modulo takes the sign of the divisor') :- arithmetic:test('Modulo takes the sign of the divisor').
+test('Leap day') :- arithmetic:test('Leap day').
+test('First of March') :- arithmetic:test('First of March').
+test('Mid February (fails)', [fail]) :- arithmetic:test('Mid February (fails)').
+:- end_tests(arithmetic).
@@ -0,0 +1,130 @@
+% generated from a rulebase
+:- module(prisoners, [run_rulebase_tests/0, hasRightToPhonecall/1, hasAdultCellmate/1, releasedBefore/2]).
+:- use_module(library(plunit)).
+:- dynamic(today/1).
+:- dynamic(expected_failure/1).
+
+date_add(date(Y,M,D), duration(DY,DM,DD), Date) :-
+	Months is (Y + DY) * 12 + M - 1 + DM,
+	Y1 is Months // 12,
+	M1 is Months mod 12 + 1,
+	days_in_month(Y1, M1, Max),
+	D1 is min(D, Max),
+	date_to_days(date(Y1,M1,D1), N),
+	N1 is N + DD,
+	days_to_date(N1, Date).
+
+date_sub(Date, duration(DY,DM,DD), Result) :-
+	NY is -DY, NM is -DM, ND is -DD,
+	date_add(Date, duration(NY,NM,ND), Result).
+
+days_in_month(Y, 2, 29) :- leap_year(Y), !.
+days_in_month(_, 2, 28) :- !.
+days_in_month(_, 4, 30) :- !.
+days_in_month(_, 6, 30) :- !.
+days_in_month(_, 9, 30) :- !.
+days_in_month(_, 11, 30) :- !.
+days_in_month(_, _, 31).
+
+leap_year(Y) :- 0 =:= Y mod 400, !.
+leap_year(Y) :- 0 =:= Y mod 4, 0 =\= Y mod 100.
+
+date_to_days(date(Y,M,D), N) :-
+	( M =< 2 -> Y0 is Y - 1, M0 is M + 9 ; Y0 is Y, M0 is M - 3 ),
+	Era is Y0 // 400,
+	Yoe is Y0 - Era * 400,
+	Doy is (153 * M0 + 2) // 5 + D - 1,
+	Doe is Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N is Era * 146097 + Doe - 719468.
+
+days_to_date(N, date(Y,M,D)) :-
+	Z is N + 719468,
+	Era is Z // 146097,
+	Doe is Z - Era * 146097,
+	Yoe is (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy is Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp is (5 * Doy + 2) // 153,
+	D is Doy - (153 * Mp + 2) // 5 + 1,
+	( Mp < 10 -> M is Mp + 3 ; M is Mp - 9 ),
+	( M =< 2 -> Y is Yoe + Era * 400 + 1 ; Y is Yoe + Era * 400 ).
+
+lower_atom(A, L) :-
+	atom_codes(A, Cs),
+	lower_codes(Cs, Ls),
+	atom_codes(L, Ls).
+
+lower_codes([], []).
+lower_codes([C|Cs], [L|Ls]) :-
+	( C >= 65, C =< 90 -> L is C + 32 ; L = C ),
+	lower_codes(Cs, Ls).
+
+today(date(2018,3,1)).
+
+'prisoner#age'(A, B) :- B = prisoner(A,_,_,_).
+'prisoner#name'(A, B) :- B = prisoner(_,A,_,_).
+'prisoner#admitted'(A, B) :- B = prisoner(_,_,A,_).
+'prisoner#sentence'(A, B) :- B = prisoner(_,_,_,A).
+
+
+:- dynamic(cellmates/2).
+
+hasRightToPhonecall(P) :- 
+	'prisoner#age'(V_1, P),
+	@>=(V_1,18),
+	'prisoner#admitted'(V_2, P),
+	date_add(V_2, duration(0,6,0), V_3),
+	today(V_4),
+	@=<(V_3,V_4).
+
+hasAdultCellmate(P) :- 
+	cellmates(P,C),
+	hasRightToPhonecall(C).
+
+releasedBefore(P,D) :- 
+	'prisoner#admitted'(V_5, P),
+	'prisoner#sentence'(V_6, P),
+	date_add(V_5, V_6, V_7),
+	@<(V_7,D).
+
+test('Right to phonecall') :- retractall(cellmates(_,_)),P1 = prisoner(23,'john',date(2017,1,1),_),hasRightToPhonecall(P1).
+
+test('Minors have no right to phonecall (fails)') :- retractall(cellmates(_,_)),P1 = prisoner(17,'henry',date(2017,1,1),_),hasRightToPhonecall(P1).
+
+test('Adult cellmate') :- retractall(cellmates(_,_)),P1 = prisoner(23,'john',date(2017,1,1),_),P2 = prisoner(15,'henry',date(2017,1,1),_),P3 = prisoner(16,'jim',_,_),assertz(cellmates(P2,P3)),assertz(cellmates(P2,P1)),hasAdultCellmate(P2).
+
+test('No cellmates (fails)') :- retractall(cellmates(_,_)),P1 = prisoner(23,'john',date(2017,1,1),_),hasAdultCellmate(P1).
+
+test('Released') :- retractall(cellmates(_,_)),P1 = prisoner(30,'john',date(2016,8,31),duration(1,6,0)),releasedBefore(P1,date(2018,3,1)).
+
+test('Not released yet (fails)') :- retractall(cellmates(_,_)),P1 = prisoner(30,'john',date(2016,8,31),duration(1,6,0)),releasedBefore(P1,date(2018,2,28)).
+
+test_cases(['Right to phonecall','Minors have no right to phonecall (fails)','Adult cellmate','No cellmates (fails)','Released','Not released yet (fails)']).
+expected_failure('Minors have no right to phonecall (fails)').
+expected_failure('No cellmates (fails)').
+expected_failure('Not released yet (fails)').
+
+run_rulebase_tests :-
+	test_cases(List),
+	run_test_cases(List, Status),
+	write('Test '), write(Status), nl.
+
+run_test_cases([], success).
+run_test_cases([H|T], Status) :-
+	passes(H), !,
+	run_test_cases(T, Status).
+run_test_cases([H|T], failure) :-
+	\+(passes(H)), !,
+	write('Test case failed: '), write(H), nl,
+	run_test_cases(T, _).
+
+passes(H) :- expected_failure(H), !, \+(test(H)).
+passes(H) :- test(H).
+
+:- begin_tests(prisoners).
+test('Right to phonecall') :- prisoners:test('Right to phonecall').
+test('Minors have no right to phonecall (fails)', [fail]) :- prisoners:test('Minors have no right to phonecall (fails)').
+test('Adult cellmate') :- prisoners:test('Adult cellmate').
+test('No cellmates (fails)', [fail]) :- prisoners:test('No cellmates (fails)').
+test('Released') :- prisoners:test('Released').
+test('Not released yet (fails)', [fail]) :- prisoners:test('Not released yet (fails)').
+:- end_tests(prisoners).
@@ -0,0 +1,111 @@
+% generated from a rulebase
+:- module(reports, [run_rulebase_tests/0, reportsTo/2, hasBoss/1]).
+:- use_module(library(plunit)).
+:- dynamic(today/1).
+:- dynamic(expected_failure/1).
+
+date_add(date(Y,M,D), duration(DY,DM,DD), Date) :-
+	Months is (Y + DY) * 12 + M - 1 + DM,
+	Y1 is Months // 12,
+	M1 is Months mod 12 + 1,
+	days_in_month(Y1, M1, Max),
+	D1 is min(D, Max),
+	date_to_days(date(Y1,M1,D1), N),
+	N1 is N + DD,
+	days_to_date(N1, Date).
+
+date_sub(Date, duration(DY,DM,DD), Result) :-
+	NY is -DY, NM is -DM, ND is -DD,
+	date_add(Date, duration(NY,NM,ND), Result).
+
+days_in_month(Y, 2, 29) :- leap_year(Y), !.
+days_in_month(_, 2, 28) :- !.
+days_in_month(_, 4, 30) :- !.
+days_in_month(_, 6, 30) :- !.
+days_in_month(_, 9, 30) :- !.
+days_in_month(_, 11, 30) :- !.
+days_in_month(_, _, 31).
+
+leap_year(Y) :- 0 =:= Y mod 400, !.
+leap_year(Y) :- 0 =:= Y mod 4, 0 =\= Y mod 100.
+
+date_to_days(date(Y,M,D), N) :-
+	( M =< 2 -> Y0 is Y - 1, M0 is M + 9 ; Y0 is Y, M0 is M - 3 ),
+	Era is Y0 // 400,
+	Yoe is Y0 - Era * 400,
+	Doy is (153 * M0 + 2) // 5 + D - 1,
+	Doe is Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N is Era * 146097 + Doe - 719468.
+
+days_to_date(N, date(Y,M,D)) :-
+	Z is N + 719468,
+	Era is Z // 146097,
+	Doe is Z - Era * 146097,
+	Yoe is (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy is Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp is (5 * Doy + 2) // 153,
+	D is Doy - (153 * Mp + 2) // 5 + 1,
+	( Mp < 10 -> M is Mp + 3 ; M is Mp - 9 ),
+	( M =< 2 -> Y is Yoe + Era * 400 + 1 ; Y is Yoe + Era * 400 ).
+
+lower_atom(A, L) :-
+	atom_codes(A, Cs),
+	lower_codes(Cs, Ls),
+	atom_codes(L, Ls).
+
+lower_codes([], []).
+lower_codes([C|Cs], [L|Ls]) :-
+	( C >= 65, C =< 90 -> L is C + 32 ; L = C ),
+	lower_codes(Cs, Ls).
+
+today(date(2018,3,1)).
+
+'person#name'(A, B) :- B = person(A).
+
+
+:- dynamic(manages/2).
+
+reportsTo(E,B) :- 
+	manages(B,E).
+reportsTo(E,B) :- 
+	manages(X,E),
+	reportsTo(X,B).
+
+hasBoss(E) :- 
+	reportsTo(E,B).
+
+test('Direct report') :- retractall(manages(_,_)),A = person('alice'),B = person('bob'),assertz(manages(A,B)),reportsTo(B,A).
+
+test('Indirect report') :- retractall(manages(_,_)),A = person('alice'),B = person('bob'),C = person('carol'),D = person('dave'),assertz(manages(A,B)),assertz(manages(B,C)),assertz(manages(C,D)),reportsTo(D,A),hasBoss(C).
+
+test('Bosses don\'t report to employees (fails)') :- retractall(manages(_,_)),A = person('alice'),B = person('bob'),C = person('carol'),assertz(manages(A,B)),assertz(manages(B,C)),reportsTo(A,C).
+
+test('Top has no boss (fails)') :- retractall(manages(_,_)),A = person('alice'),B = person('bob'),assertz(manages(A,B)),hasBoss(A).
+
+test_cases(['Direct report','Indirect report','Bosses don\'t report to employees (fails)','Top has no boss (fails)']).
+expected_failure('Bosses don\'t report to employees (fails)').
+expected_failure('Top has no boss (fails)').
+
+run_rulebase_tests :-
+	test_cases(List),
+	run_test_cases(List, Status),
+	write('Test '), write(Status), nl.
+
+run_test_cases([], success).
+run_test_cases([H|T], Status) :-
+	passes(H), !,
+	run_test_cases(T, Status).
+run_test_cases([H|T], failure) :-
+	\+(passes(H)), !,
+	write('Test case failed: '), write(H), nl,
+	run_test_cases(T, _).
+
+passes(H) :- expected_failure(H), !, \+(test(H)).
+passes(H) :- test(H).
+
+:- begin_tests(reports).
+test('Direct report') :- reports:test('Direct report').
+test('Indirect report') :- reports:test('Indirect report').
+test('Bosses don\'t report to employees (fails)', [fail]) :- reports:test('Bosses don\'t report to employees (fails)').
+test('Top has no boss (fails)', [fail]) :- reports:test('Top has no boss (fails)').
+:- end_tests(reports).
@@ -0,0 +1,139 @@
+% generated from a rulebase
+:- module(strings, [run_rulebase_tests/0, isJohn/1, validEmail/1, sameName/2]).
+:- use_module(library(plunit)).
+:- dynamic(today/1).
+:- dynamic(expected_failure/1).
+
+:- use_module(library(pcre)).
+matches(S, Re) :- re_match(Re, S).
+
+date_add(date(Y,M,D), duration(DY,DM,DD), Date) :-
+	Months is (Y + DY) * 12 + M - 1 + DM,
+	Y1 is Months // 12,
+	M1 is Months mod 12 + 1,
+	days_in_month(Y1, M1, Max),
+	D1 is min(D, Max),
+	date_to_days(date(Y1,M1,D1), N),
+	N1 is N + DD,
+	days_to_date(N1, Date).
+
+date_sub(Date, duration(DY,DM,DD), Result) :-
+	NY is -DY, NM is -DM, ND is -DD,
+	date_add(Date, duration(NY,NM,ND), Result).
+
+days_in_month(Y, 2, 29) :- leap_year(Y), !.
+days_in_month(_, 2, 28) :- !.
+days_in_month(_, 4, 30) :- !.
+days_in_month(_, 6, 30) :- !.
+days_in_month(_, 9, 30) :- !.
+days_in_month(_, 11, 30) :- !.
+days_in_month(_, _, 31).
+
+leap_year(Y) :- 0 =:= Y mod 400, !.
+leap_year(Y) :- 0 =:= Y mod 4, 0 =\= Y mod 100.
+
+date_to_days(date(Y,M,D), N) :-
+	( M =< 2 -> Y0 is Y - 1, M0 is M + 9 ; Y0 is Y, M0 is M - 3 ),
+	Era is Y0 // 400,
+	Yoe is Y0 - Era * 400,
+	Doy is (153 * M0 + 2) // 5 + D - 1,
+	Doe is Yoe * 365 + Yoe // 4 - Yoe // 100 + Doy,
+	N is Era * 146097 + Doe - 719468.
+
+days_to_date(N, date(Y,M,D)) :-
+	Z is N + 719468,
+	Era is Z // 146097,
+	Doe is Z - Era * 146097,
+	Yoe is (Doe - Doe // 1460 + Doe // 36524 - Doe // 146096) // 365,
+	Doy is Doe - (365 * Yoe + Yoe // 4 - Yoe // 100),
+	Mp is (5 * Doy + 2) // 153,
+	D is Doy - (153 * Mp + 2) // 5 + 1,
+	( Mp < 10 -> M is Mp + 3 ; M is Mp - 9 ),
+	( M =< 2 -> Y is Yoe + Era * 400 + 1 ; Y is Yoe + Era * 400 ).
+
+lower_atom(A, L) :-
+	atom_codes(A, Cs),
+	lower_codes(Cs, Ls),
+	atom_codes(L, Ls).
+
+lower_codes([], []).
+lower_codes([C|Cs], [L|Ls]) :-
+	( C >= 65, C =< 90 -> L is C + 32 ; L = C ),
+	lower_codes(Cs, Ls).
+
+today(date(2018,3,1)).
+
+'person#first'(A, B) :- B = person(A,_,_).
+'person#last'(A, B) :- B = person(_,A,_).
+'person#email'(A, B) :- B = person(_,_,A).
+
+
+isJohn(P) :- 
+	'person#first'(V_1, P),
+	lower_atom(V_1, V_2),
+	sub_atom(V_2, 0, _, _, 'jo'),
+	'person#first'(V_3, P),
+	'person#last'(V_4, P),
+	atom_concat(V_3, V_4, V_5),
+	atom_length(V_5, V_6),
+	@=<(V_6,12).
+
+validEmail(P) :- 
+	'person#email'(V_7, P),
+	matches(V_7,'^[a-z.]+@[a-z]+[.][a-z]+$'),
+	'person#email'(V_8, P),
+	'person#last'(V_9, P),
+	lower_atom(V_9, V_10),
+	sub_atom(V_8, _, _, _, V_10).
+
+sameName(A,B) :- 
+	'person#first'(V_11, A),
+	atom_concat(V_11, ' ', V_12),
+	'person#last'(V_13, A),
+	atom_concat(V_12, V_13, V_14),
+	Full = V_14,
+	'person#first'(V_15, B),
+	atom_concat(V_15, ' ', V_16),
+	'person#last'(V_17, B),
+	atom_concat(V_16, V_17, V_18),
+	==(Full,V_18).
+
+test('John') :- P = person('Johnny','Smith','johnny.smith@example.org'),isJohn(P),validEmail(P).
+
+test('Name too long (fails)') :- P = person('Jonathan','Livingston','jl@example.org'),isJohn(P).
+
+test('Email without last name (fails)') :- P = person('Jonathan','Livingston','jl@example.org'),validEmail(P).
+
+test('Same name') :- A = person('Ann','Lee',_),B = person('Ann','Lee','ann@example.org'),sameName(A,B).
+
+test('Different name (fails)') :- A = person('Ann','Lee',_),B = person('Anne','Lee',_),sameName(A,B).
+
+test_cases(['John','Name too long (fails)','Email without last name (fails)','Same name','Different name (fails)']).
+expected_failure('Name too long (fails)').
+expected_failure('Email without last name (fails)').
+expected_failure('Different name (fails)').
+
+run_rulebase_tests :-
+	test_cases(List),
+	run_test_cases(List, Status),
+	write('Test '), write(Status), nl.
+
+run_test_cases([], success).
+run_test_cases([H|T], Status) :-
+	passes(H), !,
+	run_test_cases(T, Status).
+run_test_cases([H|T], failure) :-
+	\+(passes(H)), !,
+	write('Test case failed: '), write(H), nl,
+	run_test_cases(T, _).
+
+passes(H) :- expected_failure(H), !, \+(test(H)).
+passes(H) :- test(H).
+
+:- begin_tests(strings).
+test('John') :- strings:test('John').
+test('Name too long (fails)', [fail]) :- strings:test('Name too long (fails)').
+test('Email without last name (fails)', [fail]) :- strings:test('Email without last name (fails)').
+test('Same name') :- strings:test('Same name').
+test('Different name (fails)', [fail]) :- strings:test('Different name (fails)').
+:- end_tests(strings).
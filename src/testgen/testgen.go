@@ -0,0 +1,997 @@
+package testgen
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eval"
+	"model"
+)
+
+// Examples are tests generated for a rule: the first holds, and
+// each of the others changes one field of it, or leaves out one
+// relation fact, so that the rule fails. Those names end in (fails),
+// so every runner expects them not to hold.
+type Examples struct {
+	Rule  string
+	Tests []model.Test
+	// values were solved against today's date, tests
+	// around a boundary may change outcome on another day
+	Today bool
+}
+
+const (
+	// assignments of values tried per round
+	maxTries = 10000
+	// rounds of solving the conditions again against
+	// the assignment that satisfied most of them
+	rounds = 3
+)
+
+// Generate finds inputs for which a rule holds and inputs for which
+// it fails. The conditions of the rule, with the rules it calls
+// inlined, are solved for each field they compare, which gives its
+// boundary: p.age >= 18 gives 18, tried with 17 and 19 around it.
+// Candidates are checked in the interpreter, a condition that
+// cannot be solved for a field only means fewer examples.
+func Generate(ir model.InternalRepresentation, rule string) (Examples, error) {
+	r, ok := ir.Rules[rule]
+	if !ok {
+		return Examples{}, fmt.Errorf("undefined rule %s", rule)
+	}
+	in := eval.New()
+	if err := in.Load(ir); err != nil {
+		return Examples{}, err
+	}
+	g := &generator{ir: ir, in: in, rule: r, index: map[string]int{}, types: map[string]model.Token{}, forced: map[string]interface{}{}, dropped: map[int]bool{}}
+	call := model.Expression{Functor: r.Name}
+	for _, a := range r.Args {
+		object := ""
+		if a.TypeInfo == model.OBJECT {
+			object = a.ObjectName()
+		}
+		g.declare(a.Value.(string), a.TypeInfo, object)
+		call.Args = append(call.Args, model.IdentifierTerm(a.Value.(string)))
+	}
+	g.inline(r, call, 0)
+	if err := g.instantiable(); err != nil {
+		return Examples{}, fmt.Errorf("rule %s: %s", rule, err)
+	}
+	if len(g.objects()) == 0 {
+		return Examples{}, fmt.Errorf("rule %s: a test needs an object as input", rule)
+	}
+
+	examples := Examples{Rule: rule, Today: g.today}
+	assign, ok := g.search()
+	if !ok {
+		return examples, fmt.Errorf("rule %s: no inputs found for which it holds", rule)
+	}
+	// relation facts the rule holds without are left out, the
+	// last first so a recursive rule keeps its base case
+	for i := len(g.atoms) - 1; i >= 0; i-- {
+		a := g.atoms[i]
+		if _, ok := ir.Relations[a.Functor]; ok {
+			g.dropped[i] = true
+			if holds, err := g.holds(assign, -1); err != nil || !holds {
+				delete(g.dropped, i)
+			}
+		}
+	}
+	name := strings.TrimPrefix(rule, ir.Package+".")
+	examples.Tests = append(examples.Tests, g.test(name, assign, -1))
+	seen := map[string]bool{}
+	add := func(t model.Test) {
+		if !seen[t.Name] {
+			seen[t.Name] = true
+			examples.Tests = append(examples.Tests, t)
+		}
+	}
+	used := g.used()
+	for _, v := range g.vars {
+		if !used[strings.Split(v, ".")[0]] {
+			continue
+		}
+		// each bound of each condition gives the closest value past
+		// it, for which the condition fails and so does the rule
+		failing := false
+		for _, b := range g.bounds(v, assign) {
+			if c, ok := g.failing(v, b.values, assign, b.atom); ok {
+				add(g.test(fmt.Sprintf("%s with %s %s (fails)", name, g.display(v), show(c)), g.with(assign, v, c), -1))
+				failing = true
+			}
+		}
+		// or else the closest value for which the rule fails
+		if !failing {
+			if c, ok := g.failing(v, g.candidates(v, assign), assign, -1); ok {
+				add(g.test(fmt.Sprintf("%s with %s %s (fails)", name, g.display(v), show(c)), g.with(assign, v, c), -1))
+			}
+		}
+	}
+	for i, a := range g.atoms {
+		if _, ok := ir.Relations[a.Functor]; !ok || g.dropped[i] {
+			continue
+		}
+		if holds, err := g.holds(assign, i); err == nil && !holds {
+			add(g.test(fmt.Sprintf("%s without %s (fails)", name, g.fact(a, g.bindings(assign)).text), assign, i))
+		}
+	}
+	return examples, nil
+}
+
+// a root is an input of the rule, a variable declared with let, or
+// an object referred to by a field of another, such as p.cell
+type root struct {
+	// inputs by name, lets as name#n and nested objects by path
+	name   string
+	typ    model.Token
+	object string
+}
+
+type generator struct {
+	ir   model.InternalRepresentation
+	in   *eval.Interpreter
+	rule model.Rule
+
+	roots []root
+	index map[string]int
+	// paths of the fields and scalar roots compared, in order
+	vars  []string
+	types map[string]model.Token
+	// values of fields only there to instantiate an object
+	forced map[string]interface{}
+	// comparisons, predicates and relation calls in the rule and
+	// the rules it calls, over roots
+	atoms []model.Expression
+	// relation calls left out of the facts
+	dropped map[int]bool
+	today   bool
+	model.Inliner
+}
+
+func (g *generator) declare(name string, typ model.Token, object string) {
+	if _, ok := g.index[name]; ok {
+		return
+	}
+	g.index[name] = len(g.roots)
+	g.roots = append(g.roots, root{name, typ, object})
+	if typ != model.OBJECT {
+		g.variable(name, typ)
+	}
+}
+
+func (g *generator) variable(path string, typ model.Token) {
+	if _, ok := g.types[path]; !ok {
+		g.types[path] = typ
+		g.vars = append(g.vars, path)
+	}
+}
+
+// inline adds the conditions of each body of r, called with
+// the arguments of call
+func (g *generator) inline(r model.Rule, call model.Expression, depth int) {
+	for _, body := range r.Clauses() {
+		g.Clause(r, body, call.Args, func(v model.Term) {
+			object := ""
+			if v.TypeInfo == model.OBJECT {
+				object = v.ObjectName()
+			}
+			g.declare(v.Value.(string), v.TypeInfo, object)
+		}, func(call model.Expression) {
+			if r, ok := g.ir.Rules[call.Functor]; ok {
+				if depth < model.InlineDepth {
+					g.inline(r, call, depth+1)
+				}
+				return
+			}
+			g.atoms = append(g.atoms, call)
+			g.variables(call)
+		})
+	}
+}
+
+// variables adds the fields n compares, and the objects they are in
+func (g *generator) variables(n model.Node) {
+	e, ok := n.(model.Expression)
+	if !ok {
+		return
+	}
+	if e.Functor == "today" {
+		g.today = true
+	}
+	if p, ok := path(e); ok {
+		if typ, _, ok := g.resolve(p); ok && typ != model.OBJECT {
+			g.variable(p, typ)
+		}
+		return
+	}
+	for _, a := range e.Args {
+		g.variables(a)
+	}
+}
+
+// path returns a root or field access as p.cell.number
+func path(n model.Node) (string, bool) {
+	switch n := n.(type) {
+	case model.Term:
+		if n.TypeInfo == model.IDENT || n.TypeInfo == model.OBJECT {
+			return n.Value.(string), true
+		}
+	case model.Expression:
+		if n.Functor == "." {
+			p, ok := path(n.Args[0])
+			return p + "." + n.Args[1].(model.Term).Value.(string), ok
+		}
+	}
+	return "", false
+}
+
+// resolve returns the type of a path, declaring the objects on it
+func (g *generator) resolve(p string) (model.Token, string, bool) {
+	parts := strings.Split(p, ".")
+	i, ok := g.index[parts[0]]
+	if !ok {
+		return model.ILLEGAL, "", false
+	}
+	typ, object := g.roots[i].typ, g.roots[i].object
+	for j, name := range parts[1:] {
+		if typ != model.OBJECT {
+			return model.ILLEGAL, "", false
+		}
+		found := false
+		for _, f := range g.ir.Objects[object].Fields {
+			if f.Name != name {
+				continue
+			}
+			found = true
+			typ, object = f.TypeInfo, ""
+			if typ == model.OBJECT {
+				object = f.ObjectName()
+				g.declare(strings.Join(parts[:j+2], "."), typ, object)
+			}
+		}
+		if !found {
+			return model.ILLEGAL, "", false
+		}
+	}
+	return typ, object, true
+}
+
+// instantiable gives each object a field to instantiate it with,
+// a test instantiates objects with at least one field. The values
+// differ between objects, since instances with equal fields unify.
+func (g *generator) instantiable() error {
+	for i := 0; i < len(g.roots); i++ {
+		r := g.roots[i]
+		if r.typ != model.OBJECT || g.hasFields(r.name) {
+			continue
+		}
+		fields := g.ir.Objects[r.object].Fields
+		if len(fields) == 0 {
+			return fmt.Errorf("object %s has no fields", r.object)
+		}
+		if _, _, ok := g.resolve(r.name + "." + fields[0].Name); !ok {
+			return fmt.Errorf("undefined object %s", r.object)
+		}
+		var v interface{}
+		switch fields[0].TypeInfo {
+		case model.OBJECT:
+			continue
+		case model.INT:
+			v = len(g.forced) + 1
+		case model.FLOAT:
+			v = float64(len(g.forced) + 1)
+		case model.STRING:
+			v = g.names()[r.name]
+		default:
+			v = initial(fields[0].TypeInfo)
+		}
+		g.forced[r.name+"."+fields[0].Name] = v
+	}
+	return nil
+}
+
+func (g *generator) hasFields(name string) bool {
+	for _, v := range g.vars {
+		if strings.HasPrefix(v, name+".") {
+			return true
+		}
+	}
+	for p := range g.forced {
+		if strings.HasPrefix(p, name+".") {
+			return true
+		}
+	}
+	for _, r := range g.roots {
+		if strings.HasPrefix(r.name, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) objects() []root {
+	objects := []root{}
+	for _, r := range g.roots {
+		if r.typ == model.OBJECT {
+			objects = append(objects, r)
+		}
+	}
+	return objects
+}
+
+// used returns the inputs of the rule and the roots
+// in the relation facts, by name
+func (g *generator) used() map[string]bool {
+	used := map[string]bool{}
+	for _, a := range g.rule.Args {
+		used[a.Value.(string)] = true
+	}
+	for i, a := range g.atoms {
+		if _, ok := g.ir.Relations[a.Functor]; !ok || g.dropped[i] {
+			continue
+		}
+		for _, n := range a.Args {
+			if p, ok := path(n); ok {
+				used[strings.Split(p, ".")[0]] = true
+			}
+		}
+	}
+	return used
+}
+
+// bindings instantiates the roots with the values assigned
+func (g *generator) bindings(assign map[string]interface{}) map[string]interface{} {
+	bindings := map[string]interface{}{}
+	// nested objects are declared after the object holding them
+	for i := len(g.roots) - 1; i >= 0; i-- {
+		r := g.roots[i]
+		if r.typ != model.OBJECT {
+			bindings[r.name] = assign[r.name]
+			continue
+		}
+		values := map[string]interface{}{}
+		for _, f := range g.ir.Objects[r.object].Fields {
+			p := r.name + "." + f.Name
+			if v, ok := bindings[p]; ok {
+				values[f.Name] = v
+			} else if v, ok := assign[p]; ok {
+				values[f.Name] = v
+			} else if v, ok := g.forced[p]; ok {
+				values[f.Name] = v
+			}
+		}
+		bindings[r.name] = eval.NewInstance(g.ir.Objects[r.object], values)
+	}
+	return bindings
+}
+
+// facts asserts the relation calls, but the one at index drop
+func (g *generator) facts(bindings map[string]interface{}, drop int) eval.Facts {
+	facts := eval.Facts{}
+	for i, a := range g.atoms {
+		if _, ok := g.ir.Relations[a.Functor]; !ok || i == drop || g.dropped[i] {
+			continue
+		}
+		args := []interface{}{}
+		for _, n := range a.Args {
+			v, _ := g.in.Value(n, bindings)
+			args = append(args, v)
+		}
+		facts[a.Functor] = append(facts[a.Functor], args)
+	}
+	return facts
+}
+
+func (g *generator) holds(assign map[string]interface{}, drop int) (bool, error) {
+	bindings := g.bindings(assign)
+	call := model.Expression{Functor: g.rule.Name}
+	for _, a := range g.rule.Args {
+		call.Args = append(call.Args, model.IdentifierTerm(a.Value.(string)))
+	}
+	return g.in.Solve(g.facts(bindings, drop), []model.Expression{call}, bindings)
+}
+
+// score counts the conditions an assignment satisfies
+func (g *generator) score(assign map[string]interface{}) int {
+	bindings := g.bindings(assign)
+	facts := g.facts(bindings, -1)
+	n := 0
+	for _, a := range g.atoms {
+		if holds, err := g.in.Solve(facts, []model.Expression{a}, bindings); err == nil && holds {
+			n++
+		}
+	}
+	return n
+}
+
+// search tries the candidates of each variable together, boundaries
+// first, and returns the first assignment for which the rule holds
+func (g *generator) search() (map[string]interface{}, bool) {
+	assign := map[string]interface{}{}
+	for _, v := range g.vars {
+		assign[v] = initial(g.types[v])
+	}
+	for round := 0; round < rounds; round++ {
+		candidates := make([][]interface{}, len(g.vars))
+		for i, v := range g.vars {
+			candidates[i] = g.candidates(v, assign)
+		}
+		best, bestScore := assign, -1
+		indices := make([]int, len(g.vars))
+		for try := 0; try < maxTries; try++ {
+			a := map[string]interface{}{}
+			for i, v := range g.vars {
+				a[v] = candidates[i][indices[i]]
+			}
+			if holds, err := g.holds(a, -1); err == nil && holds {
+				return a, true
+			}
+			if score := g.score(a); score > bestScore {
+				best, bestScore = a, score
+			}
+			if !next(indices, candidates) {
+				break
+			}
+		}
+		assign = best
+	}
+	return nil, false
+}
+
+// next advances indices over the candidates like an odometer,
+// the last variable fastest
+func next(indices []int, candidates [][]interface{}) bool {
+	for i := len(indices) - 1; i >= 0; i-- {
+		indices[i]++
+		if indices[i] < len(candidates[i]) {
+			return true
+		}
+		indices[i] = 0
+	}
+	return false
+}
+
+// initial returns the value a variable starts out with, numbers
+// start at 1 so they can be divided by
+func initial(typ model.Token) interface{} {
+	switch typ {
+	case model.INT:
+		return 1
+	case model.FLOAT:
+		return 1.0
+	case model.STRING:
+		return ""
+	case model.DATE:
+		return model.Today()
+	case model.DURATION:
+		return model.Duration{}
+	}
+	return nil
+}
+
+// candidates returns the values solving the conditions on v, with
+// the others as assigned, each with the values just around it. The
+// value assigned and those around it come last.
+func (g *generator) candidates(v string, assign map[string]interface{}) []interface{} {
+	values := []interface{}{}
+	for _, b := range g.bounds(v, assign) {
+		values = append(values, b.values...)
+	}
+	return unique(append(values, around(assign[v])...))
+}
+
+// a bound of a condition on a variable: the values solving it
+// with the values just around them
+type bound struct {
+	atom   int
+	values []interface{}
+}
+
+// bounds returns the bounds of the conditions on v, with the
+// others as assigned: one per side of a comparison
+func (g *generator) bounds(v string, assign map[string]interface{}) []bound {
+	bindings := g.bindings(assign)
+	bounds := []bound{}
+	for i, a := range g.atoms {
+		for _, solved := range g.solve(a, v, bindings) {
+			b := bound{atom: i}
+			for _, t := range solved {
+				for _, c := range coerce(t, g.types[v]) {
+					b.values = append(b.values, around(c)...)
+				}
+			}
+			if b.values = unique(b.values); len(b.values) > 0 {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	return bounds
+}
+
+// failing returns the value among values closest to the one
+// assigned to v for which the rule fails, and the atom at index
+// atom too unless it is -1
+func (g *generator) failing(v string, values []interface{}, assign map[string]interface{}, atom int) (interface{}, bool) {
+	values = append([]interface{}{}, values...)
+	sort.SliceStable(values, func(i, j int) bool {
+		return distance(values[i], assign[v]) < distance(values[j], assign[v])
+	})
+	for _, c := range values {
+		if same(c, assign[v]) {
+			continue
+		}
+		changed := g.with(assign, v, c)
+		if holds, err := g.holds(changed, -1); err != nil || holds {
+			continue
+		}
+		if atom < 0 {
+			return c, true
+		}
+		bindings := g.bindings(changed)
+		holds, err := g.in.Solve(g.facts(bindings, -1), []model.Expression{g.atoms[atom]}, bindings)
+		if err == nil && !holds {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// with returns a copy of assign with v changed to c
+func (g *generator) with(assign map[string]interface{}, v string, c interface{}) map[string]interface{} {
+	changed := copyOf(assign)
+	changed[v] = c
+	return changed
+}
+
+// unique drops the duplicates of values, and those a test
+// cannot be written with
+func unique(values []interface{}) []interface{} {
+	unique := []interface{}{}
+	for _, c := range values {
+		if !writable(c) {
+			continue
+		}
+		dup := false
+		for _, u := range unique {
+			dup = dup || same(c, u)
+		}
+		if !dup {
+			unique = append(unique, c)
+		}
+	}
+	return unique
+}
+
+// solve returns the values of v at which a condition changes,
+// grouped by bound
+func (g *generator) solve(a model.Expression, v string, bindings map[string]interface{}) [][]interface{} {
+	switch a.Functor {
+	// a string starts with and contains itself
+	case "==", "!=", "<", "<=", ">", ">=", "startsWith", "contains":
+		bounds := [][]interface{}{}
+		for i := 0; i < 2; i++ {
+			side, other := a.Args[i], a.Args[1-i]
+			if !mentions(side, v) || mentions(other, v) {
+				continue
+			}
+			// the values around the boundary are solved for too,
+			// x / 100 > 2 is at x = 200 but holds from x = 300
+			if t, err := g.in.Value(other, bindings); err == nil {
+				values := []interface{}{}
+				for _, t := range around(t) {
+					values = append(values, g.invert(side, v, t, bindings)...)
+				}
+				bounds = append(bounds, values)
+			}
+		}
+		return bounds
+	case "matches":
+		if mentions(a.Args[1], v) {
+			return nil
+		}
+		pattern, err := g.in.Value(a.Args[1], bindings)
+		if err != nil {
+			return nil
+		}
+		if s, ok := pattern.(string); ok {
+			if t, ok := matching(s); ok {
+				return [][]interface{}{g.invert(a.Args[0], v, t, bindings)}
+			}
+		}
+	}
+	return nil
+}
+
+func mentions(n model.Node, v string) bool {
+	if p, ok := path(n); ok {
+		return p == v
+	}
+	if e, ok := n.(model.Expression); ok {
+		for _, a := range e.Args {
+			if mentions(a, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// invert returns the values of v for which n evaluates to t
+func (g *generator) invert(n model.Node, v string, t interface{}, bindings map[string]interface{}) []interface{} {
+	if p, ok := path(n); ok {
+		if p == v {
+			return []interface{}{t}
+		}
+		return nil
+	}
+	e, ok := n.(model.Expression)
+	if !ok {
+		return nil
+	}
+	switch e.Functor {
+	case "lower":
+		return g.invert(e.Args[0], v, t, bindings)
+	case "length":
+		if n, ok := t.(int); ok && n >= 0 {
+			return g.invert(e.Args[0], v, strings.Repeat("a", n), bindings)
+		}
+		return nil
+	case "+", "-", "*", "/", "%", "concat":
+	default:
+		return nil
+	}
+	i := 0
+	if mentions(e.Args[1], v) {
+		i = 1
+	}
+	o, err := g.in.Value(e.Args[1-i], bindings)
+	if err != nil {
+		return nil
+	}
+	op, a, b := "", t, o
+	switch {
+	// x % m = t for x = t and the values m away from it
+	case e.Functor == "%":
+		m, ok := o.(int)
+		if _, isInt := t.(int); !ok || !isInt || i != 0 {
+			return nil
+		}
+		values := []interface{}{}
+		for _, x := range []int{t.(int), t.(int) - m, t.(int) + m} {
+			values = append(values, g.invert(e.Args[0], v, x, bindings)...)
+		}
+		return values
+	case e.Functor == "concat" || e.Functor == "+" && isString(t):
+		s, other := t.(string), fmt.Sprint(o)
+		if i == 0 && strings.HasSuffix(s, other) {
+			return g.invert(e.Args[0], v, strings.TrimSuffix(s, other), bindings)
+		}
+		if i == 1 && strings.HasPrefix(s, other) {
+			return g.invert(e.Args[1], v, strings.TrimPrefix(s, other), bindings)
+		}
+		return nil
+	case e.Functor == "+":
+		op = "-"
+	case e.Functor == "-" && i == 0:
+		op = "+"
+	case e.Functor == "-":
+		op, a, b = "-", o, t
+	case e.Functor == "*":
+		op = "/"
+	case i == 0:
+		op = "*"
+	default:
+		op, a, b = "/", o, t
+	}
+	x, err := g.in.Value(model.Expression{Functor: op, Args: []model.Node{literal(a), literal(b)}}, nil)
+	if err != nil {
+		return nil
+	}
+	return g.invert(e.Args[i], v, x, bindings)
+}
+
+func isString(v interface{}) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+// matching returns a string matching a regular expression, taking
+// the first of each alternative and character class, and repeating
+// as few times as allowed
+func matching(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	s, ok := sample(re)
+	if !ok {
+		return "", false
+	}
+	matched, err := regexp.MatchString(pattern, s)
+	return s, err == nil && matched
+}
+
+func sample(re *syntax.Regexp) (string, bool) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune), true
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return "", false
+		}
+		return string(re.Rune[0]), true
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return "a", true
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary, syntax.OpStar, syntax.OpQuest:
+		return "", true
+	case syntax.OpCapture, syntax.OpPlus, syntax.OpAlternate:
+		return sample(re.Sub[0])
+	case syntax.OpRepeat:
+		s, ok := sample(re.Sub[0])
+		return strings.Repeat(s, re.Min), ok
+	case syntax.OpConcat:
+		var b strings.Builder
+		for _, sub := range re.Sub {
+			s, ok := sample(sub)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(s)
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// coerce converts a value to the type of a variable,
+// a float for an int gives the ints on either side
+func coerce(v interface{}, typ model.Token) []interface{} {
+	switch x := v.(type) {
+	case int:
+		if typ == model.FLOAT {
+			return []interface{}{float64(x)}
+		}
+	case float64:
+		if typ == model.INT {
+			return []interface{}{int(math.Floor(x)), int(math.Ceil(x))}
+		}
+	}
+	if literal(v).TypeInfo != typ {
+		return nil
+	}
+	return []interface{}{v}
+}
+
+// around returns a boundary value with the values next to it
+func around(v interface{}) []interface{} {
+	switch x := v.(type) {
+	case int:
+		return []interface{}{x, x - 1, x + 1}
+	case float64:
+		return []interface{}{x, x - 0.5, x + 0.5}
+	case string:
+		return []interface{}{x, x + "a", ""}
+	case time.Time:
+		return []interface{}{x, x.AddDate(0, 0, -1), x.AddDate(0, 0, 1)}
+	case model.Duration:
+		before, after := x, x
+		before.Days--
+		after.Days++
+		return []interface{}{x, before, after}
+	}
+	return []interface{}{v}
+}
+
+func literal(v interface{}) model.Term {
+	switch x := v.(type) {
+	case int:
+		return model.IntTerm(x)
+	case float64:
+		return model.Term{Value: x, TypeInfo: model.FLOAT}
+	case string:
+		return model.StringTerm(x)
+	case time.Time:
+		return model.DateTerm(x)
+	case model.Duration:
+		return model.DurationTerm(x)
+	}
+	return model.Term{Value: v}
+}
+
+// writable reports whether a test can instantiate a field with v:
+// literals have no sign, and strings no quotes
+func writable(v interface{}) bool {
+	switch x := v.(type) {
+	case int:
+		return x >= 0
+	case float64:
+		return x >= 0
+	case string:
+		return !strings.Contains(x, `"`)
+	case model.Duration:
+		return x.Years >= 0 && x.Months >= 0 && x.Days >= 0
+	}
+	return true
+}
+
+// distance orders the values failing a rule by how close they
+// are to one for which it holds, those not numbers or dates keep
+// their order
+func distance(a, b interface{}) float64 {
+	switch x := a.(type) {
+	case int:
+		if y, ok := b.(int); ok {
+			return math.Abs(float64(x - y))
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			return math.Abs(x - y)
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return math.Abs(x.Sub(y).Hours())
+		}
+	}
+	return 0
+}
+
+func same(a, b interface{}) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return a == b
+}
+
+func copyOf(assign map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{}
+	for k, v := range assign {
+		c[k] = v
+	}
+	return c
+}
+
+// names gives the roots their names in a test: inputs keep theirs,
+// lets lose the #n and nested objects are named after their field
+func (g *generator) names() map[string]string {
+	names, used := map[string]string{}, map[string]bool{}
+	for _, r := range g.roots {
+		name := r.name
+		if i := strings.LastIndexAny(name, "#."); i >= 0 {
+			if name[i] == '#' {
+				name = name[:i]
+			} else {
+				name = name[i+1:]
+			}
+		}
+		unique := name
+		for n := 2; used[unique]; n++ {
+			unique = name + strconv.Itoa(n)
+		}
+		used[unique] = true
+		names[r.name] = unique
+	}
+	return names
+}
+
+// display returns a path with its root as named in a test
+func (g *generator) display(p string) string {
+	parts := strings.SplitN(p, ".", 2)
+	parts[0] = g.names()[parts[0]]
+	return strings.Join(parts, ".")
+}
+
+// test writes an assignment as a test of the rule, leaving
+// out the relation fact at index drop
+func (g *generator) test(name string, assign map[string]interface{}, drop int) model.Test {
+	names := g.names()
+	bindings := g.bindings(assign)
+	t := model.Test{Name: name}
+	done := map[string]bool{}
+	var instantiate func(r root)
+	instantiate = func(r root) {
+		if done[r.name] {
+			return
+		}
+		done[r.name] = true
+		fact := model.Expression{Functor: "new", Args: []model.Node{model.ObjectTerm(names[r.name], r.object)}}
+		for _, f := range g.ir.Objects[r.object].Fields {
+			p := r.name + "." + f.Name
+			if i, ok := g.index[p]; ok {
+				// objects are instantiated before those referring to them
+				instantiate(g.roots[i])
+				fact.Args = append(fact.Args, model.FieldTerm(f.Name, names[p]))
+			} else if v, ok := assign[p]; ok {
+				fact.Args = append(fact.Args, model.FieldTerm(f.Name, v))
+			} else if v, ok := g.forced[p]; ok {
+				fact.Args = append(fact.Args, model.FieldTerm(f.Name, v))
+			}
+		}
+		t.Facts = append(t.Facts, fact)
+	}
+	used := g.used()
+	for _, r := range g.objects() {
+		if used[r.name] {
+			instantiate(r)
+		}
+	}
+	for i, a := range g.atoms {
+		if _, ok := g.ir.Relations[a.Functor]; ok && i != drop && !g.dropped[i] {
+			t.Facts = append(t.Facts, g.fact(a, bindings).expression)
+		}
+	}
+	call := model.Expression{Functor: g.rule.Name}
+	for _, a := range g.rule.Args {
+		call.Args = append(call.Args, g.arg(model.IdentifierTerm(a.Value.(string)), bindings, names))
+	}
+	t.Body = []model.Expression{call}
+	return t
+}
+
+type fact struct {
+	expression model.Expression
+	text       string
+}
+
+// fact returns a relation call as a fact of a test, and as text
+func (g *generator) fact(a model.Expression, bindings map[string]interface{}) fact {
+	names := g.names()
+	f := fact{expression: model.Expression{Functor: a.Functor}}
+	args := []string{}
+	for _, n := range a.Args {
+		arg := g.arg(n, bindings, names)
+		f.expression.Args = append(f.expression.Args, arg)
+		if arg.TypeInfo == model.IDENT {
+			args = append(args, arg.Value.(string))
+		} else {
+			args = append(args, show(arg.Value))
+		}
+	}
+	f.text = strings.TrimPrefix(a.Functor, g.ir.Package+".") + "(" + strings.Join(args, ", ") + ")"
+	return f
+}
+
+// arg returns an object by name and any other value as a literal
+func (g *generator) arg(n model.Node, bindings map[string]interface{}, names map[string]string) model.Term {
+	if p, ok := path(n); ok {
+		if i, ok := g.index[p]; ok && g.roots[i].typ == model.OBJECT {
+			return model.IdentifierTerm(names[p])
+		}
+	}
+	v, _ := g.in.Value(n, bindings)
+	return literal(v)
+}
+
+// show prints a value for a test name, which cannot hold quotes
+func show(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return "'" + x + "'"
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.Format("2006-01-02")
+	case model.Duration:
+		s := "P"
+		for _, part := range []struct {
+			n    int
+			unit string
+		}{{x.Years, "Y"}, {x.Months, "M"}, {x.Days, "D"}} {
+			if part.n != 0 {
+				s += strconv.Itoa(part.n) + part.unit
+			}
+		}
+		if s == "P" {
+			s = "P0D"
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,330 @@
+package testgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"format"
+	"model"
+)
+
+const src = `object cell {
+	number : int
+}
+
+object prisoner {
+	age      : int,
+	name     : string,
+	admitted : date,
+	cell     : cell
+}
+
+relation cellmates {
+	p        : prisoner,
+	cellmate : prisoner
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+rule phonecall {
+	input {
+		p : prisoner
+	}
+	rules {
+		adult(p),
+		p.admitted + 6 months <= today
+	}
+}
+
+rule adultCellmate {
+	input {
+		p : prisoner
+	}
+	rules {
+		let c : prisoner,
+		cellmates(p, c),
+		adult(c)
+	}
+}
+
+rule upstairs {
+	input {
+		p : prisoner
+	}
+	rules {
+		let floor = p.cell.number / 100,
+		floor > 2
+	}
+}
+
+rule named {
+	input {
+		p : prisoner
+	}
+	rules {
+		startsWith(p.name, "jo"),
+		length(p.name) <= 4
+	}
+}
+
+rule working {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18,
+		p.age < 65
+	}
+}
+
+rule positive {
+	input {
+		n : int
+	}
+	rules {
+		n > 0
+	}
+}
+`
+
+func TestGenerate(t *testing.T) {
+	today := model.Today
+	model.Today = func() time.Time {
+		return time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	}
+	defer func() { model.Today = today }()
+
+	ir := model.Read(src)
+	for i, tt := range []struct {
+		rule  string
+		today bool
+		want  string
+	}{
+		{
+			rule: "adult",
+			want: `test "adult" {
+	facts {
+		p : prisoner { age : 18 }
+	}
+	rules {
+		adult(p)
+	}
+}
+
+test "adult with p.age 17 (fails)" {
+	facts {
+		p : prisoner { age : 17 }
+	}
+	rules {
+		adult(p)
+	}
+}
+`,
+		},
+		{
+			rule:  "phonecall",
+			today: true,
+			want: `test "phonecall" {
+	facts {
+		p : prisoner { age : 18, admitted : 2017-09-01 }
+	}
+	rules {
+		phonecall(p)
+	}
+}
+
+test "phonecall with p.age 17 (fails)" {
+	facts {
+		p : prisoner { age : 17, admitted : 2017-09-01 }
+	}
+	rules {
+		phonecall(p)
+	}
+}
+
+test "phonecall with p.admitted 2017-09-02 (fails)" {
+	facts {
+		p : prisoner { age : 18, admitted : 2017-09-02 }
+	}
+	rules {
+		phonecall(p)
+	}
+}
+`,
+		},
+		// the cellmate is instantiated, and can be left out
+		{
+			rule: "adultCellmate",
+			want: `test "adultCellmate" {
+	facts {
+		p : prisoner { age : 1 },
+		c : prisoner { age : 18 },
+		cellmates(p, c)
+	}
+	rules {
+		adultCellmate(p)
+	}
+}
+
+test "adultCellmate with c.age 17 (fails)" {
+	facts {
+		p : prisoner { age : 1 },
+		c : prisoner { age : 17 },
+		cellmates(p, c)
+	}
+	rules {
+		adultCellmate(p)
+	}
+}
+
+test "adultCellmate without cellmates(p, c) (fails)" {
+	facts {
+		p : prisoner { age : 1 },
+		c : prisoner { age : 18 }
+	}
+	rules {
+		adultCellmate(p)
+	}
+}
+`,
+		},
+		{
+			rule: "upstairs",
+			want: `test "upstairs" {
+	facts {
+		cell : cell { number : 300 },
+		p    : prisoner { cell : cell }
+	}
+	rules {
+		upstairs(p)
+	}
+}
+
+test "upstairs with p.cell.number 299 (fails)" {
+	facts {
+		cell : cell { number : 299 },
+		p    : prisoner { cell : cell }
+	}
+	rules {
+		upstairs(p)
+	}
+}
+`,
+		},
+		{
+			rule: "named",
+			want: `test "named" {
+	facts {
+		p : prisoner { name : jo }
+	}
+	rules {
+		named(p)
+	}
+}
+
+test "named with p.name '' (fails)" {
+	facts {
+		p : prisoner { name : "" }
+	}
+	rules {
+		named(p)
+	}
+}
+
+test "named with p.name 'aaaaa' (fails)" {
+	facts {
+		p : prisoner { name : aaaaa }
+	}
+	rules {
+		named(p)
+	}
+}
+`,
+		},
+		// one failing test per bound
+		{
+			rule: "working",
+			want: `test "working" {
+	facts {
+		p : prisoner { age : 18 }
+	}
+	rules {
+		working(p)
+	}
+}
+
+test "working with p.age 17 (fails)" {
+	facts {
+		p : prisoner { age : 17 }
+	}
+	rules {
+		working(p)
+	}
+}
+
+test "working with p.age 65 (fails)" {
+	facts {
+		p : prisoner { age : 65 }
+	}
+	rules {
+		working(p)
+	}
+}
+`,
+		},
+	} {
+		examples, err := Generate(ir, tt.rule)
+		if err != nil {
+			t.Errorf("%d): %s", i, err)
+			continue
+		}
+		if examples.Today != tt.today {
+			t.Errorf("%d): got today %v want %v", i, examples.Today, tt.today)
+		}
+		var b strings.Builder
+		tests := model.InternalRepresentation{Tests: examples.Tests}
+		for _, test := range examples.Tests {
+			tests.Declarations = append(tests.Declarations, model.Declaration{Kind: "test", Name: test.Name})
+		}
+		if err := format.Fprint(&b, tests); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%d): got\n%s\nwant\n%s", i, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	ir := model.Read(src + `
+rule never {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age > 18,
+		p.age < 18
+	}
+}
+`)
+	for i, tt := range []struct {
+		rule string
+		want string
+	}{
+		{"release", "undefined rule release"},
+		{"positive", "rule positive: a test needs an object as input"},
+		{"never", "rule never: no inputs found for which it holds"},
+	} {
+		if _, err := Generate(ir, tt.rule); err == nil || err.Error() != tt.want {
+			t.Errorf("%d): got %v want %s", i, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"deps"
+	"eval"
+	"model"
+)
+
+// Watcher runs the tests of the rulebases in a directory and,
+// when a file changes, reruns the tests that depend on what
+// changed. Each run prints the tests whose result differs from
+// the run before, and a summary.
+type Watcher struct {
+	dir string
+	// modification time and size of each .rules file
+	stamps map[string]stamp
+	// rulebase by file, relative to dir
+	files map[string]*rulebase
+}
+
+type stamp struct {
+	modified time.Time
+	size     int64
+}
+
+// a file with everything it imports, as last loaded
+type rulebase struct {
+	ir model.InternalRepresentation
+	// the tests declared in the file and their result by name
+	results map[string]result
+	// error loading or checking the file
+	err string
+}
+
+type result struct {
+	passed bool
+	err    error
+}
+
+func New(dir string) *Watcher {
+	return &Watcher{dir: dir, stamps: map[string]stamp{}, files: map[string]*rulebase{}}
+}
+
+// Watch runs the tests, then polls the directory for changes
+// every interval, until an error reading the directory
+func Watch(dir string, out io.Writer, interval time.Duration) error {
+	watcher := New(dir)
+	for {
+		if _, err := watcher.Poll(out); err != nil {
+			return err
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Poll reruns the tests if a .rules file was added, removed or
+// changed since the last poll, and reports whether one was
+func (w *Watcher) Poll(out io.Writer) (bool, error) {
+	stamps, err := w.scan()
+	if err != nil {
+		return false, err
+	}
+	changed := len(stamps) != len(w.stamps)
+	for path, s := range stamps {
+		if prev, ok := w.stamps[path]; !ok || prev != s {
+			changed = true
+		}
+	}
+	w.stamps = stamps
+	if changed {
+		w.run(out)
+	}
+	return changed, nil
+}
+
+func (w *Watcher) scan() (map[string]stamp, error) {
+	stamps := map[string]stamp{}
+	err := filepath.Walk(w.dir, func(path string, info os.FileInfo, err error) error {
+		// a file may vanish while walking, like an editor's swap
+		// file, only the directory watched has to be there
+		if err != nil && path == w.dir {
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && path != w.dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".rules") {
+			rel, err := filepath.Rel(w.dir, path)
+			if err != nil {
+				return err
+			}
+			stamps[rel] = stamp{info.ModTime(), info.Size()}
+		}
+		return nil
+	})
+	return stamps, err
+}
+
+// run reloads every file, since a file changes with the files it
+// imports, and runs the tests affected by the changes
+func (w *Watcher) run(out io.Writer) {
+	files := []string{}
+	for file := range w.stamps {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for file := range w.files {
+		if _, ok := w.stamps[file]; !ok {
+			delete(w.files, file)
+		}
+	}
+
+	ran := 0
+	for _, file := range files {
+		prev, ok := w.files[file]
+		if !ok {
+			prev = &rulebase{ir: empty(), results: map[string]result{}}
+			w.files[file] = prev
+		}
+		ir, invalid, err := load(w.dir, file)
+		if err != nil {
+			if err.Error() != prev.err {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					// parse errors already name the file
+					if !strings.HasPrefix(line, filepath.Clean(file)+": ") {
+						line = file + ": " + line
+					}
+					fmt.Fprintln(out, line)
+				}
+			}
+			prev.err = err.Error()
+			continue
+		}
+		prev.err = ""
+
+		own := map[string]bool{}
+		for _, d := range ir.Declarations {
+			if d.Kind == "test" && d.Pos.File == filepath.Clean(file) {
+				own[d.Name] = true
+			}
+		}
+		results := map[string]result{}
+		for name := range own {
+			if r, ok := prev.results[name]; ok {
+				results[name] = r
+			}
+		}
+		for _, n := range deps.Affected(prev.ir, ir, deps.Changed(prev.ir, ir)) {
+			if !own[n.Name] {
+				continue
+			}
+			r := result{err: invalid[n.Name]}
+			if r.err == nil {
+				r = test(ir, n.Name)
+			}
+			if before, ok := prev.results[n.Name]; !ok || before.passed != r.passed || !sameError(before.err, r.err) {
+				report(out, file, n.Name, r)
+			}
+			results[n.Name] = r
+			ran++
+		}
+		prev.ir, prev.results = ir, results
+	}
+
+	total, passing := 0, 0
+	for _, rb := range w.files {
+		for _, r := range rb.results {
+			total++
+			if r.passed {
+				passing++
+			}
+		}
+	}
+	fmt.Fprintf(out, "ran %d of %d tests, %d passing\n", ran, total, passing)
+}
+
+// Passed reports whether every file loaded and every test passed
+// in the last run
+func (w *Watcher) Passed() bool {
+	for _, rb := range w.files {
+		if rb.err != "" {
+			return false
+		}
+		for _, r := range rb.results {
+			if !r.passed {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func empty() model.InternalRepresentation {
+	ir, _ := model.Parse("")
+	return ir
+}
+
+// load loads a file with its imports and type checks it. Tests
+// that do not type check are returned with their error, so a test
+// can be written before the rule it tests.
+func load(dir, file string) (model.InternalRepresentation, map[string]error, error) {
+	ir, err := model.Load(dir, file)
+	if err != nil {
+		return ir, nil, err
+	}
+	invalid := map[string]error{}
+	msgs := []string{}
+	for _, err := range model.Check(ir) {
+		if cerr, ok := err.(model.CheckError); ok && cerr.Kind == "test" {
+			if invalid[cerr.Name] == nil {
+				invalid[cerr.Name] = cerr.Err
+			}
+			continue
+		}
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) > 0 {
+		return ir, nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	return ir, invalid, nil
+}
+
+// test runs the tests of the name in the interpreter
+func test(ir model.InternalRepresentation, name string) result {
+	tests := ir.Tests
+	ir.Tests = nil
+	for _, t := range tests {
+		if t.Name == name {
+			ir.Tests = append(ir.Tests, t)
+		}
+	}
+	in := eval.New()
+	if err := in.Load(ir); err != nil {
+		return result{err: err}
+	}
+	results, err := in.RunTests()
+	if err != nil {
+		return result{err: errors.New(strings.TrimPrefix(err.Error(), fmt.Sprintf("test %q: ", name)))}
+	}
+	for _, r := range results {
+		if !r.OK() {
+			return result{}
+		}
+	}
+	return result{passed: true}
+}
+
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Error() == b.Error()
+}
+
+func report(out io.Writer, file, name string, r result) {
+	switch {
+	case r.err != nil:
+		fmt.Fprintf(out, "FAIL %s: %s: %s\n", file, name, r.err)
+	case r.passed:
+		fmt.Fprintf(out, "ok   %s: %s\n", file, name)
+	default:
+		fmt.Fprintf(out, "FAIL %s: %s\n", file, name)
+	}
+}
@@ -0,0 +1,185 @@
+package watch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const prisoners = `package prisoners
+
+object prisoner {
+	age : int
+}
+
+rule adult {
+	input {
+		p : prisoner
+	}
+	rules {
+		p.age >= 18
+	}
+}
+
+test "Adult" {
+	facts {
+		p : prisoner { age : 23 }
+	}
+	rules {
+		adult(p)
+	}
+}
+`
+
+const phonecalls = `import "prisoners.rules"
+
+rule phonecall {
+	input {
+		p : prisoners.prisoner
+	}
+	rules {
+		prisoners.adult(p)
+	}
+}
+
+rule visit {
+	input {
+		p : prisoners.prisoner
+	}
+	rules {
+		p.age > 0
+	}
+}
+
+test "Phonecall" {
+	facts {
+		p : prisoners.prisoner { age : 18 }
+	}
+	rules {
+		phonecall(p)
+	}
+}
+
+test "Visit" {
+	facts {
+		p : prisoners.prisoner { age : 18 }
+	}
+	rules {
+		visit(p)
+	}
+}
+`
+
+func TestPoll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// files are told apart by modification time, which
+	// moves forward a second with every write here
+	modified := time.Now()
+	write := func(name, src string) {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modified = modified.Add(time.Second)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("prisoners.rules", prisoners)
+	write("phonecalls.rules", phonecalls)
+
+	w := New(dir)
+	for i, tt := range []struct {
+		change func()
+		want   string
+	}{
+		{
+			change: func() {},
+			want: `ok   phonecalls.rules: Phonecall
+ok   phonecalls.rules: Visit
+ok   prisoners.rules: Adult
+ran 3 of 3 tests, 3 passing
+`,
+		},
+		// nothing changed, nothing runs
+		{
+			change: func() {},
+			want:   "",
+		},
+		// a change in an imported rule reruns the tests calling it,
+		// and only those whose result changed are printed
+		{
+			change: func() {
+				write("prisoners.rules", strings.Replace(prisoners, "p.age >= 18", "p.age > 18", 1))
+			},
+			want: `FAIL phonecalls.rules: Phonecall
+ran 2 of 3 tests, 2 passing
+`,
+		},
+		{
+			change: func() {
+				write("prisoners.rules", strings.Replace(prisoners, "age : 23", "age : 17", 1))
+			},
+			want: `ok   phonecalls.rules: Phonecall
+FAIL prisoners.rules: Adult
+ran 2 of 3 tests, 2 passing
+`,
+		},
+		{
+			change: func() {
+				write("phonecalls.rules", strings.Replace(phonecalls, "p.age > 0", "p.size > 0", 1))
+			},
+			want: `phonecalls.rules: rule visit: object prisoners.prisoner has no field size
+ran 0 of 3 tests, 2 passing
+`,
+		},
+		// a test written before its rule fails until the rule is there
+		{
+			change: func() {
+				write("phonecalls.rules", strings.Replace(phonecalls, "visit(p)", "release(p)", 1))
+			},
+			want: `FAIL phonecalls.rules: Visit: undefined rule release
+ran 1 of 3 tests, 1 passing
+`,
+		},
+		{
+			change: func() {
+				os.Remove(filepath.Join(dir, "prisoners.rules"))
+			},
+			want: `phonecalls.rules: open ` + filepath.Join(dir, "prisoners.rules") + `: no such file or directory
+ran 0 of 2 tests, 1 passing
+`,
+		},
+		// parse errors name their file once
+		{
+			change: func() {
+				write("prisoners.rules", strings.Replace(prisoners, "age : int", "age : int,", 1))
+			},
+			want: `phonecalls.rules: prisoners.rules: expected ident got } at line 6 : col 0
+prisoners.rules: expected ident got } at line 6 : col 0
+ran 0 of 2 tests, 1 passing
+`,
+		},
+	} {
+		tt.change()
+		var b bytes.Buffer
+		if _, err := w.Poll(&b); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%d): got\n%s\nwant\n%s", i, got, tt.want)
+		}
+	}
+	if w.Passed() {
+		t.Errorf("got passed, want failed")
+	}
+}